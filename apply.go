@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A manifest declares a set of weblets for 'weblet apply':
+//
+//	weblets:
+//	  - name: discord
+//	    url: https://discord.com/app
+//	  - name: notes
+//	    url: ./notes-site
+//	    mode: native
+//	    icon: ./icons/notes.png
+//	    rules:
+//	      - block https://*/analytics.js
+//	    links:
+//	      - name: inbox
+//	        url: https://notes.example.com/inbox
+//	    settings:
+//	      header-bar: on
+//	      theme-color: "#1e1e2e"
+//	  - name: dashboard
+//	    url: https://dashboard.example.com
+//	    mode: native
+//	    auth:
+//	      device-url: https://login.example.com/oauth2/device
+//	      token-url: https://login.example.com/oauth2/token
+//	      client-id: kiosk
+//	      cookie: session
+//
+// Settings use the keys of 'weblet set'; anything not listed is reset to
+// its default, so applying the same manifest twice changes nothing.
+type manifest struct {
+	Weblets []manifestWeblet `yaml:"weblets"`
+}
+
+type manifestWeblet struct {
+	Name         string         `yaml:"name"`
+	URL          string         `yaml:"url"`
+	Mode         string         `yaml:"mode"` // chrome (default) or native
+	Icon         string         `yaml:"icon"` // Custom icon for light and dark themes
+	Rules        []string       `yaml:"rules"`
+	Links        []Link         `yaml:"links"` // Quick links, name and url
+	Auth         *DeviceAuth    `yaml:"auth"`  // Device flow login, see 'weblet auth'
+	Settings     map[string]any `yaml:"settings"`
+	AllowSchemes []string       `yaml:"allow-schemes"`
+}
+
+// Apply creates, updates and (with prune) removes weblets so they match a
+// manifest, printing a diff of what changed. dryRun only prints the diff.
+func (wm *WebletManager) Apply(manifestPath string, prune, dryRun bool) error {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	// Relative paths are relative to the manifest
+	baseDir, err := filepath.Abs(filepath.Dir(manifestPath))
+	if err != nil {
+		return err
+	}
+	resolve := func(path string) string {
+		if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+			return filepath.Join(baseDir, path)
+		}
+		return path
+	}
+
+	// Build and validate every desired weblet before changing anything
+	desired := make(map[string]*Weblet)
+	icons := make(map[string]map[string]string)
+	var order []string
+	for i, entry := range m.Weblets {
+		if entry.Name == "" {
+			return fmt.Errorf("weblet #%d has no name", i+1)
+		}
+		if _, dup := desired[entry.Name]; dup {
+			return fmt.Errorf("weblet '%s' is declared twice", entry.Name)
+		}
+		// Weblets named like a command before that was refused are kept
+		if _, exists := wm.weblets[entry.Name]; !exists {
+			if err := checkName(entry.Name); err != nil {
+				return err
+			}
+		}
+		weblet, webletIcons, err := wm.manifestWeblet(entry, resolve)
+		if err != nil {
+			return fmt.Errorf("weblet '%s': %w", entry.Name, err)
+		}
+		desired[entry.Name] = weblet
+		icons[entry.Name] = webletIcons
+		order = append(order, entry.Name)
+	}
+
+	// Work out the changes, and refuse them all if a locked weblet is
+	// among them
+	var added, changed, pruned []string
+	before := make(map[string]*Weblet)
+	diffs := make(map[string][]string)
+	unchanged := 0
+	for _, name := range order {
+		want := desired[name]
+		current, exists := wm.weblets[name]
+		if !exists {
+			added = append(added, name)
+			before[name] = &Weblet{}
+			continue
+		}
+
+		// The manifest is compared with what weblets.json holds, without
+		// what config.toml and weblets.d fill in
+		saved := wm.savedWeblet(current)
+
+		// Keep runtime state that isn't part of the manifest
+		want.PID = saved.PID
+		want.StartedAt = saved.StartedAt
+		want.Backend = saved.Backend
+		want.DetectedThemeColor = saved.DetectedThemeColor
+		want.Locked = saved.Locked
+		want.Kiosk = saved.Kiosk
+		want.System = saved.System
+		want.Template = saved.Template
+		want.Groups = saved.Groups
+
+		diff := webletDiff(&saved, want)
+		if len(diff) == 0 {
+			unchanged++
+			continue
+		}
+		if err := wm.checkEditable(current); err != nil {
+			return err
+		}
+		changed = append(changed, name)
+		before[name] = &saved
+		diffs[name] = diff
+	}
+	if prune {
+		for name, weblet := range wm.weblets {
+			if _, keep := desired[name]; !keep && (!weblet.System || wm.system) {
+				if err := wm.checkEditable(weblet); err != nil {
+					return err
+				}
+				pruned = append(pruned, name)
+			}
+		}
+		sort.Strings(pruned)
+	}
+
+	for _, name := range order {
+		if before[name] == nil {
+			continue
+		}
+		if _, exists := wm.weblets[name]; !exists {
+			fmt.Print(T("+ %s (%s)\n", name, desired[name].URL))
+			continue
+		}
+		fmt.Print(T("~ %s\n", name))
+		for _, line := range diffs[name] {
+			fmt.Print(T("    %s\n", line))
+		}
+	}
+	for _, name := range pruned {
+		fmt.Print(T("- %s\n", name))
+	}
+
+	if !dryRun {
+		for _, name := range append(added, changed...) {
+			for dst, source := range icons[name] {
+				if err := copyCustomIcon(source, dst); err != nil {
+					return fmt.Errorf("weblet '%s': %w", name, err)
+				}
+			}
+		}
+
+		var recreate []string
+		for _, name := range added {
+			wm.weblets[name] = desired[name]
+			recreate = append(recreate, name)
+		}
+		for _, name := range changed {
+			current, want := wm.weblets[name], desired[name]
+			if current.URL != want.URL || current.IconLight != want.IconLight || current.IconDark != want.IconDark || current.IconStyle != want.IconStyle {
+				recreate = append(recreate, name)
+			} else if !reflect.DeepEqual(current.Links, want.Links) || current.Hidden != want.Hidden {
+				if err := wm.updateDesktopEntry(want); err != nil {
+					fmt.Print(T("Warning: Could not update desktop file: %v\n", err))
+				}
+			}
+			// The manifest's values are the weblet's own now
+			wm.weblets[name] = want
+			delete(wm.fileSettings, name)
+		}
+		for _, name := range pruned {
+			if err := wm.Remove(name); err != nil {
+				return err
+			}
+		}
+
+		if err := wm.saveWeblets(); err != nil {
+			return err
+		}
+		for _, name := range append(added, changed...) {
+			if err := wm.settingsSaved(before[name], wm.weblets[name]); err != nil {
+				return err
+			}
+		}
+		for _, name := range recreate {
+			if err := wm.refreshWeblet(wm.weblets[name]); err != nil {
+				fmt.Fprint(os.Stderr, T("Warning: Failed to create desktop file for '%s': %v\n", name, err))
+			}
+		}
+		if len(recreate) > 0 {
+			if err := wm.saveWeblets(); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Print(T("\n%d added, %d changed, %d removed, %d unchanged\n", len(added), len(changed), len(pruned), unchanged))
+	if dryRun {
+		fmt.Println(T("(dry run, nothing was changed)"))
+	}
+	return nil
+}
+
+// manifestWeblet builds the weblet a manifest entry describes, and returns
+// the icon files to copy for it (by the private copy they go to) once the
+// weblet is applied
+func (wm *WebletManager) manifestWeblet(entry manifestWeblet, resolve func(string) string) (*Weblet, map[string]string, error) {
+	url, err := normalizeURL(resolve(entry.URL), entry.AllowSchemes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	weblet := &Weblet{
+		Name:      entry.Name,
+		URL:       url,
+		UseChrome: true,
+	}
+
+	switch entry.Mode {
+	case "", "chrome":
+	case "native":
+		weblet.UseChrome = false
+	default:
+		return nil, nil, fmt.Errorf("invalid mode '%s' (expected chrome or native)", entry.Mode)
+	}
+
+	for _, rule := range entry.Rules {
+		if _, _, err := parseRule(rule); err != nil {
+			return nil, nil, fmt.Errorf("invalid rule '%s': %w", rule, err)
+		}
+	}
+	weblet.Rules = entry.Rules
+
+	for _, link := range entry.Links {
+		if !linkNamePattern.MatchString(link.Name) {
+			return nil, nil, fmt.Errorf("invalid link name '%s'", link.Name)
+		}
+		linkURL, err := checkURL(link.URL, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("link %s: %w", link.Name, err)
+		}
+		weblet.Links = append(weblet.Links, Link{Name: link.Name, URL: linkURL})
+	}
+
+	if entry.Auth != nil {
+		if weblet.UseChrome {
+			return nil, nil, fmt.Errorf("auth needs mode: native")
+		}
+		if err := entry.Auth.validate(); err != nil {
+			return nil, nil, err
+		}
+		weblet.Auth = entry.Auth
+	}
+
+	if entry.Icon != "" {
+		if entry.Settings == nil {
+			entry.Settings = make(map[string]any)
+		}
+		entry.Settings["icon-light"] = entry.Icon
+		entry.Settings["icon-dark"] = entry.Icon
+	}
+
+	// Apply settings in a stable order so errors are reproducible
+	icons := make(map[string]string)
+	keys := make([]string, 0, len(entry.Settings))
+	for key := range entry.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := fmt.Sprint(entry.Settings[key])
+		if entry.Settings[key] == nil {
+			value = ""
+		}
+		switch key {
+		case "local-dir", "icon-light", "icon-dark":
+			value = resolve(value)
+		}
+		source := value
+		value, _, err := wm.parseSetting(weblet, key, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("setting %s: %w", key, err)
+		}
+		if (key == "icon-light" || key == "icon-dark") && value != "" {
+			icons[value] = source
+		}
+	}
+
+	return weblet, icons, nil
+}
+
+// webletDiff lists the fields that differ between two weblets as
+// "field: old → new" lines
+func webletDiff(a, b *Weblet) []string {
+	toMap := func(w *Weblet) map[string]any {
+		var m map[string]any
+		data, _ := json.Marshal(w)
+		json.Unmarshal(data, &m)
+		return m
+	}
+	am, bm := toMap(a), toMap(b)
+
+	keys := make(map[string]bool)
+	for k := range am {
+		keys[k] = true
+	}
+	for k := range bm {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diff []string
+	for _, k := range sorted {
+		if !reflect.DeepEqual(am[k], bm[k]) {
+			diff = append(diff, fmt.Sprintf("%s: %v → %v", k, formatValue(am[k]), formatValue(bm[k])))
+		}
+	}
+	return diff
+}
+
+func formatValue(v any) string {
+	if v == nil {
+		return "(unset)"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Native windows append what their pages were allowed to do to
+// data/<name>/audit.log: permission grants, clipboard reads and writes,
+// downloads, pages opened outside the window and certificate errors, one
+// "<time>\t<event>\t<detail>\t<page>" line each. 'weblet audit <name>'
+// lists them. The log is rotated to audit.log.1 when a window starts.
+
+// auditLimit is the number of events listed by default
+const auditLimit = 50
+
+// auditMaxSize is the size of the log at which it is rotated
+const auditMaxSize = 1 << 20
+
+// auditEvents describes the events of the log
+var auditEvents = map[string]string{
+	"permission":        "Permission granted",
+	"permission-denied": "Permission denied",
+	"clipboard-read":    "Clipboard read",
+	"clipboard-write":   "Clipboard written",
+	"clipboard-denied":  "Clipboard read denied",
+	"download":          "Download",
+	"external":          "Opened outside",
+	"certificate-error": "Certificate error",
+}
+
+func (wm *WebletManager) auditFile(name string) string {
+	return filepath.Join(wm.dataDir, "data", name, "audit.log")
+}
+
+// auditEntry is an event of the audit log
+type auditEntry struct {
+	Time   time.Time
+	Event  string
+	Detail string
+	Page   string
+}
+
+// readAudit reads the events of an audit log, oldest first
+func readAudit(path string) ([]auditEntry, error) {
+	var entries []auditEntry
+	for _, file := range []string{path + ".1", path} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, line := range splitLines(string(data)) {
+			fields := strings.SplitN(line, "\t", 4)
+			if len(fields) < 4 {
+				continue
+			}
+			at, err := time.Parse(time.RFC3339, fields[0])
+			if err != nil {
+				continue
+			}
+			entries = append(entries, auditEntry{Time: at, Event: fields[1], Detail: fields[2], Page: fields[3]})
+		}
+	}
+	return entries, nil
+}
+
+// rotateAudit keeps the previous log when the current one got big
+func rotateAudit(path string) {
+	if info, err := os.Stat(path); err == nil && info.Size() > auditMaxSize {
+		os.Rename(path, path+".1")
+	}
+}
+
+// Audit prints the most recent events of a weblet's audit log
+func (wm *WebletManager) Audit(name string, limit int) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	entries, err := readAudit(wm.auditFile(name))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		if weblet.UseChrome {
+			fmt.Print(T("Weblet '%s' uses Chrome, the audit log is only kept in native mode.\n", name))
+		} else {
+			fmt.Print(T("No audit events for weblet '%s' yet.\n", name))
+		}
+		return nil
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	for _, entry := range entries {
+		event := entry.Event
+		if description, ok := auditEvents[event]; ok {
+			event = T(description)
+		}
+		fmt.Printf("%s  %-22s %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), event, entry.Detail)
+		if entry.Page != "" {
+			fmt.Printf("                     %s\n", entry.Page)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// With 'weblet set <name> autofill on', a native weblet offers to save the
+// logins submitted in it and fills them in from the header bar's key button
+// or the context menu of a text field. Logins are kept in the Secret
+// Service (GNOME Keyring, KWallet) under the attributes weblet, origin and
+// username; 'weblet autofill' manages them with secret-tool.
+
+// savedLogin is a login in the keyring, without its password
+type savedLogin struct {
+	Origin   string
+	Username string
+}
+
+// Autofill lists, adds or removes the saved logins of a weblet
+func (wm *WebletManager) Autofill(args []string) error {
+	if len(args) < 2 {
+		return newError(ErrInvalid, "usage: weblet autofill list|add|remove <name> ...")
+	}
+	command, name := args[0], args[1]
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return fmt.Errorf("secret-tool is needed to manage logins (install libsecret-tools)")
+	}
+
+	switch command {
+	case "list":
+		logins, err := searchLogins(name)
+		if err != nil {
+			return err
+		}
+		if len(logins) == 0 {
+			fmt.Print(T("No saved logins for weblet '%s'.\n", name))
+		} else {
+			fmt.Print(T("Saved logins of weblet '%s':\n", name))
+			for _, login := range logins {
+				fmt.Printf("  %-40s %s\n", login.Origin, login.Username)
+			}
+		}
+		if !weblet.Autofill {
+			fmt.Print(T("Autofill is off (turn it on with 'weblet set %s autofill on').\n", name))
+		}
+		return nil
+
+	case "add":
+		if len(args) != 4 {
+			return newError(ErrInvalid, "usage: weblet autofill add <name> <origin> <username>")
+		}
+		origin, err := loginOrigin(args[2])
+		if err != nil {
+			return err
+		}
+		// secret-tool reads the password from the terminal or stdin
+		cmd := exec.Command("secret-tool", "store", "--label", fmt.Sprintf("weblet %s: %s", name, origin),
+			"weblet", name, "origin", origin, "username", args[3])
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("could not save the login: %w", err)
+		}
+		fmt.Print(T("Saved login '%s' for %s\n", args[3], origin))
+		return nil
+
+	case "remove":
+		if len(args) < 3 || len(args) > 4 {
+			return newError(ErrInvalid, "usage: weblet autofill remove <name> <origin> [username]")
+		}
+		origin, err := loginOrigin(args[2])
+		if err != nil {
+			return err
+		}
+		attributes := []string{"clear", "weblet", name, "origin", origin}
+		if len(args) == 4 {
+			attributes = append(attributes, "username", args[3])
+		}
+		if output, err := exec.Command("secret-tool", attributes...).CombinedOutput(); err != nil {
+			return fmt.Errorf("could not remove the login: %s", strings.TrimSpace(string(output)))
+		}
+		fmt.Print(T("Removed saved logins for %s\n", origin))
+		return nil
+	}
+
+	return newError(ErrInvalid, "unknown command '%s'", command)
+}
+
+// loginOrigin reduces a URL to the origin logins are saved under
+func loginOrigin(value string) (string, error) {
+	normalized, err := checkURL(value, nil)
+	if err != nil {
+		return "", wrapError(ErrInvalid, err)
+	}
+	scheme, rest, _ := strings.Cut(normalized, "://")
+	host, _, _ := strings.Cut(rest, "/")
+	return scheme + "://" + host, nil
+}
+
+// searchLogins lists the logins saved for a weblet, read from the
+// attribute lines of 'secret-tool search'
+func searchLogins(name string) ([]savedLogin, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "search", "--all", "weblet", name)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// secret-tool fails when nothing matches
+		if stdout.Len() == 0 && stderr.Len() == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read the keyring: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	var logins []savedLogin
+	var current *savedLogin
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "[") {
+			logins = append(logins, savedLogin{})
+			current = &logins[len(logins)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok || current == nil {
+			continue
+		}
+		switch key {
+		case "attribute.origin":
+			current.Origin = value
+		case "attribute.username":
+			current.Username = value
+		}
+	}
+	sort.Slice(logins, func(i, j int) bool {
+		if logins[i].Origin != logins[j].Origin {
+			return logins[i].Origin < logins[j].Origin
+		}
+		return logins[i].Username < logins[j].Username
+	})
+	return logins, nil
+}
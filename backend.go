@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// When the backend of a weblet is missing (Chrome isn't installed, or weblet
+// was built without the native webview), the error tells how to install it
+// with the package manager of this distribution. Run also offers to switch
+// the weblet to the other backend if that one is available: with a question
+// on a terminal, otherwise with a desktop notification, since weblets are
+// mostly started from their desktop files.
+
+// backendMissingError is returned when a weblet's backend isn't available
+type backendMissingError struct {
+	backend string // "chrome" or "native"
+	install string // Command that installs it on this distribution
+}
+
+func (e *backendMissingError) Error() string {
+	switch {
+	case e.backend == "chrome" && e.install != "":
+		return T("Chrome or Chromium not found. Install it with:") + "\n  " + e.install
+	case e.backend == "chrome":
+		return T("Chrome or Chromium not found. Install Google Chrome from https://www.google.com/chrome/ or Chromium from your distribution")
+	case e.install != "":
+		return T("This build of weblet has no native webview. Install GTK 3 and WebKitGTK 4.1 with:") + "\n  " + e.install +
+			"\n" + T("and build weblet again without the no_native tag")
+	}
+	return T("This build of weblet has no native webview. Install the GTK 3 and WebKitGTK 4.1 development packages and build weblet again without the no_native tag")
+}
+
+func (e *backendMissingError) Unwrap() error {
+	if e.backend == "chrome" {
+		return ErrBrowserMissing
+	}
+	return ErrInvalid
+}
+
+// newBackendMissingError describes how to install a missing backend
+func newBackendMissingError(backend string) error {
+	err := &backendMissingError{backend: backend}
+	if pm, ok := distroPackageManager(); ok {
+		if backend == "chrome" {
+			err.install = pm.installHint("chromium")
+		} else {
+			err.install = pm.installHint("webkit")
+		}
+	}
+	return err
+}
+
+// backendMissing is what Run returns when the weblet's backend is missing.
+// If the other backend is available the user may switch the weblet to it,
+// which runs it right away.
+func (wm *WebletManager) backendMissing(weblet *Weblet) error {
+	backend, other := "native", "chrome"
+	if weblet.UseChrome {
+		backend, other = "chrome", "native"
+	}
+	missing := newBackendMissingError(backend)
+	if other == "chrome" && findChrome() == "" || other == "native" && !nativeAvailable() {
+		return missing
+	}
+
+	var switchTo bool
+	if isTerminal(os.Stdin) && isTerminal(os.Stderr) {
+		switchTo = askSwitchBackend(weblet.Name, other)
+	} else {
+		switchTo = notifySwitchBackend(weblet.Name, missing, other)
+	}
+	if !switchTo {
+		return missing
+	}
+	if err := wm.SetChromeMode(weblet.Name, other == "chrome"); err != nil {
+		return err
+	}
+	return wm.Run(weblet.Name)
+}
+
+// askSwitchBackend asks on the terminal whether to use the other backend;
+// the error itself is printed if the answer is no
+func askSwitchBackend(name, other string) bool {
+	if other == "chrome" {
+		return confirm(T("This build of weblet has no native webview. Run weblet '%s' in Chrome instead? [y/N] ", name))
+	}
+	return confirm(T("Chrome or Chromium isn't installed. Run weblet '%s' in the native webview instead? [y/N] ", name))
+}
+
+// confirm asks a yes/no question on the terminal, no being the default
+func confirm(question string) bool {
+	fmt.Fprint(os.Stderr, question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// notifySwitchBackend shows the error as a desktop notification with a
+// button for the other backend, and waits for it to be answered
+func notifySwitchBackend(name string, missing error, other string) bool {
+	if _, err := hostLookPath("notify-send"); err != nil {
+		return false
+	}
+	label := T("Use the native webview")
+	if other == "chrome" {
+		label = T("Use Chrome")
+	}
+	args := []string{"--app-name=weblet", "--icon=weblet-" + name,
+		"--hint=string:desktop-entry:weblet-" + name,
+		T("%s can't start", name), missing.Error()}
+
+	// Actions need notify-send 0.7.10; older versions just show the message
+	output, err := hostCommand("notify-send", append([]string{"--wait", "--action=switch=" + label}, args...)...).Output()
+	if err != nil {
+		hostCommand("notify-send", args...).Run()
+		return false
+	}
+	return strings.TrimSpace(string(output)) == "switch"
+}
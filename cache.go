@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The HTTP cache of a weblet can be capped ('weblet set <name> cache-size
+// 50M') or kept off the disk ('weblet set <name> cache memory-only'), which
+// moves it to $XDG_RUNTIME_DIR, a tmpfs cleared at logout. Chrome enforces
+// the cap itself; WebKit has no limit, so its cache is emptied at launch
+// when it has grown past it.
+
+// parseSize parses a size like 50M, 1.5G or 4096 (bytes)
+func parseSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size '%s' (expected e.g. 50M or 1G)", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// formatSize formats a byte count for display, e.g. 50M
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dG", n>>30)
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%dM", (n+1<<19)>>20)
+	case n >= 1<<10:
+		return fmt.Sprintf("%dK", (n+1<<9)>>10)
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// dirSize returns the total size of the files in a directory tree
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// memoryCacheDir is where a memory-only weblet keeps its cache
+func (wm *WebletManager) memoryCacheDir(name string) string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "weblet", "cache", name)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("weblet-%d", os.Getuid()), "cache", name)
+}
+
+// pruneCache empties the WebKit disk cache of a native weblet if it is
+// larger than the weblet's limit
+func (wm *WebletManager) pruneCache(weblet *Weblet) {
+	if weblet.CacheSize <= 0 || weblet.Cache == "memory-only" {
+		return
+	}
+	cacheDir := filepath.Join(wm.webkitSiteDir(weblet.Name, wm.launchContainer(weblet)), "WebKitCache")
+	if dirSize(cacheDir) > weblet.CacheSize {
+		os.RemoveAll(cacheDir)
+	}
+}
+
+// chromeCacheArgs returns the Chrome flags for the weblet's cache settings
+func (wm *WebletManager) chromeCacheArgs(weblet *Weblet) []string {
+	var args []string
+	if weblet.Cache == "memory-only" {
+		args = append(args, "--disk-cache-dir="+wm.memoryCacheDir(weblet.Name))
+	}
+	if weblet.CacheSize > 0 {
+		args = append(args, "--disk-cache-size="+strconv.FormatInt(weblet.CacheSize, 10))
+	}
+	return args
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Chrome marks a profile as in use with symlinks in the user-data-dir:
+// SingletonLock points at "<hostname>-<pid>", SingletonSocket at the socket
+// a second launch hands its URL to, and SingletonCookie at a random token.
+// After a crash (or a power loss) they stay behind, and the next launch
+// either exits silently or shows Chrome's "profile in use" dialog. Before
+// launching, a lock whose owner is gone is removed, and a lock held by a
+// live Chrome that isn't this weblet's is reported.
+
+// chromeSingletonFiles are the lock files of a Chrome profile
+var chromeSingletonFiles = []string{"SingletonLock", "SingletonSocket", "SingletonCookie"}
+
+// chromeProfileLock reads the host and PID a profile is locked by
+func chromeProfileLock(userDataDir string) (string, int, bool) {
+	target, err := os.Readlink(filepath.Join(userDataDir, "SingletonLock"))
+	if err != nil {
+		return "", 0, false
+	}
+	// Host names may contain dashes, the PID is after the last one
+	i := strings.LastIndexByte(target, '-')
+	if i < 0 {
+		return target, 0, true
+	}
+	pid, _ := strconv.Atoi(target[i+1:])
+	return target[:i], pid, true
+}
+
+// chromeSocketAlive reports whether a Chrome process listens on the
+// profile's SingletonSocket, which also works for Chromes in another PID
+// namespace (Flatpak, Snap)
+func chromeSocketAlive(userDataDir string) bool {
+	conn, err := net.DialTimeout("unix", filepath.Join(userDataDir, "SingletonSocket"), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// isChromePID reports whether pid is a running Chrome or Chromium process
+func isChromePID(pid int) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return false
+	}
+	name := strings.TrimSpace(string(comm))
+	return strings.Contains(name, "chrome") || strings.Contains(name, "chromium")
+}
+
+// checkChromeProfile removes a stale lock from a weblet's Chrome profile
+// before it is launched, and fails when another Chrome holds the profile.
+// It is called once no process of the weblet was found.
+func (wm *WebletManager) checkChromeProfile(weblet *Weblet, userDataDir string) error {
+	host, pid, locked := chromeProfileLock(userDataDir)
+	if !locked {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	if host != hostname {
+		// A home directory shared over the network; Chrome may well run there
+		lockFile := filepath.Join(userDataDir, "SingletonLock")
+		return newError(ErrLocked, "the Chrome profile of weblet '%s' is in use on %s (remove %s if Chrome isn't running there)", weblet.Name, host, lockFile)
+	}
+
+	if isChromePID(pid) || chromeSocketAlive(userDataDir) {
+		return newError(ErrLocked, "the Chrome profile of weblet '%s' is in use by another Chrome instance (PID %d), close it first", weblet.Name, pid)
+	}
+
+	// The socket lives in a temporary directory of the dead Chrome
+	if target, err := os.Readlink(filepath.Join(userDataDir, "SingletonSocket")); err == nil {
+		dir := filepath.Dir(target)
+		if base := filepath.Base(dir); strings.HasPrefix(base, ".org.chromium.Chromium.") || strings.HasPrefix(base, ".com.google.Chrome.") {
+			os.RemoveAll(dir)
+		}
+	}
+	for _, name := range chromeSingletonFiles {
+		if err := os.Remove(filepath.Join(userDataDir, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove the stale Chrome profile lock: %w", err)
+		}
+	}
+	fmt.Print(T("Removed the stale profile lock of '%s' left by a Chrome that didn't exit cleanly\n", weblet.Name))
+	return nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The command line is 'weblet [global options] <command> [options] [args]'.
+// Options may come before or after the arguments, as --name value or
+// --name=value, and -- ends them. Global options are accepted anywhere; an
+// option of the command wins over a global one with the same name after
+// the command (weblet list -q lists names, weblet -q list prints nothing).
+// Commands are described by a table that 'weblet help' and 'weblet help
+// <command>' (or --help after any command) print. Anything that isn't a
+// command is a weblet: 'weblet <name> [url|link]'.
+
+// flagSpec declares an option of a command
+type flagSpec struct {
+	name  string // Without dashes
+	short string // One-letter alias, e.g. q for --quiet
+	value bool   // Takes a value
+}
+
+// command is a subcommand of weblet
+type command struct {
+	names      []string   // Name, and aliases running the same code (pin, unpin)
+	flags      []flagSpec // Options of the command
+	minArgs    int        // Number of arguments after the options are taken out
+	maxArgs    int        // -1 for any number
+	raw        bool       // Arguments have their own syntax and are passed on as they are (only global options are taken out)
+	json       bool       // Supports the global --json
+	standalone bool       // Runs without loading the weblets
+	summary    []string   // Lines of the command list in 'weblet help'
+	usage      []string   // Lines of 'weblet help <command>' and of usage errors
+	run        func(wm *WebletManager, inv *invocation) error
+}
+
+// invocation is a parsed command line
+type invocation struct {
+	cmd   *command
+	name  string // The command as typed, e.g. pin or unpin
+	args  []string
+	flags map[string][]string
+}
+
+// has reports whether an option was given
+func (inv *invocation) has(flag string) bool {
+	_, ok := inv.flags[flag]
+	return ok
+}
+
+// value returns the (last) value of an option, or ""
+func (inv *invocation) value(flag string) string {
+	values := inv.flags[flag]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// intValue returns the value of an option that is a positive number, or
+// def when it wasn't given
+func (inv *invocation) intValue(flag string, def int) (int, error) {
+	if !inv.has(flag) {
+		return def, nil
+	}
+	n, err := strconv.Atoi(inv.value(flag))
+	if err != nil || n <= 0 {
+		return 0, newError(ErrInvalid, "invalid --%s '%s' (expected a positive number)", flag, inv.value(flag))
+	}
+	return n, nil
+}
+
+// errUsage makes the command print its usage and exit with exitUsage
+var errUsage = errors.New("usage")
+
+// globalFlags are accepted by every command
+var globalFlags = []flagSpec{
+	{name: "system"},
+	{name: "quiet", short: "q"},
+	{name: "verbose", short: "v"},
+	{name: "json"},
+	{name: "backend", value: true},
+	{name: "data-dir", value: true},
+	{name: "config", value: true},
+	{name: "help", short: "h"},
+}
+
+// globalUsage describes the global options in 'weblet help'
+var globalUsage = []string{
+	"  --system                - Manage weblets installed for all users (run with sudo)",
+	"  --quiet, -q             - Only print errors, for scripts",
+	"  --verbose, -v           - Print the commands weblet runs and the steps of a launch",
+	"  --json                  - Machine-readable output (list, status)",
+	"  --backend stub          - Run weblets without a display, for tests and CI (also WEBLET_BACKEND=stub)",
+	"  --data-dir <dir>        - Keep all weblet data in <dir> instead of ~/.config, ~/.local/share and ~/.cache, e.g. on a USB drive",
+	"  --config <file>         - Read and save the global options ('weblet config') in <file>",
+	"  --help, -h              - Show help, also after a command",
+}
+
+// Output settings of the global options
+var (
+	verbose    bool
+	jsonOutput bool
+)
+
+// debugf prints what weblet does with --verbose
+func debugf(format string, args ...any) {
+	if verbose {
+		fmt.Fprintf(os.Stderr, "weblet: "+format+"\n", args...)
+	}
+}
+
+// findFlag looks up an option by its long or short name
+func findFlag(specs []flagSpec, name string) (flagSpec, bool) {
+	for _, spec := range specs {
+		if spec.name == name || spec.short != "" && spec.short == name {
+			return spec, true
+		}
+	}
+	return flagSpec{}, false
+}
+
+// findCommand returns the command with the given name, or nil
+func findCommand(name string) *command {
+	if name == "" {
+		return nil
+	}
+	for _, cmd := range commandList {
+		if slices.Contains(cmd.names, name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// isCommand reports whether name is a command of weblet
+func isCommand(name string) bool {
+	return findCommand(name) != nil
+}
+
+// parseCommandLine parses the arguments of weblet (without the program
+// name) into the command, its arguments and options, and the global options
+func parseCommandLine(args []string) (*invocation, map[string][]string, error) {
+	global := map[string][]string{}
+	inv := &invocation{flags: map[string][]string{}}
+	// takeFlag parses the option at args[*i] into flags when it is in specs
+	takeFlag := func(specs []flagSpec, flags map[string][]string, i *int) (bool, error) {
+		arg := args[*i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		spec, ok := findFlag(specs, name)
+		if !ok || strings.HasPrefix(arg, "--") == (len(name) == 1 && spec.short == name) {
+			return false, nil
+		}
+		switch {
+		case spec.value && !hasValue:
+			if *i+1 >= len(args) {
+				return true, newError(ErrInvalid, "--%s needs a value", spec.name)
+			}
+			*i++
+			value = args[*i]
+		case !spec.value && hasValue:
+			return true, newError(ErrInvalid, "--%s doesn't take a value", spec.name)
+		}
+		flags[spec.name] = append(flags[spec.name], value)
+		return true, nil
+	}
+
+	optionsEnded := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		isFlag := !optionsEnded && len(arg) > 1 && arg[0] == '-'
+		if !optionsEnded && arg == "--" {
+			optionsEnded = true
+			if inv.cmd != nil && !inv.cmd.raw {
+				continue
+			}
+			if inv.cmd != nil {
+				// Raw arguments keep everything after --, but not -- itself
+				inv.args = append(inv.args, args[i+1:]...)
+				break
+			}
+			continue
+		}
+
+		if inv.cmd == nil {
+			if isFlag {
+				ok, err := takeFlag(globalFlags, global, &i)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !ok {
+					return nil, nil, newError(ErrInvalid, "unknown option '%s' (see 'weblet help')", arg)
+				}
+				continue
+			}
+			if inv.cmd = findCommand(arg); inv.cmd != nil {
+				inv.name = arg
+			} else {
+				// weblet <name> [url|link]
+				inv.cmd = webletCommand
+				inv.name = arg
+				inv.args = append(inv.args, arg)
+			}
+			continue
+		}
+
+		if isFlag {
+			if ok, err := takeFlag(inv.cmd.flags, inv.flags, &i); ok || err != nil {
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if ok, err := takeFlag(globalFlags, global, &i); ok || err != nil {
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if !inv.cmd.raw {
+				return nil, nil, newError(ErrInvalid, "unknown option '%s' (see 'weblet help %s')", arg, inv.cmd.names[0])
+			}
+		}
+		inv.args = append(inv.args, arg)
+	}
+	return inv, global, nil
+}
+
+// applyGlobalFlags sets up this invocation from the global options
+func applyGlobalFlags(global map[string][]string) (bool, error) {
+	last := func(name string) string {
+		values := global[name]
+		return values[len(values)-1]
+	}
+	_, system := global["system"]
+	if _, ok := global["data-dir"]; ok {
+		if system {
+			return false, newError(ErrInvalid, "--system and --data-dir can't be combined")
+		}
+		if err := setDataDir(last("data-dir")); err != nil {
+			return false, err
+		}
+	}
+	if _, ok := global["config"]; ok {
+		if err := setConfigFile(last("config")); err != nil {
+			return false, err
+		}
+	}
+	if _, ok := global["backend"]; ok {
+		if err := setBackend(last("backend")); err != nil {
+			return false, err
+		}
+	}
+	if _, ok := global["quiet"]; ok {
+		// Informational output goes away, errors still go to stderr (see
+		// the exit codes in errors.go)
+		if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = devNull
+		}
+		showProgress = false
+	}
+	_, verbose = global["verbose"]
+	_, jsonOutput = global["json"]
+	if jsonOutput {
+		showProgress = false
+	}
+	return system, nil
+}
+
+// runCommandLine runs weblet with the given arguments
+func runCommandLine(args []string) {
+	inv, global, err := parseCommandLine(args)
+	if err != nil {
+		fail(err)
+	}
+	system, err := applyGlobalFlags(global)
+	if err != nil {
+		fail(err)
+	}
+
+	_, help := global["help"]
+	if inv.cmd == nil {
+		printHelp()
+		if help {
+			return
+		}
+		os.Exit(exitUsage)
+	}
+	if help || inv.cmd.raw && len(inv.args) > 0 && (inv.args[0] == "--help" || inv.args[0] == "-h") {
+		inv.cmd.printUsage()
+		return
+	}
+	if jsonOutput && !inv.cmd.json {
+		fail(newError(ErrInvalid, "'weblet %s' has no JSON output", inv.cmd.names[0]))
+	}
+	if len(inv.args) < inv.cmd.minArgs || inv.cmd.maxArgs >= 0 && len(inv.args) > inv.cmd.maxArgs {
+		inv.cmd.printUsage()
+		os.Exit(exitUsage)
+	}
+
+	var wm *WebletManager
+	if !inv.cmd.standalone {
+		if wm, err = NewWebletManager(system); err != nil {
+			fail(err)
+		}
+		debugf("data directory %s, config %s", wm.dataDir, wm.configFile())
+		wm.migrateCommandNames()
+	}
+	if err := inv.cmd.run(wm, inv); err != nil {
+		if errors.Is(err, errUsage) {
+			inv.cmd.printUsage()
+			os.Exit(exitUsage)
+		}
+		fail(err)
+	}
+}
+
+// printUsage prints the usage of a command; usage lines are plain text, not
+// format strings, so they may contain % signs
+func (cmd *command) printUsage() {
+	for _, line := range cmd.usage {
+		fmt.Println(translate(line))
+	}
+}
+
+// printHelp prints the list of commands and the global options
+func printHelp() {
+	fmt.Println(T("Usage:"))
+	for _, cmd := range commandList {
+		for _, line := range cmd.summary {
+			fmt.Println(translate(line))
+		}
+	}
+	fmt.Println(T("Global options:"))
+	for _, line := range globalUsage {
+		fmt.Println(translate(line))
+	}
+	fmt.Println(T("Run 'weblet help <command>' for the options of a command"))
+}
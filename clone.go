@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// Clone copies a weblet's definition and settings to a new name, e.g. for a
+// second account of the same service. With copyData the browser profile is
+// copied too, so the clone starts logged in. The desktop file, icon and
+// WM_CLASS are created for the new name.
+func (wm *WebletManager) Clone(src, dst string, copyData bool) error {
+	weblet, exists := wm.weblets[src]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", src)
+	}
+	if _, exists := wm.weblets[dst]; exists {
+		return newError(ErrExists, "weblet '%s' already exists", dst)
+	}
+	if err := checkName(dst); err != nil {
+		return err
+	}
+	// The clone isn't locked, so it mustn't be a way around the passphrase
+	if err := wm.checkUnlocked(weblet); err != nil {
+		return err
+	}
+
+	clone := *weblet
+	clone.Name = dst
+	clone.PID = 0
+	clone.StartedAt = 0
+	clone.Backend = ""
+	clone.Locked = false
+	clone.Kiosk = false
+	clone.System = false
+	clone.Rules = append([]string(nil), weblet.Rules...)
+	clone.Links = append([]Link(nil), weblet.Links...)
+	if weblet.Auth != nil {
+		auth := *weblet.Auth
+		clone.Auth = &auth
+	}
+
+	if copyData {
+		if err := wm.cloneData(weblet, dst); err != nil {
+			return err
+		}
+	}
+
+	wm.weblets[dst] = &clone
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+
+	if err := wm.createDesktopFile(dst, clone.URL); err != nil {
+		fmt.Fprint(os.Stderr, T("Warning: Failed to create desktop file: %v\n", err))
+	}
+
+	fmt.Print(T("Cloned weblet '%s' to '%s'\n", src, dst))
+	return nil
+}
+
+// cloneData copies the native and Chrome profiles of a weblet to a new name
+func (wm *WebletManager) cloneData(weblet *Weblet, dst string) error {
+	running := (weblet.PID > 0 && wm.isProcessRunning(weblet.PID)) ||
+		wm.isChromeProcessRunning(filepath.Join(wm.dataDir, "chrome-data", weblet.Name))
+	if running {
+		return fmt.Errorf("weblet '%s' is running, close it before copying its data", weblet.Name)
+	}
+
+	dirs, err := wm.profileDirs(weblet.Name, dst)
+	if err != nil {
+		return err
+	}
+	// Leftovers of a removed weblet with the new name would be mixed in
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir[1]); err == nil {
+			return newError(ErrExists, "data directory %s already exists", dir[1])
+		}
+	}
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir[0]); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyDir(dir[0], dir[1]); err != nil {
+			os.RemoveAll(dir[1])
+			return fmt.Errorf("failed to copy %s: %w", dir[0], err)
+		}
+	}
+	return nil
+}
+
+// profileDirs pairs the native and Chrome profile directories of a weblet
+// with those of another name
+func (wm *WebletManager) profileDirs(name, other string) ([][2]string, error) {
+	userDir, err := view.DataDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	// Native mode keeps its data in the user's home even for system weblets
+	chromeDir := filepath.Join(wm.dataDir, "chrome-data")
+	return [][2]string{
+		{filepath.Join(userDir, "data", name), filepath.Join(userDir, "data", other)},
+		{filepath.Join(chromeDir, name), filepath.Join(chromeDir, other)},
+	}, nil
+}
+
+// copyDir recursively copies a directory, keeping permissions and symlinks.
+// Chrome's Singleton* lock files are skipped, they belong to the running
+// instance of the original profile.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(entry.Name(), "Singleton") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case entry.Type().IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		}
+		// Sockets and other special files aren't part of the profile
+		return nil
+	})
+}
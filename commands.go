@@ -0,0 +1,811 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandList holds the commands of weblet in the order 'weblet help' lists
+// them; webletCommand runs 'weblet <name> [url|link]'. They are set up in
+// init since the help command refers back to the list.
+var (
+	commandList   []*command
+	webletCommand *command
+)
+
+func init() {
+	webletCommand = &command{
+		flags:   []flagSpec{{name: "allow-scheme", value: true}},
+		minArgs: 1, maxArgs: 2,
+		summary: []string{
+			"  weblet <name>           - Run existing weblet",
+			"  weblet <name> <url>     - Add and run weblet (url may be a local directory)",
+		},
+		usage: []string{
+			"Usage:",
+			"  weblet <name>           - Run existing weblet",
+			"  weblet <name> <url>     - Add and run weblet",
+			"Options:",
+			"  --allow-scheme <scheme> - Allow a URL scheme other than http/https",
+		},
+		run: runWeblet,
+	}
+
+	commandList = []*command{
+		{
+			names: []string{"help"}, maxArgs: 1, standalone: true,
+			summary: []string{"  weblet help [command]   - Show the commands, or the options of one"},
+			usage:   []string{"Usage: weblet help [command]"},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if len(inv.args) == 0 {
+					printHelp()
+					return nil
+				}
+				cmd := findCommand(inv.args[0])
+				if cmd == nil {
+					return newError(ErrInvalid, "unknown command '%s' (see 'weblet help')", inv.args[0])
+				}
+				cmd.printUsage()
+				return nil
+			},
+		},
+		{
+			names: []string{"version"}, standalone: true,
+			summary: []string{"  weblet version"},
+			usage:   []string{"Usage: weblet version"},
+			run: func(wm *WebletManager, inv *invocation) error {
+				fmt.Print(T("weblet version %s\n", version))
+				return nil
+			},
+		},
+		{
+			names:   []string{"setup"},
+			summary: []string{"  weblet setup"},
+			usage: []string{
+				"Usage: weblet setup",
+				"Checks and installs what weblet needs on this system",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Setup()
+			},
+		},
+		{
+			names: []string{"list"},
+			flags: []flagSpec{{name: "quiet", short: "q"}},
+			json:  true,
+			summary: []string{
+				"  weblet list [--json|--quiet] - List weblets, as JSON or only their names for scripts",
+			},
+			usage: []string{
+				"Usage: weblet list [--json|--quiet]",
+				"--json prints the weblets with their state and data sizes, --quiet only their names",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				switch {
+				case jsonOutput && inv.has("quiet"):
+					return errUsage
+				case jsonOutput:
+					return wm.ListJSON()
+				case inv.has("quiet"):
+					wm.ListNames()
+				default:
+					wm.List()
+				}
+				return nil
+			},
+		},
+		{
+			names: []string{"status"}, maxArgs: -1, json: true,
+			summary: []string{"  weblet status [name]...  - Show whether weblets run, what they do, their backend, PID, window and uptime"},
+			usage: []string{
+				"Usage: weblet status [name]... [--json]",
+				"Shows whether weblets run, what they do (in-call, playing-audio, loading or idle), their backend, PID, window and uptime",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if jsonOutput {
+					return wm.StatusJSON(inv.args)
+				}
+				return wm.Status(inv.args)
+			},
+		},
+		webletCommand,
+		{
+			names: []string{"run"},
+			flags: []flagSpec{
+				{name: "profile-startup"}, {name: "url", value: true}, {name: "zoom", value: true},
+				{name: "window", value: true}, {name: "watch", value: true}, {name: "allow-scheme", value: true},
+				{name: "container", value: true},
+			},
+			minArgs: 1, maxArgs: 2,
+			summary: []string{
+				"  weblet run <name> [<link>|--profile-startup] - Run a weblet, also one named like a command",
+				"  weblet run <name> [--url <url>] [--zoom <level>] [--window <width>x<height>] [--container <name>] - Run a weblet once with other settings",
+				"  weblet run <name> --watch <dir> - Run a local dev weblet, reloading it when files under <dir> change",
+			},
+			usage: []string{
+				"Usage: weblet run <name> [<link>|--profile-startup]",
+				"       weblet run <name> [--url <url>] [--zoom <level>] [--window <width>x<height>] [--container <name>]",
+				"       weblet run <name> --watch <dir>",
+				"Runs a weblet; --profile-startup prints how long each step of the launch took",
+				"--url, --zoom, --window and --container apply to this launch only, keep them with 'weblet set'",
+				"--watch reloads the window of a localhost or local directory weblet when files under <dir> change",
+			},
+			// Also opens weblets named like a command, and their quick links
+			run: func(wm *WebletManager, inv *invocation) error {
+				name := inv.args[0]
+				pageURL, overrides, err := parseOverrides(inv)
+				if err != nil {
+					return err
+				}
+				link := len(inv.args) == 2
+				if link && (inv.has("profile-startup") || pageURL != "") {
+					return errUsage
+				}
+				if pageURL != "" || overrides != (launchOverrides{}) {
+					if err := wm.overrideLaunch(name, pageURL, overrides); err != nil {
+						return err
+					}
+				}
+				switch {
+				case inv.has("profile-startup"):
+					return wm.ProfileStartup(name)
+				case link:
+					return wm.RunLink(name, inv.args[1])
+				}
+				return wm.Run(name)
+			},
+		},
+		{
+			names: []string{"add"},
+			flags: []flagSpec{
+				{name: "template", value: true}, {name: "allow-scheme", value: true},
+				{name: "width", value: true}, {name: "height", value: true}, {name: "maximized"},
+			},
+			minArgs: 2, maxArgs: 2,
+			summary: []string{
+				"  weblet add <name> <url> - Add weblet without running",
+				"  weblet add --template <template> <name> <url> - Add weblet with a template's settings",
+			},
+			usage: []string{
+				"Usage: weblet add <name> <url> [--template <template>] [--allow-scheme <scheme>]...",
+				"                  [--width <px>] [--height <px>] [--maximized]",
+				"--width, --height and --maximized set the window the weblet opens with",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				name := inv.args[0]
+				url, err := normalizeURL(inv.args[1], allowedSchemes(inv))
+				if err != nil {
+					return wrapError(ErrInvalid, err)
+				}
+				window, err := parseWindowOptions(inv)
+				if err != nil {
+					return err
+				}
+				if err := wm.Add(name, url, inv.value("template"), window); err != nil {
+					return err
+				}
+				fmt.Print(T("Added weblet '%s' with URL '%s'\n", name, url))
+				return nil
+			},
+		},
+		{
+			names: []string{"template"}, raw: true, maxArgs: -1,
+			summary: []string{"  weblet template [list|show|set|rules|sync|remove] - Manage setting templates"},
+			usage: []string{
+				"Usage: weblet template [list]",
+				"       weblet template show <template>",
+				"       weblet template set <template> <key> [value]   - mode (chrome|native) or any 'weblet set' key",
+				"       weblet template rules <template> [add <rule>|remove <n>|clear]",
+				"       weblet template sync <template>                - Apply template changes to its weblets",
+				"       weblet template remove <template>",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if len(inv.args) == 1 && inv.args[0] != "list" {
+					return errUsage
+				}
+				return wm.Template(inv.args)
+			},
+		},
+		{
+			names:   []string{"stop"},
+			flags:   []flagSpec{{name: "group", value: true}},
+			maxArgs: -1,
+			summary: []string{"  weblet stop <name>...|--group <group> - Close running weblets gracefully"},
+			usage: []string{
+				"Usage: weblet stop <name>...",
+				"       weblet stop --group <group>",
+				"Closes the windows of running weblets, or ends their processes if they don't close",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if inv.has("group") == (len(inv.args) > 0) {
+					return errUsage
+				}
+				names := inv.args
+				if inv.has("group") {
+					var err error
+					if names, err = wm.groupMembers(inv.value("group")); err != nil {
+						return err
+					}
+				}
+				return wm.Stop(names)
+			},
+		},
+		{
+			names: []string{"launch"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet launch <group>   - Run all weblets of a group"},
+			usage: []string{
+				"Usage: weblet launch <group>",
+				"Runs the weblets of a group that aren't running yet",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Launch(inv.args[0])
+			},
+		},
+		{
+			names: []string{"group"}, raw: true, maxArgs: -1,
+			summary: []string{"  weblet group add|remove|list <group> ... - Manage groups of weblets to launch and stop together"},
+			usage: []string{
+				"Usage: weblet group add <group> <name>...",
+				"       weblet group remove <group> [<name>...]",
+				"       weblet group list [group]",
+				"Without names, remove takes all weblets out of the group",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Group(inv.args)
+			},
+		},
+		{
+			names: []string{"remove"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet remove <name>    - Remove weblet"},
+			usage:   []string{"Usage: weblet remove <name>"},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if err := wm.Remove(inv.args[0]); err != nil {
+					return err
+				}
+				fmt.Print(T("Removed weblet '%s'\n", inv.args[0]))
+				return nil
+			},
+		},
+		{
+			names:   []string{"clone"},
+			flags:   []flagSpec{{name: "copy-data"}},
+			minArgs: 2, maxArgs: 2,
+			summary: []string{"  weblet clone <name> <new-name> [--copy-data] - Copy a weblet, e.g. for a second account"},
+			usage: []string{
+				"Usage: weblet clone <name> <new-name> [--copy-data]",
+				"Copies a weblet's settings to a new name; --copy-data also copies logins and site data",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Clone(inv.args[0], inv.args[1], inv.has("copy-data"))
+			},
+		},
+		{
+			names: []string{"rename"}, minArgs: 2, maxArgs: 2,
+			summary: []string{"  weblet rename <name> <new-name> - Rename a weblet, keeping its logins and data"},
+			usage: []string{
+				"Usage: weblet rename <name> <new-name>",
+				"Renames a weblet and moves its profile, icons, saved logins and desktop file",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Rename(inv.args[0], inv.args[1])
+			},
+		},
+		{
+			names: []string{"edit"},
+			flags: []flagSpec{
+				{name: "url", value: true}, {name: "chrome"}, {name: "native"},
+				{name: "width", value: true}, {name: "height", value: true}, {name: "maximized"},
+				{name: "allow-scheme", value: true},
+			},
+			minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet edit <name> [--url <url>] [--chrome|--native] [--width <px>] [--height <px>] [--maximized] - Change a weblet in place"},
+			usage: []string{
+				"Usage: weblet edit <name> [--url <url>] [--chrome|--native] [--width <px>] [--height <px>] [--maximized]",
+				"Changes a weblet in place, keeping its logins, icons and settings",
+				"--width and --height without --maximized open the window at that size, not maximized",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				edit, err := parseEdit(inv)
+				if err != nil {
+					return err
+				}
+				return wm.Edit(inv.args[0], edit)
+			},
+		},
+		{
+			names: []string{"export-desktop"}, minArgs: 2, maxArgs: 2,
+			summary: []string{"  weblet export-desktop <name> <dir> - Desktop file and icon for another machine"},
+			usage: []string{
+				"Usage: weblet export-desktop <name> <dir>",
+				"Writes a desktop file and icon that run the weblet on another machine or in a kiosk image",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.ExportDesktop(inv.args[0], inv.args[1])
+			},
+		},
+		{
+			names: []string{"link"}, raw: true, minArgs: 2, maxArgs: -1,
+			summary: []string{
+				"  weblet link add|remove|list <name> ... - Manage quick links to pages of a weblet",
+				"  weblet <name> <link>    - Open a quick link",
+			},
+			usage: []string{
+				"Usage: weblet link add <name> <link> <url>",
+				"       weblet link remove <name> <link>",
+				"       weblet link list <name>",
+				"Open a link with 'weblet <name> <link>'",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Link(inv.args)
+			},
+		},
+		{
+			names: []string{"storage"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet storage <name>   - Show service workers and site data sizes"},
+			usage:   []string{"Usage: weblet storage <name>"},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Storage(inv.args[0])
+			},
+		},
+		{
+			names:   []string{"devices"},
+			summary: []string{"  weblet devices          - List microphones and cameras for the microphone/camera settings"},
+			usage: []string{
+				"Usage: weblet devices",
+				"Lists microphones and cameras for the microphone/camera settings",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Devices()
+			},
+		},
+		{
+			names: []string{"history"}, minArgs: 1, maxArgs: 2,
+			summary: []string{"  weblet history <name> [query] - Search the pages a weblet visited"},
+			usage: []string{
+				"Usage: weblet history <name> [query]",
+				"Lists recently visited pages, optionally only those whose URL or title contains the query",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				query := ""
+				if len(inv.args) == 2 {
+					query = inv.args[1]
+				}
+				return wm.History(inv.args[0], query)
+			},
+		},
+		{
+			names:   []string{"audit"},
+			flags:   []flagSpec{{name: "all"}},
+			minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet audit <name> [--all] - What a weblet's pages were allowed to do (native mode)"},
+			usage: []string{
+				"Usage: weblet audit <name> [--all]",
+				"Lists permission grants, clipboard access, downloads, pages opened outside and certificate errors",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				limit := auditLimit
+				if inv.has("all") {
+					limit = 0
+				}
+				return wm.Audit(inv.args[0], limit)
+			},
+		},
+		{
+			names: []string{"autofill"}, raw: true, minArgs: 2, maxArgs: -1,
+			summary: []string{"  weblet autofill list|add|remove <name> ... - Manage the saved logins of a weblet"},
+			usage: []string{
+				"Usage: weblet autofill list <name>",
+				"       weblet autofill add <name> <origin> <username>",
+				"       weblet autofill remove <name> <origin> [username]",
+				"Logins are kept in the keyring; turn autofill on with 'weblet set <name> autofill on'",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Autofill(inv.args)
+			},
+		},
+		{
+			names: []string{"open-in-browser"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet open-in-browser <name> - Open the page shown in the default browser"},
+			usage:   []string{"Usage: weblet open-in-browser <name>"},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.OpenInBrowser(inv.args[0])
+			},
+		},
+		{
+			names:   []string{"ctl"},
+			flags:   []flagSpec{{name: "copy"}, {name: "port", value: true}},
+			minArgs: 2, maxArgs: 2,
+			summary: []string{"  weblet ctl <name> url [--copy] - Print (and copy) the URL of the page shown"},
+			usage: []string{
+				"Usage: weblet ctl <name> url [--copy]",
+				"       weblet ctl <name> mute|unmute",
+				"       weblet ctl <name> mini|pip",
+				"       weblet ctl <name> inspect [--port N]",
+				"Prints the URL of the page a running native weblet shows; --copy also copies it",
+				"mini toggles mini mode: a small frameless window above other windows (native mode)",
+				"pip shows the largest playing video in a small window above other windows, or closes it (native mode)",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if inv.args[1] != "inspect" {
+					if inv.has("port") {
+						return errUsage
+					}
+					return wm.Ctl(inv.args[0], inv.args[1], inv.has("copy"))
+				}
+				if inv.has("copy") {
+					return errUsage
+				}
+				port, err := inv.intValue("port", defaultInspectorPort)
+				if err != nil {
+					return err
+				}
+				return wm.Inspect(inv.args[0], port)
+			},
+		},
+		{
+			names: []string{"thumbnail"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet thumbnail <name> - Path of a PNG preview of the window, for switchers and docks"},
+			usage: []string{
+				"Usage: weblet thumbnail <name>",
+				"Prints the path of a PNG preview of the weblet's window, refreshed if it is open",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Thumbnail(inv.args[0])
+			},
+		},
+		{
+			names: []string{"pin", "unpin"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet pin|unpin <name> - Add to or remove from the GNOME dash / KDE task manager"},
+			usage: []string{
+				"Usage: weblet pin|unpin <name>",
+				"Adds or removes the weblet in the GNOME dash or the KDE Plasma task manager",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Pin(inv.args[0], inv.name == "pin")
+			},
+		},
+		{
+			names: []string{"route"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet route <url>      - Open a link in the weblet it belongs to, or the browser"},
+			usage: []string{
+				"Usage: weblet route <url>",
+				"Opens the URL in the weblet it belongs to, otherwise in the browser (see 'config url-router')",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Route(inv.args[0])
+			},
+		},
+		{
+			names: []string{"report"}, minArgs: 1, maxArgs: 2,
+			summary: []string{"  weblet report <name> [dir] - Redacted bundle of versions, settings, crashes and logs for bug reports"},
+			usage: []string{
+				"Usage: weblet report <name> [dir]",
+				"Bundles versions, settings, crashes and logs of a weblet into a redacted tarball for bug reports",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				dir := "."
+				if len(inv.args) == 2 {
+					dir = inv.args[1]
+				}
+				return wm.Report(inv.args[0], dir)
+			},
+		},
+		{
+			names:   []string{"mute", "unmute"},
+			flags:   []flagSpec{{name: "all"}},
+			maxArgs: -1,
+			summary: []string{"  weblet mute|unmute --all - Silence every running weblet"},
+			usage: []string{
+				"Usage: weblet mute|unmute --all",
+				"       weblet mute|unmute <name>...",
+				"Silences every running weblet (or the ones named), e.g. from a keyboard shortcut",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if inv.has("all") == (len(inv.args) > 0) {
+					return errUsage
+				}
+				return wm.SetMuted(inv.args, inv.has("all"), inv.name == "mute")
+			},
+		},
+		{
+			names: []string{"refresh"},
+			flags: []flagSpec{
+				{name: "all"}, {name: "jobs", value: true},
+				{name: "icon-cookie", value: true}, {name: "icon-header", value: true},
+			},
+			maxArgs: 1,
+			summary: []string{
+				"  weblet refresh <name>   - Refresh icon and desktop file",
+				"  weblet refresh --all [--jobs N] - Refresh all weblets in parallel",
+			},
+			usage: []string{
+				"Usage: weblet refresh <name> [--icon-cookie name=value]... [--icon-header 'Name: value']...",
+				"       weblet refresh --all [--jobs N]",
+				"Re-downloads the icon and updates the desktop file",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if inv.has("all") {
+					if len(inv.args) > 0 || inv.has("icon-cookie") || inv.has("icon-header") {
+						return errUsage
+					}
+					jobs, err := inv.intValue("jobs", 4)
+					if err != nil {
+						return err
+					}
+					return wm.RefreshAll(jobs)
+				}
+				if len(inv.args) == 0 || inv.has("jobs") {
+					return errUsage
+				}
+				// Credentials for icons of apps behind SSO
+				wm.iconAuth.Cookies = inv.flags["icon-cookie"]
+				wm.iconAuth.Headers = inv.flags["icon-header"]
+				return wm.Refresh(inv.args[0])
+			},
+		},
+		{
+			names: []string{"native"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet native <name>    - Toggle native mode (lighter, no WebRTC)"},
+			usage: []string{
+				"Usage: weblet native <name>",
+				"Toggles native webview mode (lighter weight, but no WebRTC audio)",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				name := inv.args[0]
+				weblet, exists := wm.weblets[name]
+				if !exists {
+					return newError(ErrNotFound, "weblet '%s' not found", name)
+				}
+				// Toggle native mode (inverse of Chrome mode)
+				return wm.SetChromeMode(name, !weblet.UseChrome)
+			},
+		},
+		{
+			names: []string{"rules"}, raw: true, minArgs: 1, maxArgs: -1,
+			summary: []string{"  weblet rules <name> [add <rule>|remove <n>|clear] - Manage request rules"},
+			usage: []string{
+				"Usage: weblet rules <name> [add <rule>|remove <n>|clear]",
+				"Rules (native mode only):",
+				"  block <pattern>             - Block requests matching a glob, e.g. https://*/analytics.js",
+				"  redirect <from> <to>        - Rewrite URL prefixes, e.g. http:// https://",
+				"  allow <pattern>             - Keep matching hosts/URLs in the window, open others externally",
+				"  deny <pattern>              - Show a block page for matching hosts/URLs, e.g. ads.*",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Rules(inv.args[0], inv.args[1:])
+			},
+		},
+		{
+			names: []string{"set"}, raw: true, minArgs: 2, maxArgs: 3,
+			summary: []string{"  weblet set <name> <key> [value] - Change a setting (omit value to reset)"},
+			usage: []string{
+				"Usage: weblet set <name> <key> [value]",
+				"Settings:",
+				"  local-dir <dir>             - Serve a directory as weblet-local:// (native mode)",
+				"  health-check on|off         - Check reachability before launching",
+				"  on-unreachable <command>    - Shell hook run when unreachable (e.g. start VPN)",
+				"  netns <namespace>           - Run the weblet inside a network namespace (e.g. VPN)",
+				"  tor on|off                  - Route traffic through Tor with a hardened profile",
+				"  js on|off                   - Enable or disable JavaScript",
+				"  images on|off               - Enable or disable loading images",
+				"  webgl on|off                - Enable or disable WebGL",
+				"  privacy on|off              - Reduce fingerprinting and tracking (user agent, timezone, canvas, cookies)",
+				"  dnt on|off                  - Send the Do Not Track header",
+				"  gpc on|off                  - Send the Global Privacy Control signal (native mode)",
+				"  bridge on|off               - Inject the window.weblet JS bridge (native mode)",
+				"  header-bar on|off           - Header bar with progress, unread count and menu (native mode)",
+				"  decorations on|off          - Title bar of the window; off makes it frameless (native mode)",
+				"  window-css <css>|<file.css> - GTK CSS for the header bar and window (native mode)",
+				"  startup-js <script>         - JavaScript run when a page finished loading (native mode)",
+				"  color-scheme system|dark|light - Follow the desktop's dark style or keep one (native mode)",
+				"  clipboard ask|allow|deny    - Whether pages may read the clipboard (native mode)",
+				"  browser <app.desktop>|<command> - Browser for links opened outside the window (native mode)",
+				"  location <lat,lon>          - Report a fixed location to pages (native mode)",
+				"  microphone <name>           - Default microphone, part of its name (see 'weblet devices')",
+				"  camera <name>               - Default camera, part of its name (see 'weblet devices')",
+				"  history on|off              - Record visited pages, search them with Ctrl+H (native mode)",
+				"  autofill on|off             - Save logins in the keyring and fill them in (native mode)",
+				"  idle-away on|off|<minutes>  - Show chat apps as away while you are idle (native mode)",
+				"  prewarm on|off              - Load the weblet hidden at login, for an instant first open (native mode)",
+				"  keep-alive on|off|<minutes> - Request the page periodically so the login doesn't time out (native mode)",
+				"  keep-alive-reload on|off    - Reload the page for keep-alive while the window isn't used",
+				"  keep-alive-hibernated on|off - Keep the login alive after memory-saver closed the weblet",
+				"  service-workers on|off      - Enable or disable service workers (native mode)",
+				"  offline-cache on|off        - Enable or disable offline caches of pages (native mode)",
+				"  pause-on-lock on|off        - Pause audio and video when the screen locks (default on, native mode)",
+				"  cache-size <size>           - Limit the disk cache, e.g. 50M or 1G",
+				"  cache disk|memory-only      - Keep the cache off the disk (cleared on close/logout)",
+				"  theme-color <#rrggbb>       - Window tint (default: the site's theme-color)",
+				"  icon-style <style>          - adaptive (default), rounded or raw; applied on refresh",
+				"  icon-light <file>           - Icon for light desktop themes; applied on refresh",
+				"  icon-dark <file>            - Icon for dark desktop themes; applied on refresh",
+				"  hidden on|off               - Leave the weblet out of the app grid",
+				"  window-size <width>x<height> - Window size, e.g. 1400x900 (default 1200x800)",
+				"  maximized on|off            - Open the window maximized",
+				"  zoom <level>                - Page zoom, e.g. 1.25 or 125%",
+				"  mini on|off                 - Open as a small frameless window above others, Ctrl+Shift+M toggles (native mode)",
+				"  mini-size <width>x<height>  - Size of the mini window (default 480x270)",
+				"  mini-opacity <percent>      - Opacity of the mini window, e.g. 80%",
+				"  user-agent <string>         - User agent sent instead of the browser's (not with privacy or tor)",
+				"  container <name>            - Use the separate logins of a container, default for the weblet's own",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				value := ""
+				if len(inv.args) == 3 {
+					value = inv.args[2]
+				}
+				return wm.Set(inv.args[0], inv.args[1], value)
+			},
+		},
+		{
+			names:   []string{"apply"},
+			flags:   []flagSpec{{name: "prune"}, {name: "dry-run"}, {name: "authenticate"}},
+			minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet apply <manifest.yaml> [--prune] [--dry-run] - Declaratively sync weblets"},
+			usage: []string{
+				"Usage: weblet apply <manifest.yaml> [--prune] [--dry-run] [--authenticate]",
+				"Creates and updates weblets to match a manifest; --prune removes weblets not listed",
+				"--authenticate logs in weblets with an auth block that aren't authorized yet",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if err := wm.Apply(inv.args[0], inv.has("prune"), inv.has("dry-run")); err != nil {
+					return err
+				}
+				if inv.has("authenticate") && !inv.has("dry-run") {
+					return wm.AuthenticateAll()
+				}
+				return nil
+			},
+		},
+		{
+			names:   []string{"auth"},
+			flags:   []flagSpec{{name: "force"}},
+			minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet auth <name> [--force] - Log a weblet in with a device code (kiosks, system installs)"},
+			usage: []string{
+				"Usage: weblet auth <name> [--force]",
+				"Logs in a weblet with the OAuth device flow of its auth block; --force gets a new token even if the saved one is valid",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Authenticate(inv.args[0], inv.has("force"))
+			},
+		},
+		{
+			names:   []string{"lock"},
+			flags:   []flagSpec{{name: "kiosk"}},
+			minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet lock <name> [--kiosk] - Protect settings with a passphrase"},
+			usage: []string{
+				"Usage: weblet lock <name> [--kiosk]",
+				"Protects settings, URL and removal with a passphrase (stored in the keyring)",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Lock(inv.args[0], inv.has("kiosk"))
+			},
+		},
+		{
+			names: []string{"unlock"}, minArgs: 1, maxArgs: 1,
+			summary: []string{"  weblet unlock <name>    - Remove passphrase protection"},
+			usage:   []string{"Usage: weblet unlock <name>"},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Unlock(inv.args[0])
+			},
+		},
+		{
+			names: []string{"config"}, maxArgs: 2,
+			summary: []string{"  weblet config [<key> <value>] - Show or change global options"},
+			usage: []string{
+				"Usage: weblet config [<key> <value>]",
+				"Options:",
+				"  resume-on-login on|off      - Reopen the weblets that were running at logout",
+				"  resume-hidden on|off        - Start resumed weblets minimized (native mode)",
+				"  memory-saver on|off         - Close unused weblets when memory runs low",
+				"  thumbnails on|off           - Keep window previews up to date for switchers (native mode)",
+				"  appstream on|off            - List weblets in GNOME Software and KDE Discover",
+				"  dynamic-launcher on|off     - Install launchers through the desktop portal (Flatpak)",
+				"  url-router on|off           - Open clicked links in matching weblets, others in the browser",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				switch len(inv.args) {
+				case 0:
+					return wm.SetConfig("", "")
+				case 2:
+					return wm.SetConfig(inv.args[0], inv.args[1])
+				}
+				return errUsage
+			},
+		},
+		{
+			names:   []string{"resume"},
+			summary: []string{"  weblet resume           - Reopen the weblets running at logout"},
+			usage: []string{
+				"Usage: weblet resume",
+				"Reopens the weblets running at logout",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Resume()
+			},
+		},
+		{
+			names:   []string{"memory-watch"},
+			summary: []string{"  weblet memory-watch     - Close unused weblets when memory runs low (memory-saver)"},
+			usage: []string{
+				"Usage: weblet memory-watch",
+				"Closes unused weblets when memory runs low (memory-saver)",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.WatchMemory()
+			},
+		},
+		{
+			names: []string{"prewarm"}, maxArgs: -1,
+			summary: []string{"  weblet prewarm [<name>...] - Load weblets hidden so they open instantly"},
+			usage: []string{
+				"Usage: weblet prewarm [<name>...]",
+				"Loads weblets in hidden windows so they open instantly; without names, those with 'prewarm' on",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				return wm.Prewarm(inv.args)
+			},
+		},
+		{
+			names: []string{"cache"}, minArgs: 2, maxArgs: 2,
+			summary: []string{"  weblet cache warm <name> - Load a weblet hidden once to fill its caches"},
+			usage: []string{
+				"Usage: weblet cache warm <name>",
+				"Loads the weblet hidden once, so its service worker and HTTP cache are filled for a fast next start",
+			},
+			run: func(wm *WebletManager, inv *invocation) error {
+				if inv.args[0] != "warm" {
+					return errUsage
+				}
+				return wm.WarmCache(inv.args[1])
+			},
+		},
+	}
+}
+
+// runWeblet runs 'weblet <name>', adds or updates the weblet with
+// 'weblet <name> <url>' and opens a quick link with 'weblet <name> <link>'
+func runWeblet(wm *WebletManager, inv *invocation) error {
+	name := inv.args[0]
+	if len(inv.args) == 1 {
+		return wm.Run(name)
+	}
+	arg := inv.args[1]
+
+	// weblet <name> <link> opens a quick link
+	if weblet, exists := wm.weblets[name]; exists && len(weblet.Links) > 0 &&
+		linkNamePattern.MatchString(arg) && !strings.Contains(arg, ".") {
+		// A mistyped link name mustn't replace the weblet's URL
+		return wm.RunLink(name, arg)
+	}
+
+	url, err := normalizeURL(arg, allowedSchemes(inv))
+	if err != nil {
+		return wrapError(ErrInvalid, err)
+	}
+	if existingWeblet, exists := wm.weblets[name]; exists {
+		if existingWeblet.URL == url {
+			// Same URL - just run it (idempotent behavior)
+			fmt.Print(T("Weblet '%s' already exists with this URL\n", name))
+		} else {
+			// Different URL - update it
+			if err := wm.checkEditable(existingWeblet); err != nil {
+				return err
+			}
+			existingWeblet.URL = url
+			if err := wm.saveWeblets(); err != nil {
+				return fmt.Errorf("saving weblets: %w", err)
+			}
+			fmt.Print(T("Updated weblet '%s' with new URL '%s'\n", name, url))
+		}
+	} else {
+		// Weblet doesn't exist - add it
+		if err := wm.Add(name, url, "", windowOptions{}); err != nil {
+			return err
+		}
+		fmt.Print(T("Added weblet '%s' with URL '%s'\n", name, url))
+	}
+	return wm.Run(name)
+}
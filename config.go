@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config holds global options, set with 'weblet config <key> <value>' and
+// saved in config.json next to weblets.json
+type Config struct {
+	ResumeOnLogin   bool `json:"resume_on_login,omitempty"`  // Relaunch weblets running at logout
+	ResumeHidden    bool `json:"resume_hidden,omitempty"`    // Start resumed weblets minimized
+	MemorySaver     bool `json:"memory_saver,omitempty"`     // Close unused weblets when memory runs low
+	Thumbnails      bool `json:"thumbnails,omitempty"`       // Save window previews periodically
+	AppStream       bool `json:"appstream,omitempty"`        // Write metainfo files for software centers
+	DynamicLauncher bool `json:"dynamic_launcher,omitempty"` // Install desktop entries through the portal in a Flatpak
+	URLRouter       bool `json:"url_router,omitempty"`       // Open clicked links in matching weblets
+
+	RouterBrowser string `json:"router_browser,omitempty"` // Default browser before the URL router, gets other links
+}
+
+// configFile is config.json in the config directory, or the file given with
+// --config (WEBLET_CONFIG)
+func (wm *WebletManager) configFile() string {
+	if file := os.Getenv("WEBLET_CONFIG"); file != "" {
+		return file
+	}
+	return filepath.Join(wm.configDir, "config.json")
+}
+
+// setConfigFile makes file the global options file of this invocation and
+// the processes it starts
+func setConfigFile(file string) error {
+	if file == "" {
+		return newError(ErrInvalid, "--config needs a file")
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	os.Setenv("WEBLET_CONFIG", file)
+	return nil
+}
+
+func (wm *WebletManager) loadConfig() error {
+	data, err := os.ReadFile(wm.configFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, &wm.config)
+}
+
+func (wm *WebletManager) saveConfig() error {
+	data, err := json.MarshalIndent(wm.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(wm.configFile(), data, 0644)
+}
+
+// SetConfig changes a global option, or lists them without a key
+func (wm *WebletManager) SetConfig(key, value string) error {
+	if key == "" {
+		fmt.Print(T("resume-on-login: %s\n", onOff(wm.config.ResumeOnLogin)))
+		fmt.Print(T("resume-hidden: %s\n", onOff(wm.config.ResumeHidden)))
+		fmt.Print(T("memory-saver: %s\n", onOff(wm.config.MemorySaver)))
+		fmt.Print(T("thumbnails: %s\n", onOff(wm.config.Thumbnails)))
+		fmt.Print(T("appstream: %s\n", onOff(wm.config.AppStream)))
+		fmt.Print(T("dynamic-launcher: %s\n", onOff(wm.config.DynamicLauncher)))
+		fmt.Print(T("url-router: %s\n", onOff(wm.config.URLRouter)))
+		return nil
+	}
+
+	switch key {
+	case "resume-on-login":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		if err := wm.setResumeAutostart(enabled); err != nil {
+			return err
+		}
+		wm.config.ResumeOnLogin = enabled
+
+	case "resume-hidden":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		wm.config.ResumeHidden = enabled
+
+	case "memory-saver":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		if err := wm.setMemorySaver(enabled); err != nil {
+			return err
+		}
+		wm.config.MemorySaver = enabled
+
+	case "thumbnails":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		wm.config.Thumbnails = enabled
+
+	case "appstream":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		if err := wm.setAppStream(enabled); err != nil {
+			return err
+		}
+		wm.config.AppStream = enabled
+
+	case "dynamic-launcher":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		wm.config.DynamicLauncher = enabled
+
+	case "url-router":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		if err := wm.setURLRouter(enabled); err != nil {
+			return err
+		}
+		wm.config.URLRouter = enabled
+
+	default:
+		return newError(ErrInvalid, "unknown option '%s'", key)
+	}
+
+	if err := wm.saveConfig(); err != nil {
+		return err
+	}
+	fmt.Print(T("Set %s to '%s'\n", key, value))
+	return nil
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
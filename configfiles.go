@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Settings can also be written by hand in TOML files in the config
+// directory: defaults for all weblets in config.toml
+//
+//	[defaults]
+//	backend = "native"
+//	window-size = "1400x900"
+//	header-bar = true
+//	user-agent = "Mozilla/5.0 (X11; Linux x86_64) ..."
+//
+// and settings of one weblet in weblets.d/<name>.toml, which win over the
+// defaults:
+//
+//	backend = "chrome"
+//	zoom = 1.25
+//	history = false
+//
+// Keys are those of 'weblet set' plus backend (native or chrome). The files
+// only fill in what weblets.json leaves at the default, so 'weblet set' and
+// 'weblet edit' win over them, and the options of 'weblet run' win over
+// both. The backend of a weblet's own file always applies; the one of the
+// defaults is used by 'weblet add'. Nothing of the files is written to
+// weblets.json.
+
+// fileConfig is config.toml
+type fileConfig struct {
+	Defaults map[string]any `toml:"defaults"`
+}
+
+// fileSetting is what the TOML files changed in a weblet, so saving it
+// writes the weblet's own values. They are kept by name, since rename and
+// apply put new weblets in place of the loaded ones.
+type fileSetting struct {
+	stored  Weblet // As loaded from weblets.json
+	applied Weblet // With the files applied
+}
+
+// setOnlyKeys are settings with side effects outside the weblet, which
+// only 'weblet set' changes
+var setOnlyKeys = []string{"prewarm", "hidden", "icon-light", "icon-dark"}
+
+// loadConfigFiles reads config.toml and weblets.d and applies them to the
+// weblets
+func (wm *WebletManager) loadConfigFiles() error {
+	configFile := filepath.Join(wm.configDir, "config.toml")
+	var config fileConfig
+	if _, err := toml.DecodeFile(configFile, &config); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", configFile, err)
+	}
+	wm.defaults = config.Defaults
+
+	own := make(map[string]map[string]any)
+	files, _ := filepath.Glob(wm.webletConfigFile("*"))
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".toml")
+		if _, exists := wm.weblets[name]; !exists {
+			debugf("ignoring %s, there is no weblet '%s'", file, name)
+			continue
+		}
+		settings := make(map[string]any)
+		if _, err := toml.DecodeFile(file, &settings); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		own[name] = settings
+	}
+
+	if len(wm.defaults) == 0 && len(own) == 0 {
+		return nil
+	}
+	wm.fileSettings = make(map[string]*fileSetting)
+	for _, name := range wm.sortedNames() {
+		weblet := wm.weblets[name]
+		if weblet.System {
+			continue
+		}
+		settings := make(map[string]any)
+		sources := make(map[string]string)
+		for key, value := range wm.defaults {
+			if key != "backend" {
+				settings[key], sources[key] = value, configFile
+			}
+		}
+		for key, value := range own[name] {
+			settings[key], sources[key] = value, wm.webletConfigFile(name)
+		}
+		if len(settings) == 0 {
+			continue
+		}
+
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		stored := *weblet
+		for _, key := range keys {
+			if err := wm.applyFileSetting(weblet, key, fmt.Sprint(settings[key])); err != nil {
+				return fmt.Errorf("%s: %s: %w", sources[key], key, err)
+			}
+		}
+		wm.fileSettings[name] = &fileSetting{stored: stored, applied: *weblet}
+	}
+	return nil
+}
+
+// webletConfigFile returns the weblets.d file of a weblet
+func (wm *WebletManager) webletConfigFile(name string) string {
+	return filepath.Join(wm.configDir, "weblets.d", name+".toml")
+}
+
+// applyFileSetting applies a setting of the TOML files to a weblet, unless
+// the weblet set what it changes itself
+func (wm *WebletManager) applyFileSetting(weblet *Weblet, key, value string) error {
+	if slices.Contains(setOnlyKeys, key) {
+		return fmt.Errorf("can only be changed with 'weblet set'")
+	}
+	if key == "backend" {
+		useChrome, err := parseBackend(value)
+		if err != nil {
+			return err
+		}
+		weblet.UseChrome = useChrome
+		return nil
+	}
+
+	// The size the window was left at is kept, so window-size and
+	// maximized are set directly
+	changed := *weblet
+	switch key {
+	case "window-size":
+		width, height, err := parseWindowSize(value)
+		if err != nil {
+			return err
+		}
+		changed.Width, changed.Height = width, height
+	case "maximized":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return err
+		}
+		changed.Maximized = enabled
+	default:
+		if _, _, err := wm.applySetting(&changed, key, value); err != nil {
+			return err
+		}
+	}
+
+	current, updated := reflect.ValueOf(weblet).Elem(), reflect.ValueOf(changed)
+	for i := range current.NumField() {
+		if !current.Field(i).IsZero() && !reflect.DeepEqual(current.Field(i).Interface(), updated.Field(i).Interface()) {
+			return nil
+		}
+	}
+	*weblet = changed
+	return nil
+}
+
+// parseBackend parses native or chrome
+func parseBackend(value string) (useChrome bool, err error) {
+	switch value {
+	case "chrome":
+		return true, nil
+	case "native":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid backend '%s' (expected native or chrome)", value)
+}
+
+// savedWeblet returns a weblet as it is written to weblets.json: fields the
+// TOML files set and that weren't changed since are the weblet's own again
+func (wm *WebletManager) savedWeblet(weblet *Weblet) Weblet {
+	saved := *weblet
+	file, ok := wm.fileSettings[weblet.Name]
+	if !ok {
+		return saved
+	}
+	current := reflect.ValueOf(&saved).Elem()
+	stored, applied := reflect.ValueOf(file.stored), reflect.ValueOf(file.applied)
+	for i := range current.NumField() {
+		if reflect.DeepEqual(current.Field(i).Interface(), applied.Field(i).Interface()) {
+			current.Field(i).Set(stored.Field(i))
+		}
+	}
+	return saved
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Containers keep separate logins of one weblet, e.g. a personal and a work
+// account of the same site, without a second weblet:
+//
+//	weblet set mail container work   # from now on
+//	weblet run mail --container work  # this launch only
+//
+// A native window keeps the site data of a container (cookies, storage,
+// cache) in data/<name>/containers/<container> and Chrome in the profile
+// container-<container> of the weblet's user data directory. Without a
+// container the weblet uses its own. Settings, history and the window size
+// are shared. A weblet runs in one container at a time. Containers are per
+// window, not per tab, as weblet windows have no tabs.
+
+// parseContainer parses a container name; empty and "default" are the
+// weblet's own data
+func parseContainer(value string) (string, error) {
+	if value == "" || value == "default" {
+		return "", nil
+	}
+	if !linkNamePattern.MatchString(value) {
+		return "", fmt.Errorf("invalid container '%s' (use letters, digits and dashes)", value)
+	}
+	return value, nil
+}
+
+// launchContainer returns the container of this launch of a weblet: its
+// setting, unless 'weblet run --container' chose another
+func (wm *WebletManager) launchContainer(weblet *Weblet) string {
+	switch wm.overrides.container {
+	case "":
+		return weblet.Container
+	case "default":
+		return ""
+	}
+	return wm.overrides.container
+}
+
+// webkitSiteDir returns where the native window keeps the site data of a
+// weblet's container
+func (wm *WebletManager) webkitSiteDir(name, container string) string {
+	if container == "" {
+		return filepath.Join(wm.dataDir, "data", name)
+	}
+	return filepath.Join(wm.dataDir, "data", name, "containers", container)
+}
+
+// chromeProfileName returns the Chrome profile directory of a container
+func chromeProfileName(container string) string {
+	if container == "" {
+		return "Default"
+	}
+	return "container-" + container
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// currentURL returns the URL of the page a weblet is showing, asked from
+// its running native window, or the weblet's URL if that isn't possible
+func (wm *WebletManager) currentURL(weblet *Weblet) (string, bool) {
+	if weblet.UseChrome || wm.runningPID(weblet) == 0 {
+		return weblet.URL, false
+	}
+	uri, err := view.Query(weblet.Name, "url")
+	if err != nil || uri == "" {
+		return weblet.URL, false
+	}
+	return uri, true
+}
+
+// OpenInBrowser opens the page a weblet is showing in the default browser,
+// e.g. to use browser extensions on it
+func (wm *WebletManager) OpenInBrowser(name string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+
+	target, current := wm.currentURL(weblet)
+	if !current {
+		fmt.Print(T("Weblet '%s' isn't running in native mode, opening its start page\n", name))
+	}
+
+	if err := openInBrowser(weblet, target); err != nil {
+		return fmt.Errorf("failed to open browser: %w", err)
+	}
+	if weblet.Browser != "" {
+		fmt.Print(T("Opened %s in %s\n", target, weblet.Browser))
+	} else {
+		fmt.Print(T("Opened %s in the default browser\n", target))
+	}
+	return nil
+}
+
+// openInBrowser opens a URL in the weblet's browser setting, a desktop file
+// ID or a command line, or in the default browser
+func openInBrowser(weblet *Weblet, uri string) error {
+	switch {
+	case weblet.Browser == "":
+		return openURI(uri)
+	case strings.HasSuffix(weblet.Browser, ".desktop"):
+		desktopFilePath := findDesktopFile(weblet.Browser)
+		if desktopFilePath == "" {
+			return fmt.Errorf("browser '%s' not found", weblet.Browser)
+		}
+		return hostCommand("gio", "launch", desktopFilePath, uri).Start()
+	default:
+		args := append(strings.Fields(weblet.Browser), uri)
+		return hostCommand(args[0], args[1:]...).Start()
+	}
+}
+
+// checkBrowser validates the browser setting
+func checkBrowser(browser string) error {
+	if strings.HasSuffix(browser, ".desktop") {
+		if findDesktopFile(browser) == "" {
+			return fmt.Errorf("no desktop file '%s' found in the applications directories", browser)
+		}
+		return nil
+	}
+	fields := strings.Fields(browser)
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid browser '%s'", browser)
+	}
+	if _, err := hostLookPath(fields[0]); err != nil {
+		return fmt.Errorf("browser '%s' not found", fields[0])
+	}
+	return nil
+}
+
+// Ctl runs a command against a running weblet; 'url' prints the URL of the
+// page shown, with copyURL also placing it on the clipboard, 'mute' and
+// 'unmute' silence it or turn its sound back on, 'mini' toggles mini mode
+// and 'pip' picture in picture
+func (wm *WebletManager) Ctl(name, command string, copyURL bool) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	switch command {
+	case "mute", "unmute":
+		return wm.SetMuted([]string{name}, false, command == "mute")
+	case "mini", "pip":
+		return wm.windowCommand(weblet, command)
+	case "url":
+	default:
+		return newError(ErrInvalid, "unknown command '%s'", command)
+	}
+
+	uri, current := wm.currentURL(weblet)
+	if !current {
+		if weblet.UseChrome {
+			return newError(ErrInvalid, "the current URL is only available in native mode")
+		}
+		return newError(ErrNotFound, "weblet '%s' isn't running", name)
+	}
+	fmt.Println(uri)
+
+	if copyURL {
+		// Clipboard tools first, the window itself (GTK) otherwise
+		if err := copyToClipboard(uri); err != nil {
+			if _, err := view.Query(name, "copy-url"); err != nil {
+				return fmt.Errorf("failed to copy URL: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// windowCommand sends a command to the native window of a weblet
+func (wm *WebletManager) windowCommand(weblet *Weblet, command string) error {
+	if weblet.UseChrome {
+		return newError(ErrInvalid, "'%s' needs a native window, weblet '%s' runs in Chrome", command, weblet.Name)
+	}
+	if _, err := view.Query(weblet.Name, command); err != nil {
+		return newError(ErrNotFound, "weblet '%s' isn't running", weblet.Name)
+	}
+	return nil
+}
+
+// copyToClipboard puts text on the clipboard with wl-copy, xclip or xsel
+func copyToClipboard(text string) error {
+	var tools [][]string
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, []string{"wl-copy"})
+	}
+	tools = append(tools, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"})
+
+	for _, tool := range tools {
+		if _, err := hostLookPath(tool[0]); err != nil {
+			continue
+		}
+		cmd := hostCommand(tool[0], tool[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return fmt.Errorf("no clipboard tool found (install wl-clipboard or xclip)")
+}
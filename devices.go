@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 'weblet set meet camera "C920"' and 'weblet set discord microphone
+// headset' pick the capture devices a weblet uses by default, matched
+// case-insensitively against part of the device name ('weblet devices'
+// lists them). Chrome keeps default devices in its profile preferences,
+// by ID; the native webview has no such setting, so a page script prefers
+// the device in getUserMedia and lists it first in enumerateDevices.
+
+// captureDevice is a microphone or camera: the name pages see as its
+// label, and the ID Chrome stores
+type captureDevice struct {
+	Name string
+	ID   string
+}
+
+// microphones lists the PulseAudio/PipeWire sources that aren't monitors
+// of an output
+func microphones() []captureDevice {
+	cmd := hostCommand("pactl", "list", "sources")
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	var devices []captureDevice
+	var id string
+	for _, line := range splitLines(string(output)) {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "Name: "); ok {
+			id = value
+		} else if value, ok := strings.CutPrefix(line, "Description: "); ok && id != "" {
+			if !strings.HasSuffix(id, ".monitor") {
+				devices = append(devices, captureDevice{Name: value, ID: id})
+			}
+			id = ""
+		}
+	}
+	return devices
+}
+
+// cameras lists the V4L2 devices; a camera often has a second node for
+// metadata with the same name, only the first is kept
+func cameras() []captureDevice {
+	nodes, _ := filepath.Glob("/sys/class/video4linux/video*")
+	sort.Slice(nodes, func(i, j int) bool {
+		return len(nodes[i]) < len(nodes[j]) || len(nodes[i]) == len(nodes[j]) && nodes[i] < nodes[j]
+	})
+	var devices []captureDevice
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		data, err := os.ReadFile(filepath.Join(node, "name"))
+		name := strings.TrimSpace(string(data))
+		if err != nil || name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		devices = append(devices, captureDevice{Name: name, ID: "/dev/" + filepath.Base(node)})
+	}
+	return devices
+}
+
+// findDevice returns the first device whose name contains label
+func findDevice(devices []captureDevice, label string) (captureDevice, bool) {
+	for _, device := range devices {
+		if strings.Contains(strings.ToLower(device.Name), strings.ToLower(label)) {
+			return device, true
+		}
+	}
+	return captureDevice{}, false
+}
+
+// Devices prints the microphones and cameras that can be chosen
+func (wm *WebletManager) Devices() error {
+	for _, group := range []struct {
+		title   string
+		devices []captureDevice
+	}{
+		{T("Microphones:"), microphones()},
+		{T("Cameras:"), cameras()},
+	} {
+		fmt.Println(group.title)
+		if len(group.devices) == 0 {
+			fmt.Println(T("  none found"))
+		}
+		for _, device := range group.devices {
+			fmt.Printf("  %s\n", device.Name)
+		}
+	}
+
+	var names []string
+	for name, weblet := range wm.weblets {
+		if weblet.Microphone != "" || weblet.Camera != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		fmt.Println(T("Chosen by weblets:"))
+	}
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		var choices []string
+		if weblet.Microphone != "" {
+			choices = append(choices, T("microphone \"%s\"", weblet.Microphone))
+		}
+		if weblet.Camera != "" {
+			choices = append(choices, T("camera \"%s\"", weblet.Camera))
+		}
+		fmt.Printf("  %-16s %s\n", name, strings.Join(choices, ", "))
+	}
+	return nil
+}
+
+// setChromeCaptureDevices stores the weblet's devices as Chrome's defaults.
+// A device that isn't connected is left as it was.
+func setChromeCaptureDevices(userDataDir string, weblet *Weblet) error {
+	defaults := make(map[string]string)
+	for _, choice := range []struct {
+		label   string
+		pref    string
+		devices func() []captureDevice
+	}{
+		{weblet.Microphone, "default_audio_capture_device", microphones},
+		{weblet.Camera, "default_video_capture_device", cameras},
+	} {
+		if choice.label == "" {
+			continue
+		}
+		device, ok := findDevice(choice.devices(), choice.label)
+		if !ok {
+			fmt.Print(T("Warning: No device matching '%s' found\n", choice.label))
+			continue
+		}
+		defaults[choice.pref] = device.ID
+	}
+	if len(defaults) == 0 {
+		return nil
+	}
+
+	return updateChromePreferences(userDataDir, func(prefs map[string]any) bool {
+		media, _ := prefs["media"].(map[string]any)
+		if media == nil {
+			media = make(map[string]any)
+			prefs["media"] = media
+		}
+		changed := false
+		for pref, id := range defaults {
+			if media[pref] != id {
+				media[pref] = id
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// deviceScript returns the page script that prefers the weblet's devices
+// in the native webview
+func deviceScript(weblet *Weblet) string {
+	if weblet.Microphone == "" && weblet.Camera == "" {
+		return ""
+	}
+	preferred, _ := json.Marshal(map[string]string{
+		"audioinput": strings.ToLower(weblet.Microphone),
+		"videoinput": strings.ToLower(weblet.Camera),
+	})
+	return fmt.Sprintf(`(function () {
+	const media = navigator.mediaDevices;
+	if (!media) {
+		return;
+	}
+	const preferred = %s;
+	const matches = (device) => preferred[device.kind] !== "" && device.label.toLowerCase().includes(preferred[device.kind]);
+
+	// Preferred devices first, for pages that take the first one
+	const enumerateDevices = media.enumerateDevices.bind(media);
+	media.enumerateDevices = async () => {
+		const devices = await enumerateDevices();
+		return devices.filter(matches).concat(devices.filter((device) => !matches(device)));
+	};
+
+	// Adds the preferred device to requests that don't ask for a specific
+	// one; returns null if there is nothing to change
+	const choose = async (constraints) => {
+		const devices = await enumerateDevices();
+		const chosen = Object.assign({}, constraints);
+		let changed = false;
+		for (const [key, kind] of [["audio", "audioinput"], ["video", "videoinput"]]) {
+			const wanted = constraints[key];
+			if (!wanted || (typeof wanted === "object" && wanted.deviceId)) {
+				continue;
+			}
+			const device = devices.find((device) => device.kind === kind && matches(device));
+			if (device) {
+				chosen[key] = Object.assign({}, typeof wanted === "object" ? wanted : {}, { deviceId: { exact: device.deviceId } });
+				changed = true;
+			}
+		}
+		return changed ? chosen : null;
+	};
+
+	const getUserMedia = media.getUserMedia.bind(media);
+	media.getUserMedia = async (constraints) => {
+		constraints = constraints || {};
+		const chosen = await choose(constraints);
+		if (chosen) {
+			return getUserMedia(chosen);
+		}
+		// Device names are only known once access was granted: ask again
+		// if the default device isn't the preferred one
+		const stream = await getUserMedia(constraints);
+		const retry = await choose(constraints);
+		const current = stream.getTracks().map((track) => track.getSettings().deviceId);
+		const better = retry && ["audio", "video"].some((key) => retry[key] !== constraints[key] && !current.includes(retry[key].deviceId.exact));
+		if (!better) {
+			return stream;
+		}
+		stream.getTracks().forEach((track) => track.stop());
+		return getUserMedia(retry);
+	};
+})();`, preferred)
+}
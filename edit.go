@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 'weblet edit <name> --url <url> --native --width 1400' changes a weblet in
+// place, keeping its profile, icons and settings. The desktop file is only
+// written again when the URL changed, since it names the site (and the
+// icon comes from it).
+
+// Size of a native window without window-size, as in view.RunWebview
+const (
+	defaultWindowWidth  = 1200
+	defaultWindowHeight = 800
+)
+
+// webletEdit holds the changes of 'weblet edit'; zero values keep what the
+// weblet has
+type webletEdit struct {
+	url    string
+	mode   string // chrome or native
+	window windowOptions
+}
+
+// windowOptions are the --width, --height and --maximized options of
+// 'weblet add' and 'weblet edit'
+type windowOptions struct {
+	width     int
+	height    int
+	maximized bool
+}
+
+// parseWindowOptions reads the window size options of 'weblet add' and
+// 'weblet edit'
+func parseWindowOptions(inv *invocation) (windowOptions, error) {
+	var window windowOptions
+	for _, size := range []struct {
+		flag string
+		n    *int
+	}{{"width", &window.width}, {"height", &window.height}} {
+		if !inv.has(size.flag) {
+			continue
+		}
+		n, err := strconv.Atoi(inv.value(size.flag))
+		if err != nil || n <= 0 {
+			return window, newError(ErrInvalid, "invalid %s '%s' (expected pixels)", size.flag, inv.value(size.flag))
+		}
+		*size.n = n
+	}
+	window.maximized = inv.has("maximized")
+	return window, nil
+}
+
+// parseEdit reads the options of 'weblet edit'
+func parseEdit(inv *invocation) (webletEdit, error) {
+	var edit webletEdit
+	if inv.has("chrome") && inv.has("native") {
+		return edit, newError(ErrInvalid, "--chrome and --native can't be combined")
+	}
+	switch {
+	case inv.has("chrome"):
+		edit.mode = "chrome"
+	case inv.has("native"):
+		edit.mode = "native"
+	}
+	window, err := parseWindowOptions(inv)
+	if err != nil {
+		return edit, err
+	}
+	edit.window = window
+	if inv.value("url") != "" {
+		url, err := normalizeURL(inv.value("url"), allowedSchemes(inv))
+		if err != nil {
+			return edit, wrapError(ErrInvalid, err)
+		}
+		edit.url = url
+	}
+	return edit, nil
+}
+
+// Edit applies the changes of 'weblet edit' to a weblet
+func (wm *WebletManager) Edit(name string, edit webletEdit) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if edit == (webletEdit{}) {
+		return newError(ErrInvalid, "nothing to change, use --url, --chrome, --native, --width, --height or --maximized")
+	}
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+
+	changed := false
+	urlChanged := edit.url != "" && edit.url != weblet.URL
+	if urlChanged {
+		weblet.URL = edit.url
+		weblet.DetectedThemeColor = ""
+		if _, ok := localPath(edit.url); !ok {
+			p := startProgress(T("Detecting theme color of '%s'", name))
+			weblet.DetectedThemeColor = detectThemeColor(edit.url)
+			p.Stop("")
+		}
+		changed = true
+	}
+
+	before := *weblet
+	settings, err := wm.applyWindowOptions(weblet, edit.window)
+	if err != nil {
+		return err
+	}
+	if len(settings) > 0 {
+		changed = true
+	}
+
+	if changed {
+		if err := wm.saveWeblets(); err != nil {
+			return err
+		}
+		if err := wm.settingsSaved(&before, weblet); err != nil {
+			return err
+		}
+	}
+	if urlChanged {
+		fmt.Print(T("Updated weblet '%s' with new URL '%s'\n", name, weblet.URL))
+	}
+	for _, setting := range settings {
+		fmt.Print(T("Set %s for weblet '%s' to '%s'\n", setting[0], name, setting[1]))
+	}
+
+	// Switching copies the logins over, like 'weblet native'
+	if edit.mode != "" && weblet.UseChrome != (edit.mode == "chrome") {
+		if err := wm.SetChromeMode(name, edit.mode == "chrome"); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if !changed {
+		fmt.Print(T("Weblet '%s' already has these settings\n", name))
+		return nil
+	}
+	if urlChanged {
+		if err := wm.createDesktopFile(name, weblet.URL); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Failed to create desktop file: %v\n", err))
+		}
+	}
+	if wm.isRunning(weblet) {
+		fmt.Print(T("Note: the changes apply the next time weblet '%s' opens (weblet stop %s)\n", name, name))
+	}
+	return nil
+}
+
+// applyWindowOptions changes the window-size and maximized settings of a
+// weblet as given with --width, --height and --maximized, and returns the
+// settings changed with their new values. A size without --maximized opens
+// the window at that size, not maximized.
+func (wm *WebletManager) applyWindowOptions(weblet *Weblet, window windowOptions) ([][2]string, error) {
+	var settings [][2]string
+	if window.width > 0 || window.height > 0 {
+		width, height := weblet.Width, weblet.Height
+		if width == 0 {
+			width, height = defaultWindowWidth, defaultWindowHeight
+		}
+		if window.width > 0 {
+			width = window.width
+		}
+		if window.height > 0 {
+			height = window.height
+		}
+		if width != weblet.Width || height != weblet.Height {
+			size, _, err := wm.parseSetting(weblet, "window-size", fmt.Sprintf("%dx%d", width, height))
+			if err != nil {
+				return nil, err
+			}
+			settings = append(settings, [2]string{"window-size", size})
+		}
+	}
+	if (window.maximized || window.width > 0 || window.height > 0) && window.maximized != weblet.Maximized {
+		value, _, err := wm.parseSetting(weblet, "maximized", onOff(window.maximized))
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, [2]string{"maximized", value})
+	}
+	return settings, nil
+}
+
+// parseWindowSize parses a window-size value like 1400x900; empty is the
+// default size (0x0)
+func parseWindowSize(value string) (int, int, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	w, h, ok := strings.Cut(strings.ToLower(value), "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid window size '%s' (expected <width>x<height>, e.g. 1400x900)", value)
+	}
+	return width, height, nil
+}
+
+// forgetWindowSize drops the size a native window was left at, and whether
+// it was maximized, from its window-state.ini, so the next window opens
+// with the configured size
+func (wm *WebletManager) forgetWindowSize(name string) {
+	path := filepath.Join(wm.dataDir, "data", name, "window-state.ini")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var lines []string
+	for _, line := range splitLines(string(data)) {
+		if strings.HasPrefix(line, "width=") || strings.HasPrefix(line, "height=") || strings.HasPrefix(line, "maximized=") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Exit codes, documented in the README for scripts
+const (
+	exitError          = 1 // Any other failure
+	exitUsage          = 2 // Invalid command line or argument
+	exitNotFound       = 3 // Weblet doesn't exist
+	exitExists         = 4 // Weblet already exists
+	exitBrowserMissing = 5 // Chrome/Chromium not installed
+	exitFocusFailed    = 6 // Running window couldn't be focused
+	exitLocked         = 7 // Locked or system-wide weblet, wrong passphrase, profile in use
+	exitUnreachable    = 8 // Site or local server didn't respond
+)
+
+// Error kinds returned (wrapped) by manager functions; test with errors.Is
+var (
+	ErrInvalid        = errors.New("invalid argument")
+	ErrNotFound       = errors.New("weblet not found")
+	ErrExists         = errors.New("weblet already exists")
+	ErrBrowserMissing = errors.New("browser not found")
+	ErrFocusFailed    = errors.New("focus failed")
+	ErrLocked         = errors.New("weblet is locked")
+	ErrUnreachable    = errors.New("site unreachable")
+)
+
+// webletError is an error message of a given kind
+type webletError struct {
+	kind error
+	msg  string
+}
+
+func (e *webletError) Error() string { return e.msg }
+func (e *webletError) Unwrap() error { return e.kind }
+
+// newError formats an error message of the given kind
+func newError(kind error, format string, args ...any) error {
+	return &webletError{kind: kind, msg: T(format, args...)}
+}
+
+// wrapError marks err as being of the given kind, keeping its message
+func wrapError(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &webletError{kind: kind, msg: err.Error()}
+}
+
+// exitCode maps an error to the process exit code
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalid):
+		return exitUsage
+	case errors.Is(err, ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, ErrExists):
+		return exitExists
+	case errors.Is(err, ErrBrowserMissing):
+		return exitBrowserMissing
+	case errors.Is(err, ErrFocusFailed):
+		return exitFocusFailed
+	case errors.Is(err, ErrLocked):
+		return exitLocked
+	case errors.Is(err, ErrUnreachable):
+		return exitUnreachable
+	}
+	return exitError
+}
+
+// fail prints an error and exits with its exit code
+func fail(err error) {
+	fmt.Fprint(os.Stderr, T("Error: %v\n", err))
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ExportDesktop writes a desktop file and the icon of a weblet to dir, for
+// another machine or a kiosk image. The Exec line runs the absolute path
+// of this weblet with the name and URL, which adds the weblet on its first
+// launch there; weblet's own settings aren't included. The Icon line is
+// the absolute path of the copied icon, so export straight to where the
+// files will live (e.g. in the image's file tree).
+func (wm *WebletManager) ExportDesktop(name, dir string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	// The exported entry adds the weblet with 'weblet <name> <url>'
+	if isCommand(name) {
+		return newError(ErrInvalid, "'%s' is a weblet command, rename the weblet before exporting it (weblet rename %s <new-name>)", name, name)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(execPath); err == nil {
+		execPath = resolved
+	}
+	execPath = launcherCommand(execPath)
+	if _, ok := localPath(weblet.URL); ok {
+		fmt.Print(T("Warning: %s must exist on the other machine too\n", weblet.URL))
+	}
+
+	// The biggest installed theme icon, the one createDesktopFile made
+	icon := "web-browser"
+	themeDir, err := wm.hicolorDir()
+	if err != nil {
+		return err
+	}
+	candidates := []string{filepath.Join(themeDir, "scalable", "apps", "weblet-"+name+".svg")}
+	for i := len(themeIconSizes) - 1; i >= 0; i-- {
+		size := themeIconSizes[i]
+		candidates = append(candidates, filepath.Join(themeDir, fmt.Sprintf("%dx%d", size, size), "apps", "weblet-"+name+".png"))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err != nil {
+			continue
+		}
+		icon = filepath.Join(dir, "weblet-"+name+filepath.Ext(candidate))
+		if err := copyFile(candidate, icon); err != nil {
+			return fmt.Errorf("failed to copy icon: %w", err)
+		}
+		break
+	}
+	if icon == "web-browser" {
+		fmt.Print(T("Warning: Weblet '%s' has no icon yet (see 'weblet refresh'), using a generic one\n", name))
+	}
+
+	desktopFile := filepath.Join(dir, "weblet-"+name+".desktop")
+	content := fmt.Sprintf(`[Desktop Entry]
+Version=1.0
+Type=Application
+Name=%s
+Comment=Weblet for %s
+Exec=%s %s %s
+Icon=%s
+Terminal=false
+Categories=Network;WebBrowser;
+StartupNotify=true
+StartupWMClass=weblet-%s
+`,
+		name,
+		weblet.URL,
+		execPath,
+		name,
+		weblet.URL,
+		icon,
+		name,
+	)
+	if err := os.WriteFile(desktopFile, []byte(content), 0755); err != nil {
+		return fmt.Errorf("failed to write desktop file: %w", err)
+	}
+
+	fmt.Print(T("Exported weblet '%s' to %s\n", name, dir))
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Groups tag weblets to open and close them together ('weblet group add
+// work mail calendar slack', 'weblet launch work', 'weblet stop --group
+// work'). A group is only the tag on its weblets, it exists as long as one
+// of them has it.
+
+// groupMembers returns the weblets of a group in order
+func (wm *WebletManager) groupMembers(group string) ([]string, error) {
+	var names []string
+	for name, weblet := range wm.weblets {
+		if slices.Contains(weblet.Groups, group) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, newError(ErrNotFound, "group '%s' not found", group)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Group manages groups: add and remove weblets, list them
+func (wm *WebletManager) Group(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		if len(args) > 2 {
+			return newError(ErrInvalid, "usage: weblet group list [group]")
+		}
+		if len(args) == 2 {
+			return wm.listGroups(args[1])
+		}
+		return wm.listGroups("")
+	}
+	if len(args) < 2 {
+		return newError(ErrInvalid, "usage: weblet group add|remove <group> <name>...")
+	}
+	command, group, names := args[0], args[1], args[2:]
+	if !linkNamePattern.MatchString(group) {
+		return newError(ErrInvalid, "invalid group name '%s' (use letters, digits and '-')", group)
+	}
+
+	switch command {
+	case "add":
+		if len(names) == 0 {
+			return newError(ErrInvalid, "usage: weblet group add <group> <name>...")
+		}
+	case "remove":
+		// Without names the group goes away
+		if len(names) == 0 {
+			members, err := wm.groupMembers(group)
+			if err != nil {
+				return err
+			}
+			names = members
+		}
+	default:
+		return newError(ErrInvalid, "unknown command '%s'", command)
+	}
+	for _, name := range names {
+		weblet, exists := wm.weblets[name]
+		if !exists {
+			return newError(ErrNotFound, "weblet '%s' not found", name)
+		}
+		if err := wm.checkEditable(weblet); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		i := slices.Index(weblet.Groups, group)
+		switch {
+		case command == "add" && i < 0:
+			weblet.Groups = append(weblet.Groups, group)
+			fmt.Print(T("Added weblet '%s' to group '%s'\n", name, group))
+		case command == "add":
+			fmt.Print(T("Weblet '%s' is already in group '%s'\n", name, group))
+		case i >= 0:
+			weblet.Groups = slices.Delete(weblet.Groups, i, i+1)
+			fmt.Print(T("Removed weblet '%s' from group '%s'\n", name, group))
+		default:
+			fmt.Print(T("Weblet '%s' isn't in group '%s'\n", name, group))
+		}
+	}
+	return wm.saveWeblets()
+}
+
+// listGroups prints the groups with their weblets, or the weblets of one
+func (wm *WebletManager) listGroups(group string) error {
+	if group != "" {
+		names, err := wm.groupMembers(group)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	}
+
+	members := map[string][]string{}
+	for _, name := range wm.sortedNames() {
+		for _, group := range wm.weblets[name].Groups {
+			members[group] = append(members[group], name)
+		}
+	}
+	if len(members) == 0 {
+		fmt.Println(T("No groups, add weblets to one with 'weblet group add <group> <name>...'"))
+		return nil
+	}
+	groups := make([]string, 0, len(members))
+	for group := range members {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		fmt.Printf("  %-16s %s\n", group, strings.Join(members[group], ", "))
+	}
+	return nil
+}
+
+// Launch runs the weblets of a group; running ones are left as they are
+func (wm *WebletManager) Launch(group string) error {
+	names, err := wm.groupMembers(group)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		if wm.runningPID(weblet) > 0 || wm.isWebletWindowOpen(name) {
+			fmt.Print(T("Weblet '%s' is already running\n", name))
+			continue
+		}
+		if err := wm.Run(name); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Could not launch weblet '%s': %v\n", name, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d weblet(s) failed to launch", failed)
+	}
+	return nil
+}
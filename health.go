@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// checkReachable reports an error if the weblet URL doesn't answer at all.
+// Any HTTP response (even an error status) counts as reachable.
+func checkReachable(webletURL string) error {
+	client := newHTTPClient(newFetchGuard(webletURL).transport())
+
+	resp, err := client.Get(webletURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// preflight checks that a health-checked weblet is reachable, running its
+// on-unreachable hook (e.g. to start a VPN) and re-checking if it isn't
+func (wm *WebletManager) preflight(weblet *Weblet) bool {
+	if !weblet.HealthCheck {
+		return true
+	}
+
+	err := checkReachable(weblet.URL)
+	if err == nil {
+		return true
+	}
+	fmt.Print(T("Weblet '%s' is unreachable: %v\n", weblet.Name, err))
+
+	if weblet.OnUnreachable == "" {
+		return false
+	}
+
+	fmt.Print(T("Running on-unreachable hook: %s\n", weblet.OnUnreachable))
+	cmd := exec.Command("sh", "-c", weblet.OnUnreachable)
+	cmd.Env = append(os.Environ(), "WEBLET_NAME="+weblet.Name, "WEBLET_URL="+weblet.URL)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Print(T("Warning: on-unreachable hook failed: %v\n", err))
+		return false
+	}
+
+	// Give the hook (VPN, tunnel, ...) some time to take effect
+	for i := 0; i < 15; i++ {
+		if checkReachable(weblet.URL) == nil {
+			return true
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	fmt.Print(T("Weblet '%s' is still unreachable\n", weblet.Name))
+	return false
+}
+
+// unreachablePage renders the error page shown instead of the browser's
+// blank error page when a health-checked weblet can't be reached
+func unreachablePage(weblet *Weblet) string {
+	target, _ := json.Marshal(weblet.URL)
+	name := html.EscapeString(weblet.Name)
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; color: #444; display: flex; align-items: center; justify-content: center; height: 90vh; }
+div { max-width: 32em; text-align: center; }
+button { font-size: 1em; padding: 0.5em 1.5em; margin-top: 1em; }
+</style>
+</head>
+<body>
+<div>
+<h2>%s</h2>
+<p>%s</p>
+<p>%s</p>
+<button onclick="location.href = %s">%s</button>
+</div>
+</body>
+</html>
+`,
+		T("%s - unreachable", name),
+		T("%s is unreachable", name),
+		T("Could not connect to %s.", "<code>"+html.EscapeString(weblet.URL)+"</code>"),
+		html.EscapeString(T("VPN required? Connect to the network this weblet needs and try again.")),
+		html.EscapeString(string(target)),
+		html.EscapeString(T("Retry")))
+}
+
+// writeUnreachablePage stores the error page on disk so Chrome can open it
+func (wm *WebletManager) writeUnreachablePage(weblet *Weblet) (string, error) {
+	pageDir := filepath.Join(wm.cacheDir, "pages")
+	if err := os.MkdirAll(pageDir, 0755); err != nil {
+		return "", err
+	}
+
+	pagePath := filepath.Join(pageDir, weblet.Name+"-unreachable.html")
+	if err := os.WriteFile(pagePath, []byte(unreachablePage(weblet)), 0644); err != nil {
+		return "", err
+	}
+	return pagePath, nil
+}
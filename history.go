@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With 'weblet set <name> history on', a native weblet records the pages it
+// shows on its own site in data/<name>/history.sqlite3; Ctrl+H in the
+// window searches them. 'weblet history <name> [query]' lists them, and
+// reads Chrome's own history for Chrome weblets. Both go through the
+// sqlite3 CLI, like 'weblet storage'.
+
+// historyLimit is the number of visits listed
+const historyLimit = 50
+
+// historyTitle selects a page title that fits on one line of output
+const historyTitle = "replace(replace(ifnull(title, ''), char(9), ' '), char(10), ' ')"
+
+// Chrome stores visit times in microseconds since 1601-01-01
+const chromeEpochOffset = 11644473600
+
+func (wm *WebletManager) historyFile(name string) string {
+	return filepath.Join(wm.dataDir, "data", name, "history.sqlite3")
+}
+
+// historyVisit is a page in the history, with the time of its last visit
+type historyVisit struct {
+	URL       string
+	Title     string
+	VisitedAt time.Time
+}
+
+// History prints the most recent pages of a weblet, optionally only those
+// whose URL or title contains query
+func (wm *WebletManager) History(name, query string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("sqlite3 is needed to read the history (install the sqlite3 package)")
+	}
+
+	var visits []historyVisit
+	var err error
+	if weblet.UseChrome {
+		visits, err = chromeHistory(filepath.Join(wm.dataDir, "chrome-data", name, "Default", "History"), query)
+	} else if _, statErr := os.Stat(wm.historyFile(name)); statErr == nil {
+		visits, err = queryHistory(wm.historyFile(name),
+			"SELECT url, "+historyTitle+", max(visited_at) FROM visits WHERE %s GROUP BY url ORDER BY 3 DESC LIMIT %d", query)
+	} else if !weblet.History {
+		fmt.Print(T("History is off for weblet '%s' (turn it on with 'weblet set %s history on').\n", name, name))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(visits) == 0 && query == "" {
+		fmt.Print(T("No history for weblet '%s' yet.\n", name))
+		return nil
+	}
+	if len(visits) == 0 {
+		fmt.Println(T("No matching pages."))
+		return nil
+	}
+	for _, visit := range visits {
+		title := visit.Title
+		if title == "" {
+			title = visit.URL
+		}
+		fmt.Printf("%s  %s\n", visit.VisitedAt.Format("2006-01-02 15:04"), title)
+		fmt.Printf("                  %s\n", visit.URL)
+	}
+	return nil
+}
+
+// chromeHistory reads the history of a Chrome profile. Chrome keeps the
+// database locked while running, so a copy is read.
+func chromeHistory(database, query string) ([]historyVisit, error) {
+	data, err := os.ReadFile(database)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	temp, err := os.CreateTemp("", "weblet-history-*.sqlite3")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(data)
+	temp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return queryHistory(temp.Name(),
+		"SELECT url, "+historyTitle+", last_visit_time / 1000000 - "+strconv.Itoa(chromeEpochOffset)+
+			" FROM urls WHERE hidden = 0 AND %s ORDER BY last_visit_time DESC LIMIT %d", query)
+}
+
+// queryHistory runs a history query with the sqlite3 CLI. The statement
+// has a %s for the search condition and a %d for the limit, and selects
+// url, title and a Unix time.
+func queryHistory(database, statement, query string) ([]historyVisit, error) {
+	condition := "1"
+	if query != "" {
+		// A literal, as the CLI has no portable way to bind parameters
+		literal := "'" + strings.ReplaceAll(strings.ToLower(query), "'", "''") + "'"
+		condition = fmt.Sprintf("(instr(lower(url), %s) > 0 OR instr(lower(title), %s) > 0)", literal, literal)
+	}
+	output, err := exec.Command("sqlite3", "-readonly", "-cmd", ".timeout 1000", "-separator", "\t", database,
+		fmt.Sprintf(statement, condition, historyLimit)).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("could not read history: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("could not read history: %w", err)
+	}
+
+	var visits []historyVisit
+	for _, line := range splitLines(string(output)) {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		seconds, _ := strconv.ParseInt(fields[2], 10, 64)
+		visits = append(visits, historyVisit{URL: fields[0], Title: fields[1], VisitedAt: time.Unix(seconds, 0)})
+	}
+	return visits, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultFetchTimeout = 10 * time.Second
+	maxFetchRetries     = 3
+	maxFetchRedirects   = 5
+	maxFetchSize        = 10 << 20 // 10 MB per response
+	maxRetryAfter       = 10 * time.Second
+)
+
+// fetchTimeout returns the total timeout of a fetch, configurable with
+// WEBLET_HTTP_TIMEOUT (seconds or a Go duration like 30s)
+func fetchTimeout() time.Duration {
+	value := os.Getenv("WEBLET_HTTP_TIMEOUT")
+	if value == "" {
+		return defaultFetchTimeout
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	fmt.Fprint(os.Stderr, T("Warning: invalid WEBLET_HTTP_TIMEOUT '%s', using %s\n", value, defaultFetchTimeout))
+	return defaultFetchTimeout
+}
+
+// newHTTPClient returns the client used to fetch pages, manifests and icons.
+// Transient failures (network errors, 429 and 5xx gateway errors) are retried
+// with backoff, redirects are capped and response bodies are size limited.
+// Gzip is negotiated and decoded transparently by the default transport.
+func newHTTPClient(base http.RoundTripper) *http.Client {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{
+		Timeout:   fetchTimeout(),
+		Transport: &retryTransport{base: base},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// Pages and manifests name the icons weblet fetches, so a page could point
+// them at the local network or the cloud metadata service (SSRF). The fetch
+// transport only follows http and https URLs and refuses link-local,
+// metadata, multicast and unspecified addresses; loopback and private
+// addresses only for weblets whose own site is on one. Addresses are
+// checked when connecting, which also catches DNS rebinding; with a proxy
+// the name is resolved and checked before the request.
+
+var errFetchBlocked = errors.New("fetch blocked")
+
+// fetchGuard decides which addresses a weblet's fetches may reach
+type fetchGuard struct {
+	allowPrivate bool // The weblet's own site is on a loopback or private address
+}
+
+// newFetchGuard returns the guard for the fetches of a weblet's site
+func newFetchGuard(webletURL string) *fetchGuard {
+	guard := &fetchGuard{}
+	if u, err := url.Parse(webletURL); err == nil {
+		if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+			guard.allowPrivate = addr.IsLoopback() || addr.IsPrivate()
+		} else if u.Hostname() == "localhost" || isLoopbackURL(webletURL) {
+			guard.allowPrivate = true
+		} else if addrs, err := net.DefaultResolver.LookupNetIP(context.Background(), "ip", u.Hostname()); err == nil {
+			for _, addr := range addrs {
+				if addr.IsLoopback() || addr.IsPrivate() {
+					guard.allowPrivate = true
+				}
+			}
+		}
+	}
+	return guard
+}
+
+// check returns an error if an address must not be fetched from
+func (g *fetchGuard) check(addr netip.Addr) error {
+	addr = addr.Unmap()
+	switch {
+	case addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast(), addr.IsMulticast(), addr.IsUnspecified(),
+		addr == netip.MustParseAddr("fd00:ec2::254"):
+		return fmt.Errorf("%w: %s is a link-local or metadata address", errFetchBlocked, addr)
+	case !g.allowPrivate && (addr.IsLoopback() || addr.IsPrivate()):
+		return fmt.Errorf("%w: %s is on the local network", errFetchBlocked, addr)
+	}
+	return nil
+}
+
+// transport returns a transport enforcing the guard on a base transport
+func (g *fetchGuard) transport() http.RoundTripper {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			// The proxy itself may well be on the local network
+			if g.proxied() {
+				return nil
+			}
+			return g.check(addrPort.Addr())
+		},
+	}
+	base.DialContext = dialer.DialContext
+	return &guardTransport{guard: g, base: base}
+}
+
+// proxied reports whether requests go through a proxy from the environment
+func (g *fetchGuard) proxied() bool {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// guardTransport refuses other schemes than http and https, and addresses
+// the guard rejects when a proxy connects for us
+type guardTransport struct {
+	guard *fetchGuard
+	base  http.RoundTripper
+}
+
+func (t *guardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %s URL", errFetchBlocked, req.URL.Scheme)
+	}
+	if t.guard.proxied() {
+		addrs, err := net.DefaultResolver.LookupNetIP(req.Context(), "ip", req.URL.Hostname())
+		if err == nil {
+			for _, addr := range addrs {
+				if err := t.guard.check(addr); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// retryTransport retries idempotent requests on transient failures
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.limit(t.base.RoundTrip(req))
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= maxFetchRetries || !retryable(resp, err) {
+			return t.limit(resp, err)
+		}
+
+		// Honor the server's Retry-After (in seconds) on rate limits
+		wait := backoff
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+				wait = min(time.Duration(seconds)*time.Second, maxRetryAfter)
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// limit caps the size of a response body
+func (t *retryTransport) limit(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > maxFetchSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("response too large: %d bytes", resp.ContentLength)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: maxFetchSize + 1}
+	return resp, nil
+}
+
+// retryable reports whether a failed request is worth repeating
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, errFetchBlocked)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+var errTooLarge = errors.New("response exceeds size limit")
+
+// limitedBody fails reads past the size limit instead of silently truncating
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, errTooLarge
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Translations are JSON catalogs in locales/<lang>.json mapping the English
+// format string to the translated one. Missing entries fall back to English.
+//
+//go:embed locales/*.json
+var localeFS embed.FS
+
+var (
+	catalogOnce sync.Once
+	catalog     map[string]string
+)
+
+// locale returns the language of LC_ALL, LC_MESSAGES or LANG, e.g. "de" for
+// de_DE.UTF-8 (the first one set wins, like gettext)
+func locale() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		lang, _, _ := strings.Cut(value, ".")
+		lang, _, _ = strings.Cut(lang, "@")
+		return lang
+	}
+	return ""
+}
+
+// loadCatalog loads the catalog for the current locale, trying the full
+// name (pt_BR) before the language (pt)
+func loadCatalog() {
+	lang := locale()
+	if lang == "" || lang == "C" || lang == "POSIX" {
+		return
+	}
+
+	candidates := []string{lang}
+	if base, _, ok := strings.Cut(lang, "_"); ok {
+		candidates = append(candidates, base)
+	}
+	for _, candidate := range candidates {
+		data, err := localeFS.ReadFile("locales/" + candidate + ".json")
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			catalog = nil
+			continue
+		}
+		return
+	}
+}
+
+// translate returns the translation of a string, or the string itself
+func translate(text string) string {
+	catalogOnce.Do(loadCatalog)
+	if translated, ok := catalog[text]; ok {
+		return translated
+	}
+	return text
+}
+
+// T translates a format string and formats it like fmt.Sprintf
+func T(format string, args ...any) string {
+	return fmt.Sprintf(translate(format), args...)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// iconAuth holds credentials for fetching icons of apps behind SSO, given
+// on the command line with --icon-cookie and --icon-header
+type iconAuth struct {
+	Cookies []string // "name=value"
+	Headers []string // "Name: value"
+}
+
+// authTransport adds cookies and headers to requests for the weblet's own
+// host only, so credentials never leak to third-party icon services
+type authTransport struct {
+	host    string
+	cookies []string
+	headers http.Header
+	base    http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if host == t.host || strings.HasSuffix(host, "."+t.host) {
+		req = req.Clone(req.Context())
+		for name, values := range t.headers {
+			for _, v := range values {
+				req.Header.Add(name, v)
+			}
+		}
+		for _, c := range t.cookies {
+			req.Header.Add("Cookie", c)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newIconClient returns the HTTP client used to fetch a weblet's icons. It
+// reuses the native webview's stored cookies (if sqlite3 is available) and
+// any credentials given with --icon-cookie / --icon-header.
+func (wm *WebletManager) newIconClient(webletURL, webletName string) (*http.Client, error) {
+	transport := newFetchGuard(webletURL).transport()
+	if len(wm.iconAuth.Cookies) > 0 || len(wm.iconAuth.Headers) > 0 {
+		parsed, err := url.Parse(webletURL)
+		if err != nil {
+			return nil, err
+		}
+
+		headers := make(http.Header)
+		for _, h := range wm.iconAuth.Headers {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid header '%s' (expected 'Name: value')", h)
+			}
+			headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+
+		transport = &authTransport{
+			host:    parsed.Hostname(),
+			cookies: wm.iconAuth.Cookies,
+			headers: headers,
+			base:    transport,
+		}
+	}
+
+	client := newHTTPClient(transport)
+	if jar := wm.nativeCookieJar(webletName); jar != nil {
+		client.Jar = jar
+	}
+	return client, nil
+}
+
+// nativeCookieJar loads the cookies the native webview stored for a weblet.
+// WebKit keeps them in a Firefox-style moz_cookies SQLite table, read here
+// with the sqlite3 CLI.
+func (wm *WebletManager) nativeCookieJar(webletName string) http.CookieJar {
+	cookieFile := filepath.Join(wm.dataDir, "data", webletName, "cookies.sqlite")
+	if _, err := os.Stat(cookieFile); err != nil {
+		return nil
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil
+	}
+
+	output, err := exec.Command("sqlite3", "-readonly", "-separator", "\t", cookieFile,
+		"SELECT host, path, name, value, isSecure FROM moz_cookies").Output()
+	if err != nil {
+		return nil
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		host, path, name, value, secure := fields[0], fields[1], fields[2], fields[3], fields[4] == "1"
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: strings.TrimPrefix(host, "."), Path: path}
+
+		cookie := &http.Cookie{Name: name, Value: value, Path: path, Secure: secure}
+		// A leading dot marks a domain cookie (valid for subdomains)
+		if strings.HasPrefix(host, ".") {
+			cookie.Domain = host
+		}
+		jar.SetCookies(u, []*http.Cookie{cookie})
+	}
+
+	return jar
+}
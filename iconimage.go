@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Size of generated icons (GNOME's big-icon grid uses up to 256x256)
+const iconSize = 256
+
+// decodeIcon decodes PNG, JPEG, GIF and ICO (PNG or 24/32-bit BMP entries) data
+func decodeIcon(data []byte) (image.Image, error) {
+	if len(data) >= 4 && data[0] == 0 && data[1] == 0 && data[2] == 1 && data[3] == 0 {
+		return decodeICO(data)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// decodeICO picks the largest image in an ICO file and decodes it
+func decodeICO(data []byte) (image.Image, error) {
+	if len(data) < 6 {
+		return nil, fmt.Errorf("ico: truncated header")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+
+	best, bestSize := -1, 0
+	for i := 0; i < count; i++ {
+		entry := 6 + i*16
+		if entry+16 > len(data) {
+			break
+		}
+		// A width/height of 0 means 256
+		size := int(data[entry])
+		if size == 0 {
+			size = 256
+		}
+		if size > bestSize {
+			best, bestSize = i, size
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("ico: no images")
+	}
+
+	entry := 6 + best*16
+	length := int(binary.LittleEndian.Uint32(data[entry+8 : entry+12]))
+	offset := int(binary.LittleEndian.Uint32(data[entry+12 : entry+16]))
+	if offset < 0 || length <= 0 || offset+length > len(data) {
+		return nil, fmt.Errorf("ico: invalid image offset")
+	}
+	payload := data[offset : offset+length]
+
+	if bytes.HasPrefix(payload, []byte("\x89PNG")) {
+		return png.Decode(bytes.NewReader(payload))
+	}
+	return decodeICOBitmap(payload)
+}
+
+// decodeICOBitmap decodes an uncompressed 1/4/8-bit palette or 24/32-bit DIB
+// from an ICO file
+func decodeICOBitmap(dib []byte) (image.Image, error) {
+	if len(dib) < 40 {
+		return nil, fmt.Errorf("ico: truncated bitmap header")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(dib[0:4]))
+	width := int(int32(binary.LittleEndian.Uint32(dib[4:8])))
+	height := int(int32(binary.LittleEndian.Uint32(dib[8:12]))) / 2 // XOR + AND masks
+	bpp := int(binary.LittleEndian.Uint16(dib[14:16]))
+	compression := binary.LittleEndian.Uint32(dib[16:20])
+
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 {
+		return nil, fmt.Errorf("ico: invalid bitmap size %dx%d", width, height)
+	}
+	if compression != 0 || (bpp != 1 && bpp != 4 && bpp != 8 && bpp != 24 && bpp != 32) {
+		return nil, fmt.Errorf("ico: unsupported bitmap format (%d bpp)", bpp)
+	}
+	if headerSize < 40 || headerSize > len(dib) {
+		return nil, fmt.Errorf("ico: invalid bitmap header size")
+	}
+
+	// Palette formats store their colors (BGRx) after the header
+	var palette []color.NRGBA
+	if bpp <= 8 {
+		colors := int(binary.LittleEndian.Uint32(dib[32:36]))
+		if colors == 0 || colors > 1<<bpp {
+			colors = 1 << bpp
+		}
+		if len(dib) < headerSize+colors*4 {
+			return nil, fmt.Errorf("ico: truncated palette")
+		}
+		for i := 0; i < colors; i++ {
+			c := dib[headerSize+i*4:]
+			palette = append(palette, color.NRGBA{R: c[2], G: c[1], B: c[0], A: 255})
+		}
+		headerSize += colors * 4
+	}
+
+	stride := ((width*bpp + 31) / 32) * 4
+	maskStride := ((width + 31) / 32) * 4
+	pixels := dib[headerSize:]
+	if len(pixels) < stride*height {
+		return nil, fmt.Errorf("ico: truncated bitmap data")
+	}
+	mask := pixels[stride*height:]
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		// Rows are stored bottom-up
+		row := pixels[(height-1-y)*stride:]
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			if palette != nil {
+				// Indexes are packed from the high bits of each byte
+				bit := x * bpp
+				index := int(row[bit/8]>>uint(8-bpp-bit%8)) & (1<<bpp - 1)
+				if index < len(palette) {
+					c = palette[index]
+				}
+			} else {
+				p := row[x*bpp/8:]
+				c = color.NRGBA{R: p[2], G: p[1], B: p[0], A: 255}
+				if bpp == 32 {
+					c.A = p[3]
+				}
+			}
+			if bpp != 32 && len(mask) >= maskStride*height {
+				if mask[(height-1-y)*maskStride+x/8]&(0x80>>uint(x%8)) != 0 {
+					c.A = 0
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img, nil
+}
+
+// validateIcon checks that downloaded data is an image usable as an icon:
+// its format is sniffed from the magic bytes (servers mislabel icons and
+// answer with HTML error pages), it must decode completely and be roughly
+// square. It returns the decoded image, nil for SVG, and the extension to
+// save it with.
+func validateIcon(data []byte) (image.Image, string, error) {
+	var ext string
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		ext = ".png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		ext = ".jpg"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		ext = ".gif"
+	case bytes.HasPrefix(data, []byte{0, 0, 1, 0}):
+		ext = ".ico"
+	case isSVG(data):
+		return nil, ".svg", nil
+	default:
+		return nil, "", fmt.Errorf("not an image")
+	}
+
+	img, err := decodeIcon(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid image: %w", err)
+	}
+	// Rejects social media preview images, which are rectangular
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 || float64(max(width, height))/float64(min(width, height)) > 1.25 {
+		return nil, "", fmt.Errorf("image is not a valid icon (%dx%d, not square)", width, height)
+	}
+	return img, ext, nil
+}
+
+// isSVG reports whether data is an XML document with an svg root element
+func isSVG(data []byte) bool {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local == "svg"
+		}
+	}
+}
+
+// dominantColor returns the most common opaque color of an image, quantized
+// so that anti-aliasing noise doesn't split the vote
+func dominantColor(img image.Image) (color.NRGBA, bool) {
+	counts := make(map[uint32]int)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 200 {
+				continue
+			}
+			key := uint32(c.R>>4)<<8 | uint32(c.G>>4)<<4 | uint32(c.B>>4)
+			counts[key]++
+		}
+	}
+
+	var best uint32
+	bestCount := 0
+	for key, n := range counts {
+		if n > bestCount {
+			best, bestCount = key, n
+		}
+	}
+	if bestCount == 0 {
+		return color.NRGBA{}, false
+	}
+
+	return color.NRGBA{
+		R: uint8(best>>8&0xf) * 17,
+		G: uint8(best>>4&0xf) * 17,
+		B: uint8(best&0xf) * 17,
+		A: 255,
+	}, true
+}
+
+// hasTransparency reports whether any pixel of the image is not fully opaque
+func hasTransparency(img image.Image) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// iconBackground derives a background from the icon's dominant color: a
+// pale tint for dark/saturated icons, a deep shade for very light ones
+func iconBackground(img image.Image) color.NRGBA {
+	c, ok := dominantColor(img)
+	if !ok {
+		return color.NRGBA{R: 240, G: 240, B: 240, A: 255}
+	}
+
+	mix := func(v, target uint8, amount float64) uint8 {
+		return uint8(float64(v)*(1-amount) + float64(target)*amount)
+	}
+
+	luminance := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+	if luminance > 200 {
+		return color.NRGBA{R: mix(c.R, 0, 0.7), G: mix(c.G, 0, 0.7), B: mix(c.B, 0, 0.7), A: 255}
+	}
+	return color.NRGBA{R: mix(c.R, 255, 0.85), G: mix(c.G, 255, 0.85), B: mix(c.B, 255, 0.85), A: 255}
+}
+
+// sampleBilinear reads a bilinearly interpolated (premultiplied) color at fractional coordinates
+func sampleBilinear(img image.Image, fx, fy float64) color.RGBA64 {
+	bounds := img.Bounds()
+	clamp := func(v, lo, hi int) int {
+		if v < lo {
+			return lo
+		}
+		if v > hi {
+			return hi
+		}
+		return v
+	}
+
+	x0 := int(math.Floor(fx))
+	y0 := int(math.Floor(fy))
+	dx := fx - float64(x0)
+	dy := fy - float64(y0)
+
+	var acc [4]float64
+	for _, s := range [4]struct {
+		x, y int
+		w    float64
+	}{
+		{x0, y0, (1 - dx) * (1 - dy)},
+		{x0 + 1, y0, dx * (1 - dy)},
+		{x0, y0 + 1, (1 - dx) * dy},
+		{x0 + 1, y0 + 1, dx * dy},
+	} {
+		px := clamp(bounds.Min.X+s.x, bounds.Min.X, bounds.Max.X-1)
+		py := clamp(bounds.Min.Y+s.y, bounds.Min.Y, bounds.Max.Y-1)
+		r, g, b, a := img.At(px, py).RGBA()
+		acc[0] += float64(r) * s.w
+		acc[1] += float64(g) * s.w
+		acc[2] += float64(b) * s.w
+		acc[3] += float64(a) * s.w
+	}
+
+	return color.RGBA64{R: uint16(acc[0]), G: uint16(acc[1]), B: uint16(acc[2]), A: uint16(acc[3])}
+}
+
+// roundedCoverage returns how much of the pixel at (x, y) lies inside a
+// rounded rectangle covering the whole icon (0..1, anti-aliased edge)
+func roundedCoverage(x, y int, radius float64) float64 {
+	size := float64(iconSize)
+	px, py := float64(x)+0.5, float64(y)+0.5
+
+	cx := math.Max(radius, math.Min(px, size-radius))
+	cy := math.Max(radius, math.Min(py, size-radius))
+	dist := math.Hypot(px-cx, py-cy)
+
+	return math.Max(0, math.Min(1, radius-dist+0.5))
+}
+
+// adaptIcon renders an icon onto a 256x256 canvas. Icons with transparency
+// or that are small get padding and a background generated from their
+// dominant color; rounded masks the result with a rounded rectangle.
+func adaptIcon(src image.Image, rounded bool) image.Image {
+	bounds := src.Bounds()
+	needsBackground := hasTransparency(src) || bounds.Dx() < iconSize/2
+
+	// Content area: full size for opaque artwork, padded otherwise
+	content := float64(iconSize)
+	if needsBackground {
+		content = iconSize * 0.7
+	}
+	scale := content / math.Max(float64(bounds.Dx()), float64(bounds.Dy()))
+	w, h := float64(bounds.Dx())*scale, float64(bounds.Dy())*scale
+	offX, offY := (iconSize-w)/2, (iconSize-h)/2
+
+	var background color.NRGBA
+	if needsBackground {
+		background = iconBackground(src)
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, iconSize, iconSize))
+	for y := 0; y < iconSize; y++ {
+		for x := 0; x < iconSize; x++ {
+			// Start with the background (transparent for opaque artwork)
+			r := float64(background.R) * 257
+			g := float64(background.G) * 257
+			b := float64(background.B) * 257
+			a := float64(background.A) * 257
+
+			// Composite the scaled icon over it (colors are premultiplied)
+			px, py := float64(x)+0.5, float64(y)+0.5
+			if px >= offX && px < offX+w && py >= offY && py < offY+h {
+				c := sampleBilinear(src, (px-offX)/scale-0.5, (py-offY)/scale-0.5)
+				ca := float64(c.A) / 0xffff
+				r = float64(c.R) + r*(1-ca)
+				g = float64(c.G) + g*(1-ca)
+				b = float64(c.B) + b*(1-ca)
+				a = float64(c.A) + a*(1-ca)
+			}
+
+			if rounded {
+				coverage := roundedCoverage(x, y, iconSize*0.22)
+				r, g, b, a = r*coverage, g*coverage, b*coverage, a*coverage
+			}
+
+			// Back to straight alpha for NRGBA
+			if a == 0 {
+				continue
+			}
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / a * 255),
+				G: uint8(g / a * 255),
+				B: uint8(b / a * 255),
+				A: uint8(a / 0xffff * 255),
+			})
+		}
+	}
+
+	return out
+}
+
+// processIcon post-processes a downloaded icon file into a 256x256 PNG next
+// to it. Formats that can't be decoded (e.g. SVG) are left untouched.
+func processIcon(iconPath string, rounded bool) (string, error) {
+	data, err := os.ReadFile(iconPath)
+	if err != nil {
+		return "", err
+	}
+
+	img, err := decodeIcon(data)
+	if err != nil {
+		return iconPath, nil
+	}
+
+	out := strings.TrimSuffix(iconPath, filepath.Ext(iconPath)) + ".png"
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, adaptIcon(img, rounded)); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(out, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	if out != iconPath {
+		os.Remove(iconPath)
+	}
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A weblet's icon is the best of the candidates its manifest, page, the
+// common locations and the icon services offer: each is downloaded and
+// decoded, and scored by its size, then its format. Candidates that lost or
+// were no icon at all are remembered in data/<name>/icon-candidates.json for
+// a month, so 'weblet refresh' only downloads those that could win.
+
+// iconLoserTTL is how long a losing candidate isn't downloaded again
+const iconLoserTTL = 30 * 24 * time.Hour
+
+// iconCandidates is what the last icon search found
+type iconCandidates struct {
+	Winner string           `json:"winner,omitempty"`
+	Losers map[string]int64 `json:"losers,omitempty"` // URL -> Unix time it lost
+}
+
+func (wm *WebletManager) iconCandidatesFile(name string) string {
+	return filepath.Join(wm.dataDir, "data", name, "icon-candidates.json")
+}
+
+func (wm *WebletManager) loadIconCandidates(name string) *iconCandidates {
+	candidates := &iconCandidates{}
+	if data, err := os.ReadFile(wm.iconCandidatesFile(name)); err == nil {
+		json.Unmarshal(data, candidates)
+	}
+	if candidates.Losers == nil {
+		candidates.Losers = map[string]int64{}
+	}
+	return candidates
+}
+
+func (wm *WebletManager) saveIconCandidates(name string, candidates *iconCandidates) {
+	for iconURL, lost := range candidates.Losers {
+		if time.Since(time.Unix(lost, 0)) >= iconLoserTTL {
+			delete(candidates.Losers, iconURL)
+		}
+	}
+	path := wm.iconCandidatesFile(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	if data, err := json.MarshalIndent(candidates, "", "  "); err == nil {
+		os.WriteFile(path, data, 0600)
+	}
+}
+
+// iconScore ranks icons: the larger decoded image wins, at equal sizes PNG
+// over ICO over (lossy) JPEG. SVGs have size 0, so any raster icon wins.
+func iconScore(candidate *iconCandidate) int {
+	formats := map[string]int{".png": 3, ".ico": 2, ".jpg": 1}
+	return candidate.size*4 + formats[candidate.ext]
+}
+
+// pickIcon downloads the candidate icons of a weblet and returns the best,
+// or nil when none is a usable icon
+func (wm *WebletManager) pickIcon(name string, iconURLs []string, client *http.Client, p *progress) *iconCandidate {
+	candidates := wm.loadIconCandidates(name)
+	best := wm.bestIcon(iconURLs, client, p, candidates)
+	if best == nil && len(candidates.Losers) > 0 {
+		// The winner is gone, what lost before is better than nothing
+		candidates.Losers = map[string]int64{}
+		best = wm.bestIcon(iconURLs, client, p, candidates)
+	}
+	wm.saveIconCandidates(name, candidates)
+	return best
+}
+
+// bestIcon downloads the candidates that haven't lost recently, until one
+// is as large as the generated icons, and records the winner and losers
+func (wm *WebletManager) bestIcon(iconURLs []string, client *http.Client, p *progress, candidates *iconCandidates) *iconCandidate {
+	now := time.Now()
+	seen := map[string]bool{}
+	var best *iconCandidate
+	var lost []string
+	for _, iconURL := range iconURLs {
+		if seen[iconURL] {
+			continue
+		}
+		seen[iconURL] = true
+		if at, ok := candidates.Losers[iconURL]; ok && now.Sub(time.Unix(at, 0)) < iconLoserTTL {
+			continue
+		}
+
+		p.Update(iconURL)
+		candidate, err := wm.fetchIcon(iconURL, client)
+		if err != nil {
+			// Network errors may pass, only remember what wasn't an icon
+			var urlErr *url.Error
+			if !errors.As(err, &urlErr) {
+				lost = append(lost, iconURL)
+			}
+			continue
+		}
+		switch {
+		case best == nil:
+			best = candidate
+		case iconScore(candidate) > iconScore(best):
+			lost = append(lost, best.url)
+			best = candidate
+		default:
+			lost = append(lost, iconURL)
+		}
+		// Larger icons than the generated ones gain nothing
+		if best.size >= iconSize {
+			break
+		}
+	}
+
+	for _, iconURL := range lost {
+		candidates.Losers[iconURL] = now.Unix()
+	}
+	if best != nil {
+		candidates.Winner = best.url
+		delete(candidates.Losers, best.url)
+	}
+	return best
+}
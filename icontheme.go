@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Sizes installed into the hicolor icon theme
+var themeIconSizes = []int{48, 128, 256}
+
+// hicolorDir returns ~/.local/share/icons/hicolor (or the system-wide one)
+func (wm *WebletManager) hicolorDir() (string, error) {
+	shareDir, err := wm.shareDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(shareDir, "icons", "hicolor"), nil
+}
+
+// fitIcon scales an image to fit a size x size square (keeping the aspect
+// ratio, transparent padding) using area averaging for clean downscales
+func fitIcon(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	scale := float64(size) / math.Max(float64(bounds.Dx()), float64(bounds.Dy()))
+	w := int(math.Round(float64(bounds.Dx()) * scale))
+	h := int(math.Round(float64(bounds.Dy()) * scale))
+	offX, offY := (size-w)/2, (size-h)/2
+
+	out := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Source area covered by this destination pixel
+			x0 := bounds.Min.X + int(float64(x)/scale)
+			y0 := bounds.Min.Y + int(float64(y)/scale)
+			x1 := bounds.Min.X + int(math.Ceil(float64(x+1)/scale))
+			y1 := bounds.Min.Y + int(math.Ceil(float64(y+1)/scale))
+			if x1 > bounds.Max.X {
+				x1 = bounds.Max.X
+			}
+			if y1 > bounds.Max.Y {
+				y1 = bounds.Max.Y
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 || a == 0 {
+				continue
+			}
+
+			// Averaged premultiplied values back to straight alpha
+			out.SetNRGBA(offX+x, offY+y, color.NRGBA{
+				R: uint8(r * 255 / a),
+				G: uint8(g * 255 / a),
+				B: uint8(b * 255 / a),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return out
+}
+
+// installThemeIcons installs the weblet icon into the hicolor theme in
+// several sizes (or as a scalable SVG) and returns the icon name to use in
+// the desktop file
+func (wm *WebletManager) installThemeIcons(name, iconPath string) (string, error) {
+	iconName := "weblet-" + name
+	themeDir, err := wm.hicolorDir()
+	if err != nil {
+		return "", err
+	}
+
+	// Remove icons of a previous install (the format may have changed)
+	for _, size := range themeIconSizes {
+		os.Remove(filepath.Join(themeDir, fmt.Sprintf("%dx%d", size, size), "apps", iconName+".png"))
+	}
+	os.Remove(filepath.Join(themeDir, "scalable", "apps", iconName+".svg"))
+
+	if strings.EqualFold(filepath.Ext(iconPath), ".svg") {
+		dir := filepath.Join(themeDir, "scalable", "apps")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+		if err := copyFile(iconPath, filepath.Join(dir, iconName+".svg")); err != nil {
+			return "", err
+		}
+	} else {
+		data, err := os.ReadFile(iconPath)
+		if err != nil {
+			return "", err
+		}
+		img, err := decodeIcon(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode icon: %w", err)
+		}
+
+		for _, size := range themeIconSizes {
+			dir := filepath.Join(themeDir, fmt.Sprintf("%dx%d", size, size), "apps")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return "", err
+			}
+
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, fitIcon(img, size)); err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(filepath.Join(dir, iconName+".png"), buf.Bytes(), 0644); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	updateIconCache(themeDir)
+	return iconName, nil
+}
+
+// removeThemeIcons deletes the weblet's icons from the hicolor theme
+func (wm *WebletManager) removeThemeIcons(name string) {
+	themeDir, err := wm.hicolorDir()
+	if err != nil {
+		return
+	}
+
+	iconName := "weblet-" + name
+	for _, size := range themeIconSizes {
+		dir := filepath.Join(themeDir, fmt.Sprintf("%dx%d", size, size), "apps")
+		os.Remove(filepath.Join(dir, iconName+".png"))
+		os.Remove(filepath.Join(dir, iconName+"-symbolic.png"))
+	}
+	os.Remove(filepath.Join(themeDir, "scalable", "apps", iconName+".svg"))
+
+	updateIconCache(themeDir)
+}
+
+// updateIconCache refreshes the theme's icon cache if one is in use
+func updateIconCache(themeDir string) {
+	if _, err := os.Stat(filepath.Join(themeDir, "icon-theme.cache")); err == nil {
+		exec.Command("gtk-update-icon-cache", "-f", "-t", themeDir).Run()
+	}
+}
+
+// copyFile copies a regular file
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// desktopColorScheme returns "dark" or "light" depending on the desktop's
+// color-scheme preference (GNOME, KDE or GTK_THEME)
+func desktopColorScheme() string {
+	if out, err := exec.Command("gsettings", "get", "org.gnome.desktop.interface", "color-scheme").Output(); err == nil {
+		if strings.Contains(string(out), "dark") {
+			return "dark"
+		}
+		if strings.Contains(string(out), "light") {
+			return "light"
+		}
+	}
+
+	if out, err := exec.Command("kreadconfig5", "--group", "General", "--key", "ColorScheme").Output(); err == nil {
+		if strings.Contains(strings.ToLower(string(out)), "dark") {
+			return "dark"
+		}
+	}
+
+	if strings.HasSuffix(strings.ToLower(os.Getenv("GTK_THEME")), ":dark") {
+		return "dark"
+	}
+	return "light"
+}
+
+// isMonochrome reports whether all visible pixels of an image are (nearly)
+// gray, e.g. a black glyph favicon that disappears on dark panels
+func isMonochrome(img image.Image) bool {
+	bounds := img.Bounds()
+	visible := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 64 {
+				continue
+			}
+			visible++
+			hi := max(c.R, c.G, c.B)
+			lo := min(c.R, c.G, c.B)
+			if hi-lo > 24 {
+				return false
+			}
+		}
+	}
+	return visible > 0
+}
+
+// recolorIcon paints every pixel with c, keeping the original alpha
+func recolorIcon(img image.Image, c color.NRGBA) image.Image {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			a := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A
+			out.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBA{R: c.R, G: c.G, B: c.B, A: a})
+		}
+	}
+	return out
+}
+
+// iconVariant returns the icon matching the desktop's color scheme: the
+// user's light/dark icon if configured, otherwise a recolored copy of a
+// transparent monochrome icon so it stays visible on matching backgrounds
+func (wm *WebletManager) iconVariant(weblet *Weblet, iconPath string) string {
+	iconDir := filepath.Dir(iconPath)
+	scheme := desktopColorScheme()
+
+	custom := weblet.IconLight
+	if scheme == "dark" {
+		custom = weblet.IconDark
+	}
+	if custom != "" {
+		// Work on a copy, icon processing replaces files in the icon directory
+		for _, ext := range []string{".png", ".ico", ".svg", ".jpg"} {
+			os.Remove(filepath.Join(iconDir, weblet.Name+ext))
+		}
+		variantPath := filepath.Join(iconDir, weblet.Name+filepath.Ext(custom))
+		if err := copyFile(custom, variantPath); err != nil {
+			printAboveProgress(T("Warning: Could not use %s icon: %v\n", scheme, err))
+			return iconPath
+		}
+		return variantPath
+	}
+
+	data, err := os.ReadFile(iconPath)
+	if err != nil {
+		return iconPath
+	}
+	img, err := decodeIcon(data)
+	if err != nil || !hasTransparency(img) || !isMonochrome(img) {
+		return iconPath
+	}
+
+	glyph := color.NRGBA{R: 0x24, G: 0x24, B: 0x24, A: 255}
+	if scheme == "dark" {
+		glyph = color.NRGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 255}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, recolorIcon(img, glyph)); err != nil {
+		return iconPath
+	}
+	variantPath := filepath.Join(iconDir, weblet.Name+".png")
+	if err := os.WriteFile(variantPath, buf.Bytes(), 0644); err != nil {
+		return iconPath
+	}
+	if variantPath != iconPath {
+		os.Remove(iconPath)
+	}
+	return variantPath
+}
+
+// installSymbolicIcon installs weblet-<name>-symbolic.png (a single color
+// glyph GTK recolors for panels and trays) into the hicolor theme
+func (wm *WebletManager) installSymbolicIcon(name, iconPath string) error {
+	data, err := os.ReadFile(iconPath)
+	if err != nil {
+		return err
+	}
+	img, err := decodeIcon(data)
+	if err != nil {
+		return err
+	}
+
+	// Opaque icons have no glyph shape to use, so build a mask from the
+	// distance to their dominant (background) color instead
+	mask := img
+	if !hasTransparency(img) {
+		bg, _ := dominantColor(img)
+		bounds := img.Bounds()
+		shape := image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				d := math.Abs(float64(c.R)-float64(bg.R)) + math.Abs(float64(c.G)-float64(bg.G)) + math.Abs(float64(c.B)-float64(bg.B))
+				shape.SetNRGBA(x, y, color.NRGBA{A: uint8(math.Min(255, d*2))})
+			}
+		}
+		mask = shape
+	}
+	symbolic := recolorIcon(mask, color.NRGBA{R: 0xbe, G: 0xbe, B: 0xbe, A: 255})
+
+	themeDir, err := wm.hicolorDir()
+	if err != nil {
+		return err
+	}
+	for _, size := range themeIconSizes {
+		dir := filepath.Join(themeDir, fmt.Sprintf("%dx%d", size, size), "apps")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, fitIcon(symbolic, size)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "weblet-"+name+"-symbolic.png"), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// 'weblet ctl <name> inspect' makes a native weblet inspectable remotely:
+// WebKit's inspector server listens on 127.0.0.1:<port> (for Epiphany and
+// other WebKit tools, inspector://127.0.0.1:<port>) and its HTTP frontend
+// on the next port (http://127.0.0.1:<port+1> in a browser). WebKit only
+// starts the server with the window, so a running weblet is restarted at
+// the page it shows. The inspector stays on until the weblet is closed.
+
+// defaultInspectorPort is the port the remote inspector listens on unless
+// --port is given
+const defaultInspectorPort = 9222
+
+// Inspect (re)starts a native weblet with the remote inspector on port
+func (wm *WebletManager) Inspect(name string, port int) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if weblet.UseChrome {
+		return newError(ErrInvalid, "remote inspection is for native weblets, Chrome has its own DevTools")
+	}
+	if !nativeAvailable() {
+		return newError(ErrInvalid, "native mode isn't built in")
+	}
+	if weblet.Tor {
+		return newError(ErrInvalid, "weblet '%s' uses Tor, remote inspection would expose it", name)
+	}
+	if port <= 0 || port >= 65535 {
+		return newError(ErrInvalid, "invalid port %d", port)
+	}
+
+	address := "127.0.0.1:" + strconv.Itoa(port)
+	httpAddress := "127.0.0.1:" + strconv.Itoa(port+1)
+	if pid := wm.runningPID(weblet); pid > 0 && hasEnv(pid, "WEBLET_INSPECTOR="+strconv.Itoa(port)) {
+		printInspector(name, address, httpAddress)
+		return nil
+	}
+	for _, addr := range []string{address, httpAddress} {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("port %s is in use, choose another with --port", addr)
+		}
+		listener.Close()
+	}
+
+	if wm.isRunning(weblet) {
+		fmt.Print(T("Restarting weblet '%s' with the remote inspector...\n", name))
+		if uri, current := wm.currentURL(weblet); current {
+			wm.openURL = uri
+		}
+		if err := wm.stopWeblet(weblet); err != nil {
+			return err
+		}
+	}
+
+	os.Setenv("WEBLET_INSPECTOR", strconv.Itoa(port))
+	defer os.Unsetenv("WEBLET_INSPECTOR")
+	if err := wm.Run(name); err != nil {
+		return err
+	}
+	printInspector(name, address, httpAddress)
+	return nil
+}
+
+func printInspector(name, address, httpAddress string) {
+	fmt.Print(T("Remote inspector of weblet '%s':\n", name))
+	fmt.Print(T("  inspector://%s  (Epiphany, WebKit tools)\n", address))
+	fmt.Print(T("  http://%s  (any browser)\n", httpAddress))
+	fmt.Println(T("Any local program can control the weblet through it; close the weblet to turn it off"))
+}
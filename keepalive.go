@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Intranet apps often log out users who were idle for a while. With
+// 'weblet set <name> keep-alive 10' a native window fetches its page every
+// 10 minutes from inside the page, with the page's cookies, which counts as
+// activity for most session timeouts; keep-alive-reload reloads the page
+// instead while the window isn't used. With keep-alive-hibernated the
+// memory watcher goes on requesting the weblet's URL with the stored
+// cookies after it closed the weblet to free memory (see memory.go).
+
+// defaultKeepAlive is the interval, in minutes, of 'keep-alive on'
+const defaultKeepAlive = 10
+
+// parseKeepAlive parses the keep-alive setting: on, off or minutes
+func parseKeepAlive(value string) (int, error) {
+	minutes, err := strconv.Atoi(value)
+	if err != nil {
+		enabled, switchErr := parseSwitch(value)
+		if switchErr != nil {
+			return 0, fmt.Errorf("invalid value '%s' (expected on, off or minutes)", value)
+		}
+		minutes = 0
+		if enabled {
+			minutes = defaultKeepAlive
+		}
+	}
+	if minutes < 0 {
+		return 0, fmt.Errorf("invalid value '%s' (expected on, off or minutes)", value)
+	}
+	return minutes, nil
+}
+
+// pingSession requests a weblet's URL with the cookies of its native
+// profile, like its window would
+func (wm *WebletManager) pingSession(weblet *Weblet) error {
+	client := newHTTPClient(newFetchGuard(weblet.URL).transport())
+	if jar := wm.nativeCookieJar(weblet.Name); jar != nil {
+		client.Jar = jar
+	}
+	req, err := http.NewRequest("GET", weblet.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return nil
+}
+
+// keepHibernatedAlive pings the sites of the weblets the memory watcher
+// closed whose keep-alive interval passed; hibernated maps them to their
+// last ping. Weblets opened again keep themselves alive.
+func (wm *WebletManager) keepHibernatedAlive(hibernated map[string]time.Time) {
+	for name, pinged := range hibernated {
+		weblet, exists := wm.weblets[name]
+		if !exists || weblet.KeepAlive == 0 || !weblet.KeepAliveHibernated || wm.runningPID(weblet) > 0 {
+			delete(hibernated, name)
+			continue
+		}
+		if time.Since(pinged) < time.Duration(weblet.KeepAlive)*time.Minute {
+			continue
+		}
+		if err := wm.pingSession(weblet); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Could not keep the session of weblet '%s' alive: %v\n", name, err))
+		}
+		hibernated[name] = time.Now()
+	}
+}
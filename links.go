@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// Quick links are named pages inside a weblet ('weblet link add jira sprint
+// https://.../board/42'), opened with 'weblet jira sprint', from the
+// desktop entry's actions (right-click in the dock) or the header bar menu.
+
+// Link is a named page of a weblet
+type Link struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// Link names are desktop action identifiers and command line arguments
+var linkNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]*$`)
+
+// findLink returns the index of a weblet's link, or -1
+func (w *Weblet) findLink(name string) int {
+	for i, link := range w.Links {
+		if link.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Link manages the quick links of a weblet: add, remove and list
+func (wm *WebletManager) Link(args []string) error {
+	if len(args) < 2 {
+		return newError(ErrInvalid, "usage: weblet link add|remove|list <name> ...")
+	}
+	command, name := args[0], args[1]
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+
+	if command == "list" {
+		if len(weblet.Links) == 0 {
+			fmt.Print(T("No links for weblet '%s'.\n", name))
+			return nil
+		}
+		fmt.Print(T("Links of weblet '%s':\n", name))
+		for _, link := range weblet.Links {
+			fmt.Printf("  %-16s %s\n", link.Name, link.URL)
+		}
+		return nil
+	}
+
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+
+	switch command {
+	case "add":
+		if len(args) != 4 {
+			return newError(ErrInvalid, "usage: weblet link add <name> <link> <url>")
+		}
+		linkName := args[2]
+		if !linkNamePattern.MatchString(linkName) {
+			return newError(ErrInvalid, "invalid link name '%s' (use letters, digits and '-')", linkName)
+		}
+		linkURL, err := checkURL(args[3], nil)
+		if err != nil {
+			return wrapError(ErrInvalid, err)
+		}
+		if i := weblet.findLink(linkName); i >= 0 {
+			weblet.Links[i].URL = linkURL
+		} else {
+			weblet.Links = append(weblet.Links, Link{Name: linkName, URL: linkURL})
+		}
+		fmt.Print(T("Added link '%s' to weblet '%s': %s\n", linkName, name, linkURL))
+
+	case "remove":
+		if len(args) != 3 {
+			return newError(ErrInvalid, "usage: weblet link remove <name> <link>")
+		}
+		i := weblet.findLink(args[2])
+		if i < 0 {
+			return newError(ErrNotFound, "weblet '%s' has no link '%s'", name, args[2])
+		}
+		weblet.Links = append(weblet.Links[:i], weblet.Links[i+1:]...)
+		fmt.Print(T("Removed link '%s' from weblet '%s'\n", args[2], name))
+
+	default:
+		return newError(ErrInvalid, "unknown command '%s'", command)
+	}
+
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+	if err := wm.updateDesktopEntry(weblet); err != nil {
+		fmt.Print(T("Warning: Could not update desktop file: %v\n", err))
+	}
+	return nil
+}
+
+// RunLink opens a quick link of a weblet
+func (wm *WebletManager) RunLink(name, linkName string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	i := weblet.findLink(linkName)
+	if i < 0 {
+		return newError(ErrNotFound, "weblet '%s' has no link '%s'", name, linkName)
+	}
+	return wm.openPage(weblet, weblet.Links[i].URL)
+}
+
+// openPage opens a page in a weblet: in the running window if there is
+// one, otherwise by starting the weblet at that page
+func (wm *WebletManager) openPage(weblet *Weblet, pageURL string) error {
+	if !weblet.UseChrome && wm.runningPID(weblet) > 0 {
+		if _, err := view.Query(weblet.Name, "open "+pageURL); err == nil {
+			return nil
+		}
+	}
+	wm.openURL = pageURL
+	return wm.Run(weblet.Name)
+}
+
+// desktopActions returns the desktop entry lines for a weblet's links: the
+// Actions key and one [Desktop Action] group per link
+func desktopActions(weblet *Weblet, execPath string) (string, string) {
+	if len(weblet.Links) == 0 {
+		return "", ""
+	}
+	var ids, groups strings.Builder
+	for _, link := range weblet.Links {
+		ids.WriteString(link.Name + ";")
+		fmt.Fprintf(&groups, "\n[Desktop Action %s]\nName=%s\nExec=%s %s %s\n", link.Name, link.Name, execPath, launchArgs(weblet.Name), link.Name)
+	}
+	return "Actions=" + ids.String() + "\n", groups.String()
+}
+
+// updateDesktopEntry rewrites the link actions and the NoDisplay key of an
+// existing desktop file, without looking for the icon again like
+// createDesktopFile
+func (wm *WebletManager) updateDesktopEntry(weblet *Weblet) error {
+	desktopFilePath, err := wm.getDesktopFilePath(weblet.Name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(desktopFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Keep the main group without its Actions and NoDisplay keys
+	var lines []string
+	execPath := ""
+	for _, line := range splitLines(string(data)) {
+		if strings.HasPrefix(line, "[Desktop Action ") {
+			break
+		}
+		if strings.HasPrefix(line, "Actions=") || strings.HasPrefix(line, "NoDisplay=") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "Exec="); ok && execPath == "" {
+			// The launcher may be a command with arguments (flatpak run ...)
+			execPath = strings.TrimSuffix(rest, " "+launchArgs(weblet.Name))
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if weblet.Hidden {
+		lines = append(lines, "NoDisplay=true")
+	}
+
+	actions, groups := desktopActions(weblet, execPath)
+	content := strings.Join(lines, "\n") + "\n" + actions + groups
+	return os.WriteFile(desktopFilePath, []byte(content), 0755)
+}
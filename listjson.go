@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// 'weblet list --json' and 'weblet list --quiet' are the list for scripts:
+// the first with everything 'weblet list' and 'weblet status' show plus the
+// profile directories and their sizes, the second with only the names.
+
+// webletListing is a weblet in the output of 'weblet list --json'
+type webletListing struct {
+	Name    string                      `json:"name"`
+	URL     string                      `json:"url"`
+	Backend string                      `json:"backend"` // native or chrome, as configured
+	Status  string                      `json:"status"`  // running, prewarmed or stopped
+	PID     int                         `json:"pid,omitempty"`
+	System  bool                        `json:"system,omitempty"`
+	Hidden  bool                        `json:"hidden,omitempty"`
+	Tor     bool                        `json:"tor,omitempty"`
+	Groups  []string                    `json:"groups,omitempty"`
+	Data    map[string]webletListingDir `json:"data"`      // Profile directories by backend
+	Size    int64                       `json:"data_size"` // Bytes of all profile directories
+}
+
+// webletListingDir is a profile directory of a weblet
+type webletListingDir struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"` // Bytes, 0 when the directory doesn't exist
+}
+
+// sortedNames returns the names of all weblets in order
+func (wm *WebletManager) sortedNames() []string {
+	names := make([]string, 0, len(wm.weblets))
+	for name := range wm.weblets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListNames prints the name of each weblet on its own line
+func (wm *WebletManager) ListNames() {
+	for _, name := range wm.sortedNames() {
+		fmt.Println(name)
+	}
+}
+
+// ListJSON prints all weblets as a JSON array
+func (wm *WebletManager) ListJSON() error {
+	listings := []webletListing{}
+	for _, name := range wm.sortedNames() {
+		weblet := wm.weblets[name]
+		dirs, err := wm.profileDirs(name, name)
+		if err != nil {
+			return err
+		}
+
+		listing := webletListing{
+			Name:    name,
+			URL:     weblet.URL,
+			Backend: "native",
+			Status:  "stopped",
+			System:  weblet.System,
+			Hidden:  weblet.Hidden,
+			Tor:     weblet.Tor,
+			Groups:  weblet.Groups,
+			Data:    map[string]webletListingDir{},
+		}
+		if weblet.UseChrome {
+			listing.Backend = "chrome"
+		}
+		if st := wm.status(weblet); st.running {
+			listing.Status = "running"
+			if wm.isPrewarmed(name) {
+				listing.Status = "prewarmed"
+			}
+			listing.PID = st.pid
+		}
+		for i, backend := range []string{"native", "chrome"} {
+			dir := webletListingDir{Path: dirs[i][0], Size: dirSize(dirs[i][0])}
+			listing.Data[backend] = dir
+			listing.Size += dir.Size
+		}
+		listings = append(listings, listing)
+	}
+
+	data, err := json.MarshalIndent(listings, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
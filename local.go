@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// normalizeURL turns local paths (e.g. /home/me/notes-site or ./docs) into
+// file:// URLs and validates everything else with checkURL
+func normalizeURL(arg string, allowSchemes []string) (string, error) {
+	path := arg
+	switch {
+	case strings.HasPrefix(arg, "file://"):
+		// An explicit file:// URL is opted into like any other scheme, plain
+		// paths are what local weblets are normally created from
+		if _, err := checkURL(arg, allowSchemes); err != nil {
+			return "", err
+		}
+		path = strings.TrimPrefix(arg, "file://")
+	case strings.HasPrefix(arg, "~/"):
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		path = filepath.Join(homeDir, arg[2:])
+	case strings.HasPrefix(arg, "/"), strings.HasPrefix(arg, "./"), strings.HasPrefix(arg, "../"):
+	default:
+		// Bare names are only treated as paths if they exist locally
+		if info, err := os.Stat(arg); err != nil || !info.IsDir() {
+			return checkURL(arg, allowSchemes)
+		}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path '%s': %w", arg, err)
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return "", fmt.Errorf("local path '%s' does not exist", abs)
+	}
+
+	return "file://" + abs, nil
+}
+
+// localPath returns the filesystem path of a local (file://) weblet URL
+func localPath(webletURL string) (string, bool) {
+	if !strings.HasPrefix(webletURL, "file://") {
+		return "", false
+	}
+	return strings.TrimPrefix(webletURL, "file://"), true
+}
+
+// serveLocal starts an HTTP server for a local weblet on a localhost port and
+// returns the URL to load. The port is derived from the weblet name so the
+// origin (and with it localStorage/cookies) stays the same between launches.
+func serveLocal(name, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	dir, page := path, ""
+	if !info.IsDir() {
+		dir, page = filepath.Dir(path), filepath.Base(path)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	port := 20000 + int(h.Sum32()%10000)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		// Preferred port is taken, fall back to a random one
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", fmt.Errorf("failed to start local server: %w", err)
+		}
+	}
+
+	go http.Serve(listener, http.FileServer(http.Dir(dir)))
+
+	return fmt.Sprintf("http://%s/%s", listener.Addr().String(), page), nil
+}
+
+// copyLocalIcon looks for a favicon shipped with a local site and copies it
+// into the icon directory
+func (wm *WebletManager) copyLocalIcon(path, webletName string) (string, error) {
+	dir := path
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	iconDir := filepath.Join(wm.dataDir, "icons")
+	if err := os.MkdirAll(iconDir, 0755); err != nil {
+		return "", err
+	}
+
+	candidates := []string{
+		"apple-touch-icon.png",
+		"favicon-192x192.png",
+		"favicon.png",
+		"icon.png",
+		"favicon.svg",
+		"favicon.ico",
+	}
+
+	for _, candidate := range candidates {
+		src := filepath.Join(dir, candidate)
+		data, err := os.ReadFile(src)
+		if err != nil {
+			continue
+		}
+		if _, _, err := validateIcon(data); err != nil {
+			printAboveProgress(T("Warning: Skipping %s: %v\n", candidate, err))
+			continue
+		}
+
+		iconPath := filepath.Join(iconDir, webletName+filepath.Ext(candidate))
+		if err := copyFile(src, iconPath); err != nil {
+			return "", err
+		}
+		return iconPath, nil
+	}
+
+	return "", fmt.Errorf("no icon found in %s", dir)
+}
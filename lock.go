@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Locked weblets keep a salted passphrase hash in the Secret Service
+// (GNOME Keyring, KWallet) under service=weblet weblet=<name>, stored and
+// looked up with secret-tool
+
+func secretToolArgs(name string) []string {
+	return []string{"service", "weblet", "weblet", name}
+}
+
+// hashPassphrase returns "salt$sha256(salt+passphrase)" in hex
+func hashPassphrase(salt, passphrase string) string {
+	sum := sha256.Sum256([]byte(salt + passphrase))
+	return salt + "$" + hex.EncodeToString(sum[:])
+}
+
+// storePassphrase saves the hash of a weblet's passphrase in the keyring
+func storePassphrase(name, passphrase string) error {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return fmt.Errorf("secret-tool not found. Install with: sudo apt install libsecret-tools")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	args := append([]string{"store", "--label=weblet lock: " + name}, secretToolArgs(name)...)
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(hashPassphrase(hex.EncodeToString(salt), passphrase))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store passphrase: %v %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// verifyPassphrase checks a passphrase against the stored hash
+func verifyPassphrase(name, passphrase string) (bool, error) {
+	args := append([]string{"lookup"}, secretToolArgs(name)...)
+	output, err := exec.Command("secret-tool", args...).Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to read passphrase from the keyring: %w", err)
+	}
+
+	stored := strings.TrimSpace(string(output))
+	salt, _, ok := strings.Cut(stored, "$")
+	if !ok {
+		return false, fmt.Errorf("invalid passphrase entry in the keyring")
+	}
+	return subtle.ConstantTimeCompare([]byte(hashPassphrase(salt, passphrase)), []byte(stored)) == 1, nil
+}
+
+// readPassphrase prompts for a passphrase without echoing it on terminals
+func readPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// checkUnlocked asks for the passphrase of a locked weblet before its
+// settings, URL or existence may be changed
+func (wm *WebletManager) checkUnlocked(weblet *Weblet) error {
+	if !weblet.Locked {
+		return nil
+	}
+
+	passphrase, err := readPassphrase(T("Weblet '%s' is locked. Passphrase: ", weblet.Name))
+	if err != nil {
+		return err
+	}
+	ok, err := verifyPassphrase(weblet.Name, passphrase)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return newError(ErrLocked, "wrong passphrase for weblet '%s'", weblet.Name)
+	}
+	return nil
+}
+
+// Lock protects a weblet's settings with a passphrase; kiosk additionally
+// runs it fullscreen without browser UI
+func (wm *WebletManager) Lock(name string, kiosk bool) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+
+	passphrase, err := readPassphrase(T("New passphrase: "))
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return newError(ErrInvalid, "passphrase must not be empty")
+	}
+	confirm, err := readPassphrase(T("Repeat passphrase: "))
+	if err != nil {
+		return err
+	}
+	if confirm != passphrase {
+		return newError(ErrInvalid, "passphrases do not match")
+	}
+
+	if err := storePassphrase(name, passphrase); err != nil {
+		return err
+	}
+
+	weblet.Locked = true
+	weblet.Kiosk = kiosk
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+
+	if kiosk {
+		fmt.Print(T("Locked weblet '%s' (kiosk mode)\n", name))
+	} else {
+		fmt.Print(T("Locked weblet '%s'\n", name))
+	}
+	return nil
+}
+
+// Unlock removes a weblet's passphrase protection and kiosk mode
+func (wm *WebletManager) Unlock(name string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if !weblet.Locked {
+		return newError(ErrInvalid, "weblet '%s' is not locked", name)
+	}
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+
+	weblet.Locked = false
+	weblet.Kiosk = false
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+
+	args := append([]string{"clear"}, secretToolArgs(name)...)
+	exec.Command("secret-tool", args...).Run()
+
+	fmt.Print(T("Unlocked weblet '%s'\n", name))
+	return nil
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"image/png"
 	"io"
 	"net/http"
 	"net/url"
@@ -10,10 +12,14 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/michalCapo/weblet/paths"
 	"github.com/michalCapo/weblet/view"
 )
 
@@ -21,42 +27,154 @@ import (
 var version = "dev"
 
 type Weblet struct {
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	PID       int    `json:"pid,omitempty"`
-	UseChrome bool   `json:"use_chrome,omitempty"` // Use Chrome for WebRTC-heavy apps
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	PID       int      `json:"pid,omitempty"`        // Process of the last launch, see runningPID
+	StartedAt int64    `json:"started_at,omitempty"` // Unix time of the last launch
+	Backend   string   `json:"backend,omitempty"`    // native or chrome, of the last launch
+	UseChrome bool     `json:"use_chrome,omitempty"` // Use Chrome for WebRTC-heavy apps
+	Rules     []string `json:"rules,omitempty"`      // Request block/redirect rules (native mode)
+	Links     []Link   `json:"links,omitempty"`      // Quick links to pages inside the weblet
+	LocalDir  string   `json:"local_dir,omitempty"`  // Directory served as weblet-local:// (native mode)
+
+	Auth *DeviceAuth `json:"auth,omitempty"` // Device flow login seeded into the profile (native mode)
+
+	HealthCheck   bool   `json:"health_check,omitempty"`   // Check reachability before launching
+	OnUnreachable string `json:"on_unreachable,omitempty"` // Shell hook run when unreachable (e.g. start VPN)
+	Netns         string `json:"netns,omitempty"`          // Network namespace the weblet runs in
+	Tor           bool   `json:"tor,omitempty"`            // Route traffic through Tor with a hardened profile
+	Privacy       bool   `json:"privacy,omitempty"`        // Fingerprinting and tracking reduction preset
+	DoNotTrack    bool   `json:"dnt,omitempty"`            // Send the DNT header
+	GPC           bool   `json:"gpc,omitempty"`            // Send the Sec-GPC header (native mode)
+
+	DisableJS     bool `json:"disable_js,omitempty"`     // Turn off JavaScript
+	DisableImages bool `json:"disable_images,omitempty"` // Don't load images
+	DisableWebGL  bool `json:"disable_webgl,omitempty"`  // Turn off WebGL
+	Bridge        bool `json:"bridge,omitempty"`         // Inject the window.weblet JS bridge (native mode)
+	HeaderBar     bool `json:"header_bar,omitempty"`     // Use a GTK header bar (native mode)
+	Undecorated   bool `json:"undecorated,omitempty"`    // No title bar or header bar (native mode)
+
+	StartupJS string `json:"startup_js,omitempty"` // Snippet run when a page finished loading (native mode)
+
+	WindowCSS   string `json:"window_css,omitempty"`   // GTK CSS for the window chrome (native mode)
+	ColorScheme string `json:"color_scheme,omitempty"` // dark or light instead of following the desktop (native mode)
+	Clipboard   string `json:"clipboard,omitempty"`    // allow or deny pages reading the clipboard instead of asking (native mode)
+	Browser     string `json:"browser,omitempty"`      // Desktop file ID or command opening external links
+
+	DisableServiceWorkers bool `json:"disable_service_workers,omitempty"` // Turn off service workers (native mode)
+	DisableOfflineCache   bool `json:"disable_offline_cache,omitempty"`   // Turn off the Cache API (native mode)
+
+	Location string `json:"location,omitempty"` // Fixed "latitude,longitude" reported to pages (native mode)
+
+	Microphone string `json:"microphone,omitempty"` // Part of the name of the default microphone
+	Camera     string `json:"camera,omitempty"`     // Part of the name of the default camera
+
+	History  bool `json:"history,omitempty"`   // Record visited pages for 'weblet history' and Ctrl+H (native mode)
+	Autofill bool `json:"autofill,omitempty"`  // Save and fill logins with the keyring (native mode)
+	IdleAway int  `json:"idle_away,omitempty"` // Minutes without input after which pages see the window as away (native mode)
+	Prewarm  bool `json:"prewarm,omitempty"`   // Load hidden at login so the first open is instant (native mode)
+
+	KeepPlayingOnLock bool `json:"keep_playing_on_lock,omitempty"` // Don't pause media when the screen locks (native mode)
+
+	KeepAlive           int  `json:"keep_alive,omitempty"`            // Minutes between requests keeping the login alive (native mode)
+	KeepAliveReload     bool `json:"keep_alive_reload,omitempty"`     // Reload the page instead of fetching it
+	KeepAliveHibernated bool `json:"keep_alive_hibernated,omitempty"` // Also while closed by memory-saver
+
+	CacheSize int64  `json:"cache_size,omitempty"` // Disk cache limit in bytes
+	Cache     string `json:"cache,omitempty"`      // memory-only keeps the cache off the disk
+
+	ThemeColor         string `json:"theme_color,omitempty"`          // Window tint chosen by the user
+	DetectedThemeColor string `json:"detected_theme_color,omitempty"` // Site's theme-color
+	IconStyle          string `json:"icon_style,omitempty"`           // adaptive (default), rounded or raw
+	IconLight          string `json:"icon_light,omitempty"`           // Icon used with light desktop themes
+	IconDark           string `json:"icon_dark,omitempty"`            // Icon used with dark desktop themes
+	Hidden             bool   `json:"hidden,omitempty"`               // Keep the desktop entry out of the app grid
+
+	Template string `json:"template,omitempty"` // Template the weblet was created from
+
+	Groups []string `json:"groups,omitempty"` // Groups launched and stopped together ('weblet launch')
+
+	Locked bool `json:"locked,omitempty"` // Settings, URL and removal need the passphrase
+	Kiosk  bool `json:"kiosk,omitempty"`  // Fullscreen without browser UI
+
+	Width     int  `json:"width,omitempty"`     // Window size until the user resizes it, 0 for the default
+	Height    int  `json:"height,omitempty"`    // (the native window then keeps the size it was left at)
+	Maximized bool `json:"maximized,omitempty"` // Open maximized, until the user unmaximizes the native window
+
+	Zoom float64 `json:"zoom,omitempty"` // Page zoom, 0 for 100%
+
+	UserAgent string `json:"user_agent,omitempty"` // Sent instead of the browser's (not with privacy or tor)
+	Container string `json:"container,omitempty"`  // Logins and site data to use, "" for the weblet's own
+
+	Mini        bool    `json:"mini,omitempty"`         // Open in mini mode: small, frameless, above other windows (native mode)
+	MiniWidth   int     `json:"mini_width,omitempty"`   // Width of the mini window, 0 for 480
+	MiniHeight  int     `json:"mini_height,omitempty"`  // Height of the mini window, 0 for 270
+	MiniOpacity float64 `json:"mini_opacity,omitempty"` // Opacity of the mini window, 0 for opaque
+
+	System bool `json:"-"` // Loaded from the system-wide definitions
 }
 
 type WebletManager struct {
-	weblets map[string]*Weblet
-	dataDir string
+	weblets   map[string]*Weblet
+	configDir string   // Weblets, global options and templates (see package paths)
+	dataDir   string   // Profiles, icons and Tor's state
+	cacheDir  string   // Thumbnails, error pages, launch locks
+	iconAuth  iconAuth // Credentials for icon downloads (--icon-cookie/--icon-header)
+	system    bool     // Manage system-wide weblets (--system)
+	config    Config   // Global options (config.json)
+	openURL   string   // Page to open instead of the weblet's URL (quick links)
+
+	overrides launchOverrides // Settings of this launch only ('weblet run --zoom/--window')
+
+	defaults     map[string]any          // [defaults] of config.toml
+	fileSettings map[string]*fileSetting // What config.toml and weblets.d changed in the weblets
 }
 
-func NewWebletManager() (*WebletManager, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
-	}
-
-	dataDir := filepath.Join(homeDir, ".weblet")
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
-	}
-
+func NewWebletManager(system bool) (*WebletManager, error) {
 	wm := &WebletManager{
 		weblets: make(map[string]*Weblet),
-		dataDir: dataDir,
+		system:  system,
+	}
+	if system {
+		// Only icons are cached, user data stays in each user's $HOME
+		wm.dataDir = filepath.Join(systemShareDir, "weblet")
+		wm.configDir, wm.cacheDir = wm.dataDir, wm.dataDir
+	} else {
+		var err error
+		if wm.configDir, wm.dataDir, wm.cacheDir, err = paths.Dirs(); err != nil {
+			return nil, fmt.Errorf("failed to get home directory: %w", err)
+		}
+	}
+	for _, dir := range []string{wm.configDir, wm.dataDir, wm.cacheDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
 	}
 
 	if err := wm.loadWeblets(); err != nil {
 		return nil, fmt.Errorf("failed to load weblets: %w", err)
 	}
+	if err := wm.loadConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if !system {
+		if err := wm.loadSystemWeblets(); err != nil {
+			return nil, fmt.Errorf("failed to load system weblets: %w", err)
+		}
+		if paths.Legacy() {
+			wm.migrateLegacyDir()
+		}
+		if err := wm.loadConfigFiles(); err != nil {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+	}
+	wm.loadLaunches()
 
 	return wm, nil
 }
 
 func (wm *WebletManager) loadWeblets() error {
-	dataFile := filepath.Join(wm.dataDir, "weblets.json")
+	dataFile := wm.webletsFile()
 	data, err := os.ReadFile(dataFile)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -79,10 +197,17 @@ func (wm *WebletManager) loadWeblets() error {
 }
 
 func (wm *WebletManager) saveWeblets() error {
-	dataFile := filepath.Join(wm.dataDir, "weblets.json")
+	dataFile := wm.webletsFile()
 	var weblets []Weblet
 	for _, w := range wm.weblets {
-		weblets = append(weblets, *w)
+		// System-wide weblets are only saved by an admin with --system
+		if w.System {
+			continue
+		}
+		saved := wm.savedWeblet(w)
+		// The last launch has a record of its own, see launchRecord
+		saved.PID, saved.StartedAt, saved.Backend = 0, 0, ""
+		weblets = append(weblets, saved)
 	}
 
 	data, err := json.MarshalIndent(weblets, "", "  ")
@@ -90,153 +215,356 @@ func (wm *WebletManager) saveWeblets() error {
 		return err
 	}
 
+	if wm.system {
+		if err := os.MkdirAll(systemConfigDir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s (run with sudo or pkexec): %w", systemConfigDir, err)
+		}
+	}
 	return os.WriteFile(dataFile, data, 0644)
 }
 
 func (wm *WebletManager) List() {
 	if len(wm.weblets) == 0 {
-		fmt.Println("No weblets available.")
+		fmt.Println(T("No weblets available."))
 		return
 	}
 
-	fmt.Println("Available weblets:")
-	for name, weblet := range wm.weblets {
-		mode := ""
+	names := make([]string, 0, len(wm.weblets))
+	width := len(T("NAME"))
+	for name := range wm.weblets {
+		names = append(names, name)
+		width = max(width, len(name))
+	}
+	sort.Strings(names)
+
+	// Backends whose dependencies are missing on this machine get a marker
+	missing := map[string]string{}
+	if !nativeAvailable() {
+		missing["native"] = T("this build of weblet has no native webview (built with no_native)")
+	}
+	if findChrome() == "" {
+		missing["chrome"] = T("Chrome or Chromium isn't installed")
+	}
+
+	fmt.Println(T("Available weblets:"))
+	fmt.Printf("  %-*s  %-8s  %-8s  %s\n", width, T("NAME"), T("BACKEND"), T("STATUS"), T("URL"))
+	warned := map[string]bool{}
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		backend := "chrome"
 		if !weblet.UseChrome {
-			mode = " [native]"
+			backend = "native"
+		}
+		if _, ok := missing[backend]; ok {
+			warned[backend] = true
+			backend += " ⚠"
+		}
+
+		status := T("stopped")
+		if wm.isRunning(weblet) {
+			status = T("running")
+		}
+
+		flags := ""
+		if weblet.Tor {
+			flags += " [tor]"
+		}
+		if weblet.System {
+			flags += " [system]"
 		}
-		fmt.Printf("  %s: %s%s\n", name, weblet.URL, mode)
+		if weblet.Hidden {
+			flags += " [hidden]"
+		}
+		fmt.Printf("  %-*s  %-8s  %-8s  %s%s\n", width, name, backend, status, weblet.URL, flags)
+	}
+
+	for _, backend := range []string{"native", "chrome"} {
+		if warned[backend] {
+			fmt.Print(T("⚠ %s: %s\n", backend, missing[backend]))
+		}
+	}
+}
+
+// isRunning reports whether a weblet has a live process or an open window
+func (wm *WebletManager) isRunning(weblet *Weblet) bool {
+	if wm.runningPID(weblet) > 0 {
+		return true
 	}
+	if weblet.UseChrome && wm.isChromeProcessRunning(filepath.Join(wm.dataDir, "chrome-data", weblet.Name)) {
+		return true
+	}
+	return wm.isWebletWindowOpen(weblet.Name)
 }
 
 func (wm *WebletManager) Setup() error {
-	fmt.Println("=== Weblet Setup ===")
+	fmt.Println(T("=== Weblet Setup ==="))
 	fmt.Println()
 
+	pm, knownDistro := distroPackageManager()
+	if name := osRelease()["PRETTY_NAME"]; name != "" {
+		fmt.Print(T("Distribution: %s\n", name))
+		fmt.Println()
+	}
+	installHint := func(need string) {
+		if knownDistro {
+			fmt.Printf("   - %s\n", pm.installHint(need))
+		} else {
+			fmt.Print(T("   - %s from your distribution's packages\n", need))
+		}
+	}
+	var missing []string
+
 	// Check for window management tools (needed for focusing existing windows)
-	fmt.Println("Checking window management tools:")
+	fmt.Println(T("Checking window management tools:"))
 	wmctrlInstalled := wm.checkTool("wmctrl")
 	xdotoolInstalled := wm.checkTool("xdotool")
 
 	if !wmctrlInstalled && !xdotoolInstalled {
-		fmt.Println("\n⚠️  Warning: Neither wmctrl nor xdotool found!")
-		fmt.Println("   Window focusing feature will not work.")
-		fmt.Println("   Install at least one with:")
-		fmt.Println("   - sudo apt install wmctrl")
-		fmt.Println("   - sudo apt install xdotool")
+		fmt.Println(T("\n⚠️  Warning: Neither wmctrl nor xdotool found!"))
+		fmt.Println(T("   Window focusing feature will not work."))
+		fmt.Println(T("   Install at least one with:"))
+		installHint("wmctrl")
+		installHint("xdotool")
 		fmt.Println()
+		missing = append(missing, "wmctrl", "xdotool")
 	} else if !wmctrlInstalled {
-		fmt.Println("\n⚠️  Warning: wmctrl not found (xdotool is available)")
-		fmt.Println("   Consider installing wmctrl for better compatibility:")
-		fmt.Println("   - sudo apt install wmctrl")
+		fmt.Println(T("\n⚠️  Warning: wmctrl not found (xdotool is available)"))
+		fmt.Println(T("   Consider installing wmctrl for better compatibility:"))
+		installHint("wmctrl")
 		fmt.Println()
+		missing = append(missing, "wmctrl")
 	} else if !xdotoolInstalled {
-		fmt.Println("\n⚠️  Warning: xdotool not found (wmctrl is available)")
-		fmt.Println("   Consider installing xdotool as a fallback option:")
-		fmt.Println("   - sudo apt install xdotool")
+		fmt.Println(T("\n⚠️  Warning: xdotool not found (wmctrl is available)"))
+		fmt.Println(T("   Consider installing xdotool as a fallback option:"))
+		installHint("xdotool")
 		fmt.Println()
+		missing = append(missing, "xdotool")
 	} else {
-		fmt.Println("\n✓ All window management tools are installed!")
+		fmt.Println(T("\n✓ All window management tools are installed!"))
 		fmt.Println()
 	}
 
-	fmt.Println("✓ Weblet uses native webview for displaying web applications.")
-	fmt.Println("  No browser configuration needed.")
+	if nativeAvailable() {
+		fmt.Println(T("✓ Weblet uses native webview for displaying web applications."))
+		fmt.Println(T("  No browser configuration needed."))
+	} else {
+		fmt.Println(T("⚠️  This build of weblet has no native webview (built with no_native)."))
+		fmt.Println(T("   Install GTK 3 and WebKitGTK 4.1 and build weblet again without the no_native tag:"))
+		installHint("webkit")
+		missing = append(missing, "webkit")
+	}
+
+	if len(missing) > 0 && knownDistro {
+		return wm.installPackages(pm, missing)
+	}
+	return nil
+}
+
+// installPackages installs what setup found missing with pkexec, after
+// asking on the terminal
+func (wm *WebletManager) installPackages(pm packageManager, needs []string) error {
+	if !isTerminal(os.Stdin) {
+		return nil
+	}
+	if _, err := hostLookPath("pkexec"); err != nil {
+		return nil
+	}
+
+	args := pm.installArgs(needs...)
+	fmt.Println()
+	if !confirm(T("Install them now with '%s'? [y/N] ", "pkexec "+strings.Join(args, " "))) {
+		return nil
+	}
+	cmd := hostCommand("pkexec", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to install %s: %w", strings.Join(needs, ", "), err)
+	}
 
+	fmt.Println()
+	for _, need := range needs {
+		if need == "webkit" {
+			fmt.Println(T("✓ GTK and WebKitGTK installed, build weblet again without the no_native tag to use the native webview"))
+		} else {
+			wm.checkTool(need)
+		}
+	}
 	return nil
 }
 
 func (wm *WebletManager) checkTool(tool string) bool {
-	path, err := exec.LookPath(tool)
+	path, err := hostLookPath(tool)
 	if err != nil {
-		fmt.Printf("  ✗ %s: not found\n", tool)
+		fmt.Print(T("  ✗ %s: not found\n", tool))
 		return false
 	}
-	fmt.Printf("  ✓ %s: %s\n", tool, path)
+	fmt.Print(T("  ✓ %s: %s\n", tool, path))
 	return true
 }
 
 func (wm *WebletManager) Run(name string) error {
 	weblet, exists := wm.weblets[name]
 	if !exists {
-		return fmt.Errorf("weblet '%s' not found", name)
+		return newError(ErrNotFound, "weblet '%s' not found", name)
 	}
 
 	// If weblet uses Chrome, run with Chrome instead of native webview
 	if weblet.UseChrome {
 		return wm.runWithChrome(weblet)
 	}
+	if !nativeAvailable() {
+		return wm.backendMissing(weblet)
+	}
 
 	// Check if we're already running as a background process
 	isBackground := os.Getenv("WEBLET_BACKGROUND") == "1"
 
-	// Check if webview window with this name already exists
-	if wm.isWebletWindowOpen(name) {
+	// The recorded process is the cheapest check, then look for its window.
+	// The background process is (a child of) the recorded one itself.
+	running := !isBackground && wm.runningPID(weblet) > 0
+	windowOpen := running || wm.isWebletWindowOpen(name)
+	if !isBackground {
+		markStartup("window check")
+	}
+	if windowOpen {
 		// Try to focus the existing window by title
 		if isBackground {
 			// Background process: just exit silently, window already exists
 			return nil
 		}
-		return wm.focusWindowByTitle(name)
+		// A prewarmed window is shown by its own process
+		if running && wm.isPrewarmed(name) {
+			if _, err := view.Query(name, "focus"); err == nil {
+				return nil
+			}
+		}
+		// Launched moments ago, the window may not be mapped yet
+		if running && !wm.isWebletWindowOpen(name) && !wm.isWindowMapped(name) {
+			fmt.Print(T("Weblet '%s' is starting, waiting for window...\n", name))
+			if !wm.waitForWindow(weblet) {
+				return newError(ErrFocusFailed, "weblet '%s' exited before showing its window", name)
+			}
+		}
+		return wrapError(ErrFocusFailed, wm.focusWindowByTitle(name))
 	}
 
-	// Lock file to prevent race conditions
-	lockDir := filepath.Join(wm.dataDir, "locks")
-	os.MkdirAll(lockDir, 0755)
-	lockFile := filepath.Join(lockDir, name+".lock")
-
 	if isBackground {
-		// We're the background process - remove lock when done
-		defer os.Remove(lockFile)
+		markStartupAt("background process started", processStart)
+
+		// We're the background process - remove the lock file when done
+		defer os.Remove(wm.lockFile(name))
 
 		// Double-check window doesn't exist (another process might have created it)
 		if wm.isWebletWindowOpen(name) {
 			return nil
 		}
 
-		// Run the webview
-		view.RunWebview(weblet.URL, name)
+		wm.overrides = overridesFromEnv()
+		opts, err := wm.viewOptions(weblet)
+		if err != nil {
+			return err
+		}
+
+		// Local sites are served over HTTP for the lifetime of the window
+		webletURL := weblet.URL
+		if openURL := os.Getenv("WEBLET_OPEN_URL"); openURL != "" {
+			webletURL = openURL
+		} else if path, ok := localPath(weblet.URL); ok {
+			webletURL, err = serveLocal(name, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The web processes inherit the normalized timezone
+		if weblet.Privacy {
+			os.Setenv("TZ", privacyTimezone)
+		}
+
+		// Links the window opens externally aren't routed back to it
+		os.Setenv("WEBLET_NAME", name)
+
+		// The memory-only cache lives as long as the window
+		if opts.CacheDir != "" {
+			os.MkdirAll(opts.CacheDir, 0700)
+			defer os.RemoveAll(opts.CacheDir)
+		} else {
+			wm.pruneCache(weblet)
+		}
+
+		// Tell a waiting 'weblet run' when the window is there
+		opts.ReadyFile = wm.lockFile(name)
+		os.MkdirAll(filepath.Dir(opts.ThumbnailFile), 0755)
+		rotateAudit(opts.AuditFile)
+
+		// Run the webview; a window the user closed isn't resumed at login
+		if stubBackend {
+			return runStubWindow(name, webletURL, opts)
+		}
+		view.RunWebview(webletURL, name, opts)
+		if !view.SessionEnded() {
+			wm.clearLaunch(name)
+		}
 		return nil
 	}
 
-	// Parent process: try to acquire lock atomically before spawning
-	lock, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
-	if err != nil {
-		// Lock exists - another instance is starting, wait for window and focus
-		fmt.Printf("Weblet '%s' is starting, waiting for window...\n", name)
-		for i := 0; i < 20; i++ {
-			time.Sleep(200 * time.Millisecond)
-			if wm.isWebletWindowOpen(name) {
-				return wm.focusWindowByTitle(name)
-			}
+	if weblet.Tor {
+		if weblet.Netns != "" {
+			return errTorNetns
 		}
-		// Timeout - check if lock is stale (older than 10 seconds)
-		if info, err := os.Stat(lockFile); err == nil {
-			if time.Since(info.ModTime()) > 10*time.Second {
-				os.Remove(lockFile) // Stale lock, remove it
-				return wm.Run(name) // Retry
-			}
+		if _, err := wm.ensureTor(); err != nil {
+			return err
+		}
+	}
+
+	// Optional reachability check; the webview shows the error page if it fails
+	wm.preflight(weblet)
+
+	// Parent process: hold the launch lock until the new process is recorded
+	lock, err := wm.lockLaunch(name)
+	if err != nil {
+		return fmt.Errorf("failed to lock weblet '%s': %w", name, err)
+	}
+	defer lock.Close()
+
+	// Another run may have started the weblet while we waited for the lock
+	if err := wm.reloadWeblet(name); err != nil {
+		return err
+	}
+	if wm.runningPID(weblet) > 0 {
+		fmt.Print(T("Weblet '%s' is starting, waiting for window...\n", name))
+		if !wm.waitForWindow(weblet) {
+			return newError(ErrFocusFailed, "weblet '%s' exited before showing its window", name)
 		}
-		return fmt.Errorf("timeout waiting for weblet '%s' to start", name)
+		return wrapError(ErrFocusFailed, wm.focusWindowByTitle(name))
 	}
-	lock.Close()
+	lock.Truncate(0)
 
 	// Fork to background: spawn ourselves with the same arguments
 	executable, err := os.Executable()
 	if err != nil {
-		os.Remove(lockFile)
 		return fmt.Errorf("failed to get executable path: %w", err)
 	}
 
-	cmd := exec.Command(executable, name)
-	cmd.Env = append(os.Environ(), "WEBLET_BACKGROUND=1")
+	env := []string{"WEBLET_BACKGROUND=1"}
+	if wm.openURL != "" {
+		env = append(env, "WEBLET_OPEN_URL="+wm.openURL)
+	}
+	env = append(env, wm.overrides.env()...)
 
-	// Redirect output to /dev/null but keep display access
-	devNull, err := os.OpenFile("/dev/null", os.O_WRONLY, 0)
-	if err == nil {
-		cmd.Stdout = devNull
-		cmd.Stderr = devNull
-		defer devNull.Close()
+	// run also opens weblets named like a command
+	cmd, err := wm.launchCommand(weblet, env, executable, "run", name)
+	if err != nil {
+		return err
+	}
+
+	// Redirect output to the weblet's log but keep display access
+	if logFile := wm.openLog(name); logFile != nil {
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		defer logFile.Close()
 	}
 	cmd.Stdin = nil
 
@@ -246,19 +574,168 @@ func (wm *WebletManager) Run(name string) error {
 	}
 
 	if err := cmd.Start(); err != nil {
-		os.Remove(lockFile)
 		return fmt.Errorf("failed to start background process: %w", err)
 	}
 
 	pid := cmd.Process.Pid
+	markStartup("process spawned")
+	wm.recordLaunch(weblet, pid, "native")
 
 	// Detach from the child process so it continues after we exit
 	cmd.Process.Release()
 
-	fmt.Printf("Started weblet '%s' in background (PID %d)\n", name, pid)
+	fmt.Print(T("Started weblet '%s' in background (PID %d)\n", name, pid))
 	return nil
 }
 
+// viewOptions builds the native webview settings for a weblet
+func (wm *WebletManager) viewOptions(weblet *Weblet) (view.Options, error) {
+	var opts view.Options
+
+	if err := compileRules(weblet.Rules, &opts); err != nil {
+		return opts, err
+	}
+	opts.LocalDir = weblet.LocalDir
+	opts.RetryOnFailure = isLoopbackURL(weblet.URL)
+	if weblet.HealthCheck {
+		opts.ErrorPage = unreachablePage(weblet)
+	}
+
+	opts.DisableJavaScript = weblet.DisableJS
+	opts.DisableImages = weblet.DisableImages
+	opts.DisableWebGL = weblet.DisableWebGL
+	opts.DisableServiceWorkers = weblet.DisableServiceWorkers
+	if weblet.Location != "" {
+		opts.Latitude, opts.Longitude, _ = parseLocation(weblet.Location)
+		opts.FixedLocation = true
+	}
+	opts.DisableOfflineCache = weblet.DisableOfflineCache
+	opts.Zoom, opts.Width, opts.Height = wm.launchSettings(weblet)
+	opts.Mini, opts.MiniWidth, opts.MiniHeight, opts.MiniOpacity = weblet.Mini, weblet.MiniWidth, weblet.MiniHeight, weblet.MiniOpacity
+	// A size given for one launch doesn't replace the one the window was left at
+	opts.TemporarySize = wm.overrides.width > 0
+	opts.Watch = wm.overrides.watch
+	if container := wm.launchContainer(weblet); container != "" {
+		opts.SiteDir = wm.webkitSiteDir(weblet.Name, container)
+	}
+	if weblet.History {
+		opts.HistoryFile = wm.historyFile(weblet.Name)
+	}
+	opts.Autofill = weblet.Autofill
+	opts.IdleTimeout = weblet.IdleAway * 60
+	opts.KeepAlive = weblet.KeepAlive * 60
+	opts.KeepAliveReload = weblet.KeepAliveReload
+	for _, script := range []string{wm.seedScript(weblet), deviceScript(weblet)} {
+		if script != "" {
+			opts.StartupScripts = append(opts.StartupScripts, script)
+		}
+	}
+	opts.Bridge = weblet.Bridge
+	opts.HeaderBar = weblet.HeaderBar
+	opts.WindowCSS = weblet.WindowCSS
+	opts.LoadScript = weblet.StartupJS
+	opts.ColorScheme = weblet.ColorScheme
+	opts.Browser = weblet.Browser
+	opts.Clipboard = weblet.Clipboard
+	opts.Kiosk = weblet.Kiosk
+	opts.Maximized = weblet.Maximized
+	opts.Undecorated = weblet.Undecorated
+	opts.PauseOnLock = !weblet.KeepPlayingOnLock
+	opts.Inspector, _ = strconv.Atoi(os.Getenv("WEBLET_INSPECTOR"))
+	opts.StartHidden = os.Getenv("WEBLET_START_HIDDEN") == "1"
+	opts.Prewarm = os.Getenv("WEBLET_PREWARM") == "1"
+	if settle, err := strconv.Atoi(os.Getenv("WEBLET_WARM")); err == nil && settle > 0 {
+		opts.Prewarm = true
+		opts.Warm = settle
+	}
+	opts.ProfileFile = os.Getenv("WEBLET_PROFILE")
+	opts.ThumbnailFile = wm.thumbnailFile(weblet.Name)
+	opts.AuditFile = wm.auditFile(weblet.Name)
+	opts.CrashFile = wm.crashFile(weblet.Name)
+	if wm.config.Thumbnails {
+		opts.ThumbnailInterval = thumbnailInterval
+	}
+	if weblet.Cache == "memory-only" {
+		opts.CacheDir = wm.memoryCacheDir(weblet.Name)
+		if container := wm.launchContainer(weblet); container != "" {
+			opts.CacheDir = filepath.Join(opts.CacheDir, "containers", container)
+		}
+	}
+
+	opts.Translations = make(map[string]string)
+	for _, text := range view.UIStrings {
+		if translated := translate(text); translated != text {
+			opts.Translations[text] = translated
+		}
+	}
+	if color := weblet.themeColor(); color != "" {
+		opts.ThemeColor = color
+		opts.ThemeTextColor = contrastColor(color)
+	}
+
+	for _, link := range weblet.Links {
+		opts.Links = append(opts.Links, view.Link{Name: link.Name, URL: link.URL})
+	}
+	opts.DoNotTrack = weblet.DoNotTrack
+	opts.GlobalPrivacyControl = weblet.GPC
+	opts.UserAgent = weblet.UserAgent
+	if weblet.Privacy {
+		opts.Privacy = true
+		opts.UserAgent = privacyUserAgent
+		opts.DisableWebGL = true
+	}
+
+	if weblet.Tor {
+		addr, ok := findTorProxy()
+		if !ok {
+			return opts, fmt.Errorf("Tor SOCKS proxy is not running")
+		}
+		opts.Proxy = "socks://" + addr
+		opts.UserAgent = torUserAgent
+		opts.Hardened = true
+		opts.TitleSuffix = " [Tor]"
+	}
+
+	return opts, nil
+}
+
+// launchCommand builds the command that starts a weblet process with env
+// ("KEY=value") added to the environment, placing it in the weblet's
+// network namespace if one is configured
+func (wm *WebletManager) launchCommand(weblet *Weblet, env []string, name string, args ...string) (*exec.Cmd, error) {
+	argv := append([]string{name}, args...)
+	environ := append(os.Environ(), env...)
+
+	if weblet.Netns != "" {
+		if !netnsExists(weblet.Netns) {
+			return nil, fmt.Errorf("network namespace '%s' does not exist (create it with 'ip netns add %s')", weblet.Netns, weblet.Netns)
+		}
+		wrapped, err := netnsCommand(weblet.Netns, argv, environ)
+		if err != nil {
+			return nil, err
+		}
+		argv = wrapped
+	}
+
+	cmd := hostCommandEnv(env, argv[0], argv[1:]...)
+	cmd.Env = environ
+	return cmd, nil
+}
+
+// findChrome returns the Chrome or Chromium command, or "" if neither is
+// installed
+func findChrome() string {
+	if stubBackend {
+		return stubChrome
+	}
+	for _, browser := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser"} {
+		if _, err := hostLookPath(browser); err == nil {
+			return browser
+		}
+	}
+	return ""
+}
+
 // runWithChrome runs the weblet using Chrome/Chromium in app mode
 // This is needed for WebRTC-heavy apps like Discord that need full audio device support
 func (wm *WebletManager) runWithChrome(weblet *Weblet) error {
@@ -266,132 +743,913 @@ func (wm *WebletManager) runWithChrome(weblet *Weblet) error {
 	userDataDir := filepath.Join(wm.dataDir, "chrome-data", weblet.Name)
 	os.MkdirAll(userDataDir, 0755)
 
-	// Most reliable check: look for Chrome process with this weblet's user-data-dir
-	// This works on both X11 and Wayland
-	if wm.isChromeProcessRunning(userDataDir) {
-		fmt.Printf("Weblet '%s' is already running, focusing window...\n", weblet.Name)
+	// Most reliable check: the recorded process, or a Chrome process with
+	// this weblet's user-data-dir. This works on both X11 and Wayland
+	// A quick link opens another window in the running instance
+	running := wm.runningPID(weblet) > 0 || wm.isChromeProcessRunning(userDataDir)
+	if running && wm.openURL == "" {
+		fmt.Print(T("Weblet '%s' is already running, focusing window...\n", weblet.Name))
 		// Try to focus the window using available methods
 		if err := wm.focusChromeWindowAnyMethod(weblet.Name, weblet.URL); err != nil {
 			// If focusing fails (e.g., on Wayland without proper tools), inform user
-			fmt.Printf("Note: Could not focus window automatically (%v). Please switch to it manually.\n", err)
+			fmt.Print(T("Note: Could not focus window automatically (%v). Please switch to it manually.\n", err))
 		}
 		return nil
 	}
 
 	// Fallback: Check if Chrome window exists by WM_CLASS or window title (X11 only)
-	if wm.isWebletWindowOpen(weblet.Name) {
-		return wm.focusWindowByTitle(weblet.Name)
+	if wm.openURL == "" && wm.isWebletWindowOpen(weblet.Name) {
+		return wrapError(ErrFocusFailed, wm.focusWindowByTitle(weblet.Name))
 	}
 
 	// Additional check: look for Chrome windows with the weblet's URL in the title
 	// Chrome app windows typically show the page title
-	if wm.isChromeWebletWindowOpen(weblet.Name, weblet.URL) {
-		return wm.focusChromeWindow(weblet.Name, weblet.URL)
+	if wm.openURL == "" && wm.isChromeWebletWindowOpen(weblet.Name, weblet.URL) {
+		return wrapError(ErrFocusFailed, wm.focusChromeWindow(weblet.Name, weblet.URL))
+	}
+
+	// A crashed Chrome leaves its profile locked
+	if !running {
+		if err := wm.checkChromeProfile(weblet, userDataDir); err != nil {
+			return err
+		}
+	}
+
+	// Find Chrome or Chromium
+	browser := findChrome()
+	if browser == "" {
+		return wm.backendMissing(weblet)
+	}
+
+	// Open an error page with a retry button if the pre-flight check fails
+	appURL := weblet.URL
+	if wm.openURL != "" {
+		appURL = wm.openURL
+	}
+	if !wm.preflight(weblet) {
+		pagePath, err := wm.writeUnreachablePage(weblet)
+		if err != nil {
+			return fmt.Errorf("failed to write error page: %w", err)
+		}
+		appURL = "file://" + pagePath
+	}
+
+	// Start Chrome in app mode
+	// Force X11 mode via XWayland so wmctrl can focus the window on Wayland
+	args := []string{
+		"--app=" + appURL,
+		"--user-data-dir=" + userDataDir,
+		"--class=weblet-" + weblet.Name,
+		"--ozone-platform=x11",
+	}
+	if container := wm.launchContainer(weblet); container != "" {
+		args = append(args, "--profile-directory="+chromeProfileName(container))
+	}
+
+	zoom, width, height := wm.launchSettings(weblet)
+	if weblet.Kiosk {
+		args = append(args, "--kiosk")
+	} else if weblet.Maximized && wm.overrides.width == 0 {
+		args = append(args, "--start-maximized")
+	} else if width > 0 {
+		args = append(args, fmt.Sprintf("--window-size=%d,%d", width, height))
+	}
+	if zoom > 0 {
+		// Chrome has no default page zoom flag, this also scales its controls
+		args = append(args, fmt.Sprintf("--force-device-scale-factor=%g", zoom))
+	}
+
+	// Local sites are opened directly from disk with relaxed file access
+	if _, ok := localPath(weblet.URL); ok {
+		args = append(args, "--allow-file-access-from-files")
+	}
+
+	// Content toggles for reading-only weblets
+	var blinkSettings []string
+	if weblet.DisableJS {
+		blinkSettings = append(blinkSettings, "scriptEnabled=false")
+	}
+	if weblet.DisableImages {
+		blinkSettings = append(blinkSettings, "imagesEnabled=false")
+	}
+	if len(blinkSettings) > 0 {
+		args = append(args, "--blink-settings="+strings.Join(blinkSettings, ","))
+	}
+	if weblet.DisableWebGL {
+		args = append(args, "--disable-webgl")
+	}
+	if weblet.UserAgent != "" && !weblet.Privacy && !weblet.Tor {
+		args = append(args, "--user-agent="+weblet.UserAgent)
+	}
+	args = append(args, wm.chromeCacheArgs(weblet)...)
+
+	if err := setChromeDoNotTrack(userDataDir, weblet.DoNotTrack); err != nil {
+		fmt.Print(T("Warning: Could not set Do Not Track: %v\n", err))
+	}
+	if err := setChromeCaptureDevices(userDataDir, weblet); err != nil {
+		fmt.Print(T("Warning: Could not set the microphone or camera: %v\n", err))
+	}
+	if weblet.Privacy {
+		if err := blockChromeThirdPartyCookies(userDataDir); err != nil {
+			fmt.Print(T("Warning: Could not block third-party cookies: %v\n", err))
+		}
+		args = append(args, privacyChromeArgs()...)
+	}
+
+	if weblet.Tor {
+		addr, err := wm.ensureTor()
+		if err != nil {
+			return err
+		}
+		args = append(args, torChromeArgs(addr)...)
+	}
+
+	// Give a local dev server a chance to boot instead of showing an error page
+	if isLoopbackURL(weblet.URL) {
+		if err := waitForServer(weblet.URL, 2*time.Minute); err != nil {
+			return wrapError(ErrUnreachable, err)
+		}
+	}
+
+	var env []string
+	if weblet.Privacy {
+		env = append(env, "TZ="+privacyTimezone)
+	}
+	cmd, err := wm.launchCommand(weblet, env, browser, args...)
+	if err != nil {
+		return err
+	}
+
+	// Redirect output to the weblet's log
+	if logFile := wm.openLog(weblet.Name); logFile != nil {
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+		defer logFile.Close()
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start Chrome: %w", err)
+	}
+	markStartup("process spawned")
+
+	// Joining a running instance, the new process exits right away
+	if !running {
+		wm.recordLaunch(weblet, cmd.Process.Pid, "chrome")
+	}
+	cmd.Process.Release()
+	if weblet.Tor {
+		fmt.Print(T("Started weblet '%s' with Chrome over Tor\n", weblet.Name))
+		return nil
+	}
+	fmt.Print(T("Started weblet '%s' with Chrome (WebRTC mode)\n", weblet.Name))
+	return nil
+}
+
+// Refresh re-downloads the icon and updates the desktop file for a weblet
+func (wm *WebletManager) Refresh(name string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if weblet.System && !wm.system {
+		return fmt.Errorf("weblet '%s' is installed system-wide (refresh it with 'sudo weblet --system refresh %s')", name, name)
 	}
 
-	// Find Chrome or Chromium
-	browsers := []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser"}
-	var browser string
-	for _, b := range browsers {
-		if _, err := exec.LookPath(b); err == nil {
-			browser = b
-			break
+	if err := wm.refreshWeblet(weblet); err != nil {
+		return err
+	}
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+
+	fmt.Print(T("Refreshed weblet '%s'\n", name))
+	return nil
+}
+
+// RefreshAll refreshes every weblet concurrently with a bounded number of
+// workers, reporting each result and a summary instead of stopping at the
+// first failure
+func (wm *WebletManager) RefreshAll(jobs int) error {
+	// System-wide weblets are refreshed by an admin with --system
+	var targets []*Weblet
+	for _, weblet := range wm.weblets {
+		if !weblet.System || wm.system {
+			targets = append(targets, weblet)
+		}
+	}
+
+	if len(targets) == 0 {
+		fmt.Println(T("No weblets available."))
+		return nil
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+
+	// One spinner for the whole run, the per-weblet ones would fight over
+	// the line
+	p := startProgress(T("Refreshing %d weblets", len(targets)))
+
+	queue := make(chan *Weblet)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for weblet := range queue {
+				results <- result{name: weblet.Name, err: wm.refreshWeblet(weblet)}
+			}
+		}()
+	}
+
+	go func() {
+		for _, weblet := range targets {
+			queue <- weblet
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed []result
+	finished := 0
+	for r := range results {
+		finished++
+		if r.err != nil {
+			p.Println(T("✗ %s: %v", r.name, r.err))
+			failed = append(failed, r)
+		} else {
+			p.Println(T("✓ %s", r.name))
+		}
+		p.Update(fmt.Sprintf("%d/%d", finished, len(targets)))
+	}
+	p.Stop("")
+
+	// Theme colors were updated in memory, save them once
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+
+	fmt.Print(T("\nRefreshed %d of %d weblets\n", len(targets)-len(failed), len(targets)))
+	if len(failed) > 0 {
+		fmt.Println(T("Failed:"))
+		for _, r := range failed {
+			fmt.Print(T("  %s: %v\n", r.name, r.err))
+		}
+		return fmt.Errorf("%d weblet(s) failed to refresh", len(failed))
+	}
+	return nil
+}
+
+// refreshWeblet re-downloads the icon, re-creates the desktop file and
+// re-detects the theme color of a weblet (without saving)
+func (wm *WebletManager) refreshWeblet(weblet *Weblet) error {
+	name := weblet.Name
+
+	// Remove old icon files for this weblet
+	iconDir := filepath.Join(wm.dataDir, "icons")
+	extensions := []string{".png", ".ico", ".svg", ".jpg"}
+	for _, ext := range extensions {
+		iconPath := filepath.Join(iconDir, name+ext)
+		os.Remove(iconPath) // Ignore errors, file might not exist
+	}
+
+	// Re-create the desktop file (which will re-download the icon)
+	if err := wm.createDesktopFile(name, weblet.URL); err != nil {
+		return fmt.Errorf("failed to refresh weblet: %w", err)
+	}
+
+	// Pick up theme-color changes of the site
+	if _, ok := localPath(weblet.URL); !ok {
+		p := startProgress(T("Detecting theme color of '%s'", name))
+		weblet.DetectedThemeColor = detectThemeColor(weblet.URL)
+		p.Stop("")
+	}
+
+	return nil
+}
+
+// SetChromeMode enables or disables Chrome mode for a weblet
+func (wm *WebletManager) SetChromeMode(name string, useChrome bool) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+
+	changed := weblet.UseChrome != useChrome
+	weblet.UseChrome = useChrome
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+
+	if useChrome {
+		fmt.Print(T("Weblet '%s' will now use Chrome (default, full audio support)\n", name))
+	} else {
+		fmt.Print(T("Weblet '%s' will now use native webview (lighter, no WebRTC audio)\n", name))
+	}
+
+	// Carry the logins over to the other cookie store
+	if changed {
+		if wm.runningPID(weblet) > 0 {
+			fmt.Print(T("Note: close weblet '%s' and switch again to keep its logins\n", name))
+			return nil
+		}
+		p := startProgress(T("Copying logins of '%s'", name))
+		count, err := wm.migrateSession(weblet, useChrome)
+		p.Stop("")
+		if err != nil {
+			fmt.Print(T("Warning: Could not copy logins: %v\n", err))
+		} else if count > 0 {
+			fmt.Print(T("Copied %d cookies, logins should carry over\n", count))
+		}
+	}
+	return nil
+}
+
+// Set changes a single weblet setting; an empty value resets it to the default
+func (wm *WebletManager) Set(name, key, value string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+
+	before := *weblet
+	value, nativeOnly, err := wm.applySetting(weblet, key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+	if err := wm.settingsSaved(&before, weblet); err != nil {
+		return err
+	}
+
+	if value == "" {
+		fmt.Print(T("Reset %s for weblet '%s'\n", key, name))
+	} else {
+		fmt.Print(T("Set %s for weblet '%s' to '%s'\n", key, name, value))
+	}
+	if nativeOnly && weblet.UseChrome {
+		fmt.Print(T("Note: %s is only applied in native mode (see 'weblet native')\n", key))
+	}
+	if key == "hidden" {
+		if err := wm.updateDesktopEntry(weblet); err != nil {
+			fmt.Print(T("Warning: Could not update desktop file: %v\n", err))
+		}
+	}
+	return nil
+}
+
+// defaultIdleAway is the idle time, in minutes, of 'idle-away on'; GNOME
+// blanks the screen after 5 minutes by default
+const defaultIdleAway = 5
+
+// applySetting changes a setting of a weblet like parseSetting and copies
+// a custom icon into place
+func (wm *WebletManager) applySetting(weblet *Weblet, key, value string) (string, bool, error) {
+	source := value
+	value, nativeOnly, err := wm.parseSetting(weblet, key, value)
+	if err != nil {
+		return "", false, err
+	}
+	if (key == "icon-light" || key == "icon-dark") && value != "" {
+		if err := copyCustomIcon(source, value); err != nil {
+			return "", false, err
+		}
+	}
+	return value, nativeOnly, nil
+}
+
+// copyCustomIcon copies an icon to the private copy parseSetting chose
+func copyCustomIcon(source, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := copyFile(source, dst); err != nil {
+		return fmt.Errorf("failed to copy icon: %w", err)
+	}
+	return nil
+}
+
+// settingsSaved updates what follows the settings of a weblet once they are
+// saved: the window size it was left at and the prewarm autostart entry
+func (wm *WebletManager) settingsSaved(before, weblet *Weblet) error {
+	if before.Width != weblet.Width || before.Height != weblet.Height || before.Maximized != weblet.Maximized {
+		// The size the window was left at would win over the new one
+		wm.forgetWindowSize(weblet.Name)
+	}
+	if before.Prewarm != weblet.Prewarm {
+		return wm.updatePrewarmAutostart(weblet)
+	}
+	return nil
+}
+
+// parseSetting changes a setting of a weblet in memory, without touching
+// any file, and returns the normalized value and whether the setting only
+// affects native mode
+func (wm *WebletManager) parseSetting(weblet *Weblet, key, value string) (string, bool, error) {
+	name := weblet.Name
+	nativeOnly := false
+
+	switch key {
+	case "local-dir":
+		if value != "" {
+			dir, err := filepath.Abs(value)
+			if err != nil {
+				return "", false, fmt.Errorf("invalid directory: %w", err)
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				return "", false, fmt.Errorf("'%s' is not a directory", value)
+			}
+			value = dir
+		}
+		weblet.LocalDir = value
+		nativeOnly = true
+
+	case "health-check":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.HealthCheck = enabled
+
+	case "on-unreachable":
+		weblet.OnUnreachable = value
+
+	case "netns":
+		if value != "" && weblet.Tor {
+			return "", false, errTorNetns
+		}
+		if value != "" && !netnsExists(value) {
+			fmt.Print(T("Warning: network namespace '%s' does not exist yet\n", value))
+		}
+		weblet.Netns = value
+
+	case "tor":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		if enabled && weblet.Netns != "" {
+			return "", false, errTorNetns
+		}
+		weblet.Tor = enabled
+
+	case "privacy":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Privacy = enabled
+
+	case "dnt":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.DoNotTrack = enabled
+
+	case "history":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.History = enabled
+		nativeOnly = true
+
+	case "autofill":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Autofill = enabled
+		nativeOnly = true
+
+	case "idle-away":
+		minutes, err := strconv.Atoi(value)
+		if err != nil {
+			enabled, switchErr := parseSwitch(value)
+			if switchErr != nil {
+				return "", false, fmt.Errorf("invalid value '%s' (expected on, off or minutes)", value)
+			}
+			minutes = 0
+			if enabled {
+				minutes = defaultIdleAway
+			}
+		}
+		if minutes < 0 {
+			return "", false, fmt.Errorf("invalid value '%s' (expected on, off or minutes)", value)
+		}
+		weblet.IdleAway = minutes
+		value = ""
+		if minutes > 0 {
+			value = strconv.Itoa(minutes)
+		}
+		nativeOnly = true
+
+	case "keep-alive":
+		minutes, err := parseKeepAlive(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.KeepAlive = minutes
+		value = ""
+		if minutes > 0 {
+			value = strconv.Itoa(minutes)
+		}
+		nativeOnly = true
+
+	case "keep-alive-reload", "keep-alive-hibernated":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		if key == "keep-alive-reload" {
+			weblet.KeepAliveReload = enabled
+		} else {
+			weblet.KeepAliveHibernated = enabled
+		}
+		nativeOnly = true
+
+	case "prewarm":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Prewarm = enabled
+		nativeOnly = true
+
+	case "microphone":
+		weblet.Microphone = value
+
+	case "camera":
+		weblet.Camera = value
+
+	case "gpc":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.GPC = enabled
+		nativeOnly = true
+
+	case "bridge":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Bridge = enabled
+		nativeOnly = true
+
+	case "header-bar":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.HeaderBar = enabled
+		nativeOnly = true
+
+	case "clipboard":
+		switch value {
+		case "", "ask", "allow", "deny":
+		default:
+			return "", false, fmt.Errorf("invalid clipboard access '%s' (expected ask, allow or deny)", value)
+		}
+		weblet.Clipboard = value
+		if value == "ask" {
+			weblet.Clipboard = ""
+		}
+		nativeOnly = true
+
+	case "color-scheme":
+		switch value {
+		case "", "system", "dark", "light":
+		default:
+			return "", false, fmt.Errorf("invalid color scheme '%s' (expected system, dark or light)", value)
+		}
+		weblet.ColorScheme = value
+		if value == "system" {
+			weblet.ColorScheme = ""
+		}
+		nativeOnly = true
+
+	case "window-css":
+		css, err := readWindowCSS(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.WindowCSS = css
+		value = css
+		nativeOnly = true
+
+	case "startup-js":
+		weblet.StartupJS = value
+		nativeOnly = true
+
+	case "browser":
+		if value != "" {
+			if err := checkBrowser(value); err != nil {
+				return "", false, err
+			}
+		}
+		weblet.Browser = value
+		nativeOnly = true
+
+	case "location":
+		if value != "" {
+			latitude, longitude, err := parseLocation(value)
+			if err != nil {
+				return "", false, err
+			}
+			value = strconv.FormatFloat(latitude, 'f', -1, 64) + "," + strconv.FormatFloat(longitude, 'f', -1, 64)
+		}
+		weblet.Location = value
+		nativeOnly = true
+
+	case "cache-size":
+		weblet.CacheSize = 0
+		if value != "" {
+			size, err := parseSize(value)
+			if err != nil {
+				return "", false, err
+			}
+			weblet.CacheSize = size
+			value = formatSize(size)
+		}
+
+	case "cache":
+		switch value {
+		case "", "disk":
+			value = ""
+		case "memory-only":
+		default:
+			return "", false, fmt.Errorf("invalid cache mode '%s' (expected disk or memory-only)", value)
+		}
+		weblet.Cache = value
+
+	case "theme-color":
+		if value != "" {
+			color, err := normalizeColor(value)
+			if err != nil {
+				return "", false, err
+			}
+			value = color
+		}
+		weblet.ThemeColor = value
+		nativeOnly = true
+
+	case "icon-style":
+		switch value {
+		case "", "adaptive", "rounded", "raw":
+		default:
+			return "", false, fmt.Errorf("invalid icon style '%s' (expected adaptive, rounded or raw)", value)
+		}
+		weblet.IconStyle = value
+		if value == "adaptive" {
+			weblet.IconStyle = ""
+		}
+
+	case "icon-light", "icon-dark":
+		// Keep a private copy so the variant survives the original moving
+		variant := strings.TrimPrefix(key, "icon-")
+		if value != "" {
+			if info, err := os.Stat(value); err != nil || info.IsDir() {
+				return "", false, fmt.Errorf("icon '%s' is not a file", value)
+			}
+			value = filepath.Join(wm.dataDir, "icons", name+"-custom-"+variant+filepath.Ext(value))
+		}
+		if variant == "light" {
+			weblet.IconLight = value
+		} else {
+			weblet.IconDark = value
+		}
+
+	case "window-size":
+		width, height, err := parseWindowSize(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Width, weblet.Height = width, height
+		value = ""
+		if width > 0 {
+			value = fmt.Sprintf("%dx%d", width, height)
+		}
+
+	case "maximized":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Maximized = enabled
+
+	case "zoom":
+		zoom, err := parseZoom(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Zoom = zoom
+		value = ""
+		if zoom > 0 {
+			value = strconv.FormatFloat(zoom, 'g', -1, 64)
+		}
+
+	case "mini":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Mini = enabled
+		nativeOnly = true
+
+	case "mini-size":
+		width, height, err := parseWindowSize(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.MiniWidth, weblet.MiniHeight = width, height
+		value = ""
+		if width > 0 {
+			value = fmt.Sprintf("%dx%d", width, height)
+		}
+		nativeOnly = true
+
+	case "mini-opacity":
+		opacity, err := parseOpacity(value)
+		if err != nil {
+			return "", false, err
 		}
-	}
-	if browser == "" {
-		return fmt.Errorf("Chrome or Chromium not found. Install with: sudo apt install google-chrome-stable")
-	}
+		weblet.MiniOpacity = opacity
+		value = ""
+		if opacity > 0 {
+			value = fmt.Sprintf("%.0f%%", opacity*100)
+		}
+		nativeOnly = true
 
-	// Start Chrome in app mode
-	// Force X11 mode via XWayland so wmctrl can focus the window on Wayland
-	cmd := exec.Command(browser,
-		"--app="+weblet.URL,
-		"--user-data-dir="+userDataDir,
-		"--class=weblet-"+weblet.Name,
-		"--ozone-platform=x11",
-	)
+	case "user-agent":
+		weblet.UserAgent = strings.TrimSpace(value)
+		value = weblet.UserAgent
 
-	// Redirect output to null
-	devNull, _ := os.OpenFile("/dev/null", os.O_WRONLY, 0)
-	if devNull != nil {
-		cmd.Stdout = devNull
-		cmd.Stderr = devNull
-		defer devNull.Close()
-	}
+	case "container":
+		container, err := parseContainer(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Container = container
+		value = container
 
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	case "hidden":
+		enabled, err := parseSwitch(value)
+		if err != nil {
+			return "", false, err
+		}
+		weblet.Hidden = enabled
+
+	case "js", "images", "webgl", "service-workers", "offline-cache", "pause-on-lock", "decorations":
+		// Everything is enabled by default, so resetting means on
+		enabled := true
+		if value != "" {
+			var err error
+			if enabled, err = parseSwitch(value); err != nil {
+				return "", false, err
+			}
+		}
+		switch key {
+		case "js":
+			weblet.DisableJS = !enabled
+		case "images":
+			weblet.DisableImages = !enabled
+		case "webgl":
+			weblet.DisableWebGL = !enabled
+		case "service-workers":
+			weblet.DisableServiceWorkers = !enabled
+			nativeOnly = true
+		case "offline-cache":
+			weblet.DisableOfflineCache = !enabled
+			nativeOnly = true
+		case "pause-on-lock":
+			weblet.KeepPlayingOnLock = !enabled
+			nativeOnly = true
+		case "decorations":
+			weblet.Undecorated = !enabled
+			nativeOnly = true
+		}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start Chrome: %w", err)
+	default:
+		return "", false, newError(ErrInvalid, "unknown setting '%s'", key)
 	}
 
-	cmd.Process.Release()
-	fmt.Printf("Started weblet '%s' with Chrome (WebRTC mode)\n", weblet.Name)
-	return nil
+	return value, nativeOnly, nil
 }
 
-// Refresh re-downloads the icon and updates the desktop file for a weblet
-func (wm *WebletManager) Refresh(name string) error {
-	weblet, exists := wm.weblets[name]
-	if !exists {
-		return fmt.Errorf("weblet '%s' not found", name)
+// parseOpacity parses an opacity like 0.8 or 80%; empty is opaque
+func parseOpacity(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
 	}
-
-	// Remove old icon files for this weblet
-	iconDir := filepath.Join(wm.dataDir, "icons")
-	extensions := []string{".png", ".ico", ".svg", ".jpg"}
-	for _, ext := range extensions {
-		iconPath := filepath.Join(iconDir, name+ext)
-		os.Remove(iconPath) // Ignore errors, file might not exist
+	opacity, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err == nil && (strings.HasSuffix(value, "%") || opacity > 1) {
+		opacity /= 100
 	}
-
-	// Re-create the desktop file (which will re-download the icon)
-	if err := wm.createDesktopFile(name, weblet.URL); err != nil {
-		return fmt.Errorf("failed to refresh weblet: %w", err)
+	if err != nil || opacity < 0.2 || opacity > 1 {
+		return 0, fmt.Errorf("invalid opacity '%s' (expected 20%% to 100%%, e.g. 0.8 or 80%%)", value)
 	}
-
-	fmt.Printf("Refreshed weblet '%s'\n", name)
-	return nil
+	return opacity, nil
 }
 
-// SetChromeMode enables or disables Chrome mode for a weblet
-func (wm *WebletManager) SetChromeMode(name string, useChrome bool) error {
-	weblet, exists := wm.weblets[name]
-	if !exists {
-		return fmt.Errorf("weblet '%s' not found", name)
-	}
-
-	weblet.UseChrome = useChrome
-	if err := wm.saveWeblets(); err != nil {
-		return err
+// parseSwitch parses on/off style values; an empty value means off
+func parseSwitch(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1":
+		return true, nil
+	case "off", "false", "no", "0", "":
+		return false, nil
 	}
+	return false, fmt.Errorf("invalid value '%s' (expected on or off)", value)
+}
 
-	if useChrome {
-		fmt.Printf("Weblet '%s' will now use Chrome (default, full audio support)\n", name)
-	} else {
-		fmt.Printf("Weblet '%s' will now use native webview (lighter, no WebRTC audio)\n", name)
+// parseLocation parses "latitude,longitude" in decimal degrees
+func parseLocation(value string) (float64, float64, error) {
+	lat, lon, ok := strings.Cut(value, ",")
+	latitude, err1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	longitude, err2 := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if !ok || err1 != nil || err2 != nil || latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return 0, 0, fmt.Errorf("invalid location '%s' (expected latitude,longitude, e.g. 48.1486,17.1077)", value)
 	}
-	return nil
+	return latitude, longitude, nil
 }
 
-func (wm *WebletManager) Add(name, url string) error {
+// Add creates a weblet, with the settings of a template if one is given and
+// the window size of --width, --height and --maximized
+func (wm *WebletManager) Add(name, url, template string, window windowOptions) error {
 	if _, exists := wm.weblets[name]; exists {
-		return fmt.Errorf("weblet '%s' already exists", name)
+		return newError(ErrExists, "weblet '%s' already exists", name)
+	}
+	if err := checkName(name); err != nil {
+		return err
 	}
 
-	wm.weblets[name] = &Weblet{
+	weblet := &Weblet{
 		Name:      name,
 		URL:       url,
 		UseChrome: true, // Chrome is default for full WebRTC/audio support
 	}
+	if backend, ok := wm.defaults["backend"]; ok {
+		useChrome, err := parseBackend(fmt.Sprint(backend))
+		if err != nil {
+			return fmt.Errorf("config.toml: backend: %w", err)
+		}
+		weblet.UseChrome = useChrome
+	}
+	if template != "" {
+		templates, err := wm.loadTemplates()
+		if err != nil {
+			return err
+		}
+		t, exists := templates[template]
+		if !exists {
+			return newError(ErrNotFound, "template '%s' not found", template)
+		}
+		if err := wm.applyTemplate(weblet, t); err != nil {
+			return err
+		}
+	}
+	if _, err := wm.applyWindowOptions(weblet, window); err != nil {
+		return err
+	}
+	if _, ok := localPath(url); !ok {
+		p := startProgress(T("Detecting theme color of '%s'", name))
+		weblet.DetectedThemeColor = detectThemeColor(url)
+		p.Stop("")
+	}
+	wm.weblets[name] = weblet
 
 	if err := wm.saveWeblets(); err != nil {
 		return err
 	}
+	if err := wm.settingsSaved(&Weblet{}, weblet); err != nil {
+		return err
+	}
 
 	// Create desktop file for GNOME
 	if err := wm.createDesktopFile(name, url); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to create desktop file: %v\n", err)
+		fmt.Fprint(os.Stderr, T("Warning: Failed to create desktop file: %v\n", err))
 	}
 
 	return nil
@@ -400,12 +1658,15 @@ func (wm *WebletManager) Add(name, url string) error {
 func (wm *WebletManager) Remove(name string) error {
 	weblet, exists := wm.weblets[name]
 	if !exists {
-		return fmt.Errorf("weblet '%s' not found", name)
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
 	}
 
 	// Stop if running
-	if weblet.PID > 0 && wm.isProcessRunning(weblet.PID) {
-		wm.stopProcess(weblet.PID)
+	if err := wm.stopWeblet(weblet); err != nil {
+		fmt.Fprint(os.Stderr, T("Warning: %v\n", err))
 	}
 
 	delete(wm.weblets, name)
@@ -416,8 +1677,12 @@ func (wm *WebletManager) Remove(name string) error {
 
 	// Remove desktop file for GNOME
 	if err := wm.removeDesktopFile(name); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to remove desktop file: %v\n", err)
+		fmt.Fprint(os.Stderr, T("Warning: Failed to remove desktop file: %v\n", err))
 	}
+	wm.removeThemeIcons(name)
+	wm.unpinRemoved(name)
+	os.Remove(wm.thumbnailFile(name))
+	os.Remove(wm.launchFile(name))
 
 	return nil
 }
@@ -433,9 +1698,19 @@ func (wm *WebletManager) isProcessRunning(pid int) bool {
 }
 
 func (wm *WebletManager) isWebletWindowOpen(name string) bool {
-	// Check by WM_CLASS first (most reliable - works for both native webview and Chrome)
+	// wlroots compositors list native Wayland windows, which wmctrl can't see
+	if found, err := wlrFindWindow(matchWebletWindow(name)); err == nil {
+		return found
+	}
+
+	// Windows owned by the weblet's processes (_NET_WM_PID) are an exact match
+	if _, ok := wm.pidWindow(name); ok {
+		return true
+	}
+
+	// Then check by WM_CLASS (works for both native webview and Chrome)
 	// wmctrl -lx output format: WindowID Desktop WM_CLASS Machine WindowTitle...
-	cmd := exec.Command("wmctrl", "-lx")
+	cmd := hostCommand("wmctrl", "-lx")
 	output, err := cmd.Output()
 	if err == nil {
 		lines := splitLines(string(output))
@@ -455,7 +1730,7 @@ func (wm *WebletManager) isWebletWindowOpen(name string) bool {
 	}
 
 	// Fallback: check by window title
-	cmd = exec.Command("wmctrl", "-l")
+	cmd = hostCommand("wmctrl", "-l")
 	output, err = cmd.Output()
 	if err != nil {
 		return false
@@ -484,7 +1759,14 @@ func (wm *WebletManager) isWebletWindowOpen(name string) bool {
 // isChromeWebletWindowOpen checks if a Chrome app window for this weblet is open
 // Chrome app mode windows may not use the WM_CLASS we set, so we also check by window title
 func (wm *WebletManager) isChromeWebletWindowOpen(name, webletURL string) bool {
-	cmd := exec.Command("wmctrl", "-l")
+	if found, err := wlrFindWindow(matchChromeWindow(name, webletURL)); err == nil {
+		return found
+	}
+	if _, ok := wm.pidWindow(name); ok {
+		return true
+	}
+
+	cmd := hostCommand("wmctrl", "-l")
 	output, err := cmd.Output()
 	if err != nil {
 		return false
@@ -527,9 +1809,17 @@ func (wm *WebletManager) isChromeWebletWindowOpen(name, webletURL string) bool {
 
 // focusChromeWindow finds and focuses a Chrome app window for the weblet
 func (wm *WebletManager) focusChromeWindow(name, webletURL string) error {
-	fmt.Printf("Focusing existing Chrome window: %s\n", name)
+	fmt.Print(T("Focusing existing Chrome window: %s\n", name))
 
-	cmd := exec.Command("wmctrl", "-l")
+	if err := wlrFocusWindow(matchChromeWindow(name, webletURL)); err == nil {
+		fmt.Print(T("Successfully focused window using %s\n", "wlr-foreign-toplevel"))
+		return nil
+	}
+	if windowID, ok := wm.pidWindow(name); ok {
+		return wm.focusWindowByID(windowID)
+	}
+
+	cmd := hostCommand("wmctrl", "-l")
 	output, err := cmd.Output()
 	if err != nil {
 		return fmt.Errorf("failed to list windows: %w", err)
@@ -569,11 +1859,19 @@ func (wm *WebletManager) focusChromeWindow(name, webletURL string) error {
 }
 
 func (wm *WebletManager) focusWindowByTitle(title string) error {
-	fmt.Printf("Focusing existing window: %s\n", title)
+	fmt.Print(T("Focusing existing window: %s\n", title))
+
+	if err := wlrFocusWindow(matchWebletWindow(title)); err == nil {
+		fmt.Print(T("Successfully focused window using %s\n", "wlr-foreign-toplevel"))
+		return nil
+	}
+	if windowID, ok := wm.pidWindow(title); ok {
+		return wm.focusWindowByID(windowID)
+	}
 
 	// Try to find window by WM_CLASS first (most reliable)
 	// wmctrl -lx output format: WindowID Desktop WM_CLASS Machine WindowTitle...
-	cmd := exec.Command("wmctrl", "-lx")
+	cmd := hostCommand("wmctrl", "-lx")
 	output, err := cmd.Output()
 	if err == nil {
 		lines := splitLines(string(output))
@@ -593,7 +1891,7 @@ func (wm *WebletManager) focusWindowByTitle(title string) error {
 	}
 
 	// Fallback: search by window title
-	cmd = exec.Command("wmctrl", "-l")
+	cmd = hostCommand("wmctrl", "-l")
 	output, err = cmd.Output()
 	if err != nil {
 		return fmt.Errorf("failed to list windows: %w", err)
@@ -628,18 +1926,18 @@ func (wm *WebletManager) focusWindowByID(windowID string) error {
 	}{
 		{
 			name: "wmctrl -i -a",
-			cmd:  exec.Command("wmctrl", "-i", "-a", windowID),
+			cmd:  hostCommand("wmctrl", "-i", "-a", windowID),
 		},
 		{
 			name: "xdotool windowactivate",
-			cmd:  exec.Command("xdotool", "windowactivate", windowID),
+			cmd:  hostCommand("xdotool", "windowactivate", windowID),
 		},
 	}
 
 	var lastErr error
 	for _, method := range methods {
 		if err := method.cmd.Run(); err == nil {
-			fmt.Printf("Successfully focused window using %s\n", method.name)
+			fmt.Print(T("Successfully focused window using %s\n", method.name))
 			return nil
 		} else {
 			lastErr = err
@@ -700,7 +1998,13 @@ func (wm *WebletManager) isChromeProcessRunning(userDataDir string) bool {
 // focusChromeWindowAnyMethod tries multiple methods to focus a Chrome weblet window
 // This handles both X11 and Wayland environments
 func (wm *WebletManager) focusChromeWindowAnyMethod(name, webletURL string) error {
-	// First try the standard wmctrl/xdotool methods (works on X11)
+	// wlroots compositors activate windows through the foreign-toplevel protocol
+	if err := wlrFocusWindow(matchChromeWindow(name, webletURL)); err == nil {
+		fmt.Print(T("Successfully focused window using %s\n", "wlr-foreign-toplevel"))
+		return nil
+	}
+
+	// Then try the standard wmctrl/xdotool methods (works on X11)
 	if err := wm.focusChromeWindow(name, webletURL); err == nil {
 		return nil
 	}
@@ -748,7 +2052,7 @@ func (wm *WebletManager) focusChromeWindowAnyMethod(name, webletURL string) erro
 		// The first bool is success of eval, the second (in quotes) is our result
 		outputStr := string(output)
 		if strings.Contains(outputStr, "'true'") {
-			fmt.Printf("Successfully focused window using GNOME Shell\n")
+			fmt.Print(T("Successfully focused window using GNOME Shell\n"))
 			return nil
 		}
 	}
@@ -777,21 +2081,13 @@ func splitLines(s string) []string {
 	return lines
 }
 
-func (wm *WebletManager) stopProcess(pid int) error {
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return err
-	}
-	return process.Kill()
-}
-
 func (wm *WebletManager) getDesktopFilePath(name string) (string, error) {
-	homeDir, err := os.UserHomeDir()
+	shareDir, err := wm.shareDir()
 	if err != nil {
-		return "", fmt.Errorf("failed to get home directory: %w", err)
+		return "", err
 	}
 
-	desktopDir := filepath.Join(homeDir, ".local", "share", "applications")
+	desktopDir := filepath.Join(shareDir, "applications")
 	if err := os.MkdirAll(desktopDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create applications directory: %w", err)
 	}
@@ -799,7 +2095,7 @@ func (wm *WebletManager) getDesktopFilePath(name string) (string, error) {
 	return filepath.Join(desktopDir, fmt.Sprintf("weblet-%s.desktop", name)), nil
 }
 
-func (wm *WebletManager) downloadFavicon(webletURL, webletName string) (string, error) {
+func (wm *WebletManager) downloadFavicon(webletURL, webletName string, p *progress) (string, error) {
 	parsedURL, err := url.Parse(webletURL)
 	if err != nil {
 		return "", err
@@ -810,11 +2106,13 @@ func (wm *WebletManager) downloadFavicon(webletURL, webletName string) (string,
 		return "", err
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	client, err := wm.newIconClient(webletURL, webletName)
+	if err != nil {
+		return "", err
 	}
 
 	// First, try to parse HTML to find icon links
+	p.Update(webletURL)
 	iconURLs := wm.findIconsFromHTML(webletURL, client)
 
 	// Add common favicon locations as fallback
@@ -848,29 +2146,18 @@ func (wm *WebletManager) downloadFavicon(webletURL, webletName string) (string,
 		fmt.Sprintf("https://icons.duckduckgo.com/ip3/%s.ico", cleanDomain),
 	)
 
-	var icoFallback string
-
-	// Try each icon URL, prioritizing PNG files
-	for _, iconURL := range iconURLs {
-		iconPath, err := wm.downloadIconFile(iconURL, webletName, client, iconDir)
-		if err == nil && iconPath != "" {
-			// Prefer PNG over ICO
-			if strings.HasSuffix(strings.ToLower(iconPath), ".png") {
-				return iconPath, nil
-			}
-			// Store ICO as fallback
-			if strings.HasSuffix(strings.ToLower(iconPath), ".ico") && icoFallback == "" {
-				icoFallback = iconPath
-			}
-		}
+	best := wm.pickIcon(webletName, iconURLs, client, p)
+	if best == nil {
+		return "", fmt.Errorf("failed to download any icon")
 	}
 
-	// Use ICO fallback if we have one
-	if icoFallback != "" {
-		return icoFallback, nil
+	// Use weblet name for the icon file (ensures unique icon per weblet)
+	iconPath := filepath.Join(iconDir, webletName+best.ext)
+	if err := os.WriteFile(iconPath, best.data, 0644); err != nil {
+		os.Remove(iconPath)
+		return "", err
 	}
-
-	return "", fmt.Errorf("failed to download any icon")
+	return iconPath, nil
 }
 
 func (wm *WebletManager) findIconsFromHTML(webletURL string, client *http.Client) []string {
@@ -1037,139 +2324,185 @@ func (wm *WebletManager) findIconsFromManifest(manifestURL string, client *http.
 	return iconURLs
 }
 
-func (wm *WebletManager) downloadIconFile(iconURL, webletName string, client *http.Client, iconDir string) (string, error) {
+// iconCandidate is a downloaded icon that passed validation
+type iconCandidate struct {
+	url  string
+	data []byte
+	ext  string
+	size int // Smaller side of the decoded image, 0 for SVG
+}
+
+// fetchIcon downloads an icon and validates it as an image
+func (wm *WebletManager) fetchIcon(iconURL string, client *http.Client) (*iconCandidate, error) {
 	resp, err := client.Get(iconURL)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch: status %d", resp.StatusCode)
+		return nil, fmt.Errorf("failed to fetch: status %d", resp.StatusCode)
 	}
 
 	// Read the response body
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Validate minimum size (icons should be at least a few bytes)
 	if len(data) < 100 {
-		return "", fmt.Errorf("icon too small: %d bytes", len(data))
-	}
-
-	// Determine file extension from content type or URL
-	ext := ".ico"
-	contentType := resp.Header.Get("Content-Type")
-	if strings.Contains(contentType, "png") || strings.Contains(strings.ToLower(iconURL), ".png") {
-		ext = ".png"
-	} else if strings.Contains(contentType, "svg") {
-		ext = ".svg"
-	} else if strings.Contains(contentType, "jpeg") || strings.Contains(contentType, "jpg") {
-		ext = ".jpg"
+		return nil, fmt.Errorf("icon too small: %d bytes", len(data))
 	}
 
-	// For PNG images, validate dimensions to ensure it's a proper icon (roughly square)
-	// This helps avoid grabbing social media preview images which are rectangular
-	if ext == ".png" {
-		if !wm.isValidIconDimensions(data) {
-			return "", fmt.Errorf("image is not a valid icon (not square)")
+	img, ext, err := validateIcon(data)
+	if err != nil {
+		return nil, err
+	}
+	candidate := &iconCandidate{url: iconURL, data: data, ext: ext}
+	if img != nil {
+		bounds := img.Bounds()
+		candidate.size = min(bounds.Dx(), bounds.Dy())
+	}
+	// GIFs are stored as PNG, the format the rest of weblet expects
+	if ext == ".gif" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
 		}
+		candidate.data, candidate.ext = buf.Bytes(), ".png"
 	}
+	return candidate, nil
+}
 
-	// Use weblet name for the icon file (ensures unique icon per weblet)
-	iconPath := filepath.Join(iconDir, webletName+ext)
-	out, err := os.Create(iconPath)
+// desktopExec returns the command desktop files run weblet with
+func (wm *WebletManager) desktopExec() (string, error) {
+	// Get the path to the weblet executable
+	execPath, err := os.Executable()
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to get executable path: %w", err)
 	}
-	defer out.Close()
 
-	_, err = out.Write(data)
-	if err != nil {
-		os.Remove(iconPath)
-		return "", err
+	// Check if weblet is in PATH, if so use just "weblet" for better portability
+	// But only if the PATH version is the same as our current executable
+	if pathWeblet, err := exec.LookPath("weblet"); err == nil {
+		// Check if the PATH version is the same as our current executable
+		if pathWeblet == execPath {
+			execPath = "weblet"
+		}
+		// Otherwise, use the absolute path to ensure we use our version
 	}
-
-	return iconPath, nil
+	if wm.system && strings.HasPrefix(execPath, "/home/") {
+		printAboveProgress(T("Warning: %s may not be executable by other users, install weblet into /usr/local/bin\n", execPath))
+	}
+	return launcherCommand(execPath), nil
 }
 
-// isValidIconDimensions checks if PNG data represents a roughly square icon
-// Returns true for square or near-square images (aspect ratio between 0.8 and 1.25)
-func (wm *WebletManager) isValidIconDimensions(data []byte) bool {
-	// PNG header: 8 bytes signature, then IHDR chunk
-	// IHDR chunk: 4 bytes length, 4 bytes type ("IHDR"), 4 bytes width, 4 bytes height
-	if len(data) < 24 {
-		return false
+func (wm *WebletManager) createDesktopFile(name, webletURL string) error {
+	// Try to download favicon (or pick one up from a local site)
+	var iconPath string
+	var err error
+	if path, ok := localPath(webletURL); ok {
+		iconPath, err = wm.copyLocalIcon(path, name)
+	} else {
+		p := startProgress(T("Looking for an icon for '%s'", name))
+		iconPath, err = wm.downloadFavicon(webletURL, name, p)
+		if err == nil {
+			p.Stop(T("✓ Found icon for '%s'", name))
+		} else {
+			p.Stop("")
+		}
 	}
+	if err != nil {
+		printAboveProgress(T("Warning: Could not download icon: %v\n", err))
+		// Use a default icon if favicon download fails
+		iconPath = "web-browser"
+	} else {
+		weblet, exists := wm.weblets[name]
 
-	// Check PNG signature
-	pngSig := []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}
-	for i := 0; i < 8; i++ {
-		if data[i] != pngSig[i] {
-			return true // Not a PNG, skip dimension check
+		// Use the light/dark variant matching the desktop's color scheme
+		if exists {
+			iconPath = wm.iconVariant(weblet, iconPath)
 		}
-	}
 
-	// Check for IHDR chunk type at offset 12-15
-	if data[12] != 'I' || data[13] != 'H' || data[14] != 'D' || data[15] != 'R' {
-		return true // Invalid PNG structure, skip check
-	}
+		// Symbolic variant for panels and trays, made from the unpadded glyph
+		if err := wm.installSymbolicIcon(name, iconPath); err != nil && !strings.HasSuffix(iconPath, ".svg") {
+			printAboveProgress(T("Warning: Could not create symbolic icon: %v\n", err))
+		}
 
-	// Read width (big-endian) at offset 16-19
-	width := uint32(data[16])<<24 | uint32(data[17])<<16 | uint32(data[18])<<8 | uint32(data[19])
-	// Read height (big-endian) at offset 20-23
-	height := uint32(data[20])<<24 | uint32(data[21])<<16 | uint32(data[22])<<8 | uint32(data[23])
+		// Pad and upscale to 256x256 so it looks good in big-icon grids
+		if !exists || weblet.IconStyle != "raw" {
+			rounded := exists && weblet.IconStyle == "rounded"
+			if processed, err := processIcon(iconPath, rounded); err != nil {
+				printAboveProgress(T("Warning: Could not process icon: %v\n", err))
+			} else {
+				iconPath = processed
+			}
+		}
+	}
 
-	if width == 0 || height == 0 {
-		return false
+	// In a Flatpak the DynamicLauncher portal installs the entry and its icon
+	if desktopID := wm.launcherDesktopID(name); desktopID != "" && iconPath != "web-browser" {
+		if err := wm.installLauncher(name, webletURL, iconPath, desktopID); err != nil {
+			printAboveProgress(T("Warning: Could not install the launcher through the desktop portal: %v\n", err))
+		} else {
+			printAboveProgress(T("Installed launcher: %s\n", desktopID))
+			return nil
+		}
 	}
 
-	// Calculate aspect ratio
-	var ratio float64
-	if width > height {
-		ratio = float64(width) / float64(height)
-	} else {
-		ratio = float64(height) / float64(width)
+	// Reference the icon by name from the hicolor theme so every size renders crisply
+	icon := iconPath
+	if iconPath != "web-browser" {
+		if iconName, err := wm.installThemeIcons(name, iconPath); err != nil {
+			printAboveProgress(T("Warning: Could not install theme icons: %v\n", err))
+		} else {
+			icon = iconName
+		}
 	}
 
-	// Accept roughly square icons (aspect ratio up to 1.25)
-	// This allows for some padding but rejects 1200x630 social images (ratio ~1.9)
-	return ratio <= 1.25
+	return wm.writeDesktopEntry(name, webletURL, icon)
 }
 
-func (wm *WebletManager) createDesktopFile(name, webletURL string) error {
+// writeDesktopEntry writes the desktop file (and metainfo) of a weblet
+// whose icon is installed as icon
+func (wm *WebletManager) writeDesktopEntry(name, webletURL, icon string) error {
 	desktopFilePath, err := wm.getDesktopFilePath(name)
 	if err != nil {
 		return err
 	}
-
-	// Get the path to the weblet executable
-	execPath, err := os.Executable()
+	execPath, err := wm.desktopExec()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		return err
 	}
 
-	// Check if weblet is in PATH, if so use just "weblet" for better portability
-	// But only if the PATH version is the same as our current executable
-	if pathWeblet, err := exec.LookPath("weblet"); err == nil {
-		// Check if the PATH version is the same as our current executable
-		if pathWeblet == execPath {
-			execPath = "weblet"
-		}
-		// Otherwise, use the absolute path to ensure we use our version
+	// Write the desktop file
+	desktopContent := wm.desktopEntry(name, webletURL, execPath, icon)
+	if err := os.WriteFile(desktopFilePath, []byte(desktopContent), 0644); err != nil {
+		return fmt.Errorf("failed to write desktop file: %w", err)
 	}
 
-	// Try to download favicon
-	iconPath, err := wm.downloadFavicon(webletURL, name)
-	if err != nil {
-		fmt.Printf("Warning: Could not download icon: %v\n", err)
-		// Use a default icon if favicon download fails
-		iconPath = "web-browser"
+	// Make the desktop file executable
+	if err := os.Chmod(desktopFilePath, 0755); err != nil {
+		return fmt.Errorf("failed to make desktop file executable: %w", err)
+	}
+
+	printAboveProgress(T("Created desktop file: %s\n", desktopFilePath))
+
+	if wm.config.AppStream {
+		if err := wm.writeMetainfo(name, webletURL, icon); err != nil {
+			printAboveProgress(T("Warning: Could not write metainfo: %v\n", err))
+		}
 	}
 
-	// Create desktop file content
+	// Update desktop database to make GNOME pick up the new application
+	exec.Command("update-desktop-database", filepath.Dir(desktopFilePath)).Run()
+
+	return nil
+}
+
+// desktopEntry returns the content of the desktop file of a weblet
+func (wm *WebletManager) desktopEntry(name, webletURL, execPath, icon string) string {
 	// StartupWMClass must match what we set in view.go (weblet-<name>)
 	wmClass := fmt.Sprintf("weblet-%s", name)
 	desktopContent := fmt.Sprintf(`[Desktop Entry]
@@ -1187,30 +2520,28 @@ StartupWMClass=%s
 		name,
 		webletURL,
 		execPath,
-		name,
-		iconPath,
+		launchArgs(name),
+		icon,
 		wmClass,
 	)
 
-	// Write the desktop file
-	if err := os.WriteFile(desktopFilePath, []byte(desktopContent), 0644); err != nil {
-		return fmt.Errorf("failed to write desktop file: %w", err)
-	}
-
-	// Make the desktop file executable
-	if err := os.Chmod(desktopFilePath, 0755); err != nil {
-		return fmt.Errorf("failed to make desktop file executable: %w", err)
+	// Quick links become actions (right-click in the dock)
+	if weblet, exists := wm.weblets[name]; exists {
+		if weblet.Hidden {
+			desktopContent += "NoDisplay=true\n"
+		}
+		actions, groups := desktopActions(weblet, execPath)
+		desktopContent += actions + groups
 	}
-
-	fmt.Printf("Created desktop file: %s\n", desktopFilePath)
-
-	// Update desktop database to make GNOME pick up the new application
-	exec.Command("update-desktop-database", filepath.Dir(desktopFilePath)).Run()
-
-	return nil
+	return desktopContent
 }
 
 func (wm *WebletManager) removeDesktopFile(name string) error {
+	if desktopID := wm.launcherDesktopID(name); desktopID != "" {
+		if err := view.UninstallLauncher(desktopID); err == nil {
+			fmt.Print(T("Removed launcher: %s\n", desktopID))
+		}
+	}
 	desktopFilePath, err := wm.getDesktopFilePath(name)
 	if err != nil {
 		return err
@@ -1221,149 +2552,25 @@ func (wm *WebletManager) removeDesktopFile(name string) error {
 		if err := os.Remove(desktopFilePath); err != nil {
 			return fmt.Errorf("failed to remove desktop file: %w", err)
 		}
-		fmt.Printf("Removed desktop file: %s\n", desktopFilePath)
+		fmt.Print(T("Removed desktop file: %s\n", desktopFilePath))
 
 		// Update desktop database
 		exec.Command("update-desktop-database", filepath.Dir(desktopFilePath)).Run()
 	}
+	wm.removeMetainfo(name)
 
 	return nil
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage:")
-		fmt.Println("  weblet version")
-		fmt.Println("  weblet setup")
-		fmt.Println("  weblet list")
-		fmt.Println("  weblet <name>           - Run existing weblet")
-		fmt.Println("  weblet <name> <url>     - Add and run weblet")
-		fmt.Println("  weblet add <name> <url> - Add weblet without running")
-		fmt.Println("  weblet remove <name>    - Remove weblet")
-		fmt.Println("  weblet refresh <name>   - Refresh icon and desktop file")
-		fmt.Println("  weblet native <name>    - Toggle native mode (lighter, no WebRTC)")
-		os.Exit(1)
-	}
-
-	wm, err := NewWebletManager()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-
-	command := os.Args[1]
-
-	switch command {
-	case "version":
-		fmt.Printf("weblet version %s\n", version)
-		return
-
-	case "setup":
-		if err := wm.Setup(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-	case "list":
-		wm.List()
-
-	case "add":
-		if len(os.Args) != 4 {
-			fmt.Println("Usage: weblet add <name> <url>")
-			os.Exit(1)
-		}
-		name := os.Args[2]
-		url := os.Args[3]
-		if err := wm.Add(name, url); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Added weblet '%s' with URL '%s'\n", name, url)
-
-	case "remove":
-		if len(os.Args) != 3 {
-			fmt.Println("Usage: weblet remove <name>")
-			os.Exit(1)
-		}
-		name := os.Args[2]
-		if err := wm.Remove(name); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Removed weblet '%s'\n", name)
-
-	case "refresh":
-		if len(os.Args) != 3 {
-			fmt.Println("Usage: weblet refresh <name>")
-			fmt.Println("Re-downloads the icon and updates the desktop file")
-			os.Exit(1)
-		}
-		name := os.Args[2]
-		if err := wm.Refresh(name); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-	case "native":
-		if len(os.Args) != 3 {
-			fmt.Println("Usage: weblet native <name>")
-			fmt.Println("Toggles native webview mode (lighter weight, but no WebRTC audio)")
-			os.Exit(1)
-		}
-		name := os.Args[2]
-		weblet, exists := wm.weblets[name]
-		if !exists {
-			fmt.Fprintf(os.Stderr, "Error: weblet '%s' not found\n", name)
-			os.Exit(1)
-		}
-		// Toggle native mode (inverse of Chrome mode)
-		if err := wm.SetChromeMode(name, !weblet.UseChrome); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
-		}
-
-	default:
-		// Handle: weblet <name> or weblet <name> <url>
-		name := command
-		var url string
-
-		// Check if URL is provided (add and run immediately)
-		if len(os.Args) == 3 {
-			url = os.Args[2]
-
-			// Check if weblet already exists
-			if existingWeblet, exists := wm.weblets[name]; exists {
-				if existingWeblet.URL == url {
-					// Same URL - just run it (idempotent behavior)
-					fmt.Printf("Weblet '%s' already exists with this URL\n", name)
-				} else {
-					// Different URL - update it
-					existingWeblet.URL = url
-					if err := wm.saveWeblets(); err != nil {
-						fmt.Fprintf(os.Stderr, "Error saving weblets: %v\n", err)
-						os.Exit(1)
-					}
-					fmt.Printf("Updated weblet '%s' with new URL '%s'\n", name, url)
-				}
-			} else {
-				// Weblet doesn't exist - add it
-				if err := wm.Add(name, url); err != nil {
-					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-					os.Exit(1)
-				}
-				fmt.Printf("Added weblet '%s' with URL '%s'\n", name, url)
-			}
-		} else if len(os.Args) > 3 {
-			fmt.Println("Usage:")
-			fmt.Println("  weblet <name>           - Run existing weblet")
-			fmt.Println("  weblet <name> <url>     - Add and run weblet")
-			os.Exit(1)
-		}
-
-		// Run the weblet
-		if err := wm.Run(name); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	// The fake tools of the stub backend (see stub.go)
+	if len(os.Args) >= 3 && os.Args[1] == "__stub" {
+		if err := runStub(os.Args[2], os.Args[3:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+		return
 	}
+
+	runCommandLine(os.Args[1:])
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Integration tests of launching, focusing, locking and stopping weblets:
+// they run the weblet binary with the stub backend (see stub.go), in a
+// home directory and fake window manager of their own
+
+// webletBinary is the weblet built for the tests
+var webletBinary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "weblet-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// The stub backend needs no webview, so this builds without GTK too
+	webletBinary = filepath.Join(dir, "weblet")
+	build := exec.Command("go", "build", "-tags", "no_native", "-o", webletBinary, ".")
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to build weblet:", err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// stubDesktop is a home directory with weblets and a fake window manager
+type stubDesktop struct {
+	t   *testing.T
+	env []string
+}
+
+// newStubDesktop sets up a desktop with the native weblets of urls (by
+// name) and stops whatever runs on it when the test ends
+func newStubDesktop(t *testing.T, urls map[string]string) *stubDesktop {
+	home := t.TempDir()
+	runtimeDir := filepath.Join(home, "run")
+	os.Mkdir(runtimeDir, 0700)
+
+	// The fake window manager is also read by this process (stubWindows)
+	t.Setenv("WEBLET_STUB_DIR", filepath.Join(home, "stub"))
+
+	var env []string
+	for _, variable := range os.Environ() {
+		key, _, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(key, "WEBLET_") && !strings.HasPrefix(key, "XDG_") && key != "DISPLAY" && key != "WAYLAND_DISPLAY" {
+			env = append(env, variable)
+		}
+	}
+	env = append(env,
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, ".config"),
+		"XDG_DATA_HOME="+filepath.Join(home, ".local", "share"),
+		"XDG_CACHE_HOME="+filepath.Join(home, ".cache"),
+		"XDG_RUNTIME_DIR="+runtimeDir,
+		"WEBLET_BACKEND=stub",
+		"WEBLET_STUB_DIR="+filepath.Join(home, "stub"),
+	)
+
+	var weblets []Weblet
+	for name, url := range urls {
+		weblets = append(weblets, Weblet{Name: name, URL: url})
+	}
+	data, _ := json.Marshal(weblets)
+	configDir := filepath.Join(home, ".config", "weblet")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "weblets.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &stubDesktop{t: t, env: env}
+	t.Cleanup(func() {
+		for name := range urls {
+			d.weblet("stop", name)
+		}
+		for _, window := range stubWindows() {
+			syscall.Kill(window.pid, syscall.SIGKILL)
+		}
+	})
+	return d
+}
+
+// weblet runs the weblet command and returns its output
+func (d *stubDesktop) weblet(args ...string) (string, error) {
+	cmd := exec.Command(webletBinary, args...)
+	cmd.Env = d.env
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// mustRun runs the weblet command and fails the test if it fails
+func (d *stubDesktop) mustRun(args ...string) string {
+	d.t.Helper()
+	output, err := d.weblet(args...)
+	if err != nil {
+		d.t.Fatalf("weblet %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return output
+}
+
+// windows returns the windows of a weblet
+func (d *stubDesktop) windows(name string) []*stubWindow {
+	var windows []*stubWindow
+	for _, window := range stubWindows() {
+		if window.class == "weblet-"+name+".weblet-"+name {
+			windows = append(windows, window)
+		}
+	}
+	return windows
+}
+
+// waitWindows waits until a weblet has count windows, and fails the test
+// if that doesn't happen
+func (d *stubDesktop) waitWindows(name string, count int) []*stubWindow {
+	d.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		windows := d.windows(name)
+		if len(windows) == count {
+			return windows
+		}
+		if time.Now().After(deadline) {
+			d.t.Fatalf("weblet '%s' has %d windows, expected %d", name, len(windows), count)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// activeWindow returns the ID of the focused window
+func activeWindow() string {
+	data, _ := os.ReadFile(filepath.Join(stubDir(), "active"))
+	return strings.TrimSpace(string(data))
+}
+
+// status returns the status of a weblet from 'weblet status --json'
+func (d *stubDesktop) status(name string) webletStatusJSON {
+	d.t.Helper()
+	var statuses []webletStatusJSON
+	if err := json.Unmarshal([]byte(d.mustRun("status", name, "--json")), &statuses); err != nil || len(statuses) != 1 {
+		d.t.Fatalf("unexpected status of '%s': %v", name, err)
+	}
+	return statuses[0]
+}
+
+func TestRunStartsWindow(t *testing.T) {
+	d := newStubDesktop(t, map[string]string{"mail": "https://mail.example.invalid/"})
+
+	if output := d.mustRun("mail"); !strings.Contains(output, "Started weblet 'mail'") {
+		t.Fatalf("unexpected output of the first run:\n%s", output)
+	}
+	window := d.waitWindows("mail", 1)[0]
+	if activeWindow() != window.id {
+		t.Errorf("new window %s isn't focused, %s is", window.id, activeWindow())
+	}
+
+	status := d.status("mail")
+	if status.Status != "running" || status.Backend != "native" || status.Window != window.id {
+		t.Errorf("unexpected status of a running weblet: %+v", status)
+	}
+}
+
+func TestRunFocusesRunningWeblet(t *testing.T) {
+	d := newStubDesktop(t, map[string]string{"mail": "https://mail.example.invalid/", "chat": "https://chat.example.invalid/"})
+
+	d.mustRun("mail")
+	window := d.waitWindows("mail", 1)[0]
+	d.mustRun("chat")
+	d.waitWindows("chat", 1)
+	if activeWindow() == window.id {
+		t.Fatal("the window of 'chat' didn't take the focus")
+	}
+
+	if output := d.mustRun("mail"); strings.Contains(output, "Started") {
+		t.Errorf("running weblet was started again:\n%s", output)
+	}
+	if windows := d.waitWindows("mail", 1); windows[0].id != window.id {
+		t.Errorf("window %s replaced %s", windows[0].id, window.id)
+	}
+	if activeWindow() != window.id {
+		t.Errorf("running window %s wasn't focused, %s is", window.id, activeWindow())
+	}
+}
+
+func TestConcurrentRunsStartOneWindow(t *testing.T) {
+	d := newStubDesktop(t, map[string]string{"mail": "https://mail.example.invalid/"})
+
+	// The launch lock lets one run start the weblet, the others wait for
+	// its window and focus it
+	const runs = 4
+	outputs := make([]string, runs)
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output, err := d.weblet("mail")
+			if err != nil {
+				t.Errorf("weblet mail: %v\n%s", err, output)
+			}
+			outputs[i] = output
+		}()
+	}
+	wg.Wait()
+
+	started := 0
+	for _, output := range outputs {
+		started += strings.Count(output, "Started weblet 'mail'")
+	}
+	if started != 1 {
+		t.Errorf("%d runs started the weblet, expected 1:\n%s", started, strings.Join(outputs, "\n"))
+	}
+	d.waitWindows("mail", 1)
+	// A second window would have shown up by now
+	time.Sleep(500 * time.Millisecond)
+	d.waitWindows("mail", 1)
+}
+
+func TestStopClosesWindow(t *testing.T) {
+	d := newStubDesktop(t, map[string]string{"mail": "https://mail.example.invalid/"})
+
+	d.mustRun("mail")
+	d.waitWindows("mail", 1)
+
+	if output := d.mustRun("stop", "mail"); !strings.Contains(output, "Stopped weblet 'mail'") {
+		t.Fatalf("unexpected output of stop:\n%s", output)
+	}
+	d.waitWindows("mail", 0)
+	if status := d.status("mail"); status.Status != "stopped" {
+		t.Errorf("stopped weblet has status %s", status.Status)
+	}
+
+	// It starts again afterwards
+	if output := d.mustRun("mail"); !strings.Contains(output, "Started weblet 'mail'") {
+		t.Fatalf("stopped weblet didn't start again:\n%s", output)
+	}
+	d.waitWindows("mail", 1)
+}
+
+func TestHelpKeepsPercentSigns(t *testing.T) {
+	d := newStubDesktop(t, nil)
+
+	output := d.mustRun("help", "set")
+	for _, line := range []string{" 125%\n", " 80%\n"} {
+		if !strings.Contains(output, line) {
+			t.Errorf("help of set lacks %q", line)
+		}
+	}
+	if strings.Contains(output, "%!") {
+		t.Errorf("help of set has formatting errors:\n%s", output)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// With 'weblet config memory-saver on', 'weblet memory-watch' runs in the
+// session (started right away and by an autostart entry at login) and
+// watches the kernel's memory pressure (PSI), the signal systemd-oomd acts
+// on. While tasks keep stalling for memory, it closes the background
+// weblet that was used least recently, with a notification, before the
+// desktop swaps itself to a halt. Windows in use or playing sound are
+// kept; a closed weblet is reopened like any other launch.
+
+const memoryWatchAutostartFile = "weblet-memory-watch.desktop"
+
+const (
+	pressureFile = "/proc/pressure/memory"
+
+	// pressureLimit is the share of the last 10 seconds (in percent) in
+	// which some task stalled waiting for memory that counts as running low
+	pressureLimit = 20.0
+	pressurePoll  = 5 * time.Second
+
+	// Windows used more recently than hibernateMinUnused are never closed;
+	// after closing one the system gets hibernateCooldown to recover
+	hibernateMinUnused = 2 * time.Minute
+	hibernateCooldown  = 30 * time.Second
+)
+
+// memoryPressure returns the share of the last 10 seconds in which some
+// task was stalled waiting for memory, in percent
+func memoryPressure() (float64, error) {
+	data, err := os.ReadFile(pressureFile)
+	if err != nil {
+		return 0, err
+	}
+	// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+	for _, line := range splitLines(string(data)) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		if value, ok := strings.CutPrefix(fields[1], "avg10="); ok {
+			return strconv.ParseFloat(value, 64)
+		}
+	}
+	return 0, fmt.Errorf("unexpected format of %s", pressureFile)
+}
+
+func (wm *WebletManager) memoryWatchLock() string {
+	return filepath.Join(wm.cacheDir, "locks", "memory-watch.lock")
+}
+
+// WatchMemory closes background weblets while the system is low on
+// memory; it runs until it is stopped
+func (wm *WebletManager) WatchMemory() error {
+	if !wm.config.MemorySaver {
+		fmt.Println(T("memory-saver is off (turn it on with 'weblet config memory-saver on')."))
+		return nil
+	}
+	if _, err := memoryPressure(); err != nil {
+		return fmt.Errorf("memory pressure isn't available (needs Linux 4.20 or newer): %w", err)
+	}
+
+	// One watcher per user; the lock file holds its PID
+	lockFile := wm.memoryWatchLock()
+	if err := os.MkdirAll(filepath.Dir(lockFile), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		fmt.Println(T("The memory watcher is already running."))
+		return nil
+	}
+	lock.Truncate(0)
+	fmt.Fprintf(lock, "%d\n", os.Getpid())
+
+	started := time.Now()
+	lastUsed := make(map[string]time.Time)   // Chrome weblets seen in the active window
+	hibernated := make(map[string]time.Time) // Closed weblets with keep-alive-hibernated, by their last ping
+	stalled := 0
+	for {
+		time.Sleep(pressurePoll)
+
+		// Weblets may have been added or launched since the last check
+		current, err := NewWebletManager(wm.system)
+		if err != nil {
+			continue
+		}
+		activePID := activeWindowPID()
+		for name, weblet := range current.weblets {
+			if weblet.UseChrome && activePID > 0 && current.runningPID(weblet) > 0 && current.webletPIDs(weblet)[activePID] {
+				lastUsed[name] = time.Now()
+			}
+		}
+		current.keepHibernatedAlive(hibernated)
+
+		// Wait for the pressure to last two checks, not a single spike
+		pressure, err := memoryPressure()
+		if err != nil || pressure < pressureLimit {
+			stalled = 0
+			continue
+		}
+		if stalled++; stalled < 2 {
+			continue
+		}
+
+		weblet := current.leastRecentlyUsed(lastUsed, started, activePID)
+		if weblet == nil {
+			continue
+		}
+		if err := current.hibernate(weblet); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: %s: %v\n", weblet.Name, err))
+		} else {
+			fmt.Print(T("Closed weblet '%s' to free memory (pressure %.0f%%)\n", weblet.Name, pressure))
+			if weblet.KeepAlive > 0 && weblet.KeepAliveHibernated && !weblet.UseChrome {
+				hibernated[weblet.Name] = time.Now()
+			}
+		}
+		stalled = 0
+		time.Sleep(hibernateCooldown)
+	}
+}
+
+// leastRecentlyUsed returns the running weblet whose window was used least
+// recently, if it has been unused for at least hibernateMinUnused
+func (wm *WebletManager) leastRecentlyUsed(lastUsed map[string]time.Time, started time.Time, activePID int) *Weblet {
+	// Chrome weblets playing sound are in use; without pactl they are
+	// left alone
+	streams, err := audioStreams()
+	if err != nil {
+		streams = nil
+	}
+
+	var oldest *Weblet
+	var oldestUnused time.Duration
+	for name, weblet := range wm.weblets {
+		if wm.runningPID(weblet) <= 0 {
+			continue
+		}
+		var unused time.Duration
+		if !weblet.UseChrome {
+			reply, err := view.Query(name, "unused")
+			if err != nil {
+				continue
+			}
+			seconds, err := strconv.Atoi(reply)
+			if err != nil {
+				continue
+			}
+			unused = time.Duration(seconds) * time.Second
+		} else {
+			// The active window is only known on X11 (and XWayland)
+			if activePID <= 0 || streams == nil {
+				continue
+			}
+			pids := wm.webletPIDs(weblet)
+			if pids[activePID] || playsSound(pids, streams) {
+				continue
+			}
+			since := lastUsed[name]
+			if since.IsZero() {
+				since = started
+				if launched := time.Unix(weblet.StartedAt, 0); launched.After(since) {
+					since = launched
+				}
+			}
+			unused = time.Since(since)
+		}
+		if unused >= hibernateMinUnused && unused > oldestUnused {
+			oldest, oldestUnused = weblet, unused
+		}
+	}
+	return oldest
+}
+
+// playsSound reports whether any of the processes has a playback stream
+func playsSound(pids map[int]bool, streams map[int][]string) bool {
+	for pid := range streams {
+		if pids[pid] {
+			return true
+		}
+	}
+	return false
+}
+
+// activeWindowPID returns the process owning the focused window, or 0 if
+// it can't be told (e.g. on Wayland without XWayland)
+func activeWindowPID() int {
+	output, err := hostCommand("xdotool", "getactivewindow", "getwindowpid").Output()
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(output)))
+	return pid
+}
+
+// hibernate closes a weblet to free its memory and tells the user
+func (wm *WebletManager) hibernate(weblet *Weblet) error {
+	if err := wm.stopWeblet(weblet); err != nil {
+		return err
+	}
+
+	if _, err := hostLookPath("notify-send"); err == nil {
+		hostCommand("notify-send", "--app-name=weblet", "--icon=weblet-"+weblet.Name,
+			"--hint=string:desktop-entry:weblet-"+weblet.Name,
+			T("%s was closed to free memory", weblet.Name),
+			T("The system was running low on memory. Open the weblet again when you need it.")).Run()
+	}
+	return nil
+}
+
+// setMemorySaver starts or stops the memory watcher, now and at login
+func (wm *WebletManager) setMemorySaver(enabled bool) error {
+	if err := setAutostart(memoryWatchAutostartFile, "Weblet memory saver",
+		"Close unused weblets when memory runs low", "memory-watch", enabled); err != nil {
+		return err
+	}
+
+	if !enabled {
+		data, err := os.ReadFile(wm.memoryWatchLock())
+		if err != nil {
+			return nil
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if args := processArgs(pid); len(args) > 0 && args[len(args)-1] == "memory-watch" {
+			syscall.Kill(pid, syscall.SIGTERM)
+		}
+		return nil
+	}
+
+	// The watcher exits if it doesn't find memory-saver on
+	wm.config.MemorySaver = true
+	if err := wm.saveConfig(); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+	cmd := exec.Command(executable, "memory-watch")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the memory watcher: %w", err)
+	}
+	cmd.Process.Release()
+	return nil
+}
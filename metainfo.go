@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// With 'weblet config appstream on', every weblet gets an AppStream
+// metainfo file next to its desktop file, so GNOME Software and KDE
+// Discover list it as an installed app with its name, icon and website
+// instead of a bare launcher.
+
+func (wm *WebletManager) metainfoFile(name string) (string, error) {
+	shareDir, err := wm.shareDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(shareDir, "metainfo", "weblet-"+name+".metainfo.xml"), nil
+}
+
+// writeMetainfo writes the metainfo file of a weblet; icon is the theme
+// icon name of its desktop file
+func (wm *WebletManager) writeMetainfo(name, webletURL, icon string) error {
+	path, err := wm.metainfoFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	site := webletURL
+	if parsed, err := url.Parse(webletURL); err == nil && parsed.Host != "" {
+		site = parsed.Host
+	}
+	iconLine := ""
+	if icon != "" && !filepath.IsAbs(icon) {
+		iconLine = fmt.Sprintf("  <icon type=\"stock\">%s</icon>\n", html.EscapeString(icon))
+	}
+	content := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<component type="desktop-application">
+  <id>weblet-%s</id>
+  <metadata_license>CC0-1.0</metadata_license>
+  <name>%s</name>
+  <summary>Web app for %s</summary>
+  <description>
+    <p>%s in its own window, run by weblet.</p>
+  </description>
+  <launchable type="desktop-id">weblet-%s.desktop</launchable>
+%s  <url type="homepage">%s</url>
+  <categories>
+    <category>Network</category>
+    <category>WebBrowser</category>
+  </categories>
+</component>
+`,
+		html.EscapeString(name),
+		html.EscapeString(name),
+		html.EscapeString(site),
+		html.EscapeString(webletURL),
+		html.EscapeString(name),
+		iconLine,
+		html.EscapeString(webletURL),
+	)
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+func (wm *WebletManager) removeMetainfo(name string) {
+	if path, err := wm.metainfoFile(name); err == nil {
+		os.Remove(path)
+	}
+}
+
+// setAppStream writes or removes the metainfo files of all weblets
+func (wm *WebletManager) setAppStream(enabled bool) error {
+	themeDir, err := wm.hicolorDir()
+	if err != nil {
+		return err
+	}
+	for name, weblet := range wm.weblets {
+		if !enabled {
+			wm.removeMetainfo(name)
+			continue
+		}
+		icon := ""
+		if matches, _ := filepath.Glob(filepath.Join(themeDir, "*", "apps", "weblet-"+name+".*")); len(matches) > 0 {
+			icon = "weblet-" + name
+		}
+		if err := wm.writeMetainfo(name, weblet.URL, icon); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/michalCapo/weblet/paths"
+)
+
+// Older versions kept everything in ~/.weblet. The first run that finds it
+// moves it to the XDG directories (see package paths): the weblets, global
+// options and templates to ~/.config/weblet, thumbnails, error pages, locks
+// and sockets to ~/.cache/weblet, and the rest (profiles, icons, Tor) to
+// ~/.local/share/weblet. While one of its weblets runs its files are in
+// use, so the move waits for a later run. When something can't be moved,
+// what was moved goes back and ~/.weblet stays in use.
+
+// legacyConfigEntries are the files of ~/.weblet that go to the config
+// directory, legacyCacheEntries those that go to the cache directory
+var (
+	legacyConfigEntries = []string{"weblets.json", "config.json", "templates.json"}
+	legacyCacheEntries  = []string{"thumbnails", "pages", "locks", "sockets"}
+)
+
+// migrateLegacyDir moves ~/.weblet to the XDG directories and makes the
+// manager use them
+func (wm *WebletManager) migrateLegacyDir() {
+	legacyDir, err := paths.LegacyDir()
+	if err != nil {
+		return
+	}
+	for _, name := range wm.sortedNames() {
+		if len(wm.webletPIDs(wm.weblets[name])) > 0 {
+			debugf("not moving %s yet, weblet %s is running", legacyDir, name)
+			return
+		}
+	}
+	configDir, dataDir, cacheDir, err := paths.XDGDirs()
+	if err == nil {
+		err = moveLegacyDir(legacyDir, configDir, dataDir, cacheDir)
+	}
+	if err != nil {
+		fmt.Fprint(os.Stderr, T("Warning: Could not move %s to the XDG directories, it stays in use: %v\n", legacyDir, err))
+		return
+	}
+	wm.configDir, wm.dataDir, wm.cacheDir = configDir, dataDir, cacheDir
+	fmt.Fprint(os.Stderr, T("Moved %s to %s, %s and %s\n", legacyDir, configDir, dataDir, cacheDir))
+
+	// Paths of custom icons and desktop files that use an icon file
+	// still point into the old directory
+	wm.relocateLegacyPaths(legacyDir+string(filepath.Separator), dataDir+string(filepath.Separator))
+}
+
+// moveLegacyDir moves the entries of legacyDir to their XDG directories and
+// removes it; on failure the moved entries are put back
+func moveLegacyDir(legacyDir, configDir, dataDir, cacheDir string) (err error) {
+	entries, err := os.ReadDir(legacyDir)
+	if err != nil {
+		return err
+	}
+	var moved [][2]string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(moved) - 1; i >= 0; i-- {
+			os.Rename(moved[i][1], moved[i][0])
+		}
+	}()
+
+	for _, entry := range entries {
+		dir := dataDir
+		switch {
+		case slices.Contains(legacyConfigEntries, entry.Name()):
+			dir = configDir
+		case slices.Contains(legacyCacheEntries, entry.Name()):
+			dir = cacheDir
+		}
+		from, to := filepath.Join(legacyDir, entry.Name()), filepath.Join(dir, entry.Name())
+		if _, err := os.Lstat(to); err == nil {
+			return fmt.Errorf("%s already exists", to)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		// Renames keep permissions and work while nothing uses the files;
+		// a home directory spread over file systems isn't supported
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		moved = append(moved, [2]string{from, to})
+	}
+	return os.Remove(legacyDir)
+}
+
+// relocateLegacyPaths rewrites paths under the old directory in the
+// weblets and their desktop files
+func (wm *WebletManager) relocateLegacyPaths(oldPrefix, newPrefix string) {
+	changed := false
+	for _, weblet := range wm.weblets {
+		if weblet.System {
+			continue
+		}
+		for _, icon := range []*string{&weblet.IconLight, &weblet.IconDark} {
+			if strings.HasPrefix(*icon, oldPrefix) {
+				*icon = newPrefix + strings.TrimPrefix(*icon, oldPrefix)
+				changed = true
+			}
+		}
+
+		desktopFile, err := wm.getDesktopFilePath(weblet.Name)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(desktopFile)
+		if err != nil || !strings.Contains(string(data), oldPrefix) {
+			continue
+		}
+		content := strings.ReplaceAll(string(data), oldPrefix, newPrefix)
+		if err := os.WriteFile(desktopFile, []byte(content), 0644); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Could not update desktop file of weblet '%s': %v\n", weblet.Name, err))
+		}
+	}
+	if changed {
+		if err := wm.saveWeblets(); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Could not save weblets: %v\n", err))
+		}
+	}
+}
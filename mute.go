@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// 'weblet mute --all' is the panic button for "something is playing sound
+// somewhere": it silences every running weblet at once. Native windows are
+// muted through their control socket, which keeps them silent until
+// unmuted; Chrome weblets by muting their streams in PulseAudio/PipeWire,
+// which covers the streams playing at that moment.
+
+// SetMuted mutes or unmutes the named weblets, or all running ones
+func (wm *WebletManager) SetMuted(names []string, all, muted bool) error {
+	if all {
+		names = nil
+		for name, weblet := range wm.weblets {
+			if wm.runningPID(weblet) > 0 || weblet.UseChrome && wm.isChromeProcessRunning(filepath.Join(wm.dataDir, "chrome-data", name)) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if _, exists := wm.weblets[name]; !exists {
+			return newError(ErrNotFound, "weblet '%s' not found", name)
+		}
+	}
+
+	done := 0
+	for _, name := range names {
+		if err := wm.setWebletMuted(wm.weblets[name], muted); err != nil {
+			// Named weblets are expected to be running
+			if !all {
+				return err
+			}
+			fmt.Fprint(os.Stderr, T("Warning: %s: %v\n", name, err))
+			continue
+		}
+		done++
+	}
+
+	switch {
+	case all && len(names) == 0:
+		fmt.Println(T("No weblets are running."))
+	case muted:
+		fmt.Print(T("Muted %d weblet(s)\n", done))
+	default:
+		fmt.Print(T("Unmuted %d weblet(s)\n", done))
+	}
+	if done < len(names) {
+		return fmt.Errorf("%d weblet(s) could not be changed", len(names)-done)
+	}
+	return nil
+}
+
+// setWebletMuted mutes one weblet, through its window if it is native
+func (wm *WebletManager) setWebletMuted(weblet *Weblet, muted bool) error {
+	command := "unmute"
+	if muted {
+		command = "mute"
+	}
+	if !weblet.UseChrome {
+		if _, err := view.Query(weblet.Name, command); err == nil {
+			return nil
+		}
+	}
+
+	pids := wm.webletPIDs(weblet)
+	if len(pids) == 0 {
+		return newError(ErrNotFound, "weblet '%s' isn't running", weblet.Name)
+	}
+	return muteStreams(pids, muted)
+}
+
+// muteStreams mutes the PulseAudio/PipeWire playback streams of the given
+// processes
+func muteStreams(pids map[int]bool, muted bool) error {
+	if _, err := hostLookPath("pactl"); err != nil {
+		return fmt.Errorf("pactl is needed to mute Chrome weblets (install pulseaudio-utils)")
+	}
+	streams, err := audioStreams()
+	if err != nil {
+		return err
+	}
+	flag := "0"
+	if muted {
+		flag = "1"
+	}
+	for pid, ids := range streams {
+		if !pids[pid] {
+			continue
+		}
+		for _, stream := range ids {
+			if err := hostCommand("pactl", "set-sink-input-mute", stream, flag).Run(); err != nil {
+				return fmt.Errorf("could not mute stream %s: %w", stream, err)
+			}
+		}
+	}
+	return nil
+}
+
+// audioStreams lists the playback streams by the process playing them,
+// read from 'pactl list sink-inputs'
+func audioStreams() (map[int][]string, error) {
+	return pulseStreams("sink-inputs", "Sink Input #")
+}
+
+// recordingStreams lists the recording streams (microphones) by the
+// process recording, read from 'pactl list source-outputs'
+func recordingStreams() (map[int][]string, error) {
+	return pulseStreams("source-outputs", "Source Output #")
+}
+
+// pulseStreams lists the streams of a 'pactl list' section by process
+func pulseStreams(section, header string) (map[int][]string, error) {
+	cmd := hostCommand("pactl", "list", section)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list audio streams: %w", err)
+	}
+
+	streams := make(map[int][]string)
+	var stream string
+	for _, line := range splitLines(string(output)) {
+		if id, ok := strings.CutPrefix(line, header); ok {
+			stream = id
+			continue
+		}
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "application.process.id = ")
+		if !ok || stream == "" {
+			continue
+		}
+		pid, _ := strconv.Atoi(strings.Trim(value, `"`))
+		streams[pid] = append(streams[pid], stream)
+		stream = ""
+	}
+	return streams, nil
+}
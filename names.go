@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Weblets are opened with 'weblet <name>', so a weblet named like a command
+// (list, remove, ...) is shadowed by it. New weblets can't take these
+// names. Ones created before are opened with 'weblet run <name>', which is
+// also what their desktop files are pointed at.
+
+// checkName reports whether a new weblet can have the given name, which is
+// part of paths, the window class and command lines
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\\x00") || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "-") {
+		return newError(ErrInvalid, "invalid weblet name '%s'", name)
+	}
+	if isCommand(name) {
+		return newError(ErrInvalid, "'%s' is a weblet command, choose another name", name)
+	}
+	return nil
+}
+
+// launchArgs are the arguments of weblet that open a weblet
+func launchArgs(name string) string {
+	if isCommand(name) {
+		return "run " + name
+	}
+	return name
+}
+
+// migrateCommandNames points the desktop files of weblets named like a
+// command, which ran the command, at 'weblet run <name>'
+func (wm *WebletManager) migrateCommandNames() {
+	for name, weblet := range wm.weblets {
+		if !isCommand(name) || weblet.System != wm.system {
+			continue
+		}
+		desktopFilePath, err := wm.getDesktopFilePath(name)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(desktopFilePath)
+		if err != nil {
+			continue
+		}
+
+		lines := splitLines(string(data))
+		migrated := false
+		for i, line := range lines {
+			rest, ok := strings.CutPrefix(line, "Exec=")
+			if !ok {
+				continue
+			}
+			if execPath, ok := strings.CutSuffix(rest, " "+name); ok && !strings.HasSuffix(execPath, " run") {
+				lines[i] = "Exec=" + execPath + " " + launchArgs(name)
+				migrated = true
+			}
+			break
+		}
+		if !migrated {
+			continue
+		}
+		if err := os.WriteFile(desktopFilePath, []byte(strings.Join(lines, "\n")+"\n"), 0755); err != nil {
+			continue
+		}
+		// The link actions are written again from the new Exec key
+		if err := wm.updateDesktopEntry(weblet); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Could not update desktop file: %v\n", err))
+		}
+		fmt.Fprint(os.Stderr, T("Note: weblet '%s' has the name of a command, open it with 'weblet run %s' or rename it (weblet rename %s <new-name>)\n", name, name, name))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// isLoopbackURL reports whether the URL points at this machine (e.g. a local dev server)
+func isLoopbackURL(webletURL string) bool {
+	parsed, err := url.Parse(webletURL)
+	if err != nil {
+		return false
+	}
+
+	host := parsed.Hostname()
+	if host == "localhost" || host == "0.0.0.0" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// urlAddress returns the host:port to dial for a URL
+func urlAddress(webletURL string) (string, error) {
+	parsed, err := url.Parse(webletURL)
+	if err != nil {
+		return "", err
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		port = "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(parsed.Hostname(), port), nil
+}
+
+// waitForServer polls a loopback URL until something accepts connections,
+// so a dev server that is still booting doesn't end up as an error page
+func waitForServer(webletURL string, timeout time.Duration) error {
+	addr, err := urlAddress(webletURL)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	announced := false
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server at %s did not come up within %s", webletURL, timeout)
+		}
+		if !announced {
+			fmt.Print(T("Waiting for %s…\n", webletURL))
+			announced = true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// netnsExists reports whether a named network namespace (ip netns add) exists
+func netnsExists(netns string) bool {
+	_, err := os.Stat(filepath.Join("/var/run/netns", netns))
+	return err == nil
+}
+
+// netnsSessionEnv are the variables the command needs from the session,
+// besides the WEBLET_ ones, once sudo reset the environment
+var netnsSessionEnv = []string{"DISPLAY", "WAYLAND_DISPLAY", "XAUTHORITY", "TZ", "XDG_RUNTIME_DIR", "DBUS_SESSION_BUS_ADDRESS", "HOME"}
+
+// netnsCommand wraps argv so it runs inside a network namespace with the
+// given environment. firejail is used when available since it doesn't need
+// root and keeps the environment; otherwise ip netns exec is run through
+// sudo and the command drops back to the current user. sudo resets the
+// environment, so the variables are set again with env instead of relying
+// on what sudoers lets through.
+func netnsCommand(netns string, argv, environ []string) ([]string, error) {
+	if _, err := exec.LookPath("firejail"); err == nil {
+		return append([]string{"firejail", "--quiet", "--noprofile", "--netns=" + netns}, argv...), nil
+	}
+
+	if _, err := exec.LookPath("ip"); err != nil {
+		return nil, fmt.Errorf("neither firejail nor ip found, cannot use network namespace '%s'", netns)
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	wrapped := []string{
+		"sudo", "-n", "ip", "netns", "exec", netns,
+		"sudo", "-n", "-u", current.Username,
+		"env",
+	}
+	for _, variable := range environ {
+		key, _, _ := strings.Cut(variable, "=")
+		if strings.HasPrefix(key, "WEBLET_") || slices.Contains(netnsSessionEnv, key) {
+			wrapped = append(wrapped, variable)
+		}
+	}
+	return append(wrapped, argv...), nil
+}
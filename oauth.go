@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kiosks and system installs can't log in interactively on first start. A
+// weblet with an auth block is authorized once with the OAuth 2.0 device
+// flow (RFC 8628) from any other device: 'weblet auth <name>' (or 'weblet
+// apply --authenticate') prints a code to enter at the provider, polls for
+// the token and seeds it into the weblet's profile, as a cookie of the
+// weblet's site or a localStorage item. Saved refresh tokens renew it
+// without a new code, e.g. from a systemd timer.
+
+// DeviceAuth describes how a weblet is authorized with the device flow
+type DeviceAuth struct {
+	DeviceURL    string `json:"device_url" yaml:"device-url"`                 // Device authorization endpoint
+	TokenURL     string `json:"token_url" yaml:"token-url"`                   // Token endpoint
+	ClientID     string `json:"client_id" yaml:"client-id"`                   // Public client registered with the provider
+	Scope        string `json:"scope,omitempty" yaml:"scope"`                 // Space-separated scopes
+	Cookie       string `json:"cookie,omitempty" yaml:"cookie"`               // Cookie the access token is stored in
+	LocalStorage string `json:"local_storage,omitempty" yaml:"local-storage"` // localStorage key the access token is stored in
+}
+
+// oauthToken is the token response, saved in data/<name>/oauth-token.json
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	ExpiresAt    int64  `json:"expires_at,omitempty"` // Unix time, computed from expires_in
+}
+
+// deviceCode is the device authorization response
+type deviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURL         string `json:"verification_url"` // Google's name for it
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// oauthError is the error response of the token endpoint
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// validate checks a weblet's auth block
+func (auth *DeviceAuth) validate() error {
+	if auth.ClientID == "" {
+		return fmt.Errorf("auth needs a client-id")
+	}
+	for _, endpoint := range []string{auth.DeviceURL, auth.TokenURL} {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("auth endpoints must be https URLs (got '%s')", endpoint)
+		}
+	}
+	if auth.Cookie == "" && auth.LocalStorage == "" {
+		return fmt.Errorf("auth needs a cookie or local-storage key to store the token in")
+	}
+	return nil
+}
+
+func (wm *WebletManager) tokenFile(name string) string {
+	return filepath.Join(wm.dataDir, "data", name, "oauth-token.json")
+}
+
+// loadToken reads the saved token of a weblet, or nil
+func (wm *WebletManager) loadToken(name string) *oauthToken {
+	data, err := os.ReadFile(wm.tokenFile(name))
+	if err != nil {
+		return nil
+	}
+	var token oauthToken
+	if json.Unmarshal(data, &token) != nil || token.AccessToken == "" {
+		return nil
+	}
+	return &token
+}
+
+func (wm *WebletManager) saveToken(name string, token *oauthToken) error {
+	path := wm.tokenFile(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// valid reports whether a token exists and doesn't expire within a minute
+func (token *oauthToken) valid() bool {
+	return token != nil && (token.ExpiresAt == 0 || time.Now().Add(time.Minute).Unix() < token.ExpiresAt)
+}
+
+// Authenticate authorizes a weblet with its auth block: with the saved
+// refresh token if there is one, otherwise with a new device code. force
+// skips a still valid token.
+func (wm *WebletManager) Authenticate(name string, force bool) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if weblet.Auth == nil {
+		return newError(ErrInvalid, "weblet '%s' has no auth block (add one with 'weblet apply')", name)
+	}
+	if weblet.UseChrome {
+		return newError(ErrInvalid, "seeding a login needs native mode (run 'weblet native %s')", name)
+	}
+	if err := weblet.Auth.validate(); err != nil {
+		return wrapError(ErrInvalid, err)
+	}
+
+	token := wm.loadToken(name)
+	switch {
+	case token.valid() && !force:
+		// Seeded again below, in case the profile was reset
+	case token != nil && token.RefreshToken != "":
+		refreshed, err := refreshToken(weblet.Auth, token.RefreshToken)
+		if err == nil {
+			token = refreshed
+			break
+		}
+		fmt.Print(T("Could not refresh the token (%v), asking for a new authorization\n", err))
+		fallthrough
+	default:
+		authorized, err := deviceAuthorization(name, weblet.Auth)
+		if err != nil {
+			return err
+		}
+		token = authorized
+	}
+
+	if err := wm.saveToken(name, token); err != nil {
+		return err
+	}
+	if weblet.Auth.Cookie != "" {
+		if err := wm.seedCookie(weblet, token); err != nil {
+			return fmt.Errorf("could not store the token as a cookie: %w", err)
+		}
+	}
+	fmt.Print(T("Weblet '%s' is authorized.\n", name))
+	if wm.runningPID(weblet) > 0 {
+		fmt.Println(T("Restart it to use the new login."))
+	}
+	return nil
+}
+
+// AuthenticateAll authorizes the weblets with an auth block whose token is
+// missing or expired, one after the other
+func (wm *WebletManager) AuthenticateAll() error {
+	var names []string
+	for name, weblet := range wm.weblets {
+		if weblet.Auth != nil && !wm.loadToken(name).valid() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		if err := wm.Authenticate(name, false); err != nil {
+			fmt.Fprint(os.Stderr, T("Error: %v\n", err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d weblets could not be authorized", failed, len(names))
+	}
+	return nil
+}
+
+// deviceAuthorization runs the device flow: it prints the code the user
+// enters at the provider, then polls until the authorization is granted
+func deviceAuthorization(name string, auth *DeviceAuth) (*oauthToken, error) {
+	form := url.Values{"client_id": {auth.ClientID}}
+	if auth.Scope != "" {
+		form.Set("scope", auth.Scope)
+	}
+	var code deviceCode
+	if err := postForm(auth.DeviceURL, form, &code); err != nil {
+		return nil, fmt.Errorf("device authorization failed: %w", err)
+	}
+	verification := code.VerificationURI
+	if verification == "" {
+		verification = code.VerificationURL
+	}
+	if code.DeviceCode == "" || code.UserCode == "" || verification == "" {
+		return nil, fmt.Errorf("device authorization failed: incomplete response")
+	}
+
+	fmt.Print(T("To authorize '%s', open %s and enter the code: %s\n", name, verification, code.UserCode))
+	if code.VerificationURIComplete != "" {
+		fmt.Print(T("(or open %s)\n", code.VerificationURIComplete))
+	}
+
+	interval := time.Duration(max(code.Interval, 5)) * time.Second
+	deadline := time.Now().Add(time.Duration(max(code.ExpiresIn, 60)) * time.Second)
+	form = url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {code.DeviceCode},
+		"client_id":   {auth.ClientID},
+	}
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		token, err := requestToken(auth.TokenURL, form)
+		if err == nil {
+			return token, nil
+		}
+		oauthErr, ok := err.(*oauthError)
+		if !ok {
+			return nil, err
+		}
+		switch oauthErr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("authorization failed: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("the code expired before it was entered")
+}
+
+// refreshToken gets a new access token with a refresh token
+func refreshToken(auth *DeviceAuth, refresh string) (*oauthToken, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refresh},
+		"client_id":     {auth.ClientID},
+	}
+	if auth.Scope != "" {
+		form.Set("scope", auth.Scope)
+	}
+	token, err := requestToken(auth.TokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	// Providers may keep the refresh token unchanged
+	if token.RefreshToken == "" {
+		token.RefreshToken = refresh
+	}
+	return token, nil
+}
+
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// requestToken posts to the token endpoint; OAuth errors are returned as
+// *oauthError
+func requestToken(tokenURL string, form url.Values) (*oauthToken, error) {
+	var token oauthToken
+	if err := postForm(tokenURL, form, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("the token response has no access token")
+	}
+	if token.ExpiresIn > 0 {
+		token.ExpiresAt = time.Now().Unix() + token.ExpiresIn
+	}
+	return &token, nil
+}
+
+// postForm posts a form and decodes the JSON response into result; error
+// responses with an OAuth error code become *oauthError
+func postForm(endpoint string, form url.Values, result any) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := newHTTPClient(nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr oauthError
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Code != "" {
+			return &oauthErr
+		}
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// seedCookie stores the access token as a cookie of the weblet's host in
+// WebKit's cookie database, replacing an earlier one
+func (wm *WebletManager) seedCookie(weblet *Weblet, token *oauthToken) error {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return fmt.Errorf("sqlite3 is needed (install the sqlite3 package)")
+	}
+	u, err := url.Parse(weblet.URL)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("weblet URL has no host")
+	}
+	cookieFile := wm.webkitCookieFile(weblet)
+	if err := os.MkdirAll(filepath.Dir(cookieFile), 0700); err != nil {
+		return err
+	}
+
+	expiry := token.ExpiresAt
+	if expiry == 0 {
+		expiry = time.Now().AddDate(1, 0, 0).Unix()
+	}
+	return writeWebKitCookies(cookieFile, []sessionCookie{{
+		host: u.Hostname(), path: "/", name: weblet.Auth.Cookie, value: token.AccessToken,
+		expiry: expiry, secure: u.Scheme == "https",
+	}})
+}
+
+// seedScript returns a startup script that puts the saved access token in
+// localStorage for the weblet's origin. A value the page stored itself (e.g.
+// a token it refreshed) is kept; the last seeded token is remembered under
+// "weblet-seed:<key>" to tell them apart.
+func (wm *WebletManager) seedScript(weblet *Weblet) string {
+	if weblet.Auth == nil || weblet.Auth.LocalStorage == "" {
+		return ""
+	}
+	token := wm.loadToken(weblet.Name)
+	u, err := url.Parse(weblet.URL)
+	if token == nil || err != nil {
+		return ""
+	}
+	origin, _ := json.Marshal(u.Scheme + "://" + u.Host)
+	key, _ := json.Marshal(weblet.Auth.LocalStorage)
+	marker, _ := json.Marshal("weblet-seed:" + weblet.Auth.LocalStorage)
+	value, _ := json.Marshal(token.AccessToken)
+	return fmt.Sprintf(`(function () {
+	if (location.origin !== %s) {
+		return;
+	}
+	const current = localStorage.getItem(%s);
+	if (current === null || current === localStorage.getItem(%s)) {
+		localStorage.setItem(%s, %s);
+		localStorage.setItem(%s, %s);
+	}
+})();`, origin, key, marker, key, value, marker, value)
+}
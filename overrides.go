@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 'weblet run <name> --url <url> --zoom 1.5 --window 1600x900' opens a
+// weblet once with other settings, e.g. to try them before keeping them
+// with 'weblet set'; --container opens it with the logins of another
+// container (see containers.go). Nothing is saved: the background process
+// of a native window gets them in WEBLET_OPEN_URL, WEBLET_ZOOM,
+// WEBLET_WINDOW_SIZE, WEBLET_CONTAINER and WEBLET_WATCH, and a window
+// opened at another size doesn't remember it.
+// --watch <dir> reloads the window of a local dev server or directory when
+// files under <dir> change (see view/watch.go).
+
+// launchOverrides are the settings of a single launch; zero values keep
+// the weblet's own
+type launchOverrides struct {
+	zoom   float64
+	width  int
+	height int
+	watch  string // Directory whose changes reload the page
+
+	container string // Container to run in, "default" for the weblet's own data
+}
+
+// parseZoom parses a zoom level like 1.5 or 150%; empty is the default
+func parseZoom(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	zoom, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err == nil && strings.HasSuffix(value, "%") {
+		zoom /= 100
+	}
+	if err != nil || zoom < 0.25 || zoom > 5 {
+		return 0, fmt.Errorf("invalid zoom '%s' (expected 0.25 to 5, e.g. 1.25 or 125%%)", value)
+	}
+	return zoom, nil
+}
+
+// parseOverrides reads the --url, --zoom, --window and --container options
+// of 'weblet run'
+func parseOverrides(inv *invocation) (string, launchOverrides, error) {
+	var overrides launchOverrides
+	pageURL := ""
+	if inv.has("url") {
+		var err error
+		if pageURL, err = checkURL(inv.value("url"), allowedSchemes(inv)); err != nil {
+			return "", overrides, wrapError(ErrInvalid, err)
+		}
+	}
+	if inv.has("zoom") {
+		zoom, err := parseZoom(inv.value("zoom"))
+		if err != nil || zoom == 0 {
+			return "", overrides, newError(ErrInvalid, "invalid --zoom '%s' (expected 0.25 to 5, e.g. 1.25 or 125%%)", inv.value("zoom"))
+		}
+		overrides.zoom = zoom
+	}
+	if inv.has("window") {
+		width, height, err := parseWindowSize(inv.value("window"))
+		if err != nil || width == 0 {
+			return "", overrides, newError(ErrInvalid, "invalid --window '%s' (expected <width>x<height>, e.g. 1600x900)", inv.value("window"))
+		}
+		overrides.width, overrides.height = width, height
+	}
+	if inv.has("container") {
+		container, err := parseContainer(inv.value("container"))
+		if err != nil {
+			return "", overrides, wrapError(ErrInvalid, err)
+		}
+		overrides.container = container
+		if container == "" {
+			overrides.container = "default"
+		}
+	}
+	if inv.has("watch") {
+		dir, err := filepath.Abs(inv.value("watch"))
+		if err != nil {
+			return "", overrides, wrapError(ErrInvalid, err)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return "", overrides, newError(ErrInvalid, "invalid --watch '%s' (not a directory)", inv.value("watch"))
+		}
+		overrides.watch = dir
+	}
+	return pageURL, overrides, nil
+}
+
+// overrideLaunch makes the next launch of a weblet use other settings. A
+// running weblet would only be focused, so it has to be stopped first.
+func (wm *WebletManager) overrideLaunch(name, pageURL string, overrides launchOverrides) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if wm.runningPID(weblet) > 0 || wm.isWebletWindowOpen(name) {
+		return newError(ErrInvalid, "weblet '%s' is already running, stop it to open it with other settings (weblet stop %s)", name, name)
+	}
+	if overrides.watch != "" {
+		if weblet.UseChrome {
+			return newError(ErrInvalid, "--watch needs a native window, weblet '%s' runs in Chrome", name)
+		}
+		if _, local := localPath(weblet.URL); !local && weblet.LocalDir == "" && !isLoopbackURL(weblet.URL) {
+			return newError(ErrInvalid, "--watch is for weblets of a local dev server or directory, not %s", weblet.URL)
+		}
+	}
+	wm.openURL = pageURL
+	wm.overrides = overrides
+	return nil
+}
+
+// env returns the overrides for the background process of a native window
+func (o launchOverrides) env() []string {
+	var env []string
+	if o.zoom > 0 {
+		env = append(env, "WEBLET_ZOOM="+strconv.FormatFloat(o.zoom, 'g', -1, 64))
+	}
+	if o.width > 0 {
+		env = append(env, fmt.Sprintf("WEBLET_WINDOW_SIZE=%dx%d", o.width, o.height))
+	}
+	if o.watch != "" {
+		env = append(env, "WEBLET_WATCH="+o.watch)
+	}
+	if o.container != "" {
+		env = append(env, "WEBLET_CONTAINER="+o.container)
+	}
+	return env
+}
+
+// overridesFromEnv returns the overrides the background process was
+// started with
+func overridesFromEnv() launchOverrides {
+	var o launchOverrides
+	o.zoom, _ = parseZoom(os.Getenv("WEBLET_ZOOM"))
+	o.width, o.height, _ = parseWindowSize(os.Getenv("WEBLET_WINDOW_SIZE"))
+	o.watch = os.Getenv("WEBLET_WATCH")
+	o.container = os.Getenv("WEBLET_CONTAINER")
+	return o
+}
+
+// launchSettings returns the zoom and window size of this launch of a
+// weblet: its settings, unless they are overridden
+func (wm *WebletManager) launchSettings(weblet *Weblet) (zoom float64, width, height int) {
+	zoom, width, height = weblet.Zoom, weblet.Width, weblet.Height
+	if wm.overrides.zoom > 0 {
+		zoom = wm.overrides.zoom
+	}
+	if wm.overrides.width > 0 {
+		width, height = wm.overrides.width, wm.overrides.height
+	}
+	return zoom, width, height
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// What weblet needs from the system is packaged differently on every
+// distribution. The distribution is detected from /etc/os-release, by its
+// ID and then the IDs it is like (e.g. Pop!_OS is like Ubuntu and Debian),
+// so install hints and 'weblet setup' use its package manager.
+
+// packageManager installs packages on a distribution
+type packageManager struct {
+	install  []string          // Command (run as root) the package names are appended to
+	packages map[string]string // Packages of "wmctrl", "xdotool", "chromium" and "webkit"
+}
+
+// packageManagers by os-release ID. "webkit" are the GTK 3 and WebKitGTK
+// 4.1 development packages the native webview is built against.
+var packageManagers = map[string]packageManager{
+	"debian": {[]string{"apt", "install"}, map[string]string{
+		"wmctrl": "wmctrl", "xdotool": "xdotool", "chromium": "chromium",
+		"webkit": "libgtk-3-dev libwebkit2gtk-4.1-dev"}},
+	"ubuntu": {[]string{"apt", "install"}, map[string]string{
+		"wmctrl": "wmctrl", "xdotool": "xdotool", "chromium": "chromium-browser",
+		"webkit": "libgtk-3-dev libwebkit2gtk-4.1-dev"}},
+	"fedora": {[]string{"dnf", "install"}, map[string]string{
+		"wmctrl": "wmctrl", "xdotool": "xdotool", "chromium": "chromium",
+		"webkit": "gtk3-devel webkit2gtk4.1-devel"}},
+	"arch": {[]string{"pacman", "-S"}, map[string]string{
+		"wmctrl": "wmctrl", "xdotool": "xdotool", "chromium": "chromium",
+		"webkit": "gtk3 webkit2gtk-4.1"}},
+	"opensuse": {[]string{"zypper", "install"}, map[string]string{
+		"wmctrl": "wmctrl", "xdotool": "xdotool", "chromium": "chromium",
+		"webkit": "gtk3-devel webkit2gtk3-devel"}},
+	"alpine": {[]string{"apk", "add"}, map[string]string{
+		"wmctrl": "wmctrl", "xdotool": "xdotool", "chromium": "chromium",
+		"webkit": "gtk+3.0-dev webkit2gtk-4.1-dev"}},
+	"void": {[]string{"xbps-install"}, map[string]string{
+		"wmctrl": "wmctrl", "xdotool": "xdotool", "chromium": "chromium",
+		"webkit": "gtk+3-devel webkit2gtk-devel"}},
+	"gentoo": {[]string{"emerge"}, map[string]string{
+		"wmctrl": "x11-misc/wmctrl", "xdotool": "x11-misc/xdotool", "chromium": "www-client/chromium",
+		"webkit": "x11-libs/gtk+:3 net-libs/webkit-gtk:4.1"}},
+}
+
+// osRelease reads the fields of /etc/os-release
+func osRelease() map[string]string {
+	fields := map[string]string{}
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return fields
+	}
+	for _, line := range splitLines(string(data)) {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = strings.Trim(value, `"'`)
+		}
+	}
+	return fields
+}
+
+// distroPackageManager returns the package manager of this distribution
+func distroPackageManager() (packageManager, bool) {
+	release := osRelease()
+	ids := append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...)
+	for _, id := range ids {
+		// opensuse-leap, opensuse-tumbleweed, ...
+		if strings.HasPrefix(id, "opensuse") || id == "suse" {
+			id = "opensuse"
+		}
+		if pm, ok := packageManagers[id]; ok {
+			return pm, true
+		}
+	}
+	return packageManager{}, false
+}
+
+// installArgs is the command that installs what weblet needs, without
+// sudo or pkexec in front
+func (pm packageManager) installArgs(needs ...string) []string {
+	args := append([]string{}, pm.install...)
+	for _, need := range needs {
+		args = append(args, strings.Fields(pm.packages[need])...)
+	}
+	return args
+}
+
+// installHint is the command to show the user, e.g. "sudo dnf install wmctrl"
+func (pm packageManager) installHint(needs ...string) string {
+	return "sudo " + strings.Join(pm.installArgs(needs...), " ")
+}
@@ -0,0 +1,109 @@
+// Package paths locates the files of weblet, following the XDG Base
+// Directory specification: the weblets and global options are kept in
+// $XDG_CONFIG_HOME/weblet (~/.config/weblet), the native and Chrome profiles,
+// icons and Tor's state in $XDG_DATA_HOME/weblet (~/.local/share/weblet),
+// and what can be made again (thumbnails, error pages, launch locks and
+// control sockets) in $XDG_CACHE_HOME/weblet (~/.cache/weblet).
+//
+// With --data-dir (WEBLET_DATA_DIR) all of them are that one directory.
+// Older versions kept everything in ~/.weblet; as long as it exists, e.g.
+// while its weblets still run and it can't be moved yet (see migrate.go),
+// it is used for all of them.
+package paths
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// LegacyDir returns ~/.weblet, where older versions kept everything
+func LegacyDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".weblet"), nil
+}
+
+// Portable returns the directory given with --data-dir, or ""
+func Portable() string {
+	return os.Getenv("WEBLET_DATA_DIR")
+}
+
+// Legacy reports whether ~/.weblet is still in use
+func Legacy() bool {
+	if Portable() != "" {
+		return false
+	}
+	dir, err := LegacyDir()
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// ConfigDir returns where the weblets and global options are saved
+func ConfigDir() (string, error) {
+	return dir("XDG_CONFIG_HOME", ".config")
+}
+
+// DataDir returns where the profiles and icons of the weblets are kept
+func DataDir() (string, error) {
+	return dir("XDG_DATA_HOME", filepath.Join(".local", "share"))
+}
+
+// CacheDir returns where files that can be made again are kept
+func CacheDir() (string, error) {
+	return dir("XDG_CACHE_HOME", ".cache")
+}
+
+// Dirs returns the configuration, data and cache directories
+func Dirs() (config, data, cache string, err error) {
+	if config, err = ConfigDir(); err != nil {
+		return
+	}
+	if data, err = DataDir(); err != nil {
+		return
+	}
+	cache, err = CacheDir()
+	return
+}
+
+// XDGDirs returns the configuration, data and cache directories weblet
+// uses when ~/.weblet is gone
+func XDGDirs() (config, data, cache string, err error) {
+	if config, err = xdgDir("XDG_CONFIG_HOME", ".config"); err != nil {
+		return
+	}
+	if data, err = xdgDir("XDG_DATA_HOME", filepath.Join(".local", "share")); err != nil {
+		return
+	}
+	cache, err = xdgDir("XDG_CACHE_HOME", ".cache")
+	return
+}
+
+// dir returns the directory of one kind, see the package comment
+func dir(env, fallback string) (string, error) {
+	if dir := Portable(); dir != "" {
+		return dir, nil
+	}
+	if Legacy() {
+		return LegacyDir()
+	}
+	return xdgDir(env, fallback)
+}
+
+// xdgDir returns the weblet directory in an XDG base directory: the one
+// in env, or fallback under the home directory. The specification has
+// relative paths ignored.
+func xdgDir(env, fallback string) (string, error) {
+	if base := os.Getenv(env); filepath.IsAbs(base) {
+		return filepath.Join(base, "weblet"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, fallback, "weblet"), nil
+}
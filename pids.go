@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Windows are matched by the PID their client sets in _NET_WM_PID, which is
+// exact, unlike class and title matching. The processes of a weblet are
+// found in /proc: its recorded PID (see recordLaunch), the background native
+// process or the Chrome instance using its profile, and all their children.
+
+// procEntries returns the PIDs of all processes
+func procEntries() []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// parentPID reads the parent of a process from /proc/<pid>/stat
+func parentPID(pid int) int {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+	// The command name may contain spaces and parentheses, the fields
+	// after it are "state ppid ..."
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// processStartTime reads when a process started from /proc/<pid>/stat,
+// whose starttime is in clock ticks (USER_HZ, 100 on Linux) since boot
+func processStartTime(pid int) (time.Time, bool) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	// starttime is the 22nd field, the 20th after the command name
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, false
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, line := range splitLines(string(stat)) {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			boot, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, false
+			}
+			return time.Unix(boot, 0).Add(time.Duration(ticks) * time.Second / 100), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// processArgs reads the command line of a process
+func processArgs(pid int) []string {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+}
+
+// hasEnv reports whether a process was started with the given variable
+func hasEnv(pid int, variable string) bool {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return false
+	}
+	for _, env := range bytes.Split(data, []byte{0}) {
+		if string(env) == variable {
+			return true
+		}
+	}
+	return false
+}
+
+// webletPIDs returns the processes of a running weblet, including children
+func (wm *WebletManager) webletPIDs(weblet *Weblet) map[int]bool {
+	pids := make(map[int]bool)
+	if pid := wm.runningPID(weblet); pid > 0 {
+		pids[pid] = true
+	}
+
+	all := procEntries()
+	userDataDir := "--user-data-dir=" + filepath.Join(wm.dataDir, "chrome-data", weblet.Name)
+	for _, pid := range all {
+		args := processArgs(pid)
+		if len(args) == 0 {
+			continue
+		}
+		if weblet.UseChrome {
+			for _, arg := range args {
+				if arg == userDataDir {
+					pids[pid] = true
+					break
+				}
+			}
+		} else if args[len(args)-1] == weblet.Name && hasEnv(pid, "WEBLET_BACKGROUND=1") {
+			pids[pid] = true
+		}
+	}
+	if len(pids) == 0 {
+		return pids
+	}
+
+	// Add descendants; the window may belong to a child (e.g. a wrapper
+	// script or network namespace helper started the real process)
+	parents := make(map[int]int, len(all))
+	for _, pid := range all {
+		parents[pid] = parentPID(pid)
+	}
+	for changed := true; changed; {
+		changed = false
+		for pid, ppid := range parents {
+			if pids[ppid] && !pids[pid] {
+				pids[pid] = true
+				changed = true
+			}
+		}
+	}
+	return pids
+}
+
+// pidWindow returns the X11 window owned by one of the weblet's processes,
+// read from _NET_WM_PID via 'wmctrl -lp' or 'xdotool search --pid'
+func (wm *WebletManager) pidWindow(name string) (string, bool) {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return "", false
+	}
+	pids := wm.webletPIDs(weblet)
+	if len(pids) == 0 {
+		return "", false
+	}
+
+	// wmctrl -lp output format: WindowID Desktop PID Machine WindowTitle...
+	if output, err := hostCommand("wmctrl", "-lp").Output(); err == nil {
+		for _, line := range splitLines(string(output)) {
+			parts := strings.Fields(line)
+			if len(parts) < 3 {
+				continue
+			}
+			if pid, err := strconv.Atoi(parts[2]); err == nil && pids[pid] {
+				return parts[0], true
+			}
+		}
+		return "", false
+	}
+
+	for pid := range pids {
+		output, err := hostCommand("xdotool", "search", "--onlyvisible", "--pid", strconv.Itoa(pid)).Output()
+		if err != nil {
+			continue
+		}
+		if lines := splitLines(string(output)); len(lines) > 0 && lines[0] != "" {
+			return lines[0], true
+		}
+	}
+	return "", false
+}
+
+// isWebletProcess reports whether pid is a process launched for the weblet,
+// so a recorded PID that was reused by another program isn't trusted
+func (wm *WebletManager) isWebletProcess(weblet *Weblet, pid int) bool {
+	args := processArgs(pid)
+	if len(args) == 0 {
+		return false
+	}
+	if weblet.Backend == "chrome" {
+		userDataDir := "--user-data-dir=" + filepath.Join(wm.dataDir, "chrome-data", weblet.Name)
+		for _, arg := range args {
+			if arg == userDataDir {
+				return true
+			}
+		}
+		return false
+	}
+	return args[len(args)-1] == weblet.Name && hasEnv(pid, "WEBLET_BACKGROUND=1")
+}
+
+// runningPID returns the recorded PID of a running weblet, or 0 (clearing
+// the record) if that process is gone or isn't the weblet's anymore
+func (wm *WebletManager) runningPID(weblet *Weblet) int {
+	if weblet.PID <= 0 {
+		return 0
+	}
+	// The background process sees the PID its parent recorded for it
+	if weblet.PID != os.Getpid() && wm.isProcessRunning(weblet.PID) && wm.isWebletProcess(weblet, weblet.PID) {
+		return weblet.PID
+	}
+	if weblet.PID != os.Getpid() {
+		weblet.PID = 0
+		weblet.StartedAt = 0
+		weblet.Backend = ""
+	}
+	return 0
+}
+
+// recordLaunch saves the PID and backend of a freshly started weblet
+func (wm *WebletManager) recordLaunch(weblet *Weblet, pid int, backend string) {
+	weblet.PID = pid
+	weblet.StartedAt = time.Now().Unix()
+	weblet.Backend = backend
+	if err := wm.saveLaunch(weblet); err != nil {
+		fmt.Fprint(os.Stderr, T("Warning: Could not save PID of weblet '%s': %v\n", weblet.Name, err))
+	}
+}
+
+// A launch record holds the PID, start time and backend of a weblet's last
+// launch. Each weblet has a file of its own in the user's data directory
+// instead of weblets.json, so launches of two weblets, or a launch and a
+// 'weblet set', don't overwrite each other, and system-wide weblets get one
+// per user.
+type launchRecord struct {
+	PID       int    `json:"pid"`
+	StartedAt int64  `json:"started_at"`
+	Backend   string `json:"backend"`
+}
+
+// launchFile returns the launch record file of a weblet
+func (wm *WebletManager) launchFile(name string) string {
+	return filepath.Join(wm.dataDir, "launches", name+".json")
+}
+
+// readLaunch reads the launch record of a weblet
+func (wm *WebletManager) readLaunch(name string) (launchRecord, bool) {
+	var record launchRecord
+	data, err := os.ReadFile(wm.launchFile(name))
+	if err != nil || json.Unmarshal(data, &record) != nil {
+		return launchRecord{}, false
+	}
+	return record, true
+}
+
+// saveLaunch saves the launch of a weblet, or removes its record once it
+// has none
+func (wm *WebletManager) saveLaunch(weblet *Weblet) error {
+	path := wm.launchFile(weblet.Name)
+	if weblet.PID <= 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(launchRecord{weblet.PID, weblet.StartedAt, weblet.Backend})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Readers never see half a record
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadLaunches fills in the launches of the weblets. Launches weblets.json
+// still holds from before launch records get a record of their own.
+func (wm *WebletManager) loadLaunches() {
+	for name, weblet := range wm.weblets {
+		if record, ok := wm.readLaunch(name); ok {
+			weblet.PID, weblet.StartedAt, weblet.Backend = record.PID, record.StartedAt, record.Backend
+		} else if weblet.PID > 0 {
+			wm.saveLaunch(weblet)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 'weblet pin' adds a weblet's desktop entry to the favorites of GNOME
+// Shell's dash (the org.gnome.shell favorite-apps key) or to the launchers
+// of KDE Plasma's task managers (through a plasmashell script, which also
+// saves them), so one command gets a weblet into the dock.
+
+// desktopID returns the desktop file ID docks know the weblet by
+func (wm *WebletManager) desktopID(name string) (string, error) {
+	if id := wm.launcherDesktopID(name); id != "" {
+		return id, nil
+	}
+	desktopFilePath, err := wm.getDesktopFilePath(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(desktopFilePath); err != nil {
+		return "", newError(ErrNotFound, "weblet '%s' has no desktop file, run 'weblet refresh %s'", name, name)
+	}
+	return filepath.Base(desktopFilePath), nil
+}
+
+// Pin adds a weblet to the dock, or removes it with pinned false
+func (wm *WebletManager) Pin(name string, pinned bool) error {
+	if _, exists := wm.weblets[name]; !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	id, err := wm.desktopID(name)
+	if err != nil {
+		return err
+	}
+
+	if isKDE() {
+		if err := setPlasmaLauncher(id, pinned); err != nil {
+			return err
+		}
+	} else {
+		changed, err := setGnomeFavorite(id, pinned)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			if pinned {
+				fmt.Print(T("Weblet '%s' is already pinned\n", name))
+			} else {
+				fmt.Print(T("Weblet '%s' isn't pinned\n", name))
+			}
+			return nil
+		}
+	}
+
+	if pinned {
+		fmt.Print(T("Pinned weblet '%s' to the dock\n", name))
+	} else {
+		fmt.Print(T("Unpinned weblet '%s' from the dock\n", name))
+	}
+	return nil
+}
+
+// unpinRemoved drops a removed weblet from the dock, if it was pinned
+func (wm *WebletManager) unpinRemoved(name string) {
+	desktopFilePath, err := wm.getDesktopFilePath(name)
+	if err != nil {
+		return
+	}
+	id := filepath.Base(desktopFilePath)
+	if launcherID := wm.launcherDesktopID(name); launcherID != "" {
+		id = launcherID
+	}
+	if isKDE() {
+		setPlasmaLauncher(id, false)
+	} else {
+		setGnomeFavorite(id, false)
+	}
+}
+
+func isKDE() bool {
+	for _, desktop := range strings.Split(os.Getenv("XDG_CURRENT_DESKTOP"), ":") {
+		if desktop == "KDE" {
+			return true
+		}
+	}
+	return false
+}
+
+// setGnomeFavorite adds or removes a desktop file ID in GNOME Shell's
+// favorite-apps and reports whether the list changed
+func setGnomeFavorite(id string, pinned bool) (bool, error) {
+	favorites, err := gnomeFavorites()
+	if err != nil {
+		return false, err
+	}
+
+	i := slices.Index(favorites, id)
+	switch {
+	case pinned && i < 0:
+		favorites = append(favorites, id)
+	case !pinned && i >= 0:
+		favorites = slices.Delete(favorites, i, i+1)
+	default:
+		return false, nil
+	}
+	return true, setGnomeFavorites(favorites)
+}
+
+// gnomeFavorites reads GNOME Shell's favorite-apps
+func gnomeFavorites() ([]string, error) {
+	if _, err := hostLookPath("gsettings"); err != nil {
+		return nil, fmt.Errorf("pinning needs gsettings (GNOME Shell) or KDE Plasma")
+	}
+	output, err := hostCommand("gsettings", "get", "org.gnome.shell", "favorite-apps").Output()
+	if err != nil {
+		return nil, fmt.Errorf("pinning needs GNOME Shell or KDE Plasma")
+	}
+	return parseStringArray(string(output)), nil
+}
+
+// setGnomeFavorites writes GNOME Shell's favorite-apps
+func setGnomeFavorites(favorites []string) error {
+	quoted := make([]string, len(favorites))
+	for i, favorite := range favorites {
+		quoted[i] = gvariantString(favorite)
+	}
+	value := "[" + strings.Join(quoted, ", ") + "]"
+	if output, err := hostCommand("gsettings", "set", "org.gnome.shell", "favorite-apps", value).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update favorite apps: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// parseStringArray parses a GVariant string array as printed by gsettings,
+// e.g. ['a.desktop', 'b.desktop'] or @as []
+func parseStringArray(s string) []string {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "@as"))
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.Trim(strings.TrimSpace(item), `'"`)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// plasmaLauncherScript updates the launchers of every task manager widget
+const plasmaLauncherScript = `var id = %s, pinned = %t;
+panels().forEach(function (panel) {
+    panel.widgets().forEach(function (widget) {
+        if (widget.type != "org.kde.plasma.icontasks" && widget.type != "org.kde.plasma.taskmanager") {
+            return;
+        }
+        widget.currentConfigGroup = ["General"];
+        var launchers = widget.readConfig("launchers", []);
+        if (typeof launchers == "string") {
+            launchers = launchers ? launchers.split(",") : [];
+        }
+        launchers = launchers.filter(function (launcher) { return launcher != id; });
+        if (pinned) {
+            launchers.push(id);
+        }
+        widget.writeConfig("launchers", launchers);
+    });
+});`
+
+// plasmaRenameScript replaces a launcher in every task manager widget,
+// keeping its place
+const plasmaRenameScript = `var from = %s, to = %s;
+panels().forEach(function (panel) {
+    panel.widgets().forEach(function (widget) {
+        if (widget.type != "org.kde.plasma.icontasks" && widget.type != "org.kde.plasma.taskmanager") {
+            return;
+        }
+        widget.currentConfigGroup = ["General"];
+        var launchers = widget.readConfig("launchers", []);
+        if (typeof launchers == "string") {
+            launchers = launchers ? launchers.split(",") : [];
+        }
+        if (launchers.indexOf(from) < 0) {
+            return;
+        }
+        launchers = launchers.map(function (launcher) { return launcher == from ? to : launcher; });
+        widget.writeConfig("launchers", launchers);
+    });
+});`
+
+// setPlasmaLauncher adds or removes a desktop file ID in the task managers
+// of KDE Plasma's panels
+func setPlasmaLauncher(id string, pinned bool) error {
+	return evaluatePlasmaScript(fmt.Sprintf(plasmaLauncherScript, strconv.Quote("applications:"+id), pinned))
+}
+
+// evaluatePlasmaScript runs a script in plasmashell
+func evaluatePlasmaScript(script string) error {
+	qdbus := ""
+	for _, tool := range []string{"qdbus6", "qdbus", "qdbus-qt5"} {
+		if _, err := hostLookPath(tool); err == nil {
+			qdbus = tool
+			break
+		}
+	}
+	if qdbus == "" {
+		return fmt.Errorf("pinning in KDE Plasma needs qdbus")
+	}
+	if output, err := hostCommand(qdbus, "org.kde.plasmashell", "/PlasmaShell", "org.kde.PlasmaShell.evaluateScript", script).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update the task manager: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// renamePinned replaces a desktop file ID in the dock, if it is pinned
+func renamePinned(oldID, newID string) error {
+	if isKDE() {
+		return evaluatePlasmaScript(fmt.Sprintf(plasmaRenameScript,
+			strconv.Quote("applications:"+oldID), strconv.Quote("applications:"+newID)))
+	}
+	favorites, err := gnomeFavorites()
+	if err != nil {
+		return err
+	}
+	i := slices.Index(favorites, oldID)
+	if i < 0 {
+		return nil
+	}
+	favorites[i] = newID
+	return setGnomeFavorites(favorites)
+}
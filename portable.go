@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 'weblet --data-dir <dir> ...' keeps everything weblet stores in <dir>
+// instead of the XDG directories (see package paths): weblets, config,
+// icons and the native and Chrome profiles, e.g. on a USB drive for a
+// portable setup on shared machines.
+// The directory is passed on in WEBLET_DATA_DIR to the processes weblet
+// starts, and desktop files and autostart entries written meanwhile run
+// weblet with --data-dir again.
+
+// setDataDir makes dir the data directory of this invocation
+func setDataDir(dir string) error {
+	if dir == "" {
+		return newError(ErrInvalid, "--data-dir needs a directory")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	os.Setenv("WEBLET_DATA_DIR", dir)
+	return nil
+}
+
+// dataDirArgs returns the --data-dir and --config arguments for commands
+// in desktop files, or nothing when the defaults are used
+func dataDirArgs() string {
+	args := ""
+	if dir := os.Getenv("WEBLET_DATA_DIR"); dir != "" {
+		args += " --data-dir " + desktopQuote(dir)
+	}
+	if file := os.Getenv("WEBLET_CONFIG"); file != "" {
+		args += " --config " + desktopQuote(file)
+	}
+	return args
+}
+
+// desktopQuote quotes an argument of a desktop file's Exec key: inside
+// double quotes ", `, $ and \ are escaped, the key's value escapes
+// backslashes once more, and % is doubled
+func desktopQuote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\n\"'\\><~|&;$*?#()`%") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range arg {
+		switch r {
+		case '"', '`', '$':
+			b.WriteString(`\\`)
+		case '\\':
+			b.WriteString(`\\\\`)
+			continue
+		case '%':
+			// Field codes like %u start with %
+			b.WriteByte('%')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
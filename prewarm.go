@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// 'weblet prewarm <name>' starts a native weblet with its window hidden:
+// the page loads (and WebKit throttles it, as for any hidden page) but
+// nothing is shown until the weblet is opened, which then only presents the
+// window. With 'weblet set <name> prewarm on' an autostart entry does this
+// at login. A prewarmed window writes "prewarmed" to its ready file (see
+// ready.go); the memory watcher closes it like any unused window.
+
+const prewarmAutostartFile = "weblet-prewarm.desktop"
+
+// isPrewarmed reports whether a weblet's window is loaded but not shown yet
+func (wm *WebletManager) isPrewarmed(name string) bool {
+	data, err := os.ReadFile(wm.lockFile(name))
+	return err == nil && bytes.Equal(data, []byte("prewarmed\n"))
+}
+
+// Prewarm starts the named weblets, or those with prewarm on, hidden
+func (wm *WebletManager) Prewarm(names []string) error {
+	if len(names) == 0 {
+		for name, weblet := range wm.weblets {
+			if weblet.Prewarm {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			fmt.Println(T("No weblets have prewarm on."))
+			return nil
+		}
+	}
+	for _, name := range names {
+		if _, exists := wm.weblets[name]; !exists {
+			return newError(ErrNotFound, "weblet '%s' not found", name)
+		}
+	}
+
+	os.Setenv("WEBLET_PREWARM", "1")
+	var failed int
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		if weblet.UseChrome {
+			fmt.Fprint(os.Stderr, T("Warning: Weblet '%s' uses Chrome, prewarming needs native mode\n", name))
+			failed++
+			continue
+		}
+		if wm.runningPID(weblet) > 0 || wm.isWebletWindowOpen(name) {
+			fmt.Print(T("Weblet '%s' is already running\n", name))
+			continue
+		}
+		if err := wm.Run(name); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Could not prewarm weblet '%s': %v\n", name, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d weblet(s) could not be prewarmed", failed)
+	}
+	return nil
+}
+
+// updatePrewarmAutostart keeps the login autostart entry while weblet or
+// any other weblet has prewarm on
+func (wm *WebletManager) updatePrewarmAutostart(weblet *Weblet) error {
+	enabled := weblet.Prewarm
+	for name, other := range wm.weblets {
+		if name != weblet.Name && other.Prewarm {
+			enabled = true
+		}
+	}
+	return setAutostart(prewarmAutostartFile, "Weblet prewarm",
+		"Load weblets hidden so they open instantly", "prewarm", enabled)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// The privacy preset ('weblet set <name> privacy on') is a middle ground
+// between the defaults and Tor mode: the weblet keeps its network and
+// logins, but looks like many other browsers and leaks less to third
+// parties. It normalizes the user agent and timezone, turns off WebGL and
+// canvas readback, blocks third-party cookies and strips referrers.
+
+// privacyUserAgent is the most common desktop browser, so the weblet
+// doesn't stand out by its engine or operating system
+const privacyUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36"
+
+// privacyTimezone hides the local timezone from Date and Intl
+const privacyTimezone = "UTC"
+
+// privacyChromeArgs returns the Chrome flags of the privacy preset
+func privacyChromeArgs() []string {
+	return []string{
+		"--user-agent=" + privacyUserAgent,
+		"--disable-webgl",
+		"--disable-reading-from-canvas",
+		"--no-referrers",
+	}
+}
+
+// updateChromePreferences changes the Preferences of the weblet's Chrome
+// profile for settings without a command line flag. update returns whether
+// it changed anything.
+func updateChromePreferences(userDataDir string, update func(prefs map[string]any) bool) error {
+	path := filepath.Join(userDataDir, "Default", "Preferences")
+	prefs := make(map[string]any)
+	if data, err := os.ReadFile(path); err == nil {
+		// Keep large integers intact when writing the file back
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&prefs); err != nil {
+			return err
+		}
+	}
+	if !update(prefs) {
+		return nil
+	}
+
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// blockChromeThirdPartyCookies turns on third-party cookie blocking in the
+// weblet's Chrome profile
+func blockChromeThirdPartyCookies(userDataDir string) error {
+	return updateChromePreferences(userDataDir, func(prefs map[string]any) bool {
+		profile, _ := prefs["profile"].(map[string]any)
+		if profile == nil {
+			profile = make(map[string]any)
+			prefs["profile"] = profile
+		}
+		if profile["block_third_party_cookies"] == true {
+			return false
+		}
+		profile["block_third_party_cookies"] = true
+		profile["cookie_controls_mode"] = 1 // Block third-party cookies
+		return true
+	})
+}
+
+// setChromeDoNotTrack makes Chrome send the DNT header, or stop sending it
+func setChromeDoNotTrack(userDataDir string, enabled bool) error {
+	return updateChromePreferences(userDataDir, func(prefs map[string]any) bool {
+		current, _ := prefs["enable_do_not_track"].(bool)
+		if current == enabled {
+			return false
+		}
+		prefs["enable_do_not_track"] = enabled
+		return true
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 'weblet run <name> --profile-startup' times the launch of a weblet, for
+// performance work and slow-start reports. The launcher, the background
+// process and its window append their steps to the file named by
+// WEBLET_PROFILE as "<step> <unix microseconds>" lines; the launcher waits
+// for the first page load and prints them relative to its own start.
+
+// processStart is when this process started, close enough to its exec
+var processStart = time.Now()
+
+// profileTimeout is how long to wait for the first page load
+const profileTimeout = time.Minute
+
+// markStartup records a startup step of a profiled launch
+func markStartup(step string) {
+	markStartupAt(step, time.Now())
+}
+
+func markStartupAt(step string, at time.Time) {
+	debugf("%s at %s", step, at.Format("15:04:05.000"))
+	path := os.Getenv("WEBLET_PROFILE")
+	if path == "" {
+		return
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintf(file, "%s %d\n", step, at.UnixMicro())
+}
+
+// startupStep is a step of a profiled launch
+type startupStep struct {
+	Name string
+	At   time.Time
+}
+
+// readStartupSteps reads the steps recorded so far
+func readStartupSteps(path string) []startupStep {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var steps []startupStep
+	for _, line := range splitLines(string(data)) {
+		separator := strings.LastIndexByte(line, ' ')
+		if separator < 0 {
+			continue
+		}
+		micros, err := strconv.ParseInt(line[separator+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		steps = append(steps, startupStep{Name: line[:separator], At: time.UnixMicro(micros)})
+	}
+	return steps
+}
+
+// ProfileStartup launches a weblet and prints how long each step took
+func (wm *WebletManager) ProfileStartup(name string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if wm.runningPID(weblet) > 0 || wm.isWebletWindowOpen(name) {
+		return newError(ErrInvalid, "weblet '%s' is already running, close it to profile its startup", name)
+	}
+
+	file, err := os.CreateTemp("", "weblet-profile-*.txt")
+	if err != nil {
+		return err
+	}
+	path := file.Name()
+	file.Close()
+	defer os.Remove(path)
+	os.Setenv("WEBLET_PROFILE", path)
+
+	markStartupAt("started", processStart)
+	markStartup("weblets loaded")
+	if err := wm.Run(name); err != nil {
+		return err
+	}
+
+	// Chrome doesn't report its page loads, only its window is waited for
+	finished, exited := false, false
+	deadline := time.Now().Add(profileTimeout)
+	for !finished && time.Now().Before(deadline) {
+		if weblet.UseChrome {
+			if _, ok := wm.pidWindow(name); ok {
+				markStartup("window shown")
+				finished = true
+			}
+		} else {
+			for _, step := range readStartupSteps(path) {
+				if step.Name == "load finished" || step.Name == "load failed" {
+					finished = true
+				}
+			}
+			if !finished && wm.runningPID(weblet) == 0 {
+				exited = true // The window was closed or crashed
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	steps := readStartupSteps(path)
+	sort.Slice(steps, func(i, j int) bool { return steps[i].At.Before(steps[j].At) })
+	fmt.Print(T("Startup of weblet '%s':\n", name))
+	previous := processStart
+	for _, step := range steps {
+		fmt.Printf("  %-28s %7d ms  %+7d ms\n", step.Name,
+			step.At.Sub(processStart).Milliseconds(), step.At.Sub(previous).Milliseconds())
+		previous = step.At
+	}
+	switch {
+	case exited:
+		fmt.Println(T("The weblet exited before its first page loaded."))
+	case !finished:
+		fmt.Print(T("The weblet didn't finish starting within %s.\n", profileTimeout))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// showProgress enables spinners for slow network operations; main turns it
+// off for --quiet, and it is off anyway when stderr isn't a terminal
+var showProgress = isTerminal(os.Stderr)
+
+// activeProgress is the spinner currently drawn, only one owns the line
+var (
+	activeMu       sync.Mutex
+	activeProgress *progress
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progress is a single-line spinner on stderr showing what a long operation
+// is doing and for how long. A nil *progress is valid and prints nothing.
+type progress struct {
+	mu     sync.Mutex
+	title  string
+	detail string
+	start  time.Time
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgress starts a spinner titled title. It returns nil when progress
+// output is disabled or another spinner is already running (e.g. the
+// per-icon spinners inside 'refresh --all').
+func startProgress(title string) *progress {
+	if !showProgress {
+		return nil
+	}
+
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	if activeProgress != nil {
+		return nil
+	}
+
+	p := &progress{title: title, start: time.Now(), done: make(chan struct{})}
+	activeProgress = p
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			p.draw(spinnerFrames[frame%len(spinnerFrames)])
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *progress) draw(frame string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	line := fmt.Sprintf("%s %s (%.1fs)", frame, p.title, time.Since(p.start).Seconds())
+	if p.detail != "" {
+		line += " " + p.detail
+	}
+	// Keep it on one line so \r can redraw it
+	if runes := []rune(line); len(runes) > 78 {
+		line = string(runes[:77]) + "…"
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K"+line)
+}
+
+// Update shows what the operation is currently doing, e.g. the icon
+// candidate being tried
+func (p *progress) Update(detail string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.detail = detail
+	p.mu.Unlock()
+}
+
+// Println prints a line above the spinner
+func (p *progress) Println(line string) {
+	if p == nil {
+		fmt.Println(line)
+		return
+	}
+	p.mu.Lock()
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	fmt.Println(line)
+	p.mu.Unlock()
+}
+
+// printAboveProgress is fmt.Print for output that may come while a spinner
+// runs, e.g. warnings of the workers of 'refresh --all': it is printed above
+// the spinner instead of into its line
+func printAboveProgress(text string) {
+	activeMu.Lock()
+	p := activeProgress
+	activeMu.Unlock()
+	if p == nil {
+		fmt.Print(text)
+		return
+	}
+	p.mu.Lock()
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	fmt.Print(text)
+	p.mu.Unlock()
+}
+
+// Stop removes the spinner and, if result isn't empty, prints it with the
+// elapsed time
+func (p *progress) Stop(result string) {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	if result != "" {
+		fmt.Fprint(os.Stderr, T("%s (%.1fs)\n", result, time.Since(p.start).Seconds()))
+	}
+
+	activeMu.Lock()
+	activeProgress = nil
+	activeMu.Unlock()
+}
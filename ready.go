@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// A native weblet is started by a short-lived 'weblet run' that forks the
+// background process holding the window. Concurrent runs are serialized by
+// an flock on locks/<name>.lock, held until the PID of the new process is
+// recorded; the lock is released by the kernel if the launcher dies, so it
+// can't go stale. The background process writes "mapped" to the same file
+// when its window appears (see view.Options.ReadyFile), which a run that
+// wants to focus the window waits for with inotify.
+
+const windowMapped = "mapped\n"
+
+func (wm *WebletManager) lockFile(name string) string {
+	return filepath.Join(wm.cacheDir, "locks", name+".lock")
+}
+
+// lockLaunch takes the launch lock of a weblet, waiting while another run
+// is starting it
+func (wm *WebletManager) lockLaunch(name string) (*os.File, error) {
+	lockFile := wm.lockFile(name)
+	if err := os.MkdirAll(filepath.Dir(lockFile), 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// isWindowMapped reports whether the background process of a weblet has
+// mapped its window
+func (wm *WebletManager) isWindowMapped(name string) bool {
+	data, err := os.ReadFile(wm.lockFile(name))
+	return err == nil && bytes.Equal(data, []byte(windowMapped))
+}
+
+// waitForWindow waits until a starting weblet has mapped its window. There
+// is no timeout, a slow machine just takes longer; it returns false if the
+// process exits without showing a window.
+func (wm *WebletManager) waitForWindow(weblet *Weblet) bool {
+	// The file is replaced when written, so the directory is watched
+	var events *os.File
+	if fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK); err == nil {
+		if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(wm.lockFile(weblet.Name)), syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_DELETE); err == nil {
+			// Non-blocking, so reads honor deadlines
+			events = os.NewFile(uintptr(fd), "inotify")
+			defer events.Close()
+		} else {
+			syscall.Close(fd)
+		}
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		if wm.isWindowMapped(weblet.Name) {
+			return true
+		}
+		if wm.runningPID(weblet) == 0 {
+			// Exited without reporting a window
+			return wm.isWebletWindowOpen(weblet.Name)
+		}
+
+		// Woken by any change in the lock directory; the process is checked
+		// again every second in case it died before showing its window
+		if events == nil {
+			time.Sleep(200 * time.Millisecond)
+			continue
+		}
+		events.SetReadDeadline(time.Now().Add(time.Second))
+		events.Read(buf)
+	}
+}
+
+// reloadWeblet re-reads the launch record of a weblet, which another run
+// may have written in the meantime
+func (wm *WebletManager) reloadWeblet(name string) error {
+	record, _ := wm.readLaunch(name)
+	weblet := wm.weblets[name]
+	weblet.PID, weblet.StartedAt, weblet.Backend = record.PID, record.StartedAt, record.Backend
+	return nil
+}
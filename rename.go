@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 'weblet rename <old> <new>' gives a weblet a new name without losing its
+// logins: the native and Chrome profiles, icons, thumbnail and weblets.d
+// file are moved, the keyring entries (lock passphrase, saved logins)
+// re-stored under the new name, and the desktop file is written again with
+// the new StartupWMClass (weblet-<new>), keeping the weblet's place in the
+// dock.
+
+// Rename renames a weblet and moves its data
+func (wm *WebletManager) Rename(oldName, newName string) error {
+	weblet, exists := wm.weblets[oldName]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", oldName)
+	}
+	if _, exists := wm.weblets[newName]; exists {
+		return newError(ErrExists, "weblet '%s' already exists", newName)
+	}
+	if err := checkName(newName); err != nil {
+		return err
+	}
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+	if wm.isRunning(weblet) {
+		return newError(ErrInvalid, "weblet '%s' is running, close it first (weblet stop %s)", oldName, oldName)
+	}
+
+	moves, err := wm.renameMoves(oldName, newName)
+	if err != nil {
+		return err
+	}
+	// Leftovers of a removed weblet with the new name would be mixed in
+	for _, move := range moves {
+		if _, err := os.Lstat(move[1]); err == nil {
+			return newError(ErrExists, "%s already exists", move[1])
+		}
+	}
+	var moved [][2]string
+	undo := func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			os.Rename(moved[i][1], moved[i][0])
+		}
+	}
+	for _, move := range moves {
+		if err := os.MkdirAll(filepath.Dir(move[1]), 0755); err != nil {
+			undo()
+			return err
+		}
+		if err := os.Rename(move[0], move[1]); err != nil {
+			undo()
+			return fmt.Errorf("failed to move %s: %w", move[0], err)
+		}
+		moved = append(moved, move)
+	}
+
+	// Desktop IDs are taken before the old desktop file is gone
+	oldID, pinErr := wm.desktopID(oldName)
+
+	renamed := *weblet
+	renamed.Name = newName
+	renamed.IconLight = renamedIcon(weblet.IconLight, oldName, newName)
+	renamed.IconDark = renamedIcon(weblet.IconDark, oldName, newName)
+	delete(wm.weblets, oldName)
+	wm.weblets[newName] = &renamed
+	file, hasFile := wm.fileSettings[oldName]
+	if hasFile {
+		delete(wm.fileSettings, oldName)
+		wm.fileSettings[newName] = file
+	}
+	if err := wm.saveWeblets(); err != nil {
+		delete(wm.weblets, newName)
+		wm.weblets[oldName] = weblet
+		if hasFile {
+			delete(wm.fileSettings, newName)
+			wm.fileSettings[oldName] = file
+		}
+		undo()
+		return err
+	}
+
+	if err := renameSecrets(weblet, oldName, newName); err != nil {
+		fmt.Fprint(os.Stderr, T("Warning: %v\n", err))
+	}
+
+	if err := wm.removeDesktopFile(oldName); err != nil {
+		fmt.Fprint(os.Stderr, T("Warning: Failed to remove desktop file: %v\n", err))
+	}
+	icon := wm.renameThemeIcons(oldName, newName)
+	if desktopID := wm.launcherDesktopID(newName); desktopID != "" {
+		iconPath := filepath.Join(wm.dataDir, "icons", newName+".png")
+		if err := wm.installLauncher(newName, renamed.URL, iconPath, desktopID); err != nil {
+			fmt.Print(T("Warning: Could not install the launcher through the desktop portal: %v\n", err))
+		}
+	} else if err := wm.writeDesktopEntry(newName, renamed.URL, icon); err != nil {
+		fmt.Fprint(os.Stderr, T("Warning: Failed to create desktop file: %v\n", err))
+	}
+	if pinErr == nil {
+		if newID, err := wm.desktopID(newName); err == nil {
+			renamePinned(oldID, newID)
+		}
+	}
+
+	fmt.Print(T("Renamed weblet '%s' to '%s'\n", oldName, newName))
+	return nil
+}
+
+// renameMoves lists the files and directories of a weblet that carry its
+// name, with where they go for the new name
+func (wm *WebletManager) renameMoves(oldName, newName string) ([][2]string, error) {
+	candidates, err := wm.profileDirs(oldName, newName)
+	if err != nil {
+		return nil, err
+	}
+	candidates = append(candidates,
+		[2]string{wm.thumbnailFile(oldName), wm.thumbnailFile(newName)},
+		[2]string{wm.memoryCacheDir(oldName), wm.memoryCacheDir(newName)},
+		[2]string{wm.webletConfigFile(oldName), wm.webletConfigFile(newName)})
+
+	// Downloaded icons (<name>.png, ...) and custom variants
+	// (<name>-custom-dark.svg, ...)
+	iconDir := filepath.Join(wm.dataDir, "icons")
+	for _, pattern := range []string{oldName + ".*", oldName + "-custom-*"} {
+		matches, _ := filepath.Glob(filepath.Join(iconDir, pattern))
+		for _, match := range matches {
+			base := newName + strings.TrimPrefix(filepath.Base(match), oldName)
+			candidates = append(candidates, [2]string{match, filepath.Join(iconDir, base)})
+		}
+	}
+
+	var moves [][2]string
+	for _, candidate := range candidates {
+		if _, err := os.Lstat(candidate[0]); err == nil {
+			moves = append(moves, candidate)
+		}
+	}
+	return moves, nil
+}
+
+// renamedIcon is the path of a custom icon variant after the rename
+func renamedIcon(path, oldName, newName string) string {
+	prefix := oldName + "-custom-"
+	if !strings.HasPrefix(filepath.Base(path), prefix) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(path), newName+"-custom-"+strings.TrimPrefix(filepath.Base(path), prefix))
+}
+
+// renameThemeIcons renames the weblet's icons in the hicolor theme and
+// returns the icon name for the desktop file
+func (wm *WebletManager) renameThemeIcons(oldName, newName string) string {
+	themeDir, err := wm.hicolorDir()
+	if err != nil {
+		return "web-browser"
+	}
+	icons, _ := filepath.Glob(filepath.Join(themeDir, "*", "apps", "weblet-"+oldName+".*"))
+	symbolic, _ := filepath.Glob(filepath.Join(themeDir, "*", "apps", "weblet-"+oldName+"-symbolic.*"))
+	for _, path := range append(icons, symbolic...) {
+		base := "weblet-" + newName + strings.TrimPrefix(filepath.Base(path), "weblet-"+oldName)
+		os.Rename(path, filepath.Join(filepath.Dir(path), base))
+	}
+	if len(icons) == 0 {
+		return "web-browser"
+	}
+	updateIconCache(themeDir)
+	return "weblet-" + newName
+}
+
+// renameSecrets stores the lock passphrase and saved logins of a weblet
+// under its new name in the keyring
+func renameSecrets(weblet *Weblet, oldName, newName string) error {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		if weblet.Locked || weblet.Autofill {
+			return fmt.Errorf("secret-tool not found, the keyring entries of '%s' weren't renamed", oldName)
+		}
+		return nil
+	}
+
+	if weblet.Locked {
+		if err := moveSecret("weblet lock: "+newName, secretToolArgs(oldName), secretToolArgs(newName)); err != nil {
+			return fmt.Errorf("failed to rename the passphrase: %w", err)
+		}
+	}
+
+	logins, err := searchLogins(oldName)
+	if err != nil {
+		return err
+	}
+	for _, login := range logins {
+		// The passphrase entry has the weblet attribute too
+		if login.Origin == "" {
+			continue
+		}
+		from := []string{"weblet", oldName, "origin", login.Origin, "username", login.Username}
+		to := []string{"weblet", newName, "origin", login.Origin, "username", login.Username}
+		if err := moveSecret(fmt.Sprintf("weblet %s: %s", newName, login.Origin), from, to); err != nil {
+			return fmt.Errorf("failed to rename the login '%s' for %s: %w", login.Username, login.Origin, err)
+		}
+	}
+	return nil
+}
+
+// moveSecret stores a keyring secret under new attributes and removes the
+// old entry
+func moveSecret(label string, from, to []string) error {
+	secret, err := exec.Command("secret-tool", append([]string{"lookup"}, from...)...).Output()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("secret-tool", append([]string{"store", "--label=" + label}, to...)...)
+	cmd.Stdin = strings.NewReader(string(secret))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v %s", err, strings.TrimSpace(string(output)))
+	}
+	return exec.Command("secret-tool", append([]string{"clear"}, from...)...).Run()
+}
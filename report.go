@@ -0,0 +1,224 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// The output of a weblet's window or Chrome goes to data/<name>/weblet.log
+// and crashes of the native web process to crashes.log. 'weblet report'
+// bundles them with versions and settings into a tarball for bug reports,
+// with the paths, query strings and private settings redacted.
+
+// logLimit is the size at which weblet.log is rotated to weblet.log.1
+const logLimit = 1 << 20
+
+// reportLogLines is how many lines of the log a report includes
+const reportLogLines = 300
+
+func (wm *WebletManager) logFile(name string) string {
+	return filepath.Join(wm.dataDir, "data", name, "weblet.log")
+}
+
+func (wm *WebletManager) crashFile(name string) string {
+	return filepath.Join(wm.dataDir, "data", name, "crashes.log")
+}
+
+// openLog opens the log of a weblet for a new launch, or returns nil
+func (wm *WebletManager) openLog(name string) *os.File {
+	path := wm.logFile(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() > logLimit {
+		os.Rename(path, path+".1")
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil
+	}
+	fmt.Fprintf(file, "=== %s weblet %s launched\n", time.Now().Format(time.RFC3339), version)
+	return file
+}
+
+// Report writes a redacted bug report bundle of a weblet into dir and
+// prints its path
+func (wm *WebletManager) Report(name, dir string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("weblet-report-%s-%s.tar.gz", name, time.Now().Format("20060102-150405")))
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	defer file.Close()
+	compressed := gzip.NewWriter(file)
+	archive := tar.NewWriter(compressed)
+
+	add := func(fileName string, data []byte) error {
+		header := &tar.Header{Name: "weblet-report/" + fileName, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write(data)
+		return err
+	}
+
+	settings, err := json.MarshalIndent(redactWeblet(weblet), "", "  ")
+	if err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"system.txt", []byte(wm.systemReport(weblet))},
+		{"weblet.json", settings},
+		{"crashes.log", []byte(redactText(readTail(wm.crashFile(name), reportLogLines)))},
+		{"weblet.log", []byte(redactText(readTail(wm.logFile(name)+".1", reportLogLines) + readTail(wm.logFile(name), reportLogLines)))},
+	}
+	for _, f := range files {
+		if err := add(f.name, f.data); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+
+	crashes := strings.Count(readTail(wm.crashFile(name), reportLogLines), "\n")
+	fmt.Print(T("Wrote %s (%d crashes recorded)\n", path, crashes))
+	fmt.Println(T("Check its contents before attaching it to a bug report"))
+	return nil
+}
+
+// systemReport describes the versions and environment weblet runs in
+func (wm *WebletManager) systemReport(weblet *Weblet) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "weblet: %s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if name := osRelease()["PRETTY_NAME"]; name != "" {
+		fmt.Fprintf(&b, "os: %s\n", name)
+	}
+	if data, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		fmt.Fprintf(&b, "kernel: %s\n", strings.TrimSpace(string(data)))
+	}
+	fmt.Fprintf(&b, "desktop: %s\n", os.Getenv("XDG_CURRENT_DESKTOP"))
+	fmt.Fprintf(&b, "session: %s\n", os.Getenv("XDG_SESSION_TYPE"))
+	if sandbox != sandboxNone {
+		fmt.Fprintf(&b, "sandbox: %s\n", sandbox)
+	}
+
+	native := view.Versions()
+	if !view.Available {
+		native = "not built in (no_native)"
+	}
+	if stubBackend {
+		native = "stub backend"
+	}
+	fmt.Fprintf(&b, "native: %s\n", native)
+	chrome := "not found"
+	if browser := findChrome(); browser != "" {
+		if output, err := hostCommand(browser, "--version").Output(); err == nil {
+			chrome = strings.TrimSpace(string(output))
+		} else {
+			chrome = browser
+		}
+	}
+	fmt.Fprintf(&b, "chrome: %s\n", chrome)
+	for _, tool := range []string{"wmctrl", "xdotool", "pactl", "sqlite3"} {
+		status := "missing"
+		if _, err := hostLookPath(tool); err == nil {
+			status = "found"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", tool, status)
+	}
+
+	backend := "chrome"
+	if !weblet.UseChrome {
+		backend = "native"
+	}
+	fmt.Fprintf(&b, "backend: %s\n", backend)
+	fmt.Fprintf(&b, "running: %t\n", wm.isRunning(weblet))
+	return b.String()
+}
+
+// redactWeblet returns a copy of a weblet's settings without what could
+// identify the user: URLs keep only their origin, hooks and locations go
+func redactWeblet(weblet *Weblet) Weblet {
+	redacted := *weblet
+	redacted.URL = redactURL(weblet.URL)
+	redacted.Links = nil
+	for _, link := range weblet.Links {
+		redacted.Links = append(redacted.Links, Link{Name: link.Name, URL: redactURL(link.URL)})
+	}
+	if redacted.OnUnreachable != "" {
+		redacted.OnUnreachable = "[redacted]"
+	}
+	if redacted.Location != "" {
+		redacted.Location = "[redacted]"
+	}
+	if redacted.LocalDir != "" {
+		redacted.LocalDir = "[redacted]"
+	}
+	if redacted.Auth != nil {
+		auth := *redacted.Auth
+		auth.ClientID = "[redacted]"
+		redacted.Auth = &auth
+	}
+	return redacted
+}
+
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "[redacted]"
+	}
+	return u.Scheme + "://" + u.Host + "/…"
+}
+
+var (
+	urlPattern  = regexp.MustCompile(`\b(https?|wss?)://[^\s"'<>]+`)
+	homePattern = regexp.MustCompile(`/home/[^/\s]+`)
+)
+
+// redactText shortens the URLs in log lines to their origin and hides the
+// user's home directory
+func redactText(text string) string {
+	text = urlPattern.ReplaceAllStringFunc(text, redactURL)
+	return homePattern.ReplaceAllString(text, "~")
+}
+
+// readTail returns the last lines of a file, "" if it doesn't exist
+func readTail(path string, lines int) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	data = bytes.TrimRight(data, "\n")
+	all := splitLines(string(data))
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	if len(all) == 0 {
+		return ""
+	}
+	return strings.Join(all, "\n") + "\n"
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Weblets running when the session ends are relaunched at the next login
+// ('weblet config resume-on-login on'), like a browser restoring its
+// session. A weblet counts as running at logout if its last launch is still
+// recorded (see recordLaunch) but the process is gone:
+//   - native weblets clear the record when the user closes them, but keep
+//     it when the session ends or they are killed by it
+//   - Chrome marks how its profile was closed in Preferences (exit_type),
+//     a normal close isn't resumed
+
+const resumeAutostartFile = "weblet-resume.desktop"
+
+// setResumeAutostart adds or removes the login autostart entry that runs
+// 'weblet resume'
+func (wm *WebletManager) setResumeAutostart(enabled bool) error {
+	return setAutostart(resumeAutostartFile, "Weblet session restore",
+		"Reopen the weblets that were running at logout", "resume", enabled)
+}
+
+// setAutostart adds or removes a login autostart entry running weblet with
+// the given command
+func setAutostart(file, name, comment, command string, enabled bool) error {
+	autostartDir, err := autostartDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(autostartDir, file)
+
+	if !enabled {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+	content := fmt.Sprintf(`[Desktop Entry]
+Type=Application
+Name=%s
+Comment=%s
+Exec=%s %s
+NoDisplay=true
+X-GNOME-Autostart-enabled=true
+`, name, comment, launcherCommand(execPath), command)
+
+	if err := os.MkdirAll(autostartDir, 0755); err != nil {
+		return err
+	}
+	requestBackground(comment)
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// endedWithSession reports whether a weblet whose process is gone was
+// still open when the session ended
+func (wm *WebletManager) endedWithSession(weblet *Weblet) bool {
+	if weblet.Backend != "chrome" {
+		return true
+	}
+
+	data, err := os.ReadFile(filepath.Join(wm.dataDir, "chrome-data", weblet.Name, "Default", "Preferences"))
+	if err != nil {
+		return false
+	}
+	var prefs struct {
+		Profile struct {
+			ExitType string `json:"exit_type"`
+		} `json:"profile"`
+	}
+	if err := json.Unmarshal(data, &prefs); err != nil {
+		return false
+	}
+	return prefs.Profile.ExitType == "SessionEnded" || prefs.Profile.ExitType == "Crashed"
+}
+
+// Resume relaunches the weblets that were running when the last session
+// ended; run at login by the autostart entry
+func (wm *WebletManager) Resume() error {
+	if !wm.config.ResumeOnLogin {
+		return nil
+	}
+
+	var names []string
+	for name, weblet := range wm.weblets {
+		if weblet.PID <= 0 {
+			continue
+		}
+		pid, backend := weblet.PID, weblet.Backend
+		if wm.runningPID(weblet) > 0 {
+			continue // Already running
+		}
+		// runningPID cleared the stale record, judge it as it was
+		weblet.PID, weblet.Backend = pid, backend
+		if wm.endedWithSession(weblet) {
+			names = append(names, name)
+		}
+		weblet.PID, weblet.StartedAt, weblet.Backend = 0, 0, ""
+		if err := wm.saveLaunch(weblet); err != nil {
+			return err
+		}
+	}
+	sort.Strings(names)
+
+	if wm.config.ResumeHidden {
+		os.Setenv("WEBLET_START_HIDDEN", "1")
+	}
+
+	var failed int
+	for _, name := range names {
+		if err := wm.Run(name); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Could not resume weblet '%s': %v\n", name, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d weblet(s) failed to resume", failed)
+	}
+	return nil
+}
+
+// clearLaunch forgets the launch of a native weblet that was closed by the
+// user. The record is re-read, as another run may have replaced it since.
+func (wm *WebletManager) clearLaunch(name string) {
+	record, ok := wm.readLaunch(name)
+	// The recorded PID is ours, or a wrapper's (network namespace)
+	if !ok || (record.PID != os.Getpid() && record.PID != os.Getppid()) {
+		return
+	}
+	os.Remove(wm.launchFile(name))
+}
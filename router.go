@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// With 'weblet config url-router on', weblet-url-router.desktop becomes the
+// default browser and runs 'weblet route <url>' for every clicked link: a
+// link in a weblet's scope opens in that weblet (the running window or a
+// new one), everything else goes to the browser that was the default
+// before. A weblet's scope is the host of its URL with its subdomains and
+// the hosts and URLs of its allow rules, minus its deny rules.
+
+const routerDesktopID = "weblet-url-router.desktop"
+
+func (wm *WebletManager) routerDesktopFile() (string, error) {
+	shareDir, err := wm.shareDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(shareDir, "applications", routerDesktopID), nil
+}
+
+// setURLRouter installs the router as the default browser, remembering the
+// previous one, or restores that browser
+func (wm *WebletManager) setURLRouter(enabled bool) error {
+	if _, err := hostLookPath("xdg-settings"); err != nil {
+		return fmt.Errorf("the URL router needs xdg-settings (xdg-utils)")
+	}
+	desktopFilePath, err := wm.routerDesktopFile()
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		if wm.config.RouterBrowser != "" {
+			if output, err := hostCommand("xdg-settings", "set", "default-web-browser", wm.config.RouterBrowser).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to restore the default browser: %s", strings.TrimSpace(string(output)))
+			}
+			fmt.Print(T("Restored the default browser: %s\n", wm.config.RouterBrowser))
+		}
+		wm.config.RouterBrowser = ""
+		os.Remove(desktopFilePath)
+		return nil
+	}
+
+	execPath, err := wm.desktopExec()
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf(`[Desktop Entry]
+Version=1.0
+Type=Application
+Name=Weblet URL Router
+Comment=Opens links in the matching weblet, others in the browser
+Exec=%s route %%u
+Icon=web-browser
+Terminal=false
+NoDisplay=true
+MimeType=x-scheme-handler/http;x-scheme-handler/https;
+Categories=Network;WebBrowser;
+`, execPath)
+	if err := os.MkdirAll(filepath.Dir(desktopFilePath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(desktopFilePath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write desktop file: %w", err)
+	}
+	hostCommand("update-desktop-database", filepath.Dir(desktopFilePath)).Run()
+
+	output, err := hostCommand("xdg-settings", "get", "default-web-browser").Output()
+	if browser := strings.TrimSpace(string(output)); err == nil && browser != "" && browser != routerDesktopID {
+		wm.config.RouterBrowser = browser
+	}
+	if wm.config.RouterBrowser == "" {
+		fmt.Println(T("Warning: No default browser to forward other links to, set one and turn the router on again"))
+	}
+	if output, err := hostCommand("xdg-settings", "set", "default-web-browser", routerDesktopID).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set the default browser: %s", strings.TrimSpace(string(output)))
+	}
+	fmt.Print(T("Links now open in matching weblets, others in %s\n", wm.config.RouterBrowser))
+	return nil
+}
+
+// Route opens a URL in the weblet whose scope it is in, or in the browser.
+// A weblet's own external links never come back to it (see WEBLET_NAME).
+func (wm *WebletManager) Route(rawURL string) error {
+	if weblet := wm.routeTarget(rawURL, os.Getenv("WEBLET_NAME")); weblet != nil {
+		return wm.openPage(weblet, rawURL)
+	}
+	return wm.forwardToBrowser(rawURL)
+}
+
+// routeTarget returns the weblet with the most specific scope containing the
+// URL, skipping the weblet named skip
+func (wm *WebletManager) routeTarget(rawURL, skip string) *Weblet {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	names := make([]string, 0, len(wm.weblets))
+	for name := range wm.weblets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var target *Weblet
+	best := 0
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		if name == skip {
+			continue
+		}
+		if score := webletScope(weblet, rawURL, host); score > best {
+			target, best = weblet, score
+		}
+	}
+	return target
+}
+
+// webletScope reports how specifically a weblet's scope contains a URL:
+// 0 when it doesn't, otherwise longer matching hosts score higher than
+// allow rules
+func webletScope(weblet *Weblet, rawURL, host string) int {
+	if _, ok := localPath(weblet.URL); ok {
+		return 0
+	}
+	var allow, deny []string
+	for _, rule := range weblet.Rules {
+		action, args, err := parseRule(rule)
+		if err != nil {
+			continue
+		}
+		switch action {
+		case "allow":
+			allow = append(allow, args[0])
+		case "deny":
+			deny = append(deny, args[0])
+		}
+	}
+	if navPatternMatches(deny, rawURL, host) {
+		return 0
+	}
+
+	start, err := url.Parse(weblet.URL)
+	if err != nil {
+		return 0
+	}
+	startHost := strings.TrimPrefix(strings.ToLower(start.Hostname()), "www.")
+	if startHost != "" && (strings.TrimPrefix(host, "www.") == startHost || strings.HasSuffix(host, "."+startHost)) {
+		return 1 + len(startHost)
+	}
+	if navPatternMatches(allow, rawURL, host) {
+		return 1
+	}
+	return 0
+}
+
+// navPatternMatches matches navigation patterns like the native window:
+// globs against the host, or against the whole URL when they contain a '/'
+func navPatternMatches(patterns []string, rawURL, host string) bool {
+	for _, pattern := range patterns {
+		subject := host
+		if strings.Contains(pattern, "/") {
+			subject = rawURL
+		} else if rest, ok := strings.CutPrefix(pattern, "*."); ok && strings.EqualFold(rest, host) {
+			// "*.example.com" also covers example.com itself
+			return true
+		}
+		if matched, err := regexp.MatchString("(?i)"+globToURLFilter(pattern)+"$", subject); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardToBrowser opens a URL in the default browser from before the
+// router was installed
+func (wm *WebletManager) forwardToBrowser(rawURL string) error {
+	if wm.config.RouterBrowser == "" {
+		return fmt.Errorf("no browser to open %s in, turn 'config url-router' off and on again", rawURL)
+	}
+	desktopFilePath := findDesktopFile(wm.config.RouterBrowser)
+	if desktopFilePath == "" {
+		return fmt.Errorf("browser '%s' not found", wm.config.RouterBrowser)
+	}
+	return hostCommand("gio", "launch", desktopFilePath, rawURL).Run()
+}
+
+// findDesktopFile looks up a desktop file ID in the XDG data directories
+func findDesktopFile(id string) string {
+	dataDirs := []string{os.Getenv("XDG_DATA_HOME")}
+	if dataDirs[0] == "" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			dataDirs[0] = filepath.Join(homeDir, ".local", "share")
+		}
+	}
+	systemDirs := os.Getenv("XDG_DATA_DIRS")
+	if systemDirs == "" {
+		systemDirs = "/usr/local/share:/usr/share"
+	}
+	dataDirs = append(dataDirs, filepath.SplitList(systemDirs)...)
+
+	for _, dir := range dataDirs {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, "applications", id)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// Request rules are simple per-weblet lines such as:
+//
+//	block https://*/analytics.js
+//	redirect http:// https://
+//	redirect https://old.example.com/ https://new.example.com/
+//	allow *.google.com
+//	deny ads.*
+//
+// Block rules and the http:// -> https:// upgrade are compiled into a WebKit
+// content filter; other redirects rewrite the pages the window loads, not
+// those of frames. Allow and deny patterns contain navigation (see
+// view.Options).
+
+type contentRule struct {
+	Trigger struct {
+		URLFilter string `json:"url-filter"`
+	} `json:"trigger"`
+	Action struct {
+		Type string `json:"type"`
+	} `json:"action"`
+}
+
+// parseRule validates a single rule and returns its action and arguments
+func parseRule(rule string) (string, []string, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("empty rule")
+	}
+
+	// Accept "allow: *.google.com" as well as "allow *.google.com"
+	action := strings.TrimSuffix(strings.ToLower(fields[0]), ":")
+	args := fields[1:]
+
+	switch action {
+	case "block":
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("usage: block <pattern>")
+		}
+	case "redirect":
+		// Allow "redirect http:// → https://" as well as "redirect http:// https://"
+		if len(args) == 3 && (args[1] == "→" || args[1] == "->") {
+			args = []string{args[0], args[2]}
+		}
+		if len(args) != 2 {
+			return "", nil, fmt.Errorf("usage: redirect <from-prefix> <to-prefix>")
+		}
+		// The rewritten URL would match again, without end
+		if strings.HasPrefix(args[1], args[0]) {
+			return "", nil, fmt.Errorf("'%s' starts with '%s' and would be redirected again", args[1], args[0])
+		}
+	case "allow", "deny":
+		if len(args) != 1 {
+			return "", nil, fmt.Errorf("usage: %s <host-or-url-pattern>", action)
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown rule action '%s' (expected block, redirect, allow or deny)", fields[0])
+	}
+
+	return action, args, nil
+}
+
+// globToURLFilter converts a glob pattern (* matches anything) into the
+// regular expression subset understood by WebKit content filters
+func globToURLFilter(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '.', '?', '+', '(', ')', '[', ']', '\\', '^', '$':
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// compileRules turns the weblet's rules into a content filter, navigation
+// redirects and navigation patterns
+func compileRules(rules []string, opts *view.Options) error {
+	var filters []contentRule
+
+	for _, rule := range rules {
+		action, args, err := parseRule(rule)
+		if err != nil {
+			return fmt.Errorf("invalid rule '%s': %w", rule, err)
+		}
+
+		switch action {
+		case "block":
+			var cr contentRule
+			cr.Trigger.URLFilter = globToURLFilter(args[0])
+			cr.Action.Type = "block"
+			filters = append(filters, cr)
+		case "redirect":
+			// Upgrading to HTTPS is supported natively by content filters
+			if args[0] == "http://" && args[1] == "https://" {
+				var cr contentRule
+				cr.Trigger.URLFilter = "^http://"
+				cr.Action.Type = "make-https"
+				filters = append(filters, cr)
+				continue
+			}
+			opts.Redirects = append(opts.Redirects, view.Redirect{From: args[0], To: args[1]})
+		case "allow":
+			opts.AllowPatterns = append(opts.AllowPatterns, args[0])
+		case "deny":
+			opts.DenyPatterns = append(opts.DenyPatterns, args[0])
+		}
+	}
+
+	if len(filters) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return err
+	}
+	opts.ContentRules = string(data)
+	return nil
+}
+
+// Rules lists, adds or removes request rules for a weblet
+func (wm *WebletManager) Rules(name string, args []string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+
+	if len(args) == 0 {
+		if len(weblet.Rules) == 0 {
+			fmt.Print(T("No rules for weblet '%s'.\n", name))
+			return nil
+		}
+		fmt.Print(T("Rules for weblet '%s':\n", name))
+		for i, rule := range weblet.Rules {
+			fmt.Print(T("  %d: %s\n", i+1, rule))
+		}
+		return nil
+	}
+
+	if err := wm.checkEditable(weblet); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		rule := strings.Join(args[1:], " ")
+		if _, _, err := parseRule(rule); err != nil {
+			return fmt.Errorf("invalid rule '%s': %w", rule, err)
+		}
+		weblet.Rules = append(weblet.Rules, rule)
+		fmt.Print(T("Added rule to weblet '%s': %s\n", name, rule))
+
+	case "remove":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: weblet rules <name> remove <number>")
+		}
+		var index int
+		if _, err := fmt.Sscanf(args[1], "%d", &index); err != nil || index < 1 || index > len(weblet.Rules) {
+			return fmt.Errorf("invalid rule number: %s", args[1])
+		}
+		rule := weblet.Rules[index-1]
+		weblet.Rules = append(weblet.Rules[:index-1], weblet.Rules[index:]...)
+		fmt.Print(T("Removed rule from weblet '%s': %s\n", name, rule))
+
+	case "clear":
+		weblet.Rules = nil
+		fmt.Print(T("Cleared rules for weblet '%s'\n", name))
+
+	default:
+		return fmt.Errorf("unknown rules command '%s' (expected add, remove or clear)", args[0])
+	}
+
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+
+	if weblet.UseChrome {
+		fmt.Println(T("Note: request rules are only applied in native mode (see 'weblet native')"))
+	}
+	return nil
+}
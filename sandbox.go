@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// Weblet may itself run in a Flatpak sandbox or a Toolbox/Distrobox
+// container. Tools it drives (wmctrl, xdotool, pactl, Chrome) then usually
+// live on the host: a tool missing in the sandbox is run on the host with
+// flatpak-spawn --host (needs --talk-name=org.freedesktop.Flatpak) or the
+// container's host-exec helper. Desktop files and autostart entries must
+// launch weblet through the sandbox, and a Flatpak opens pages through the
+// OpenURI portal and asks the Background portal before running at login.
+//
+// Chrome started through flatpak-spawn stays reachable by its proxy
+// process, which is visible in /proc inside the sandbox, carries Chrome's
+// arguments and forwards signals.
+
+const (
+	sandboxNone    = ""
+	sandboxFlatpak = "flatpak"
+	sandboxToolbox = "toolbox" // Toolbox and Distrobox
+)
+
+// sandbox is the kind of sandbox weblet runs in
+var sandbox = detectSandbox()
+
+func detectSandbox() string {
+	if _, err := os.Stat("/.flatpak-info"); err == nil {
+		return sandboxFlatpak
+	}
+	for _, marker := range []string{"/run/.toolboxenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return sandboxToolbox
+		}
+	}
+	return sandboxNone
+}
+
+// containerName reads the name of the Toolbox/Distrobox container
+func containerName() string {
+	data, err := os.ReadFile("/run/.containerenv")
+	if err != nil {
+		return ""
+	}
+	for _, line := range splitLines(string(data)) {
+		if value, ok := strings.CutPrefix(line, "name="); ok {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// hostSpawner returns the command prefix that runs a program on the host
+func hostSpawner() []string {
+	if _, err := exec.LookPath("flatpak-spawn"); err == nil {
+		return []string{"flatpak-spawn", "--host"}
+	}
+	for _, helper := range []string{"distrobox-host-exec", "host-spawn"} {
+		if _, err := exec.LookPath(helper); err == nil {
+			return []string{helper}
+		}
+	}
+	return nil
+}
+
+var (
+	hostToolsLock sync.Mutex
+	hostTools     = make(map[string]bool) // Tools found only on the host
+)
+
+// hostLookPath finds a tool in the sandbox or, failing that, on the host;
+// outside a sandbox it is exec.LookPath
+func hostLookPath(tool string) (string, error) {
+	if stubBackend && stubTools[tool] {
+		return tool, nil
+	}
+	path, err := exec.LookPath(tool)
+	if err == nil || sandbox == sandboxNone {
+		return path, err
+	}
+
+	hostToolsLock.Lock()
+	defer hostToolsLock.Unlock()
+	if hostTools[tool] {
+		return tool, nil
+	}
+	spawner := hostSpawner()
+	if spawner == nil {
+		return "", err
+	}
+	argv := append(spawner, "sh", "-c", `command -v "$0"`, tool)
+	output, hostErr := exec.Command(argv[0], argv[1:]...).Output()
+	if hostErr != nil {
+		return "", err
+	}
+	hostTools[tool] = true
+	return strings.TrimSpace(string(output)), nil
+}
+
+// hostCommand is exec.Command for a tool that may only exist on the host
+func hostCommand(tool string, args ...string) *exec.Cmd {
+	return hostCommandEnv(nil, tool, args...)
+}
+
+// hostCommandEnv is hostCommand for a tool that needs env ("KEY=value") in
+// its environment. The host spawners don't pass on the caller's
+// environment, so on the host it is set with env; the caller still adds it
+// to the command's Env for a tool run in the sandbox.
+func hostCommandEnv(env []string, tool string, args ...string) *exec.Cmd {
+	debugf("running %s", strings.Join(append([]string{tool}, args...), " "))
+	if cmd, ok := stubCommand(tool, args...); ok {
+		return cmd
+	}
+	if sandbox != sandboxNone {
+		if _, err := exec.LookPath(tool); err != nil {
+			if _, err := hostLookPath(tool); err == nil {
+				argv := hostSpawner()
+				if len(env) > 0 {
+					argv = append(append(argv, "env"), env...)
+				}
+				argv = append(argv, tool)
+				argv = append(argv, args...)
+				return exec.Command(argv[0], argv[1:]...)
+			}
+		}
+	}
+	return exec.Command(tool, args...)
+}
+
+// launcherCommand returns how desktop files and autostart entries run
+// weblet: through the sandbox when it runs in one, otherwise executable,
+// with the data directory and config file given with --data-dir and --config
+func launcherCommand(executable string) string {
+	command := executable
+	switch sandbox {
+	case sandboxFlatpak:
+		if id := os.Getenv("FLATPAK_ID"); id != "" {
+			command = "flatpak run --command=weblet " + id
+		}
+	case sandboxToolbox:
+		name := containerName()
+		if name == "" {
+			break
+		}
+		if _, err := exec.LookPath("distrobox-host-exec"); err == nil {
+			command = "distrobox enter " + name + " -- " + executable
+		} else {
+			command = "toolbox run -c " + name + " " + executable
+		}
+	}
+	return command + dataDirArgs()
+}
+
+// autostartDir returns the autostart directory of the session; a Flatpak's
+// own config directory isn't read at login
+func autostartDir() (string, error) {
+	if sandbox == sandboxFlatpak {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(homeDir, ".config", "autostart"), nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "autostart"), nil
+}
+
+// requestBackground asks the Background portal to let the Flatpak run
+// without a window, e.g. when started at login
+func requestBackground(reason string) {
+	if sandbox != sandboxFlatpak {
+		return
+	}
+	exec.Command("gdbus", "call", "--session",
+		"--dest", "org.freedesktop.portal.Desktop",
+		"--object-path", "/org/freedesktop/portal/desktop",
+		"--method", "org.freedesktop.portal.Background.RequestBackground",
+		"", fmt.Sprintf("{'reason': <%s>}", gvariantString(reason))).Run()
+}
+
+// openURI opens a URL in the default browser: through the OpenURI portal
+// in a Flatpak, with the host's xdg-open in a container
+func openURI(uri string) error {
+	if sandbox == sandboxFlatpak {
+		return exec.Command("gdbus", "call", "--session",
+			"--dest", "org.freedesktop.portal.Desktop",
+			"--object-path", "/org/freedesktop/portal/desktop",
+			"--method", "org.freedesktop.portal.OpenURI.OpenURI",
+			"", gvariantString(uri), "{}").Run()
+	}
+	return hostCommand("xdg-open", uri).Start()
+}
+
+// launcherDesktopID returns the desktop file ID of a weblet installed with
+// the DynamicLauncher portal, or "" when 'config dynamic-launcher' is off
+// or weblet doesn't run in a Flatpak (the ID must start with the app ID)
+func (wm *WebletManager) launcherDesktopID(name string) string {
+	id := os.Getenv("FLATPAK_ID")
+	if !wm.config.DynamicLauncher || sandbox != sandboxFlatpak || id == "" || wm.system {
+		return ""
+	}
+	return id + ".weblet-" + name + ".desktop"
+}
+
+// installLauncher installs the desktop entry and icon through the portal;
+// it rewrites Exec to run the command inside the Flatpak
+func (wm *WebletManager) installLauncher(name, webletURL, iconPath, desktopID string) error {
+	icon, err := os.ReadFile(iconPath)
+	if err != nil {
+		return err
+	}
+	entry := wm.desktopEntry(name, webletURL, "weblet", desktopID)
+	return view.InstallLauncher(name, icon, desktopID, entry)
+}
+
+// gvariantString quotes a string in GVariant text format
+func gvariantString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
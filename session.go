@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Switching a weblet between native mode and Chrome carries its persistent
+// cookies over, so logins survive the switch (best effort: some sites bind
+// sessions to more than cookies). WebKit keeps them in a Firefox-style
+// moz_cookies table, Chrome in its profile's Cookies database with the
+// values encrypted: v10 with a fixed key, v11 with a key from the keyring
+// ("Chrome Safe Storage"). Cookies are written to Chrome unencrypted, which
+// it accepts, and to WebKit decrypted. Both stores are edited with the
+// sqlite3 CLI while the weblet is closed.
+
+// sessionCookie is a persistent cookie in a browser-neutral form
+type sessionCookie struct {
+	host, path, name, value string
+	expiry                  int64 // Unix time
+	secure, httpOnly        bool
+	sameSite                int // 0 none, 1 lax, 2 strict (libsoup's values)
+}
+
+func (wm *WebletManager) webkitCookieFile(weblet *Weblet) string {
+	return filepath.Join(wm.webkitSiteDir(weblet.Name, weblet.Container), "cookies.sqlite")
+}
+
+func (wm *WebletManager) chromeProfileDir(name string) string {
+	return filepath.Join(wm.dataDir, "chrome-data", name)
+}
+
+// chromeCookieFile returns the cookie database of a weblet's Chrome profile,
+// "" if Chrome didn't create one yet
+func (wm *WebletManager) chromeCookieFile(weblet *Weblet) string {
+	profile := filepath.Join(wm.chromeProfileDir(weblet.Name), chromeProfileName(weblet.Container))
+	for _, path := range []string{filepath.Join(profile, "Network", "Cookies"), filepath.Join(profile, "Cookies")} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// migrateSession copies the cookies of a weblet's previous mode into the
+// store of the new one and returns how many were copied
+func (wm *WebletManager) migrateSession(weblet *Weblet, toChrome bool) (int, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return 0, fmt.Errorf("sqlite3 is needed (install the sqlite3 package)")
+	}
+
+	if toChrome {
+		if _, err := os.Stat(wm.webkitCookieFile(weblet)); err != nil {
+			return 0, nil
+		}
+		cookies, err := readWebKitCookies(wm.webkitCookieFile(weblet))
+		if err != nil || len(cookies) == 0 {
+			return 0, err
+		}
+		cookieFile := wm.chromeCookieFile(weblet)
+		if cookieFile == "" {
+			if cookieFile, err = wm.initChromeProfile(weblet); err != nil {
+				return 0, err
+			}
+		}
+		return len(cookies), writeChromeCookies(cookieFile, cookies)
+	}
+
+	cookieFile := wm.chromeCookieFile(weblet)
+	if cookieFile == "" {
+		return 0, nil
+	}
+	cookies, err := readChromeCookies(cookieFile)
+	if err != nil || len(cookies) == 0 {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(wm.webkitCookieFile(weblet)), 0700); err != nil {
+		return 0, err
+	}
+	return len(cookies), writeWebKitCookies(wm.webkitCookieFile(weblet), cookies)
+}
+
+// initChromeProfile lets a headless Chrome create the profile, and with it
+// the cookie database in the schema of the installed version
+func (wm *WebletManager) initChromeProfile(weblet *Weblet) (string, error) {
+	browser := findChrome()
+	if browser == "" {
+		return "", newBackendMissingError("chrome")
+	}
+	cmd := hostCommand(browser, "--headless=new", "--no-first-run", "--disable-gpu",
+		"--user-data-dir="+wm.chromeProfileDir(weblet.Name), "--profile-directory="+chromeProfileName(weblet.Container),
+		"--dump-dom", "about:blank")
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+
+	cookieFile := wm.chromeCookieFile(weblet)
+	if cookieFile == "" {
+		return "", fmt.Errorf("Chrome didn't create a cookie database")
+	}
+	return cookieFile, nil
+}
+
+// sqlQuote quotes a string for an SQL statement
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// querySQLite runs a query with the sqlite3 CLI and returns its rows
+func querySQLite(database, query string) ([][]string, error) {
+	output, err := exec.Command("sqlite3", "-readonly", "-cmd", ".timeout 2000", "-separator", "\t", database, query).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", database, err)
+	}
+	var rows [][]string
+	for _, line := range splitLines(string(output)) {
+		if line != "" {
+			rows = append(rows, strings.Split(line, "\t"))
+		}
+	}
+	return rows, nil
+}
+
+func execSQLite(database, statement string) error {
+	if output, err := exec.Command("sqlite3", "-cmd", ".timeout 2000", database, statement).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func readWebKitCookies(database string) ([]sessionCookie, error) {
+	rows, err := querySQLite(database, "SELECT host, path, name, hex(value), expiry, isSecure, isHttpOnly, sameSite"+
+		" FROM moz_cookies WHERE expiry > strftime('%s', 'now')")
+	if err != nil {
+		return nil, err
+	}
+	var cookies []sessionCookie
+	for _, row := range rows {
+		if len(row) != 8 {
+			continue
+		}
+		value, err := hex.DecodeString(row[3])
+		if err != nil {
+			continue
+		}
+		expiry, _ := strconv.ParseInt(row[4], 10, 64)
+		sameSite, _ := strconv.Atoi(row[7])
+		cookies = append(cookies, sessionCookie{
+			host: row[0], path: row[1], name: row[2], value: string(value), expiry: expiry,
+			secure: row[5] == "1", httpOnly: row[6] == "1", sameSite: sameSite,
+		})
+	}
+	return cookies, nil
+}
+
+func writeWebKitCookies(database string, cookies []sessionCookie) error {
+	// The schema of libsoup's cookie jar, for profiles that never ran
+	var statement strings.Builder
+	statement.WriteString("CREATE TABLE IF NOT EXISTS moz_cookies (id INTEGER PRIMARY KEY, name TEXT, value TEXT, host TEXT," +
+		" path TEXT, expiry INTEGER, lastAccessed INTEGER, isSecure INTEGER, isHttpOnly INTEGER, sameSite INTEGER);" +
+		" BEGIN;")
+	now := time.Now().Unix()
+	for _, c := range cookies {
+		fmt.Fprintf(&statement, " DELETE FROM moz_cookies WHERE name = %s AND host = %s AND path = %s;",
+			sqlQuote(c.name), sqlQuote(c.host), sqlQuote(c.path))
+		fmt.Fprintf(&statement, " INSERT INTO moz_cookies (name, value, host, path, expiry, lastAccessed, isSecure, isHttpOnly, sameSite)"+
+			" VALUES (%s, %s, %s, %s, %d, %d, %d, %d, %d);",
+			sqlQuote(c.name), sqlQuote(c.value), sqlQuote(c.host), sqlQuote(c.path), c.expiry, now,
+			boolInt(c.secure), boolInt(c.httpOnly), c.sameSite)
+	}
+	statement.WriteString(" COMMIT;")
+	return execSQLite(database, statement.String())
+}
+
+func readChromeCookies(database string) ([]sessionCookie, error) {
+	rows, err := querySQLite(database, "SELECT host_key, path, name, hex(value), hex(encrypted_value), expires_utc,"+
+		" is_secure, is_httponly, samesite FROM cookies WHERE has_expires = 1")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	keys := chromeKeys()
+	var cookies []sessionCookie
+	for _, row := range rows {
+		if len(row) != 9 {
+			continue
+		}
+		expiresUTC, _ := strconv.ParseInt(row[5], 10, 64)
+		expiry := expiresUTC/1000000 - chromeEpochOffset
+		if expiry <= now {
+			continue
+		}
+		value, _ := hex.DecodeString(row[3])
+		if encrypted, _ := hex.DecodeString(row[4]); len(encrypted) > 0 {
+			decrypted, ok := decryptChromeValue(encrypted, row[0], keys)
+			if !ok {
+				continue
+			}
+			value = decrypted
+		}
+		// Chrome's unspecified SameSite (-1) and none both mean none in libsoup
+		sameSite, _ := strconv.Atoi(row[8])
+		if sameSite < 0 {
+			sameSite = 0
+		}
+		cookies = append(cookies, sessionCookie{
+			host: row[0], path: row[1], name: row[2], value: string(value), expiry: expiry,
+			secure: row[6] == "1", httpOnly: row[7] == "1", sameSite: sameSite,
+		})
+	}
+	return cookies, nil
+}
+
+func writeChromeCookies(database string, cookies []sessionCookie) error {
+	// The columns differ between Chrome versions: known ones get their
+	// values, newer ones their zero value
+	rows, err := querySQLite(database, "SELECT name, type FROM pragma_table_info('cookies')")
+	if err != nil {
+		return err
+	}
+
+	var statement strings.Builder
+	statement.WriteString("BEGIN;")
+	now := (time.Now().Unix() + chromeEpochOffset) * 1000000
+	for i, c := range cookies {
+		scheme, port := 1, 80
+		if c.secure {
+			scheme, port = 2, 443
+		}
+		sameSite := c.sameSite
+		if sameSite == 0 {
+			sameSite = -1
+		}
+		known := map[string]string{
+			"creation_utc":    strconv.FormatInt(now+int64(i), 10),
+			"host_key":        sqlQuote(c.host),
+			"name":            sqlQuote(c.name),
+			"value":           sqlQuote(c.value),
+			"encrypted_value": "X''",
+			"path":            sqlQuote(c.path),
+			"expires_utc":     strconv.FormatInt((c.expiry+chromeEpochOffset)*1000000, 10),
+			"is_secure":       strconv.Itoa(boolInt(c.secure)),
+			"is_httponly":     strconv.Itoa(boolInt(c.httpOnly)),
+			"last_access_utc": strconv.FormatInt(now, 10),
+			"last_update_utc": strconv.FormatInt(now, 10),
+			"has_expires":     "1",
+			"is_persistent":   "1",
+			"priority":        "1",
+			"samesite":        strconv.Itoa(sameSite),
+			"source_scheme":   strconv.Itoa(scheme),
+			"source_port":     strconv.Itoa(port),
+		}
+		var columns, values []string
+		for _, row := range rows {
+			if len(row) != 2 {
+				continue
+			}
+			value, ok := known[row[0]]
+			if !ok {
+				switch strings.ToUpper(row[1]) {
+				case "TEXT", "LONGVARCHAR":
+					value = "''"
+				case "BLOB":
+					value = "X''"
+				default:
+					value = "0"
+				}
+			}
+			columns = append(columns, row[0])
+			values = append(values, value)
+		}
+		fmt.Fprintf(&statement, " DELETE FROM cookies WHERE host_key = %s AND name = %s AND path = %s;",
+			sqlQuote(c.host), sqlQuote(c.name), sqlQuote(c.path))
+		fmt.Fprintf(&statement, " INSERT INTO cookies (%s) VALUES (%s);", strings.Join(columns, ", "), strings.Join(values, ", "))
+	}
+	statement.WriteString(" COMMIT;")
+	return execSQLite(database, statement.String())
+}
+
+// chromeKeys returns the keys Chrome encrypts cookies with on Linux: "v10"
+// values use a fixed password, "v11" ones the password in the keyring
+func chromeKeys() map[string][]byte {
+	keys := map[string][]byte{"v10": chromeKey("peanuts"), "v11": chromeKey("")}
+	for _, application := range []string{"chrome", "chromium"} {
+		output, err := hostCommand("secret-tool", "lookup", "application", application).Output()
+		if password := strings.TrimSpace(string(output)); err == nil && password != "" {
+			keys["v11"] = chromeKey(password)
+			break
+		}
+	}
+	return keys
+}
+
+// chromeKey derives an AES key from a password like Chrome: PBKDF2-SHA1
+// with the salt "saltysalt", one iteration and 16 bytes, which is the first
+// block of HMAC-SHA1(password, salt || 1)
+func chromeKey(password string) []byte {
+	mac := hmac.New(sha1.New, []byte(password))
+	mac.Write([]byte("saltysalt"))
+	mac.Write([]byte{0, 0, 0, 1})
+	return mac.Sum(nil)[:16]
+}
+
+// decryptChromeValue decrypts an encrypted_value (AES-128-CBC, an IV of 16
+// spaces); newer Chrome versions prefix the value with the SHA-256 of the
+// host
+func decryptChromeValue(encrypted []byte, host string, keys map[string][]byte) ([]byte, bool) {
+	if len(encrypted) < 3 {
+		return nil, false
+	}
+	key, ok := keys[string(encrypted[:3])]
+	data := encrypted[3:]
+	if !ok || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, false
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, false
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, bytes.Repeat([]byte(" "), aes.BlockSize)).CryptBlocks(plain, data)
+
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(plain) {
+		return nil, false
+	}
+	plain = plain[:len(plain)-padding]
+	if hostHash := sha256.Sum256([]byte(host)); len(plain) >= len(hostHash) && bytes.Equal(plain[:len(hostHash)], hostHash[:]) {
+		plain = plain[len(hostHash):]
+	}
+	return plain, true
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// stopTimeout is how long a weblet gets to quit after SIGTERM before it is
+// killed. Chrome writes its profile on the way out; killing it right away
+// leaves "Chrome didn't shut down correctly" and can corrupt the profile.
+const stopTimeout = 10 * time.Second
+
+// stopWeblet quits a running weblet gracefully: it asks the window to close,
+// then sends SIGTERM and only kills the processes if they are still around
+// after stopTimeout. It does nothing if the weblet isn't running.
+func (wm *WebletManager) stopWeblet(weblet *Weblet) error {
+	pids := wm.webletPIDs(weblet)
+	if len(pids) == 0 {
+		return nil
+	}
+	defer func() {
+		weblet.PID = 0
+		weblet.StartedAt = 0
+		weblet.Backend = ""
+		wm.saveLaunch(weblet)
+	}()
+
+	// Signal only the top-level processes, they shut their children down
+	var roots []int
+	for pid := range pids {
+		if !pids[parentPID(pid)] {
+			roots = append(roots, pid)
+		}
+	}
+
+	// Closing the window is what the user would do; native weblets flush
+	// their website data, Chrome exits with its last window. Native windows
+	// are closed through their control socket, which works on Wayland too.
+	if !weblet.UseChrome {
+		if _, err := view.Query(weblet.Name, "close"); err == nil && wm.waitForExit(roots, 3*time.Second) {
+			return nil
+		}
+	}
+	if windowID, ok := wm.pidWindow(weblet.Name); ok {
+		if hostCommand("wmctrl", "-i", "-c", windowID).Run() == nil && wm.waitForExit(roots, 3*time.Second) {
+			return nil
+		}
+	}
+
+	all := make([]int, 0, len(pids))
+	for pid := range pids {
+		all = append(all, pid)
+	}
+
+	for _, pid := range roots {
+		syscall.Kill(pid, syscall.SIGTERM)
+	}
+	if !wm.waitForExit(all, 2*time.Second) {
+		// Children a wrapper left behind get the signal themselves
+		for _, pid := range all {
+			syscall.Kill(pid, syscall.SIGTERM)
+		}
+		if !wm.waitForExit(all, stopTimeout) {
+			for _, pid := range all {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+			return fmt.Errorf("weblet '%s' didn't quit within %s and was killed", weblet.Name, stopTimeout)
+		}
+	}
+	return nil
+}
+
+// Stop quits running weblets gracefully ('weblet stop')
+func (wm *WebletManager) Stop(names []string) error {
+	for _, name := range names {
+		if _, exists := wm.weblets[name]; !exists {
+			return newError(ErrNotFound, "weblet '%s' not found", name)
+		}
+	}
+
+	var failed int
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		if len(wm.webletPIDs(weblet)) == 0 {
+			fmt.Print(T("Weblet '%s' isn't running\n", name))
+			continue
+		}
+		if err := wm.stopWeblet(weblet); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: %v\n", err))
+			failed++
+			continue
+		}
+		fmt.Print(T("Stopped weblet '%s'\n", name))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d weblet(s) had to be killed", failed)
+	}
+	return nil
+}
+
+// waitForExit waits until none of the processes is running
+func (wm *WebletManager) waitForExit(pids []int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		running := false
+		for _, pid := range pids {
+			if wm.isProcessRunning(pid) {
+				running = true
+				break
+			}
+		}
+		if !running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
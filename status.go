@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// webletStatus is the run state of a weblet as 'weblet status' shows it
+type webletStatus struct {
+	running bool
+	backend string
+	pid     int
+	window  string // X11 window ID, "wayland" or empty when none is found
+	started time.Time
+}
+
+// status finds the processes and window of a weblet. The recorded launch
+// is used when it is still alive, otherwise the weblet's own processes
+// (e.g. a Chrome instance started before PIDs were recorded).
+func (wm *WebletManager) status(weblet *Weblet) webletStatus {
+	st := webletStatus{backend: "native"}
+	if weblet.UseChrome {
+		st.backend = "chrome"
+	}
+
+	if pid := wm.runningPID(weblet); pid > 0 {
+		st.pid = pid
+		st.started = time.Unix(weblet.StartedAt, 0)
+		if weblet.Backend != "" {
+			st.backend = weblet.Backend
+		}
+	} else if pids := wm.webletPIDs(weblet); len(pids) > 0 {
+		// The oldest top-level process stands for the weblet
+		for pid := range pids {
+			if pids[parentPID(pid)] {
+				continue
+			}
+			if st.pid == 0 || pid < st.pid {
+				st.pid = pid
+			}
+		}
+		st.started, _ = processStartTime(st.pid)
+	}
+
+	if id, ok := wm.pidWindow(weblet.Name); ok {
+		st.window = id
+	} else if found, err := wlrFindWindow(matchWebletWindow(weblet.Name)); err == nil && found {
+		st.window = "wayland"
+	}
+	st.running = st.pid > 0 || st.window != ""
+	return st
+}
+
+// activityProbe finds out what running weblets are doing: in-call (camera,
+// microphone or screen captured), playing-audio, loading or idle. Native
+// windows tell it over their control socket; Chrome weblets are judged by
+// the audio streams of their processes, listed once with pactl, and are
+// never seen loading.
+type activityProbe struct {
+	listed    bool
+	playing   map[int][]string
+	recording map[int][]string
+}
+
+// activity returns what a running weblet is doing, or "" if that isn't
+// known
+func (p *activityProbe) activity(wm *WebletManager, weblet *Weblet, st webletStatus) string {
+	if !st.running {
+		return ""
+	}
+	if st.backend != "chrome" {
+		reply, err := view.Query(weblet.Name, "activity")
+		if err != nil {
+			return ""
+		}
+		return reply
+	}
+	if !p.listed {
+		p.listed = true
+		p.playing, _ = audioStreams()
+		p.recording, _ = recordingStreams()
+	}
+	if p.playing == nil {
+		return ""
+	}
+	pids := wm.webletPIDs(weblet)
+	switch {
+	case playsSound(pids, p.recording):
+		return "in-call"
+	case playsSound(pids, p.playing):
+		return "playing-audio"
+	}
+	return "idle"
+}
+
+// statusNames checks the weblets named in 'weblet status', or returns all
+func (wm *WebletManager) statusNames(names []string) ([]string, error) {
+	for _, name := range names {
+		if _, exists := wm.weblets[name]; !exists {
+			return nil, newError(ErrNotFound, "weblet '%s' not found", name)
+		}
+	}
+	if len(names) == 0 {
+		for name := range wm.weblets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	return names, nil
+}
+
+// Status prints the run state of the given weblets, or of all
+func (wm *WebletManager) Status(names []string) error {
+	names, err := wm.statusNames(names)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Println(T("No weblets available."))
+		return nil
+	}
+
+	width := len(T("NAME"))
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+	fmt.Printf("%-*s  %-9s  %-13s  %-7s  %-8s  %-10s  %s\n", width, T("NAME"), T("STATUS"), T("ACTIVITY"), T("BACKEND"), T("PID"), T("WINDOW"), T("UPTIME"))
+	var probe activityProbe
+	for _, name := range names {
+		weblet := wm.weblets[name]
+		st := wm.status(weblet)
+
+		status, activity, pid, window, uptime := T("stopped"), "-", "-", "-", "-"
+		if st.running {
+			status = T("running")
+			if wm.isPrewarmed(name) {
+				status = T("prewarmed")
+			}
+		}
+		if a := probe.activity(wm, weblet, st); a != "" {
+			activity = T(a)
+		}
+		if st.pid > 0 {
+			pid = strconv.Itoa(st.pid)
+		}
+		if st.window != "" {
+			window = st.window
+		}
+		if !st.started.IsZero() && st.started.Unix() > 0 {
+			uptime = time.Since(st.started).Round(time.Second).String()
+		}
+		fmt.Printf("%-*s  %-9s  %-13s  %-7s  %-8s  %-10s  %s\n", width, name, status, activity, st.backend, pid, window, uptime)
+	}
+	return nil
+}
+
+// webletStatusJSON is a weblet in the output of 'weblet status --json'
+type webletStatusJSON struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`             // running, prewarmed or stopped
+	Activity string `json:"activity,omitempty"` // in-call, playing-audio, loading or idle
+	Backend  string `json:"backend"`            // native or chrome, as launched
+	PID      int    `json:"pid,omitempty"`
+	Window   string `json:"window,omitempty"`  // X11 window ID or "wayland"
+	Started  int64  `json:"started,omitempty"` // Unix time of the launch
+}
+
+// StatusJSON prints the run state of the given weblets, or of all, as a
+// JSON array
+func (wm *WebletManager) StatusJSON(names []string) error {
+	names, err := wm.statusNames(names)
+	if err != nil {
+		return err
+	}
+	statuses := []webletStatusJSON{}
+	var probe activityProbe
+	for _, name := range names {
+		st := wm.status(wm.weblets[name])
+		status := webletStatusJSON{Name: name, Status: "stopped", Backend: st.backend, PID: st.pid, Window: st.window}
+		status.Activity = probe.activity(wm, wm.weblets[name], st)
+		if st.running {
+			status.Status = "running"
+			if wm.isPrewarmed(name) {
+				status.Status = "prewarmed"
+			}
+		}
+		if !st.started.IsZero() && st.started.Unix() > 0 {
+			status.Started = st.started.Unix()
+		}
+		statuses = append(statuses, status)
+	}
+
+	data, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 'weblet storage <name>' shows what a weblet keeps on disk for offline use:
+// the registered service workers and the size of each kind of site data.
+// Neither browser engine offers this without a running window, so it is
+// read from the profile directly; the lists are best effort.
+
+// Storage kinds, in display order
+var storageKinds = []string{"Service workers", "Cache storage", "IndexedDB", "Local storage", "HTTP cache"}
+
+// webkitStorageDirs maps WebKit data directories (lower case) to their kind;
+// they are found at the top level or per origin, depending on the version
+var webkitStorageDirs = map[string]string{
+	"serviceworkers": "Service workers",
+	"cachestorage":   "Cache storage",
+	"indexeddb":      "IndexedDB",
+	"localstorage":   "Local storage",
+	"webkitcache":    "HTTP cache",
+}
+
+// chromeStorageDirs maps directories of a Chrome profile to their kind
+var chromeStorageDirs = map[string]string{
+	"Service Worker/Database":     "Service workers",
+	"Service Worker/ScriptCache":  "Service workers",
+	"Service Worker/CacheStorage": "Cache storage",
+	"IndexedDB":                   "IndexedDB",
+	"Local Storage":               "Local storage",
+	"Cache":                       "HTTP cache",
+}
+
+// Chrome keeps registrations in LevelDB under "REG:<origin>\x00<id>" keys
+var chromeRegistration = regexp.MustCompile(`REG:(https?://[^\x00]+)\x00`)
+
+// Storage prints the service workers and site data sizes of a weblet
+func (wm *WebletManager) Storage(name string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+
+	var workers []string
+	sizes := make(map[string]int64)
+	if weblet.UseChrome {
+		profile := filepath.Join(wm.dataDir, "chrome-data", name, chromeProfileName(weblet.Container))
+		workers = chromeServiceWorkers(filepath.Join(profile, "Service Worker", "Database"))
+		for dir, kind := range chromeStorageDirs {
+			sizes[kind] += dirSize(filepath.Join(profile, dir))
+		}
+	} else {
+		workers, sizes = webkitStorage(wm.webkitSiteDir(name, weblet.Container))
+	}
+	if weblet.Cache == "memory-only" {
+		sizes["HTTP cache"] = 0
+	}
+
+	fmt.Print(T("Service workers of '%s':\n", name))
+	if len(workers) == 0 {
+		fmt.Println(T("  none"))
+	}
+	for _, worker := range workers {
+		fmt.Printf("  %s\n", worker)
+	}
+	if weblet.DisableServiceWorkers {
+		fmt.Println(T("  (service workers are turned off, registrations are dropped at launch)"))
+	}
+
+	fmt.Println(T("Storage:"))
+	var total int64
+	for _, kind := range storageKinds {
+		fmt.Printf("  %-18s %8s\n", T(kind), formatSize(sizes[kind]))
+		total += sizes[kind]
+	}
+	fmt.Printf("  %-18s %8s\n", T("Total"), formatSize(total))
+	return nil
+}
+
+// webkitStorage walks a WebKit data directory for service worker
+// registrations and the sizes of its storage directories
+func webkitStorage(dataDir string) ([]string, map[string]int64) {
+	var workers []string
+	sizes := make(map[string]int64)
+	filepath.WalkDir(dataDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !entry.IsDir() {
+			if strings.HasPrefix(entry.Name(), "ServiceWorkerRegistrations") && strings.HasSuffix(entry.Name(), ".sqlite3") {
+				workers = append(workers, webkitServiceWorkers(path)...)
+			}
+			return nil
+		}
+		if kind, ok := webkitStorageDirs[strings.ToLower(entry.Name())]; ok && path != dataDir {
+			sizes[kind] += dirSize(path)
+			// Registrations are inside the service worker directory
+			if kind != "Service workers" {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	sort.Strings(workers)
+	return workers, sizes
+}
+
+// webkitServiceWorkers reads the registrations from a WebKit service worker
+// database with the sqlite3 CLI, as "scope (script)"
+func webkitServiceWorkers(database string) []string {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil
+	}
+	output, err := exec.Command("sqlite3", "-readonly", "-separator", "\t", database,
+		"SELECT scopeURL, scriptURL FROM Records").Output()
+	if err != nil {
+		return nil
+	}
+	var workers []string
+	for _, line := range splitLines(string(output)) {
+		scope, script, _ := strings.Cut(line, "\t")
+		if scope == "" {
+			continue
+		}
+		if script != "" {
+			workers = append(workers, fmt.Sprintf("%s (%s)", scope, script))
+		} else {
+			workers = append(workers, scope)
+		}
+	}
+	return workers
+}
+
+// chromeServiceWorkers lists the origins with service worker registrations
+// found in Chrome's LevelDB files
+func chromeServiceWorkers(databaseDir string) []string {
+	entries, err := os.ReadDir(databaseDir)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); ext != ".ldb" && ext != ".log" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(databaseDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		for _, match := range chromeRegistration.FindAllSubmatch(data, -1) {
+			seen[string(match[1])] = true
+		}
+	}
+	workers := make([]string, 0, len(seen))
+	for origin := range seen {
+		workers = append(workers, origin)
+	}
+	sort.Strings(workers)
+	return workers
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// The stub backend ('weblet --backend stub ...' or WEBLET_BACKEND=stub)
+// runs weblets without a display, for integration tests of launching,
+// focusing, locking and stopping, and for CI pipelines that set weblets up
+// from dotfiles. Native windows are plain processes answering on the
+// control socket, Chrome is a fake that takes Chrome's arguments, and
+// wmctrl and xdotool are answered by a fake window manager whose windows
+// are files in $WEBLET_STUB_DIR (default $XDG_RUNTIME_DIR/weblet-stub):
+// windows/<id> holds "<pid>\t<class>\t<title>", active the focused window.
+
+// stubBackend is set for the stub backend, inherited by the processes
+// weblet starts through WEBLET_BACKEND
+var stubBackend = os.Getenv("WEBLET_BACKEND") == "stub"
+
+// stubChrome is the Chrome command of the stub backend
+const stubChrome = "weblet-stub-chrome"
+
+// stubTools are the tools the stub backend fakes
+var stubTools = map[string]bool{"wmctrl": true, "xdotool": true, stubChrome: true}
+
+// setBackend selects the backend of this process and the ones it starts
+func setBackend(backend string) error {
+	switch backend {
+	case "stub":
+		stubBackend = true
+		os.Setenv("WEBLET_BACKEND", "stub")
+	case "desktop":
+		stubBackend = false
+		os.Unsetenv("WEBLET_BACKEND")
+	default:
+		return newError(ErrInvalid, "unknown backend '%s' (expected desktop or stub)", backend)
+	}
+	return nil
+}
+
+// nativeAvailable reports whether native weblets can run: with the webview
+// built in, or as stub windows
+func nativeAvailable() bool {
+	return view.Available || stubBackend
+}
+
+// stubCommand returns the command running a fake tool, if the stub backend
+// fakes it
+func stubCommand(tool string, args ...string) (*exec.Cmd, bool) {
+	if !stubBackend || !stubTools[tool] {
+		return nil, false
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, false
+	}
+	return exec.Command(executable, append([]string{"__stub", tool}, args...)...), true
+}
+
+// runStub runs a fake tool ('weblet __stub <tool> <args>')
+func runStub(tool string, args []string) error {
+	switch tool {
+	case "wmctrl":
+		return stubWmctrl(args)
+	case "xdotool":
+		return stubXdotool(args)
+	case stubChrome:
+		return stubChromeMain(args)
+	}
+	return fmt.Errorf("no stub for %s", tool)
+}
+
+func stubDir() string {
+	if dir := os.Getenv("WEBLET_STUB_DIR"); dir != "" {
+		return dir
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "weblet-stub")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("weblet-stub-%d", os.Getuid()))
+}
+
+// stubWindow is a window of the fake window manager
+type stubWindow struct {
+	id    string
+	pid   int
+	class string // WM_CLASS as "instance.class"
+	title string
+}
+
+func newStubWindow(class, title string) *stubWindow {
+	return &stubWindow{id: fmt.Sprintf("0x%08x", os.Getpid()), pid: os.Getpid(), class: class, title: title}
+}
+
+func (w *stubWindow) path() string {
+	return filepath.Join(stubDir(), "windows", w.id)
+}
+
+// show maps the window, focused like a newly opened one
+func (w *stubWindow) show() error {
+	if err := os.MkdirAll(filepath.Dir(w.path()), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(w.path(), fmt.Appendf(nil, "%d\t%s\t%s\n", w.pid, w.class, w.title), 0600); err != nil {
+		return err
+	}
+	return w.activate()
+}
+
+func (w *stubWindow) activate() error {
+	return os.WriteFile(filepath.Join(stubDir(), "active"), []byte(w.id+"\n"), 0600)
+}
+
+// closed reports whether the window was closed through the window manager
+func (w *stubWindow) closed() bool {
+	_, err := os.Stat(w.path())
+	return err != nil
+}
+
+// stubWindows lists the windows of the fake window manager, forgetting
+// those whose process is gone
+func stubWindows() []*stubWindow {
+	dir := filepath.Join(stubDir(), "windows")
+	entries, _ := os.ReadDir(dir)
+	var windows []*stubWindow
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSuffix(string(data), "\n"), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || syscall.Kill(pid, 0) != nil {
+			os.Remove(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		windows = append(windows, &stubWindow{id: entry.Name(), pid: pid, class: fields[1], title: fields[2]})
+	}
+	return windows
+}
+
+func findStubWindow(id string) *stubWindow {
+	for _, window := range stubWindows() {
+		if window.id == id {
+			return window
+		}
+	}
+	return nil
+}
+
+// waitStubWindow blocks until the window is closed through the window
+// manager, the process is asked to quit or done fires
+func waitStubWindow(window *stubWindow, shown func() bool, done <-chan time.Time) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			os.Remove(window.path())
+			return
+		case <-done:
+			if !shown() {
+				return
+			}
+		case <-ticker.C:
+			if shown() && window.closed() {
+				return
+			}
+		}
+	}
+}
+
+// runStubWindow stands in for the native window of a weblet: it answers on
+// the control socket and is listed by the fake window manager while shown
+func runStubWindow(name, webletURL string, opts view.Options) error {
+	// Like the webview, a second window of the weblet focuses the first
+	if _, err := view.Query(name, "focus"); err == nil {
+		return nil
+	}
+	socketPath, err := view.ControlSocket(name)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(socketPath), 0755)
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	defer listener.Close()
+
+	window := newStubWindow("weblet-"+name+".weblet-"+name, name)
+	var lock sync.Mutex
+	currentURL := webletURL
+	shown := !opts.Prewarm
+	isShown := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return shown
+	}
+
+	ready := "prewarmed\n"
+	if shown {
+		if err := window.show(); err != nil {
+			return err
+		}
+		ready = "mapped\n"
+	}
+	if opts.ReadyFile != "" {
+		os.WriteFile(opts.ReadyFile, []byte(ready), 0644)
+	}
+	fmt.Printf("stub window of '%s' showing %s\n", name, webletURL)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 4096)
+			n, _ := conn.Read(buf)
+			command := string(buf[:n])
+
+			lock.Lock()
+			switch {
+			case command == "focus":
+				if !shown {
+					shown = true
+					window.show()
+				} else {
+					window.activate()
+				}
+			case command == "url":
+				fmt.Fprintln(conn, currentURL)
+			case strings.HasPrefix(command, "open "):
+				currentURL = strings.TrimPrefix(command, "open ")
+				fmt.Fprintln(conn, "ok")
+			case command == "copy-url", command == "mute", command == "unmute", command == "mini", command == "pip":
+				fmt.Fprintln(conn, "ok")
+			case command == "close":
+				shown = true
+				os.Remove(window.path())
+				fmt.Fprintln(conn, "ok")
+			case command == "unused":
+				fmt.Fprintln(conn, 0)
+			case command == "activity":
+				fmt.Fprintln(conn, "idle")
+			}
+			lock.Unlock()
+			conn.Close()
+		}
+	}()
+
+	// A warming window closes after the settle time unless it was shown
+	var done <-chan time.Time
+	if opts.Warm > 0 {
+		done = time.After(time.Duration(opts.Warm) * time.Second)
+	}
+	waitStubWindow(window, isShown, done)
+	return nil
+}
+
+// stubWmctrl answers 'wmctrl -l', '-lx', '-lp', '-i -a <id>' and
+// '-i -c <id>' from the fake window manager
+func stubWmctrl(args []string) error {
+	switch {
+	case len(args) == 1 && (args[0] == "-l" || args[0] == "-lx" || args[0] == "-lp"):
+		// WindowID Desktop [WM_CLASS|PID] Machine WindowTitle
+		for _, window := range stubWindows() {
+			column := ""
+			switch args[0] {
+			case "-lx":
+				column = window.class + " "
+			case "-lp":
+				column = strconv.Itoa(window.pid) + " "
+			}
+			fmt.Printf("%s  0 %sstub %s\n", window.id, column, window.title)
+		}
+		return nil
+	case len(args) == 3 && args[0] == "-i" && (args[1] == "-a" || args[1] == "-c"):
+		window := findStubWindow(args[2])
+		if window == nil {
+			return fmt.Errorf("window %s not found", args[2])
+		}
+		if args[1] == "-a" {
+			return window.activate()
+		}
+		return os.Remove(window.path())
+	}
+	return fmt.Errorf("stub wmctrl: unsupported arguments %q", args)
+}
+
+// stubXdotool answers 'xdotool search --onlyvisible --pid <pid>',
+// 'windowactivate <id>' and 'getactivewindow getwindowpid'
+func stubXdotool(args []string) error {
+	switch {
+	case len(args) == 4 && args[0] == "search" && args[1] == "--onlyvisible" && args[2] == "--pid":
+		found := false
+		for _, window := range stubWindows() {
+			if strconv.Itoa(window.pid) == args[3] {
+				fmt.Println(window.id)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no windows of process %s", args[3])
+		}
+		return nil
+	case len(args) == 2 && args[0] == "windowactivate":
+		window := findStubWindow(args[1])
+		if window == nil {
+			return fmt.Errorf("window %s not found", args[1])
+		}
+		return window.activate()
+	case len(args) == 2 && args[0] == "getactivewindow" && args[1] == "getwindowpid":
+		data, err := os.ReadFile(filepath.Join(stubDir(), "active"))
+		if err != nil {
+			return fmt.Errorf("no active window")
+		}
+		window := findStubWindow(strings.TrimSpace(string(data)))
+		if window == nil {
+			return fmt.Errorf("no active window")
+		}
+		fmt.Println(window.pid)
+		return nil
+	}
+	return fmt.Errorf("stub xdotool: unsupported arguments %q", args)
+}
+
+// stubChromeMain fakes Chrome: headless runs exit right away, an app window
+// is a stub window until closed, and a second start with the same profile
+// joins the running instance
+func stubChromeMain(args []string) error {
+	var userDataDir, class, app string
+	headless := false
+	for _, arg := range args {
+		switch {
+		case arg == "--version":
+			fmt.Println("Stub Chrome (weblet --backend stub)")
+			return nil
+		case strings.HasPrefix(arg, "--headless"):
+			headless = true
+		case strings.HasPrefix(arg, "--user-data-dir="):
+			userDataDir = strings.TrimPrefix(arg, "--user-data-dir=")
+		case strings.HasPrefix(arg, "--class="):
+			class = strings.TrimPrefix(arg, "--class=")
+		case strings.HasPrefix(arg, "--app="):
+			app = strings.TrimPrefix(arg, "--app=")
+		}
+	}
+	if headless {
+		if slices.Contains(args, "--dump-dom") {
+			fmt.Println("<html><head></head><body></body></html>")
+		}
+		return nil
+	}
+
+	// Not Chrome's SingletonLock, which a real Chrome would trip over
+	lockFile := filepath.Join(userDataDir, "StubLock")
+	if data, err := os.ReadFile(lockFile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && syscall.Kill(pid, 0) == nil {
+			for _, window := range stubWindows() {
+				if window.pid == pid {
+					return window.activate()
+				}
+			}
+			return nil
+		}
+	}
+	if userDataDir != "" {
+		os.MkdirAll(userDataDir, 0755)
+		os.WriteFile(lockFile, []byte(strconv.Itoa(os.Getpid())), 0644)
+		defer os.Remove(lockFile)
+	}
+
+	if class == "" {
+		class = "google-chrome"
+	}
+	title := "Stub Chrome"
+	if parsed, err := url.Parse(app); err == nil && parsed.Host != "" {
+		title = parsed.Host
+	}
+	window := newStubWindow(class+"."+class, title)
+	if err := window.show(); err != nil {
+		return err
+	}
+	fmt.Printf("stub Chrome showing %s\n", app)
+	waitStubWindow(window, func() bool { return true }, nil)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// System-wide weblets are provisioned by an admin with --system (via sudo or
+// pkexec): definitions live in /etc/weblet, desktop files and icons in
+// /usr/local/share. Every user sees them, with data still under their $HOME.
+const (
+	systemConfigDir = "/etc/weblet"
+	systemShareDir  = "/usr/local/share"
+)
+
+// webletsFile returns the file the manager's weblet definitions are saved to
+func (wm *WebletManager) webletsFile() string {
+	if wm.system {
+		return filepath.Join(systemConfigDir, "weblets.json")
+	}
+	return filepath.Join(wm.configDir, "weblets.json")
+}
+
+// shareDir returns the XDG data directory desktop files and icons go to
+func (wm *WebletManager) shareDir() (string, error) {
+	if wm.system {
+		return systemShareDir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".local", "share"), nil
+}
+
+// loadSystemWeblets adds the system-wide weblets a user hasn't overridden
+// with a weblet of the same name
+func (wm *WebletManager) loadSystemWeblets() error {
+	data, err := os.ReadFile(filepath.Join(systemConfigDir, "weblets.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var weblets []Weblet
+	if err := json.Unmarshal(data, &weblets); err != nil {
+		return err
+	}
+
+	for _, w := range weblets {
+		if _, exists := wm.weblets[w.Name]; exists {
+			continue
+		}
+		weblet := w
+		weblet.System = true
+		wm.weblets[w.Name] = &weblet
+	}
+
+	return nil
+}
+
+// checkEditable refuses changes to system-wide weblets outside --system
+// mode and asks for the passphrase of locked weblets
+func (wm *WebletManager) checkEditable(weblet *Weblet) error {
+	if weblet.System && !wm.system {
+		return newError(ErrLocked, "weblet '%s' is installed system-wide (change it with 'sudo weblet --system ...')", weblet.Name)
+	}
+	return wm.checkUnlocked(weblet)
+}
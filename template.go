@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Template is a reusable bundle of settings weblets can be created from
+// with 'weblet add --template'. Weblets remember their template, so later
+// edits can be pushed to them with 'weblet template sync'.
+type Template struct {
+	Name     string            `json:"name"`
+	Mode     string            `json:"mode,omitempty"`     // chrome (default) or native
+	Rules    []string          `json:"rules,omitempty"`    // Request rules, see 'weblet rules'
+	Settings map[string]string `json:"settings,omitempty"` // 'weblet set' keys and values
+}
+
+func (wm *WebletManager) templatesFile() string {
+	if wm.system {
+		return filepath.Join(systemConfigDir, "templates.json")
+	}
+	return filepath.Join(wm.configDir, "templates.json")
+}
+
+func (wm *WebletManager) loadTemplates() (map[string]*Template, error) {
+	templates := make(map[string]*Template)
+
+	data, err := os.ReadFile(wm.templatesFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return templates, nil
+		}
+		return nil, err
+	}
+
+	var list []*Template
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	for _, t := range list {
+		templates[t.Name] = t
+	}
+	return templates, nil
+}
+
+func (wm *WebletManager) saveTemplates(templates map[string]*Template) error {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*Template, 0, len(names))
+	for _, name := range names {
+		list = append(list, templates[name])
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(wm.templatesFile()), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(wm.templatesFile(), data, 0644)
+}
+
+// applyTemplate applies a template's mode, rules and settings to a weblet.
+// Rules the weblet already has are kept.
+func (wm *WebletManager) applyTemplate(weblet *Weblet, t *Template) error {
+	switch t.Mode {
+	case "":
+	case "chrome":
+		weblet.UseChrome = true
+	case "native":
+		weblet.UseChrome = false
+	}
+
+	for _, rule := range t.Rules {
+		present := false
+		for _, existing := range weblet.Rules {
+			if existing == rule {
+				present = true
+				break
+			}
+		}
+		if !present {
+			weblet.Rules = append(weblet.Rules, rule)
+		}
+	}
+
+	keys := make([]string, 0, len(t.Settings))
+	for key := range t.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, _, err := wm.applySetting(weblet, key, t.Settings[key]); err != nil {
+			return fmt.Errorf("template '%s', setting %s: %w", t.Name, key, err)
+		}
+	}
+
+	weblet.Template = t.Name
+	return nil
+}
+
+// Template manages templates: list, show, set, rules, remove and sync
+func (wm *WebletManager) Template(args []string) error {
+	templates, err := wm.loadTemplates()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 || args[0] == "list" {
+		if len(templates) == 0 {
+			fmt.Println(T("No templates defined."))
+			return nil
+		}
+		fmt.Println(T("Available templates:"))
+		names := make([]string, 0, len(templates))
+		for name := range templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Print(T("  %s (%d weblets)\n", name, len(wm.templateWeblets(name))))
+		}
+		return nil
+	}
+
+	if len(args) < 2 {
+		return newError(ErrInvalid, "missing template name")
+	}
+	command, name := args[0], args[1]
+	t, exists := templates[name]
+	if !exists && command != "set" && command != "rules" {
+		return newError(ErrNotFound, "template '%s' not found", name)
+	}
+	if !exists {
+		t = &Template{Name: name}
+	}
+
+	switch command {
+	case "show":
+		fmt.Print(T("Template '%s':\n", name))
+		if t.Mode != "" {
+			fmt.Print(T("  mode: %s\n", t.Mode))
+		}
+		keys := make([]string, 0, len(t.Settings))
+		for key := range t.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Print(T("  %s: %s\n", key, t.Settings[key]))
+		}
+		for i, rule := range t.Rules {
+			fmt.Print(T("  rule %d: %s\n", i+1, rule))
+		}
+		return nil
+
+	case "set":
+		if len(args) < 3 || len(args) > 4 {
+			return newError(ErrInvalid, "usage: weblet template set <template> <key> [value]")
+		}
+		key, value := args[2], ""
+		if len(args) == 4 {
+			value = args[3]
+		}
+		if key == "mode" {
+			if value != "" && value != "chrome" && value != "native" {
+				return newError(ErrInvalid, "invalid mode '%s' (expected chrome or native)", value)
+			}
+			t.Mode = value
+		} else {
+			// Validate against a scratch weblet, like 'weblet set' would
+			value, _, err = wm.applySetting(&Weblet{Name: name}, key, value)
+			if err != nil {
+				return err
+			}
+			if t.Settings == nil {
+				t.Settings = make(map[string]string)
+			}
+			if value == "" {
+				delete(t.Settings, key)
+			} else {
+				t.Settings[key] = value
+			}
+		}
+		if value == "" {
+			fmt.Print(T("Reset %s for template '%s'\n", key, name))
+		} else {
+			fmt.Print(T("Set %s for template '%s' to '%s'\n", key, name, value))
+		}
+
+	case "rules":
+		if len(args) < 3 {
+			return newError(ErrInvalid, "usage: weblet template rules <template> [add <rule>|remove <n>|clear]")
+		}
+		switch args[2] {
+		case "add":
+			rule := strings.Join(args[3:], " ")
+			if _, _, err := parseRule(rule); err != nil {
+				return fmt.Errorf("invalid rule '%s': %w", rule, err)
+			}
+			t.Rules = append(t.Rules, rule)
+			fmt.Print(T("Added rule to template '%s': %s\n", name, rule))
+		case "remove":
+			var index int
+			if len(args) != 4 {
+				return newError(ErrInvalid, "usage: weblet template rules <template> remove <number>")
+			}
+			if _, err := fmt.Sscanf(args[3], "%d", &index); err != nil || index < 1 || index > len(t.Rules) {
+				return newError(ErrInvalid, "invalid rule number: %s", args[3])
+			}
+			rule := t.Rules[index-1]
+			t.Rules = append(t.Rules[:index-1], t.Rules[index:]...)
+			fmt.Print(T("Removed rule from template '%s': %s\n", name, rule))
+		case "clear":
+			t.Rules = nil
+			fmt.Print(T("Cleared rules for template '%s'\n", name))
+		default:
+			return newError(ErrInvalid, "unknown rules command '%s' (expected add, remove or clear)", args[2])
+		}
+
+	case "remove":
+		delete(templates, name)
+		if err := wm.saveTemplates(templates); err != nil {
+			return err
+		}
+		// Weblets keep their settings, they just stop following the template
+		for _, weblet := range wm.templateWeblets(name) {
+			weblet.Template = ""
+		}
+		if err := wm.saveWeblets(); err != nil {
+			return err
+		}
+		fmt.Print(T("Removed template '%s'\n", name))
+		return nil
+
+	case "sync":
+		return wm.syncTemplate(t)
+
+	default:
+		return newError(ErrInvalid, "unknown template command '%s'", command)
+	}
+
+	templates[name] = t
+	if err := wm.saveTemplates(templates); err != nil {
+		return err
+	}
+	if n := len(wm.templateWeblets(name)); n > 0 {
+		fmt.Print(T("Run 'weblet template sync %s' to update the %d weblets using it\n", name, n))
+	}
+	return nil
+}
+
+// templateWeblets returns the weblets created from a template
+func (wm *WebletManager) templateWeblets(name string) []*Weblet {
+	var weblets []*Weblet
+	for _, weblet := range wm.weblets {
+		if weblet.Template == name {
+			weblets = append(weblets, weblet)
+		}
+	}
+	sort.Slice(weblets, func(i, j int) bool { return weblets[i].Name < weblets[j].Name })
+	return weblets
+}
+
+// syncTemplate re-applies a template to every weblet created from it.
+// Settings changed on a weblet itself are overwritten if the template sets
+// them too; settings removed from the template are left alone.
+func (wm *WebletManager) syncTemplate(t *Template) error {
+	weblets := wm.templateWeblets(t.Name)
+	if len(weblets) == 0 {
+		fmt.Print(T("No weblets use template '%s'\n", t.Name))
+		return nil
+	}
+
+	var recreate []*Weblet
+	before := make(map[string]Weblet)
+	for _, weblet := range weblets {
+		if err := wm.checkEditable(weblet); err != nil {
+			fmt.Fprint(os.Stderr, T("Skipping '%s': %v\n", weblet.Name, err))
+			continue
+		}
+		before[weblet.Name] = *weblet
+		if err := wm.applyTemplate(weblet, t); err != nil {
+			return err
+		}
+		if old := before[weblet.Name]; old.IconStyle != weblet.IconStyle || old.IconLight != weblet.IconLight || old.IconDark != weblet.IconDark {
+			recreate = append(recreate, weblet)
+		}
+		fmt.Print(T("Updated weblet '%s'\n", weblet.Name))
+	}
+
+	if err := wm.saveWeblets(); err != nil {
+		return err
+	}
+	for _, weblet := range weblets {
+		if old, synced := before[weblet.Name]; synced {
+			if err := wm.settingsSaved(&old, weblet); err != nil {
+				return err
+			}
+		}
+	}
+	for _, weblet := range recreate {
+		if err := wm.refreshWeblet(weblet); err != nil {
+			fmt.Fprint(os.Stderr, T("Warning: Failed to create desktop file for '%s': %v\n", weblet.Name, err))
+		}
+	}
+	if len(recreate) > 0 {
+		return wm.saveWeblets()
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// normalizeColor validates a #rgb / #rrggbb color and expands it to #rrggbb
+func normalizeColor(color string) (string, error) {
+	color = strings.TrimSpace(color)
+	if !hexColorPattern.MatchString(color) {
+		return "", fmt.Errorf("invalid color '%s' (expected #rrggbb)", color)
+	}
+	color = strings.ToLower(color)
+	if len(color) == 4 {
+		color = string([]byte{'#', color[1], color[1], color[2], color[2], color[3], color[3]})
+	}
+	return color, nil
+}
+
+// contrastColor picks black or white text for the given background color
+func contrastColor(color string) string {
+	var r, g, b int
+	fmt.Sscanf(color, "#%02x%02x%02x", &r, &g, &b)
+
+	// Perceived luminance (ITU-R BT.601)
+	luminance := (299*r + 587*g + 114*b) / 1000
+	if luminance > 150 {
+		return "#000000"
+	}
+	return "#ffffff"
+}
+
+// themeColor returns the color used to tint the weblet's window: the user's
+// choice if set, otherwise the one detected from the site
+func (w *Weblet) themeColor() string {
+	if w.ThemeColor != "" {
+		return w.ThemeColor
+	}
+	return w.DetectedThemeColor
+}
+
+// detectThemeColor reads the site's theme-color meta tag, falling back to
+// theme_color in its web app manifest
+func detectThemeColor(webletURL string) string {
+	client := newHTTPClient(newFetchGuard(webletURL).transport())
+
+	resp, err := client.Get(webletURL)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ""
+	}
+	html := string(body)
+
+	patterns := []string{
+		`<meta[^>]*name=["']theme-color["'][^>]*content=["']([^"']+)["'][^>]*>`,
+		`<meta[^>]*content=["']([^"']+)["'][^>]*name=["']theme-color["'][^>]*>`,
+	}
+	for _, pattern := range patterns {
+		if match := regexp.MustCompile(pattern).FindStringSubmatch(html); match != nil {
+			if color, err := normalizeColor(match[1]); err == nil {
+				return color
+			}
+		}
+	}
+
+	// Fall back to the manifest
+	manifestPatterns := []string{
+		`<link[^>]*rel=["']manifest["'][^>]*href=["']([^"']+)["'][^>]*>`,
+		`<link[^>]*href=["']([^"']+)["'][^>]*rel=["']manifest["'][^>]*>`,
+	}
+	for _, pattern := range manifestPatterns {
+		match := regexp.MustCompile(pattern).FindStringSubmatch(html)
+		if match == nil {
+			continue
+		}
+
+		base, err := url.Parse(webletURL)
+		if err != nil {
+			return ""
+		}
+		ref, err := url.Parse(match[1])
+		if err != nil {
+			return ""
+		}
+
+		mresp, err := client.Get(base.ResolveReference(ref).String())
+		if err != nil {
+			return ""
+		}
+		defer mresp.Body.Close()
+
+		var manifest struct {
+			ThemeColor string `json:"theme_color"`
+		}
+		if err := json.NewDecoder(mresp.Body).Decode(&manifest); err != nil {
+			return ""
+		}
+		if color, err := normalizeColor(manifest.ThemeColor); err == nil {
+			return color
+		}
+		return ""
+	}
+
+	return ""
+}
+
+// readWindowCSS returns the GTK CSS of the window-css setting, given inline
+// or as the path of a .css file (which is copied, like custom icons)
+func readWindowCSS(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(value, ".css") && !strings.Contains(value, "{") {
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return "", fmt.Errorf("failed to read CSS: %w", err)
+		}
+		value = strings.TrimSpace(string(data))
+	}
+	if value != "" && !strings.Contains(value, "{") {
+		return "", fmt.Errorf("invalid CSS '%s' (expected rules like 'headerbar { background: #4a154b; }' or a .css file)", value)
+	}
+	return value, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/michalCapo/weblet/view"
+)
+
+// Native windows save a small PNG snapshot of their page to
+// thumbnails/<name>.png, for switchers, docks and scripts to show live
+// previews like browser tab previews. With 'weblet config thumbnails on'
+// it is refreshed when the window loses focus and every thumbnailInterval;
+// otherwise only on request ('weblet thumbnail' or the "thumbnail" control
+// socket command). The last snapshot stays after the window is closed.
+
+// thumbnailInterval is how often open windows refresh their thumbnail, in
+// seconds
+const thumbnailInterval = 60
+
+func (wm *WebletManager) thumbnailFile(name string) string {
+	return filepath.Join(wm.cacheDir, "thumbnails", name+".png")
+}
+
+// Thumbnail prints the path of a weblet's thumbnail, refreshed first if its
+// window is open
+func (wm *WebletManager) Thumbnail(name string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if !weblet.UseChrome {
+		if path, err := view.Query(name, "thumbnail"); err == nil && path != "" {
+			fmt.Println(path)
+			return nil
+		}
+	}
+
+	path := wm.thumbnailFile(name)
+	if _, err := os.Stat(path); err != nil {
+		if weblet.UseChrome {
+			return newError(ErrInvalid, "weblet '%s' uses Chrome, thumbnails need native mode", name)
+		}
+		return newError(ErrNotFound, "no thumbnail of weblet '%s' yet, it is taken while the window is shown", name)
+	}
+	fmt.Println(path)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// torUserAgent matches Tor Browser so Tor weblets blend in with its users
+const torUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+// Tor SOCKS ports: system tor daemon first, then the one weblet starts itself
+var torSocksAddrs = []string{"127.0.0.1:9050", "127.0.0.1:9150"}
+
+// errTorNetns refuses Tor in a network namespace: the namespace can't reach
+// the SOCKS port of Tor on the host's loopback
+var errTorNetns = errors.New("tor and netns can't be combined (Tor listens on the host's loopback, which the namespace can't reach)")
+
+// findTorProxy returns the address of a running Tor SOCKS proxy
+func findTorProxy() (string, bool) {
+	for _, addr := range torSocksAddrs {
+		if isTorSocks(addr) {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// isTorSocks reports whether Tor listens on addr, and not some other
+// program: Tor's SOCKS port answers an HTTP request with a 501 that names it
+func isTorSocks(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, 500*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		return false
+	}
+	reply, _ := io.ReadAll(io.LimitReader(conn, 1024))
+	return strings.Contains(string(reply), "Tor is not an HTTP Proxy")
+}
+
+// ensureTor returns the address of a Tor SOCKS proxy, starting a private
+// tor process if none is running yet
+func (wm *WebletManager) ensureTor() (string, error) {
+	if addr, ok := findTorProxy(); ok {
+		return addr, nil
+	}
+
+	torPath, err := exec.LookPath("tor")
+	if err != nil {
+		return "", fmt.Errorf("Tor is not running and tor was not found. Install with: sudo apt install tor")
+	}
+
+	torDataDir := filepath.Join(wm.dataDir, "tor")
+	if err := os.MkdirAll(torDataDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create tor data directory: %w", err)
+	}
+
+	addr := torSocksAddrs[len(torSocksAddrs)-1]
+	cmd := exec.Command(torPath,
+		"--SocksPort", addr,
+		"--DataDirectory", torDataDir,
+		"--Log", "notice file "+filepath.Join(torDataDir, "tor.log"),
+	)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start tor: %w", err)
+	}
+	cmd.Process.Release()
+
+	fmt.Println(T("Starting Tor..."))
+	for i := 0; i < 60; i++ {
+		if isTorSocks(addr) {
+			return addr, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return "", fmt.Errorf("timeout waiting for Tor SOCKS proxy on %s", addr)
+}
+
+// torChromeArgs routes Chrome through Tor without DNS or WebRTC leaks
+func torChromeArgs(addr string) []string {
+	return []string{
+		"--proxy-server=socks5://" + addr,
+		"--host-resolver-rules=MAP * ~NOTFOUND , EXCLUDE 127.0.0.1",
+		"--force-webrtc-ip-handling-policy=disable_non_proxied_udp",
+		"--user-agent=" + torUserAgent,
+		"--disable-reading-from-canvas",
+	}
+}
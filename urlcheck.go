@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Schemes that run code or embed content instead of pointing at a site.
+// These are refused even with --allow-scheme.
+var dangerousSchemes = []string{"javascript", "vbscript", "data", "blob", "about"}
+
+// A leading "scheme:" that isn't a "host:port" prefix
+var schemePattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+var hostPortPattern = regexp.MustCompile(`^[^/:]+:\d+(/|$)`)
+
+// checkURL validates a weblet URL and returns it in canonical form. Only
+// http and https are accepted unless a scheme is explicitly allowed; URLs
+// without a scheme get https:// (or http:// for localhost).
+func checkURL(rawURL string, allowSchemes []string) (string, error) {
+	// URLs end up in webviews, command lines and desktop files
+	for _, r := range rawURL {
+		if r <= ' ' || r == 0x7f || r == '"' || r == '`' || r == '\\' {
+			return "", fmt.Errorf("URL '%s' contains whitespace, quotes or control characters", rawURL)
+		}
+	}
+
+	match := schemePattern.FindStringSubmatch(rawURL)
+	if match == nil || hostPortPattern.MatchString(rawURL) {
+		if isLoopbackURL("http://" + rawURL) {
+			rawURL = "http://" + rawURL
+		} else {
+			rawURL = "https://" + rawURL
+		}
+		match = schemePattern.FindStringSubmatch(rawURL)
+	}
+
+	scheme := strings.ToLower(match[1])
+	if slices.Contains(dangerousSchemes, scheme) {
+		return "", fmt.Errorf("refusing '%s:' URL", scheme)
+	}
+	if scheme != "http" && scheme != "https" && !slices.Contains(allowSchemes, scheme) {
+		return "", fmt.Errorf("scheme '%s' is not allowed (use --allow-scheme %s)", scheme, scheme)
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL '%s': %w", rawURL, err)
+	}
+	if (scheme == "http" || scheme == "https") && parsed.Hostname() == "" {
+		return "", fmt.Errorf("URL '%s' has no host", rawURL)
+	}
+	if parsed.User != nil {
+		return "", fmt.Errorf("URL '%s' contains credentials", rawURL)
+	}
+
+	return rawURL, nil
+}
+
+// allowedSchemes returns the schemes of the --allow-scheme options of a
+// command, e.g. file
+func allowedSchemes(inv *invocation) []string {
+	var schemes []string
+	for _, scheme := range inv.flags["allow-scheme"] {
+		schemes = append(schemes, strings.ToLower(strings.TrimSuffix(scheme, ":")))
+	}
+	return schemes
+}
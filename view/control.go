@@ -0,0 +1,78 @@
+package view
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/michalCapo/weblet/paths"
+)
+
+// A running native window listens on sockets/<name>.sock in the cache
+// directory (~/.cache/weblet, see package paths) for control commands
+// from other weblet processes: "focus" raises the window,
+// "url" replies with the URL of the page shown, "copy-url" puts it on the
+// clipboard, "open <url>" shows another page, "mute"/"unmute" silence
+// the page or turn its sound back on, "close" closes the window like its
+// close button and "unused" replies with the seconds
+// since the window was last used (0 while active or playing sound);
+// "thumbnail" saves a snapshot of the page and replies with its path,
+// "mini" and "pip" toggle mini mode and picture in picture, and
+// "activity" replies with what the page is doing (in-call, playing-audio,
+// loading or idle).
+
+// DataDir returns where weblet keeps the profiles of native windows:
+// ~/.local/share/weblet, or the directory given with --data-dir, which is
+// passed on in WEBLET_DATA_DIR
+func DataDir() (string, error) {
+	return paths.DataDir()
+}
+
+// ControlSocket returns the control socket of a weblet's window
+func ControlSocket(name string) (string, error) {
+	// A data directory on a removable drive (FAT) can't hold sockets, they
+	// go to the runtime directory, apart from those of other directories
+	if dir := os.Getenv("WEBLET_DATA_DIR"); dir != "" {
+		runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+		if runtimeDir == "" {
+			runtimeDir = os.TempDir()
+		}
+		sum := sha256.Sum256([]byte(dir))
+		return filepath.Join(runtimeDir, "weblet-"+hex.EncodeToString(sum[:4]), name+".sock"), nil
+	}
+	cacheDir, err := paths.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "sockets", name+".sock"), nil
+}
+
+// Query sends a command to the running native window of a weblet and
+// returns its reply
+func Query(name, command string) (string, error) {
+	path, err := ControlSocket(name)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return "", err
+	}
+	conn.(*net.UnixConn).CloseWrite()
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(reply)), nil
+}
@@ -0,0 +1,262 @@
+package view
+
+// Redirect rewrites the pages the main frame loads whose URL starts with
+// From so that the prefix is replaced by To
+type Redirect struct {
+	From string
+	To   string
+}
+
+// Link is a quick link listed in the header bar menu
+type Link struct {
+	Name string
+	URL  string
+}
+
+// UIStrings are the English texts of the native window (menu items and
+// built-in pages) that can be translated with Options.Translations
+var UIStrings = []string{
+	"Back",
+	"Forward",
+	"Reload",
+	"Open in Browser",
+	"History",
+	"Search history",
+	"Fill Credentials",
+	"No saved logins for %s.",
+	"Save the password of %s?",
+	"It is kept in the keyring for %s.",
+	"Not Now",
+	"Save",
+	"Waiting…",
+	"Waiting for %s…",
+	"Blocked",
+	"%s is not allowed in this weblet.",
+	"Allow %s to read the clipboard?",
+	"It can see what you copied, also in other apps.",
+	"Deny",
+	"Allow",
+	"%s read the clipboard",
+	"%s copied to the clipboard",
+	"Tools",
+	"Pick Color",
+	"Ruler",
+	"Viewport Size",
+	"Phone",
+	"Large Phone",
+	"Tablet",
+	"Laptop",
+	"Desktop",
+	"Click to copy a color, Esc cancels",
+	"Copied color %s",
+	"Viewport %d × %d",
+	"Mini Mode",
+	"Leave Mini Mode",
+	"Picture in Picture",
+	"Close Picture in Picture",
+	"No video is playing",
+	"This video can't be shown in picture in picture",
+}
+
+// Options holds per-weblet settings applied to the native webview
+type Options struct {
+	// ContentRules is a WebKit content blocker rule list (JSON) compiled
+	// into a content filter before the first page load
+	ContentRules string
+	Redirects    []Redirect
+
+	// AllowPatterns and DenyPatterns contain navigation: globs matched
+	// against the host (or the whole URL if they contain a '/'). Denied
+	// URLs show a block page; with allow patterns, anything outside them
+	// and the start host opens in the default browser.
+	AllowPatterns []string
+	DenyPatterns  []string
+
+	// LocalDir is served under the weblet-local:// scheme when set
+	LocalDir string
+
+	// RetryOnFailure shows a waiting page and keeps reloading when the
+	// page cannot be loaded (used for local dev servers that are booting)
+	RetryOnFailure bool
+
+	// ErrorPage is HTML shown instead of the default page when a load fails
+	ErrorPage string
+
+	// Proxy routes all traffic through the given proxy URI (e.g. socks://127.0.0.1:9050)
+	Proxy     string
+	UserAgent string
+	// Hardened disables WebGL, WebRTC, Web Audio and permission grants to
+	// reduce fingerprinting and leaks
+	Hardened    bool
+	TitleSuffix string
+
+	DisableJavaScript bool
+	DisableImages     bool
+	DisableWebGL      bool
+
+	// Links are shown in the header bar menu
+	Links []Link
+
+	// Privacy blocks third-party cookies, adds noise to canvas readback
+	// and strips referrers
+	Privacy bool
+
+	// DoNotTrack and GlobalPrivacyControl send the DNT and Sec-GPC
+	// signals
+	DoNotTrack           bool
+	GlobalPrivacyControl bool
+
+	// FixedLocation reports Latitude/Longitude to pages asking for the
+	// position instead of the real location
+	FixedLocation bool
+	Latitude      float64
+	Longitude     float64
+
+	// DisableServiceWorkers and DisableOfflineCache hide the APIs pages use
+	// to work offline and drop what they stored before
+	DisableServiceWorkers bool
+	DisableOfflineCache   bool
+
+	// Autofill offers to save submitted logins in the Secret Service and
+	// fills them in from the header bar or the context menu
+	Autofill bool
+
+	// IdleTimeout is the time in seconds without keyboard or mouse input
+	// after which pages see the window as hidden and unfocused, so chat
+	// apps show the user as away; 0 turns it off
+	IdleTimeout int
+
+	// KeepAlive is the time in seconds between requests for the page from
+	// inside it, which keep a login alive; with KeepAliveReload the page is
+	// reloaded instead while the window isn't used. 0 turns it off.
+	KeepAlive       int
+	KeepAliveReload bool
+
+	// PauseOnLock pauses the page's audio and video when the screen locks
+	PauseOnLock bool
+
+	// Watch is a directory whose changed files reload the page, for local
+	// dev servers
+	Watch string
+
+	// Width and Height are the size of a new window; the size it was left
+	// at (window-state.ini) wins. 0 uses 1200x800.
+	Width  int
+	Height int
+	// Maximized opens a new window maximized; whether it was left
+	// maximized (window-state.ini) wins
+	Maximized bool
+	// TemporarySize opens the window at Width x Height even if it was left
+	// at another size, and doesn't remember its size
+	TemporarySize bool
+
+	// Zoom is the page zoom level, 0 for 100%
+	Zoom float64
+
+	// Mini opens the window in mini mode: small, frameless and above other
+	// windows, MiniWidth x MiniHeight (0 for 480x270) at MiniOpacity (0 for
+	// opaque). Ctrl+Shift+M toggles it.
+	Mini        bool
+	MiniWidth   int
+	MiniHeight  int
+	MiniOpacity float64
+
+	// StartupScripts run at the start of every page of the top frame, in
+	// the page's world
+	StartupScripts []string
+
+	// LoadScript runs in the page each time the top frame finished loading
+	// a page, e.g. to dismiss a cookie banner
+	LoadScript string
+
+	// HistoryFile is the SQLite database visited pages of the weblet's
+	// site are recorded in; Ctrl+H searches it
+	HistoryFile string
+
+	// Bridge injects window.weblet (setBadge, notify, setTitle,
+	// requestAttention, closeWindow) into the page
+	Bridge bool
+
+	// HeaderBar replaces the server-side title bar with a GTK header bar
+	// showing the icon, title, unread count, load progress and a menu with
+	// the design tools
+	HeaderBar bool
+
+	// Clipboard is "allow" or "deny" for scripts reading the clipboard;
+	// empty asks once per site
+	Clipboard string
+
+	// ColorScheme is "dark" or "light"; empty follows the desktop's color
+	// scheme as it changes, for the window and the page's
+	// prefers-color-scheme
+	ColorScheme string
+
+	// Browser opens pages outside the window: a desktop file ID such as
+	// "firefox.desktop" or a command line the URL is appended to, e.g.
+	// "firefox -P work"; empty uses the default browser
+	Browser string
+
+	// WindowCSS is GTK CSS styling the window chrome, e.g.
+	// "headerbar { background: #4a154b; }"
+	WindowCSS string
+
+	// Undecorated leaves out the title bar and header bar
+	Undecorated bool
+
+	// Kiosk runs fullscreen without header bar or context menu
+	Kiosk bool
+
+	// Inspector is the port of the remote inspector server on 127.0.0.1,
+	// with its HTTP frontend on the next port ('weblet ctl inspect')
+	Inspector int
+
+	// StartHidden opens the window minimized (session restore)
+	StartHidden bool
+
+	// Prewarm loads the page without showing the window until the first
+	// focus request, so it opens instantly
+	Prewarm bool
+
+	// Warm closes a prewarmed window this many seconds after the page
+	// loaded, once its caches are filled ('weblet cache warm')
+	Warm int
+
+	// CacheDir holds the HTTP cache instead of the data directory, e.g. on
+	// a tmpfs for a memory-only cache
+	CacheDir string
+
+	// SiteDir holds the site data (cookies, storage and, without CacheDir,
+	// the cache) instead of the data directory, for a container with its
+	// own logins
+	SiteDir string
+
+	// ThumbnailFile is where a small PNG snapshot of the page is saved on
+	// request, and when the window loses focus and every
+	// ThumbnailInterval seconds if that is set
+	ThumbnailFile     string
+	ThumbnailInterval int
+
+	// AuditFile gets permission grants, clipboard access, downloads, pages
+	// opened outside the window and certificate errors, for 'weblet audit'
+	AuditFile string
+
+	// CrashFile gets a line for every crash of the web process, for
+	// 'weblet report'
+	CrashFile string
+
+	// ProfileFile gets the times of the startup steps of the window, for
+	// 'weblet run --profile-startup'
+	ProfileFile string
+
+	// ReadyFile is written once the window is mapped, to tell the launcher
+	// it can be focused
+	ReadyFile string
+
+	// Translations maps UIStrings to the user's language
+	Translations map[string]string
+
+	// ThemeColor (#rrggbb) tints the header bar and page background;
+	// ThemeTextColor is the contrasting text color
+	ThemeColor     string
+	ThemeTextColor string
+}
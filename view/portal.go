@@ -0,0 +1,201 @@
+//go:build !no_native
+
+package view
+
+/*
+#cgo linux pkg-config: gio-2.0
+#include <gio/gio.h>
+#include <stdlib.h>
+
+// The DynamicLauncher portal installs desktop entries with their icon for
+// sandboxed apps, after the user confirmed them in a dialog (PrepareInstall
+// answers through a Request object). It runs on its own main context so it
+// works without a GTK main loop.
+
+typedef struct {
+    GMainLoop *loop;
+    guint32 response;
+    gchar *token;
+} PrepareResult;
+
+static void on_prepare_response(GDBusConnection *bus, const gchar *sender, const gchar *path,
+                                const gchar *iface, const gchar *signal, GVariant *params, gpointer data) {
+    PrepareResult *result = (PrepareResult *)data;
+    GVariant *results = NULL;
+    g_variant_get(params, "(u@a{sv})", &result->response, &results);
+    g_variant_lookup(results, "token", "s", &result->token);
+    g_variant_unref(results);
+    g_main_loop_quit(result->loop);
+}
+
+static gboolean on_prepare_timeout(gpointer data) {
+    PrepareResult *result = (PrepareResult *)data;
+    result->response = 2;
+    g_main_loop_quit(result->loop);
+    return G_SOURCE_REMOVE;
+}
+
+static char *error_message(GError *error) {
+    char *message = g_strdup(error->message);
+    g_error_free(error);
+    return message;
+}
+
+static GDBusConnection *launcher_portal(char **message) {
+    GError *error = NULL;
+    GDBusConnection *bus = g_bus_get_sync(G_BUS_TYPE_SESSION, NULL, &error);
+    if (bus == NULL) {
+        *message = error_message(error);
+        return NULL;
+    }
+    GVariant *version = g_dbus_connection_call_sync(bus, "org.freedesktop.portal.Desktop", "/org/freedesktop/portal/desktop",
+                                                    "org.freedesktop.DBus.Properties", "Get",
+                                                    g_variant_new("(ss)", "org.freedesktop.portal.DynamicLauncher", "version"),
+                                                    NULL, G_DBUS_CALL_FLAGS_NONE, 5000, NULL, &error);
+    if (version == NULL) {
+        g_error_free(error);
+        *message = g_strdup("the DynamicLauncher portal isn't available");
+        g_object_unref(bus);
+        return NULL;
+    }
+    g_variant_unref(version);
+    return bus;
+}
+
+// launcher_install returns NULL or an error message (free with g_free)
+static char *launcher_install(const char *name, const void *icon, size_t icon_size,
+                              const char *desktop_id, const char *entry) {
+    char *message = NULL;
+    GDBusConnection *bus = launcher_portal(&message);
+    if (bus == NULL) {
+        return message;
+    }
+
+    GMainContext *context = g_main_context_new();
+    g_main_context_push_thread_default(context);
+    PrepareResult result = {g_main_loop_new(context, FALSE), 2, NULL};
+
+    // The Request object path is derived from our bus name and the token
+    gchar *token = g_strdup_printf("weblet%u", g_random_int());
+    gchar *sender = g_strdup(g_dbus_connection_get_unique_name(bus) + 1);
+    g_strdelimit(sender, ".", '_');
+    gchar *request_path = g_strdup_printf("/org/freedesktop/portal/desktop/request/%s/%s", sender, token);
+    guint subscription = g_dbus_connection_signal_subscribe(bus, "org.freedesktop.portal.Desktop",
+                                                            "org.freedesktop.portal.Request", "Response",
+                                                            request_path, NULL, G_DBUS_SIGNAL_FLAGS_NONE,
+                                                            on_prepare_response, &result, NULL);
+
+    GBytes *bytes = g_bytes_new(icon, icon_size);
+    GIcon *bytes_icon = g_bytes_icon_new(bytes);
+    GVariant *icon_variant = g_icon_serialize(bytes_icon);
+    GVariantBuilder options;
+    g_variant_builder_init(&options, G_VARIANT_TYPE_VARDICT);
+    g_variant_builder_add(&options, "{sv}", "handle_token", g_variant_new_string(token));
+    g_variant_builder_add(&options, "{sv}", "editable_name", g_variant_new_boolean(FALSE));
+    g_variant_builder_add(&options, "{sv}", "editable_icon", g_variant_new_boolean(FALSE));
+
+    GError *error = NULL;
+    GVariant *reply = g_dbus_connection_call_sync(bus, "org.freedesktop.portal.Desktop", "/org/freedesktop/portal/desktop",
+                                                  "org.freedesktop.portal.DynamicLauncher", "PrepareInstall",
+                                                  g_variant_new("(ssva{sv})", "", name, icon_variant, &options),
+                                                  G_VARIANT_TYPE("(o)"), G_DBUS_CALL_FLAGS_NONE, -1, NULL, &error);
+    if (reply == NULL) {
+        message = error_message(error);
+    } else {
+        g_variant_unref(reply);
+        // The user confirms in a dialog
+        GSource *timeout = g_timeout_source_new_seconds(600);
+        g_source_set_callback(timeout, on_prepare_timeout, &result, NULL);
+        g_source_attach(timeout, context);
+        g_main_loop_run(result.loop);
+        g_source_destroy(timeout);
+        g_source_unref(timeout);
+
+        if (result.response != 0 || result.token == NULL) {
+            message = g_strdup("the installation was cancelled");
+        } else {
+            reply = g_dbus_connection_call_sync(bus, "org.freedesktop.portal.Desktop", "/org/freedesktop/portal/desktop",
+                                                "org.freedesktop.portal.DynamicLauncher", "Install",
+                                                g_variant_new("(sssa{sv})", result.token, desktop_id, entry, NULL),
+                                                NULL, G_DBUS_CALL_FLAGS_NONE, -1, NULL, &error);
+            if (reply == NULL) {
+                message = error_message(error);
+            } else {
+                g_variant_unref(reply);
+            }
+        }
+    }
+
+    g_dbus_connection_signal_unsubscribe(bus, subscription);
+    g_variant_unref(icon_variant);
+    g_object_unref(bytes_icon);
+    g_bytes_unref(bytes);
+    g_free(result.token);
+    g_main_loop_unref(result.loop);
+    g_main_context_pop_thread_default(context);
+    g_main_context_unref(context);
+    g_free(request_path);
+    g_free(sender);
+    g_free(token);
+    g_object_unref(bus);
+    return message;
+}
+
+static char *launcher_uninstall(const char *desktop_id) {
+    char *message = NULL;
+    GDBusConnection *bus = launcher_portal(&message);
+    if (bus == NULL) {
+        return message;
+    }
+    GError *error = NULL;
+    GVariant *reply = g_dbus_connection_call_sync(bus, "org.freedesktop.portal.Desktop", "/org/freedesktop/portal/desktop",
+                                                  "org.freedesktop.portal.DynamicLauncher", "Uninstall",
+                                                  g_variant_new("(sa{sv})", desktop_id, NULL),
+                                                  NULL, G_DBUS_CALL_FLAGS_NONE, -1, NULL, &error);
+    if (reply == NULL) {
+        message = error_message(error);
+    } else {
+        g_variant_unref(reply);
+    }
+    g_object_unref(bus);
+    return message;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// InstallLauncher installs a desktop entry and its icon (PNG, JPEG or SVG
+// data) with the DynamicLauncher portal, which asks the user to confirm.
+// desktopID must start with the app ID of the sandbox.
+func InstallLauncher(name string, icon []byte, desktopID, entry string) error {
+	cName := C.CString(name)
+	cDesktopID := C.CString(desktopID)
+	cEntry := C.CString(entry)
+	cIcon := C.CBytes(icon)
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cDesktopID))
+	defer C.free(unsafe.Pointer(cEntry))
+	defer C.free(cIcon)
+
+	if message := C.launcher_install(cName, cIcon, C.size_t(len(icon)), cDesktopID, cEntry); message != nil {
+		defer C.g_free(C.gpointer(message))
+		return errors.New(C.GoString(message))
+	}
+	return nil
+}
+
+// UninstallLauncher removes a desktop entry installed with InstallLauncher
+func UninstallLauncher(desktopID string) error {
+	cDesktopID := C.CString(desktopID)
+	defer C.free(unsafe.Pointer(cDesktopID))
+
+	if message := C.launcher_uninstall(cDesktopID); message != nil {
+		defer C.g_free(C.gpointer(message))
+		return errors.New(C.GoString(message))
+	}
+	return nil
+}
@@ -3,77 +3,2982 @@
 package view
 
 /*
-#cgo linux pkg-config: gtk+-3.0 webkit2gtk-4.1 gdk-3.0 gdk-x11-3.0 x11
+#cgo linux pkg-config: gtk+-3.0 webkit2gtk-4.1 gdk-3.0 gdk-x11-3.0 x11 gio-unix-2.0 sqlite3 libsecret-1
 #include <gtk/gtk.h>
 #include <gdk/gdk.h>
 #include <gdk/gdkx.h>
 #include <webkit2/webkit2.h>
+#include <gio/gunixfdlist.h>
+#include <gio/gdesktopappinfo.h>
+#include <sqlite3.h>
+#include <libsecret/secret.h>
+#include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <unistd.h>
 
 static GtkWidget *main_window = NULL;
 static WebKitWebView *main_webview = NULL;
+static WebKitWebContext *web_context = NULL;
+static WebKitWebsiteDataManager *website_data = NULL;
 static int app_running = 0;
+static int close_requested = 0;
+
+static void save_window_state(void);
+static void session_quit_done(void);
+static void on_clipboard_request(WebKitPermissionRequest *request);
+static int hardened = 0;
+
+// Translations of UI strings (English -> localized), set before weblet_init
+static GHashTable *translations = NULL;
+
+void weblet_add_translation(const char *text, const char *translated) {
+    if (translations == NULL) {
+        translations = g_hash_table_new_full(g_str_hash, g_str_equal, g_free, g_free);
+    }
+    g_hash_table_insert(translations, g_strdup(text), g_strdup(translated));
+}
+
+static const char *tr(const char *text) {
+    const char *translated = translations != NULL ? g_hash_table_lookup(translations, text) : NULL;
+    return translated != NULL ? translated : text;
+}
+
+// Audit log: permission grants, clipboard access, downloads, pages opened
+// outside the window and certificate errors are appended to the audit file
+// as "<time>\t<event>\t<detail>\t<page>" lines for 'weblet audit'
+static char *audit_file = NULL;
+
+void weblet_set_audit_file(const char *path) {
+    g_free(audit_file);
+    audit_file = g_strdup(path);
+}
+
+static void audit(const char *event, const char *detail) {
+    if (audit_file == NULL) {
+        return;
+    }
+    FILE *file = fopen(audit_file, "a");
+    if (file == NULL) {
+        return;
+    }
+    GDateTime *now = g_date_time_new_now_local();
+    gchar *time = g_date_time_format(now, "%Y-%m-%dT%H:%M:%S%:z");
+    const char *page = main_webview != NULL ? webkit_web_view_get_uri(main_webview) : NULL;
+    gchar *clean_detail = g_strdelimit(g_strdup(detail != NULL ? detail : ""), "\t\n", ' ');
+    fprintf(file, "%s\t%s\t%s\t%s\n", time, event, clean_detail, page != NULL ? page : "");
+    fclose(file);
+    g_free(clean_detail);
+    g_free(time);
+    g_date_time_unref(now);
+}
+
+// Pages opened outside the window go to the browser setting, a desktop file
+// ID (firefox.desktop) or a command line the URL is appended to, otherwise
+// to the default browser
+static char *external_browser = NULL;
+
+void weblet_set_browser(const char *browser) {
+    g_free(external_browser);
+    external_browser = g_strdup(browser);
+}
+
+static void open_external(const char *uri) {
+    audit("external", uri);
+    GError *error = NULL;
+    if (external_browser != NULL && g_str_has_suffix(external_browser, ".desktop")) {
+        GDesktopAppInfo *info = g_desktop_app_info_new(external_browser);
+        if (info != NULL) {
+            GList uris = {(gpointer)uri, NULL, NULL};
+            g_app_info_launch_uris(G_APP_INFO(info), &uris, NULL, &error);
+            g_object_unref(info);
+        } else {
+            g_printerr("Browser %s not found, using the default browser\n", external_browser);
+            g_app_info_launch_default_for_uri(uri, NULL, &error);
+        }
+    } else if (external_browser != NULL) {
+        gint argc = 0;
+        gchar **argv = NULL;
+        if (g_shell_parse_argv(external_browser, &argc, &argv, &error)) {
+            gchar **command = g_new0(gchar *, argc + 2);
+            memcpy(command, argv, argc * sizeof(gchar *));
+            command[argc] = (gchar *)uri;
+            g_spawn_async(NULL, command, NULL, G_SPAWN_SEARCH_PATH, NULL, NULL, NULL, &error);
+            g_free(command);
+            g_strfreev(argv);
+        }
+    } else {
+        g_app_info_launch_default_for_uri(uri, NULL, &error);
+    }
+    if (error != NULL) {
+        g_printerr("Could not open %s: %s\n", uri, error->message);
+        g_error_free(error);
+    }
+}
+
+// Quit once WebKit had a moment to write pending website data
+static gboolean on_flush_done(gpointer data) {
+    session_quit_done();
+    gtk_main_quit();
+    return G_SOURCE_REMOVE;
+}
 
 static void on_destroy(GtkWidget *widget, gpointer data) {
+    save_window_state();
     app_running = 0;
-    gtk_main_quit();
+    main_webview = NULL;
+
+    // Releasing the context and data manager closes the network session,
+    // which writes cookies, local storage and IndexedDB to disk
+    if (web_context != NULL) {
+        g_object_unref(web_context);
+        web_context = NULL;
+    }
+    if (website_data != NULL) {
+        g_object_unref(website_data);
+        website_data = NULL;
+    }
+    g_timeout_add(500, on_flush_done, NULL);
+}
+
+// Closing the window runs the page's unload handlers first; WebKit emits
+// "close" on the view when they are done. A second close request (e.g. the
+// page hangs) closes the window right away.
+static gboolean on_delete(GtkWidget *widget, GdkEvent *event, gpointer data) {
+    if (main_webview == NULL || close_requested) {
+        return FALSE;
+    }
+    close_requested = 1;
+    webkit_web_view_try_close(main_webview);
+    return TRUE;
+}
+
+static void on_webview_close(WebKitWebView *web_view, gpointer data) {
+    gtk_widget_destroy(main_window);
+}
+
+// Crashes of the web process are appended to the crash file as
+// "<time>\t<reason>\t<page>" lines for 'weblet report'. The page is
+// reloaded, unless it crashed less than a minute before.
+static char *crash_file = NULL;
+static gint64 last_crash = 0;
+
+void weblet_set_crash_file(const char *path) {
+    g_free(crash_file);
+    crash_file = g_strdup(path);
+}
+
+static void on_web_process_terminated(WebKitWebView *web_view, WebKitWebProcessTerminationReason reason, gpointer data) {
+    const char *name = "crashed";
+    if (reason == WEBKIT_WEB_PROCESS_EXCEEDED_MEMORY_LIMIT) {
+        name = "exceeded-memory-limit";
+    } else if (reason == WEBKIT_WEB_PROCESS_TERMINATED_BY_API) {
+        return;
+    }
+    const char *uri = webkit_web_view_get_uri(web_view);
+    g_printerr("Web process %s on %s\n", name, uri != NULL ? uri : "");
+
+    if (crash_file != NULL) {
+        FILE *file = fopen(crash_file, "a");
+        if (file != NULL) {
+            GDateTime *now = g_date_time_new_now_local();
+            gchar *time = g_date_time_format(now, "%Y-%m-%dT%H:%M:%S%:z");
+            fprintf(file, "%s\t%s\t%s\n", time, name, uri != NULL ? uri : "");
+            fclose(file);
+            g_free(time);
+            g_date_time_unref(now);
+        }
+    }
+
+    gint64 now = g_get_monotonic_time();
+    if (last_crash == 0 || now - last_crash > 60 * G_USEC_PER_SEC) {
+        webkit_web_view_reload(web_view);
+    }
+    last_crash = now;
+}
+
+// Set WM_CLASS after window is realized
+static void on_realize(GtkWidget *widget, gpointer data) {
+    const char *wm_class = (const char *)data;
+    GdkWindow *gdk_window = gtk_widget_get_window(widget);
+    if (gdk_window != NULL && GDK_IS_X11_WINDOW(gdk_window)) {
+        gdk_x11_window_set_utf8_property(gdk_window, "_GTK_APPLICATION_ID", wm_class);
+        // Set WM_CLASS using Xlib
+        Display *display = GDK_DISPLAY_XDISPLAY(gdk_display_get_default());
+        Window xwindow = GDK_WINDOW_XID(gdk_window);
+        XClassHint *class_hint = XAllocClassHint();
+        if (class_hint) {
+            class_hint->res_name = (char *)wm_class;
+            class_hint->res_class = (char *)wm_class;
+            XSetClassHint(display, xwindow, class_hint);
+            XFree(class_hint);
+        }
+    }
+}
+
+// Handle permission requests (microphone, camera, notifications, etc.)
+static gboolean on_permission_request(WebKitWebView *web_view,
+                                       WebKitPermissionRequest *request,
+                                       gpointer user_data) {
+    // Hardened weblets never expose devices or location
+    if (hardened) {
+        g_print("Denying permission request (hardened mode)\n");
+        audit("permission-denied", G_OBJECT_TYPE_NAME(request));
+        webkit_permission_request_deny(request);
+        return TRUE;
+    }
+
+    // Auto-grant media (microphone/camera) permissions
+    if (WEBKIT_IS_USER_MEDIA_PERMISSION_REQUEST(request)) {
+        g_print("Granting microphone/camera permission\n");
+        WebKitUserMediaPermissionRequest *media = WEBKIT_USER_MEDIA_PERMISSION_REQUEST(request);
+        if (webkit_user_media_permission_is_for_display_device(media)) {
+            audit("permission", "screen");
+        } else if (webkit_user_media_permission_is_for_audio_device(media) &&
+                   webkit_user_media_permission_is_for_video_device(media)) {
+            audit("permission", "microphone, camera");
+        } else {
+            audit("permission", webkit_user_media_permission_is_for_video_device(media) ? "camera" : "microphone");
+        }
+        webkit_permission_request_allow(request);
+        return TRUE;
+    }
+
+    // Auto-grant notification permissions
+    if (WEBKIT_IS_NOTIFICATION_PERMISSION_REQUEST(request)) {
+        g_print("Granting notification permission\n");
+        audit("permission", "notifications");
+        webkit_permission_request_allow(request);
+        return TRUE;
+    }
+
+    // Auto-grant geolocation permissions
+    if (WEBKIT_IS_GEOLOCATION_PERMISSION_REQUEST(request)) {
+        g_print("Granting geolocation permission\n");
+        audit("permission", "location");
+        webkit_permission_request_allow(request);
+        return TRUE;
+    }
+
+    // Auto-grant device info permissions (enumerate devices)
+    if (WEBKIT_IS_DEVICE_INFO_PERMISSION_REQUEST(request)) {
+        g_print("Granting device info permission\n");
+        audit("permission", "device list");
+        webkit_permission_request_allow(request);
+        return TRUE;
+    }
+
+    // Reading the clipboard follows the weblet's clipboard setting
+    if (WEBKIT_IS_CLIPBOARD_PERMISSION_REQUEST(request)) {
+        on_clipboard_request(request);
+        return TRUE;
+    }
+
+    // For other permissions, allow by default
+    audit("permission", G_OBJECT_TYPE_NAME(request));
+    webkit_permission_request_allow(request);
+    return TRUE;
+}
+
+// URL of the page shown, readable from the control socket's thread
+static GMutex current_uri_lock;
+static char *current_uri = NULL;
+
+static void on_uri_changed(WebKitWebView *web_view, GParamSpec *pspec, gpointer data) {
+    g_mutex_lock(&current_uri_lock);
+    g_free(current_uri);
+    current_uri = g_strdup(webkit_web_view_get_uri(web_view));
+    g_mutex_unlock(&current_uri_lock);
+}
+
+// weblet_current_uri returns a copy of the page URL (free with g_free)
+char *weblet_current_uri() {
+    g_mutex_lock(&current_uri_lock);
+    char *uri = g_strdup(current_uri);
+    g_mutex_unlock(&current_uri_lock);
+    return uri;
+}
+
+static gboolean copy_uri_idle(gpointer data) {
+    const char *uri = main_webview != NULL ? webkit_web_view_get_uri(main_webview) : NULL;
+    if (uri != NULL) {
+        GtkClipboard *clipboard = gtk_clipboard_get(GDK_SELECTION_CLIPBOARD);
+        gtk_clipboard_set_text(clipboard, uri, -1);
+        gtk_clipboard_store(clipboard);
+    }
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_copy_uri puts the page URL on the clipboard; thread-safe
+void weblet_copy_uri() {
+    g_idle_add(copy_uri_idle, NULL);
+}
+
+// Thumbnails: small snapshots of the page for switchers and docks, saved as
+// PNG when the window loses focus and every thumbnail_interval seconds
+// while it is shown (if set), and on request from the control socket
+#define THUMBNAIL_WIDTH 320
+static char *thumbnail_file = NULL;
+static int thumbnail_interval = 0;
+
+void weblet_set_thumbnails(const char *path, int interval) {
+    g_free(thumbnail_file);
+    thumbnail_file = g_strdup(path);
+    thumbnail_interval = interval;
+}
+
+static void on_thumbnail_snapshot(GObject *source, GAsyncResult *result, gpointer data) {
+    cairo_surface_t *snapshot = webkit_web_view_get_snapshot_finish(WEBKIT_WEB_VIEW(source), result, NULL);
+    if (snapshot == NULL) {
+        return;
+    }
+    int width = cairo_image_surface_get_width(snapshot);
+    int height = cairo_image_surface_get_height(snapshot);
+    if (width > 0 && height > 0) {
+        double scale = width > THUMBNAIL_WIDTH ? (double)THUMBNAIL_WIDTH / width : 1.0;
+        cairo_surface_t *thumbnail = cairo_image_surface_create(CAIRO_FORMAT_ARGB32, MAX(1, (int)(width * scale)),
+                                                                MAX(1, (int)(height * scale)));
+        cairo_t *cr = cairo_create(thumbnail);
+        cairo_scale(cr, scale, scale);
+        cairo_set_source_surface(cr, snapshot, 0, 0);
+        cairo_pattern_set_filter(cairo_get_source(cr), CAIRO_FILTER_GOOD);
+        cairo_paint(cr);
+        cairo_destroy(cr);
+
+        // Readers never see a half-written file
+        gchar *temp = g_strconcat(thumbnail_file, ".tmp", NULL);
+        if (cairo_surface_write_to_png(thumbnail, temp) == CAIRO_STATUS_SUCCESS) {
+            rename(temp, thumbnail_file);
+        }
+        g_free(temp);
+        cairo_surface_destroy(thumbnail);
+    }
+    cairo_surface_destroy(snapshot);
+}
+
+static void take_thumbnail(void) {
+    if (thumbnail_file == NULL || main_webview == NULL || !gtk_widget_get_mapped(GTK_WIDGET(main_webview))) {
+        return;
+    }
+    webkit_web_view_get_snapshot(main_webview, WEBKIT_SNAPSHOT_REGION_VISIBLE, WEBKIT_SNAPSHOT_OPTIONS_NONE,
+                                 NULL, on_thumbnail_snapshot, NULL);
+}
+
+static gboolean thumbnail_idle(gpointer data) {
+    take_thumbnail();
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_request_thumbnail saves a thumbnail now; thread-safe
+void weblet_request_thumbnail() {
+    g_idle_add(thumbnail_idle, NULL);
+}
+
+static gboolean on_thumbnail_timer(gpointer data) {
+    take_thumbnail();
+    return app_running ? G_SOURCE_CONTINUE : G_SOURCE_REMOVE;
+}
+
+// Window activity for 'weblet memory-watch', which closes the weblet that
+// was used least recently when memory runs low; read from the control
+// socket thread
+static gint window_active = 0;
+static gint last_active = 0;
+static gint playing_audio = 0;
+
+static gint monotonic_seconds(void) {
+    return (gint)(g_get_monotonic_time() / G_USEC_PER_SEC);
+}
+
+static void on_active_changed(GObject *window, GParamSpec *pspec, gpointer data) {
+    int active = gtk_window_is_active(GTK_WINDOW(window));
+    g_atomic_int_set(&window_active, active);
+    g_atomic_int_set(&last_active, monotonic_seconds());
+    // What a switcher shows is the window as it was left
+    if (!active && thumbnail_interval > 0) {
+        take_thumbnail();
+    }
+}
+
+static void on_playing_audio_changed(WebKitWebView *web_view, GParamSpec *pspec, gpointer data) {
+    g_atomic_int_set(&playing_audio, webkit_web_view_is_playing_audio(web_view));
+}
+
+// Activity of the page for 'weblet status'
+static gint page_loading = 0;
+static gint in_call = 0;
+
+static void on_loading_changed(WebKitWebView *web_view, GParamSpec *pspec, gpointer data) {
+    g_atomic_int_set(&page_loading, webkit_web_view_is_loading(web_view));
+}
+
+// A page capturing the camera, microphone or screen is in a call
+static void on_capture_state_changed(WebKitWebView *web_view, GParamSpec *pspec, gpointer data) {
+    g_atomic_int_set(&in_call,
+                     webkit_web_view_get_camera_capture_state(web_view) == WEBKIT_MEDIA_CAPTURE_STATE_ACTIVE ||
+                     webkit_web_view_get_microphone_capture_state(web_view) == WEBKIT_MEDIA_CAPTURE_STATE_ACTIVE ||
+                     webkit_web_view_get_display_capture_state(web_view) == WEBKIT_MEDIA_CAPTURE_STATE_ACTIVE);
+}
+
+// weblet_activity returns what the page is doing: in-call, playing-audio,
+// loading or idle; thread-safe
+const char *weblet_activity() {
+    if (g_atomic_int_get(&in_call)) {
+        return "in-call";
+    }
+    if (g_atomic_int_get(&playing_audio)) {
+        return "playing-audio";
+    }
+    if (g_atomic_int_get(&page_loading)) {
+        return "loading";
+    }
+    return "idle";
+}
+
+// weblet_unused_seconds returns how long the window hasn't been used, 0
+// while it is active or plays sound; thread-safe
+int weblet_unused_seconds() {
+    if (g_atomic_int_get(&window_active) || g_atomic_int_get(&playing_audio)) {
+        return 0;
+    }
+    return monotonic_seconds() - g_atomic_int_get(&last_active);
+}
+
+// Keep-alive: sites that log out idle users see a request for the page
+// every keep_alive seconds, made by the page so it carries its cookies. A
+// reload is only done while the window isn't used, so nothing typed is lost.
+static int keep_alive = 0;
+static int keep_alive_reload = 0;
+
+void weblet_set_keep_alive(int seconds, int reload) {
+    keep_alive = seconds;
+    keep_alive_reload = reload;
+}
+
+static gboolean on_keep_alive(gpointer data) {
+    if (!app_running) {
+        return G_SOURCE_REMOVE;
+    }
+    if (main_webview == NULL || webkit_web_view_is_loading(main_webview)) {
+        return G_SOURCE_CONTINUE;
+    }
+    if (keep_alive_reload && weblet_unused_seconds() >= keep_alive) {
+        webkit_web_view_reload(main_webview);
+    } else {
+        webkit_web_view_evaluate_javascript(main_webview,
+                                            "fetch(location.href, {credentials: 'include', cache: 'no-store'}).catch(() => {})",
+                                            -1, NULL, NULL, NULL, NULL, NULL);
+    }
+    return G_SOURCE_CONTINUE;
+}
+
+static gboolean set_muted_idle(gpointer data) {
+    if (main_webview != NULL) {
+        webkit_web_view_set_is_muted(main_webview, GPOINTER_TO_INT(data));
+    }
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_set_muted silences all audio of the page, or turns it back on;
+// thread-safe
+void weblet_set_muted(int muted) {
+    g_idle_add(set_muted_idle, GINT_TO_POINTER(muted));
+}
+
+static gboolean close_idle(gpointer data) {
+    if (main_window != NULL) {
+        gtk_window_close(GTK_WINDOW(main_window));
+    }
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_close closes the window like its close button, running the page's
+// unload handlers first; thread-safe
+void weblet_close() {
+    g_idle_add(close_idle, NULL);
+}
+
+static gboolean reload_idle(gpointer data) {
+    if (main_webview != NULL) {
+        webkit_web_view_reload_bypass_cache(main_webview);
+    }
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_reload reloads the page without the cache, for changed files of a
+// watched directory; thread-safe
+void weblet_reload() {
+    g_idle_add(reload_idle, NULL);
+}
+
+// Idle presence: a webview never loses visibility or focus while the window
+// stays open, so chat apps keep showing the user as online. While there is
+// no input for idle_timeout seconds, the idle script makes the page see a
+// hidden, unfocused window. The idle time comes from Mutter's idle monitor,
+// or logind's IdleHint outside GNOME.
+static int idle_timeout = 0;
+static int user_idle = 0;
+static GDBusConnection *idle_bus = NULL;
+static int idle_from_logind = 0;
+static char *idle_script = NULL;
+
+void weblet_set_idle_presence(int seconds, const char *script) {
+    idle_timeout = seconds;
+    g_free(idle_script);
+    idle_script = g_strdup(script);
+}
+
+static void send_idle_state(void) {
+    if (main_webview == NULL) {
+        return;
+    }
+    webkit_web_view_evaluate_javascript(main_webview,
+                                        user_idle ? "window.webletSetIdle && window.webletSetIdle(true)"
+                                                  : "window.webletSetIdle && window.webletSetIdle(false)",
+                                        -1, NULL, NULL, NULL, NULL, NULL);
+}
+
+static void set_user_idle(int idle) {
+    if (idle != user_idle) {
+        user_idle = idle;
+        send_idle_state();
+    }
+}
+
+// A new page starts out active: tell it if the user is away
+static void on_idle_load_changed(WebKitWebView *web_view, WebKitLoadEvent event, gpointer data) {
+    if (event == WEBKIT_LOAD_FINISHED && user_idle) {
+        send_idle_state();
+    }
+}
+
+static void on_logind_idle_hint(GObject *source, GAsyncResult *result, gpointer data) {
+    GVariant *reply = g_dbus_connection_call_finish(G_DBUS_CONNECTION(source), result, NULL);
+    if (reply == NULL) {
+        g_clear_object(&idle_bus);
+        return;
+    }
+    GVariant *hint = NULL;
+    g_variant_get(reply, "(v)", &hint);
+    set_user_idle(g_variant_get_boolean(hint));
+    g_variant_unref(hint);
+    g_variant_unref(reply);
+}
+
+static void on_mutter_idle_time(GObject *source, GAsyncResult *result, gpointer data) {
+    GVariant *reply = g_dbus_connection_call_finish(G_DBUS_CONNECTION(source), result, NULL);
+    if (reply == NULL) {
+        // Not GNOME: ask logind on the next poll
+        g_clear_object(&idle_bus);
+        idle_bus = g_bus_get_sync(G_BUS_TYPE_SYSTEM, NULL, NULL);
+        idle_from_logind = 1;
+        return;
+    }
+    guint64 idle_ms = 0;
+    g_variant_get(reply, "(t)", &idle_ms);
+    set_user_idle(idle_ms >= (guint64)idle_timeout * 1000);
+    g_variant_unref(reply);
+}
+
+static gboolean poll_idle(gpointer data) {
+    if (idle_bus == NULL || !app_running) {
+        return G_SOURCE_REMOVE;
+    }
+    if (idle_from_logind) {
+        g_dbus_connection_call(idle_bus, "org.freedesktop.login1", "/org/freedesktop/login1/session/auto",
+                               "org.freedesktop.DBus.Properties", "Get",
+                               g_variant_new("(ss)", "org.freedesktop.login1.Session", "IdleHint"),
+                               G_VARIANT_TYPE("(v)"), G_DBUS_CALL_FLAGS_NONE, -1, NULL,
+                               on_logind_idle_hint, NULL);
+    } else {
+        g_dbus_connection_call(idle_bus, "org.gnome.Mutter.IdleMonitor", "/org/gnome/Mutter/IdleMonitor/Core",
+                               "org.gnome.Mutter.IdleMonitor", "GetIdletime", NULL,
+                               G_VARIANT_TYPE("(t)"), G_DBUS_CALL_FLAGS_NONE, -1, NULL,
+                               on_mutter_idle_time, NULL);
+    }
+    return G_SOURCE_CONTINUE;
+}
+
+static void watch_idle(void) {
+    idle_bus = g_bus_get_sync(G_BUS_TYPE_SESSION, NULL, NULL);
+    if (idle_bus != NULL) {
+        g_timeout_add_seconds(2, poll_idle, NULL);
+    }
+}
+
+// Pausing media when the screen locks: logind's Lock signal (loginctl
+// lock-session, idle actions) and the session's LockedHint, which GNOME and
+// Plasma set while the screen is locked, pause the audio and video of the
+// page, so music doesn't keep playing to an empty room. Nothing resumes on
+// unlock.
+static int pause_on_lock = 0;
+static GDBusConnection *lock_bus = NULL;
+
+void weblet_set_pause_on_lock(int enabled) {
+    pause_on_lock = enabled;
+}
+
+// Pauses the media elements of the page and its same-origin frames, in the
+// isolated world so the page can't stop it
+static const char pause_media_script[] =
+    "(function pause(doc) {"
+    "  doc.querySelectorAll('audio, video').forEach((media) => media.pause());"
+    "  doc.querySelectorAll('iframe').forEach((frame) => {"
+    "    try { if (frame.contentDocument) pause(frame.contentDocument); } catch (e) {}"
+    "  });"
+    "})(document);";
+
+static void on_session_lock(GDBusConnection *bus, const gchar *sender, const gchar *path,
+                            const gchar *interface, const gchar *signal, GVariant *parameters, gpointer data) {
+    gboolean locked = TRUE;
+    if (g_strcmp0(signal, "PropertiesChanged") == 0) {
+        GVariant *changed = NULL;
+        g_variant_get(parameters, "(&s@a{sv}@as)", NULL, &changed, NULL);
+        locked = g_variant_lookup(changed, "LockedHint", "b", &locked) && locked;
+        g_variant_unref(changed);
+    }
+    if (locked && main_webview != NULL) {
+        webkit_web_view_evaluate_javascript(main_webview, pause_media_script, -1, "weblet", NULL, NULL, NULL, NULL);
+    }
+}
+
+static void on_lock_session(GObject *source, GAsyncResult *result, gpointer data) {
+    GVariant *reply = g_dbus_connection_call_finish(G_DBUS_CONNECTION(source), result, NULL);
+    if (reply == NULL) {
+        return;
+    }
+    const gchar *session = NULL;
+    g_variant_get(reply, "(&o)", &session);
+    g_dbus_connection_signal_subscribe(lock_bus, "org.freedesktop.login1", "org.freedesktop.login1.Session",
+                                       "Lock", session, NULL, G_DBUS_SIGNAL_FLAGS_NONE,
+                                       on_session_lock, NULL, NULL);
+    g_dbus_connection_signal_subscribe(lock_bus, "org.freedesktop.login1", "org.freedesktop.DBus.Properties",
+                                       "PropertiesChanged", session, "org.freedesktop.login1.Session",
+                                       G_DBUS_SIGNAL_FLAGS_NONE, on_session_lock, NULL, NULL);
+    g_variant_unref(reply);
+}
+
+static void watch_session_lock(void) {
+    lock_bus = g_bus_get_sync(G_BUS_TYPE_SYSTEM, NULL, NULL);
+    if (lock_bus == NULL) {
+        return;
+    }
+    g_dbus_connection_call(lock_bus, "org.freedesktop.login1", "/org/freedesktop/login1",
+                           "org.freedesktop.login1.Manager", "GetSession", g_variant_new("(s)", "auto"),
+                           G_VARIANT_TYPE("(o)"), G_DBUS_CALL_FLAGS_NONE, -1, NULL, on_lock_session, NULL);
+}
+
+// Do Not Track and Global Privacy Control: WebKit can't add headers to
+// every request, so they are sent with the pages weblet loads itself and
+// exposed to scripts as navigator.doNotTrack/globalPrivacyControl
+static int send_dnt = 0;
+static int send_gpc = 0;
+
+void weblet_set_privacy_signals(int dnt, int gpc) {
+    send_dnt = dnt;
+    send_gpc = gpc;
+}
+
+static void load_page(WebKitWebView *web_view, const char *uri) {
+    if (!send_dnt && !send_gpc) {
+        webkit_web_view_load_uri(web_view, uri);
+        return;
+    }
+    WebKitURIRequest *request = webkit_uri_request_new(uri);
+    SoupMessageHeaders *headers = webkit_uri_request_get_http_headers(request);
+    if (headers != NULL) {
+        if (send_dnt) {
+            soup_message_headers_replace(headers, "DNT", "1");
+        }
+        if (send_gpc) {
+            soup_message_headers_replace(headers, "Sec-GPC", "1");
+        }
+    }
+    webkit_web_view_load_request(web_view, request);
+    g_object_unref(request);
+}
+
+// Request rules configured before weblet_init
+typedef struct {
+    char *from;
+    char *to;
+} redirect_rule;
+
+static char *content_rules = NULL;
+static redirect_rule *redirects = NULL;
+static int redirect_count = 0;
+
+void weblet_set_content_rules(const char *json) {
+    g_free(content_rules);
+    content_rules = g_strdup(json);
+}
+
+void weblet_add_redirect(const char *from, const char *to) {
+    redirects = g_renew(redirect_rule, redirects, redirect_count + 1);
+    redirects[redirect_count].from = g_strdup(from);
+    redirects[redirect_count].to = g_strdup(to);
+    redirect_count++;
+}
+
+// Navigation patterns: globs matched against the host, or against the whole
+// URL when they contain a '/'. Denied URLs show a block page; with allow
+// patterns, everything outside them (and the start host) opens externally.
+static GPtrArray *allow_patterns = NULL;
+static GPtrArray *deny_patterns = NULL;
+static char *start_host = NULL;
+
+void weblet_add_nav_pattern(const char *pattern, int allow) {
+    GPtrArray **list = allow ? &allow_patterns : &deny_patterns;
+    if (*list == NULL) {
+        *list = g_ptr_array_new_with_free_func(g_free);
+    }
+    g_ptr_array_add(*list, g_strdup(pattern));
+}
+
+static gboolean nav_pattern_matches(GPtrArray *patterns, const char *uri, const char *host) {
+    if (patterns == NULL) {
+        return FALSE;
+    }
+    for (guint i = 0; i < patterns->len; i++) {
+        const char *pattern = g_ptr_array_index(patterns, i);
+        if (strchr(pattern, '/') != NULL) {
+            if (g_pattern_match_simple(pattern, uri)) {
+                return TRUE;
+            }
+        } else if (host != NULL) {
+            // "*.example.com" also covers example.com itself
+            if (g_pattern_match_simple(pattern, host) ||
+                (g_str_has_prefix(pattern, "*.") && g_ascii_strcasecmp(pattern + 2, host) == 0)) {
+                return TRUE;
+            }
+        }
+    }
+    return FALSE;
+}
+
+// Apply allow/deny patterns to a page the main frame started loading;
+// returns TRUE if it was handled. Subframe navigations are left to block rules, so an off-limits iframe
+// doesn't replace the page or open tabs in the browser. The request was
+// sent by then: hosts that must not be contacted at all need a block rule.
+static gboolean contain_navigation(WebKitWebView *web_view, const char *uri) {
+    GUri *parsed = g_uri_parse(uri, G_URI_FLAGS_NONE, NULL);
+    if (parsed == NULL) {
+        return FALSE;
+    }
+    const char *scheme = g_uri_get_scheme(parsed);
+    const char *host = g_uri_get_host(parsed);
+    gboolean handled = FALSE;
+
+    if (g_ascii_strcasecmp(scheme, "http") == 0 || g_ascii_strcasecmp(scheme, "https") == 0) {
+        if (nav_pattern_matches(deny_patterns, uri, host)) {
+            gchar *escaped = g_markup_escape_text(uri, -1);
+            gchar *message = g_strdup_printf(tr("%s is not allowed in this weblet."), escaped);
+            gchar *html = g_strdup_printf(
+                "<html><head><meta charset=\"utf-8\"><title>%s</title></head>"
+                "<body style=\"font-family:sans-serif;display:flex;flex-direction:column;align-items:center;justify-content:center;height:90vh;color:#555\">"
+                "<h2>%s</h2><p>%s</p></body></html>", tr("Blocked"), tr("Blocked"), message);
+            g_free(message);
+            webkit_web_view_load_alternate_html(web_view, html, NULL, NULL);
+            g_free(html);
+            g_free(escaped);
+            handled = TRUE;
+        } else if (allow_patterns != NULL && g_strcmp0(host, start_host) != 0 &&
+                   !nav_pattern_matches(allow_patterns, uri, host)) {
+            webkit_web_view_stop_loading(web_view);
+            open_external(uri);
+            handled = TRUE;
+        }
+    }
+
+    g_uri_unref(parsed);
+    return handled;
+}
+
+// Target of the last redirect, which isn't rewritten again so rules can't
+// send the window back and forth
+static gchar *redirect_target = NULL;
+
+// Rewrite a page the main frame started loading if it matches a redirect
+// rule
+static void redirect_navigation(WebKitWebView *web_view, const char *uri) {
+    if (g_strcmp0(uri, redirect_target) == 0) {
+        return;
+    }
+    for (int i = 0; i < redirect_count; i++) {
+        if (g_str_has_prefix(uri, redirects[i].from)) {
+            g_free(redirect_target);
+            redirect_target = g_strconcat(redirects[i].to, uri + strlen(redirects[i].from), NULL);
+            load_page(web_view, redirect_target);
+            return;
+        }
+    }
+}
+
+// Contain and rewrite the pages the main frame loads; load-changed is only
+// emitted for the main frame, unlike decide-policy
+static void on_navigation_load_changed(WebKitWebView *web_view, WebKitLoadEvent event, gpointer user_data) {
+    if (event != WEBKIT_LOAD_STARTED && event != WEBKIT_LOAD_REDIRECTED) {
+        return;
+    }
+    const char *uri = webkit_web_view_get_uri(web_view);
+    if (uri != NULL && !contain_navigation(web_view, uri)) {
+        redirect_navigation(web_view, uri);
+    }
+}
+
+// Install the compiled content filter, then load the initial URL
+static void on_content_filter_saved(GObject *source, GAsyncResult *result, gpointer user_data) {
+    gchar *url = (gchar *)user_data;
+    GError *error = NULL;
+    WebKitUserContentFilter *filter = webkit_user_content_filter_store_save_finish(
+        WEBKIT_USER_CONTENT_FILTER_STORE(source), result, &error);
+
+    if (filter != NULL) {
+        webkit_user_content_manager_add_filter(
+            webkit_web_view_get_user_content_manager(main_webview), filter);
+        webkit_user_content_filter_unref(filter);
+    } else if (error != NULL) {
+        g_printerr("Failed to compile request rules: %s\n", error->message);
+        g_error_free(error);
+    }
+
+    load_page(main_webview, url);
+    g_free(url);
+}
+
+// Directory served under the weblet-local:// scheme
+static char *local_dir = NULL;
+
+void weblet_set_local_dir(const char *dir) {
+    g_free(local_dir);
+    local_dir = g_strdup(dir);
+}
+
+// Serve weblet-local://<path> from the configured local directory
+static void on_local_scheme_request(WebKitURISchemeRequest *request, gpointer user_data) {
+    const char *base = (const char *)user_data;
+    const char *uri = webkit_uri_scheme_request_get_uri(request);
+    const char *rel = uri + strlen("weblet-local://");
+
+    // Drop query string and fragment
+    gchar *rel_path = g_strndup(rel, strcspn(rel, "?#"));
+    gchar *unescaped = g_uri_unescape_string(rel_path, NULL);
+    g_free(rel_path);
+
+    gchar *joined = g_build_filename(base, unescaped != NULL ? unescaped : "", NULL);
+    gchar *path = g_canonicalize_filename(joined, NULL);
+    g_free(joined);
+    g_free(unescaped);
+
+    if (g_file_test(path, G_FILE_TEST_IS_DIR)) {
+        gchar *index = g_build_filename(path, "index.html", NULL);
+        g_free(path);
+        path = index;
+    }
+
+    // Refuse paths escaping the base directory
+    gchar *base_prefix = g_strconcat(base, G_DIR_SEPARATOR_S, NULL);
+    gboolean inside = g_str_has_prefix(path, base_prefix);
+    g_free(base_prefix);
+
+    GError *error = NULL;
+    GFileInputStream *stream = NULL;
+    goffset size = -1;
+    if (inside) {
+        GFile *file = g_file_new_for_path(path);
+        GFileInfo *info = g_file_query_info(file, G_FILE_ATTRIBUTE_STANDARD_SIZE, G_FILE_QUERY_INFO_NONE, NULL, NULL);
+        if (info != NULL) {
+            size = g_file_info_get_size(info);
+            g_object_unref(info);
+        }
+        stream = g_file_read(file, NULL, &error);
+        g_object_unref(file);
+    } else {
+        error = g_error_new(G_IO_ERROR, G_IO_ERROR_PERMISSION_DENIED, "Path outside local directory: %s", uri);
+    }
+
+    if (stream != NULL) {
+        gchar *content_type = g_content_type_guess(path, NULL, 0, NULL);
+        gchar *mime = g_content_type_get_mime_type(content_type);
+        webkit_uri_scheme_request_finish(request, G_INPUT_STREAM(stream), size, mime);
+        g_free(mime);
+        g_free(content_type);
+        g_object_unref(stream);
+    } else {
+        webkit_uri_scheme_request_finish_error(request, error);
+        g_error_free(error);
+    }
+
+    g_free(path);
+}
+
+// Retry failed loads of loopback URLs while a local dev server boots
+static int retry_on_failure = 0;
+
+void weblet_set_retry_on_failure(int enabled) {
+    retry_on_failure = enabled;
+}
+
+// Custom page shown instead of WebKit's error page
+static char *error_page = NULL;
+
+void weblet_set_error_page(const char *html) {
+    g_free(error_page);
+    error_page = g_strdup(html);
+}
+
+static gboolean on_retry_load(gpointer data) {
+    gchar *uri = (gchar *)data;
+    if (app_running && main_webview != NULL) {
+        load_page(main_webview, uri);
+    }
+    g_free(uri);
+    return FALSE;
+}
+
+// Pages with an invalid certificate aren't shown (there are no exceptions),
+// the attempt is recorded
+static gboolean on_tls_errors(WebKitWebView *web_view, gchar *failing_uri, GTlsCertificate *certificate,
+                              GTlsCertificateFlags errors, gpointer data) {
+    audit("certificate-error", failing_uri);
+    return FALSE;
+}
+
+static void on_download_destination(WebKitDownload *download, gchar *destination, gpointer data) {
+    gchar *detail = g_strdup_printf("%s -> %s", webkit_uri_request_get_uri(webkit_download_get_request(download)),
+                                    destination);
+    audit("download", detail);
+    g_free(detail);
+}
+
+static void on_download_started(WebKitWebContext *context, WebKitDownload *download, gpointer data) {
+    g_signal_connect(download, "created-destination", G_CALLBACK(on_download_destination), NULL);
+}
+
+static gboolean on_load_failed(WebKitWebView *web_view,
+                               WebKitLoadEvent load_event,
+                               gchar *failing_uri,
+                               GError *error,
+                               gpointer user_data) {
+    // Navigations cancelled by the user or by a redirect are not failures
+    if (g_error_matches(error, WEBKIT_NETWORK_ERROR, WEBKIT_NETWORK_ERROR_CANCELLED) ||
+        g_error_matches(error, WEBKIT_POLICY_ERROR, WEBKIT_POLICY_ERROR_FRAME_LOAD_INTERRUPTED_BY_POLICY_CHANGE)) {
+        return FALSE;
+    }
+
+    if (!retry_on_failure) {
+        if (error_page != NULL) {
+            webkit_web_view_load_alternate_html(web_view, error_page, failing_uri, NULL);
+            return TRUE;
+        }
+        return FALSE;
+    }
+
+    gchar *escaped = g_markup_escape_text(failing_uri, -1);
+    gchar *message = g_strdup_printf(tr("Waiting for %s…"), escaped);
+    gchar *html = g_strdup_printf(
+        "<html><head><meta charset=\"utf-8\"><title>%s</title></head>"
+        "<body style=\"font-family:sans-serif;display:flex;align-items:center;justify-content:center;height:90vh;color:#555\">"
+        "<p>%s</p></body></html>", tr("Waiting…"), message);
+    g_free(message);
+    webkit_web_view_load_alternate_html(web_view, html, failing_uri, NULL);
+    g_free(html);
+    g_free(escaped);
+
+    g_timeout_add(1000, on_retry_load, g_strdup(failing_uri));
+    return TRUE;
+}
+
+// Network and privacy settings
+static char *proxy_uri = NULL;
+static char *user_agent = NULL;
+
+void weblet_set_proxy(const char *uri) {
+    g_free(proxy_uri);
+    proxy_uri = g_strdup(uri);
+}
+
+void weblet_set_user_agent(const char *ua) {
+    g_free(user_agent);
+    user_agent = g_strdup(ua);
+}
+
+void weblet_set_hardened(int enabled) {
+    hardened = enabled;
+}
+
+// Content toggles for reading-only weblets
+static int enable_javascript = 1;
+static int auto_load_images = 1;
+static int enable_webgl = 1;
+
+void weblet_set_content_settings(int javascript, int images, int webgl) {
+    enable_javascript = javascript;
+    auto_load_images = images;
+    enable_webgl = webgl;
+}
+
+// Privacy preset: no third-party cookies, canvas noise and no referrers
+static char *privacy_script = NULL;
+
+void weblet_set_privacy_script(const char *script) {
+    g_free(privacy_script);
+    privacy_script = g_strdup(script);
+}
+
+// Fixed position reported to pages instead of the real location
+static int fixed_location = 0;
+static double fixed_latitude = 0;
+static double fixed_longitude = 0;
+
+void weblet_set_location(double latitude, double longitude) {
+    fixed_location = 1;
+    fixed_latitude = latitude;
+    fixed_longitude = longitude;
+}
+
+// Answers position requests with the fixed location instead of GeoClue
+static gboolean on_geolocation_start(WebKitGeolocationManager *manager, gpointer data) {
+    WebKitGeolocationPosition *position = webkit_geolocation_position_new(fixed_latitude, fixed_longitude, 10.0);
+    webkit_geolocation_manager_update_position(manager, position);
+    webkit_geolocation_position_free(position);
+    return TRUE;
+}
+
+// Offline support: service workers and the Cache API they fill
+static int enable_service_workers = 1;
+static int enable_offline_cache = 1;
+
+void weblet_set_offline_settings(int service_workers, int offline_cache) {
+    enable_service_workers = service_workers;
+    enable_offline_cache = offline_cache;
+}
+
+// Client-side header bar with icon, title, unread pill, progress and menu
+static int use_header_bar = 0;
+static GtkWidget *title_label = NULL;
+static GtkWidget *badge_label = NULL;
+static GtkWidget *progress_bar = NULL;
+
+void weblet_set_header_bar(int enabled) {
+    use_header_bar = enabled;
+}
+
+// Kiosk mode: fullscreen, no header bar and no context menu
+static int kiosk = 0;
+
+void weblet_set_kiosk(int enabled) {
+    kiosk = enabled;
+}
+
+// Undecorated windows have neither a title bar nor a header bar, for
+// dashboards that look better without them
+static int undecorated = 0;
+
+void weblet_set_undecorated(int enabled) {
+    undecorated = enabled;
+}
+
+// Cache directory other than the data directory (memory-only cache)
+static char *cache_dir = NULL;
+
+void weblet_set_cache_dir(const char *path) {
+    g_free(cache_dir);
+    cache_dir = g_strdup(path);
+}
+
+// Directory of the site data other than the data directory (containers)
+static char *site_dir = NULL;
+
+void weblet_set_site_dir(const char *path) {
+    g_free(site_dir);
+    site_dir = g_strdup(path);
+}
+
+// Start minimized, for weblets reopened at login
+static int start_hidden = 0;
+
+void weblet_set_start_hidden(int enabled) {
+    start_hidden = enabled;
+}
+
+// Prewarm: load the page without showing the window, which appears on the
+// first focus request. Hidden pages are throttled by WebKit, and kept
+// silent until shown.
+static int prewarm = 0;
+
+void weblet_set_prewarm(int enabled) {
+    prewarm = enabled;
+}
+
+// Remote inspection ('weblet ctl inspect'): the page is listed by the
+// inspector server WebKit starts from WEBKIT_INSPECTOR_SERVER
+static int inspectable = 0;
+
+void weblet_set_inspectable(int enabled) {
+    inspectable = enabled;
+}
+
+// Cache warming ('weblet cache warm'): a prewarmed window that closes once
+// the page has loaded and had warm_settle seconds to install its service
+// worker and fill the caches, or after warm_limit seconds if it doesn't
+// finish loading. Showing the window meanwhile keeps it open.
+static int warm_settle = 0;
+static guint warm_timer = 0;
+static const int warm_limit = 120;
+
+void weblet_set_warm(int settle) {
+    warm_settle = settle;
+}
+
+static gboolean on_warm_done(gpointer data) {
+    warm_timer = 0;
+    if (main_window != NULL) {
+        gtk_widget_destroy(main_window);
+    }
+    return G_SOURCE_REMOVE;
+}
+
+static void on_warm_load_changed(WebKitWebView *web_view, WebKitLoadEvent event, gpointer data) {
+    if (event == WEBKIT_LOAD_FINISHED && warm_timer != 0) {
+        g_source_remove(warm_timer);
+        warm_timer = g_timeout_add_seconds(warm_settle, on_warm_done, NULL);
+    }
+}
+
+// Startup profiling ('weblet run --profile-startup'): steps are appended to
+// the profile file as "<step> <unix microseconds>" until the first page
+// finished loading
+static char *profile_file = NULL;
+static int first_paint_pending = 0;
+static int profile_load_failed = 0;
+
+void weblet_set_profile_file(const char *path) {
+    g_free(profile_file);
+    profile_file = g_strdup(path);
+}
+
+static void profile_mark(const char *step) {
+    if (profile_file == NULL) {
+        return;
+    }
+    FILE *file = fopen(profile_file, "a");
+    if (file != NULL) {
+        fprintf(file, "%s %" G_GINT64_FORMAT "\n", step, g_get_real_time());
+        fclose(file);
+    }
+}
+
+static gboolean on_profile_draw(GtkWidget *widget, cairo_t *cr, gpointer data) {
+    if (first_paint_pending) {
+        first_paint_pending = 0;
+        profile_mark("first paint");
+    }
+    return FALSE;
+}
+
+static void on_profile_load_changed(WebKitWebView *web_view, WebKitLoadEvent event, gpointer data) {
+    if (event == WEBKIT_LOAD_COMMITTED) {
+        profile_mark("page committed");
+        first_paint_pending = 1;
+    } else if (event == WEBKIT_LOAD_FINISHED) {
+        profile_mark(profile_load_failed ? "load failed" : "load finished");
+        g_clear_pointer(&profile_file, g_free);
+    }
+}
+
+// A failed load is followed by WEBKIT_LOAD_FINISHED
+static gboolean on_profile_load_failed(WebKitWebView *web_view, WebKitLoadEvent event,
+                                       gchar *failing_uri, GError *error, gpointer data) {
+    if (!g_error_matches(error, WEBKIT_NETWORK_ERROR, WEBKIT_NETWORK_ERROR_CANCELLED)) {
+        profile_load_failed = 1;
+    }
+    return FALSE;
+}
+
+// Written once the window is mapped, a 'weblet run' waiting for the window
+// of a starting weblet watches this file instead of polling for the window
+static char *ready_file = NULL;
+
+void weblet_set_ready_file(const char *path) {
+    g_free(ready_file);
+    ready_file = g_strdup(path);
+}
+
+static void mark_window_ready(void) {
+    if (ready_file == NULL) {
+        return;
+    }
+    g_file_set_contents(ready_file, "mapped\n", -1, NULL);
+    g_free(ready_file);
+    ready_file = NULL;
+}
+
+static gboolean on_map_event(GtkWidget *widget, GdkEvent *event, gpointer data) {
+    if (prewarm) {
+        prewarm = 0;
+        webkit_web_view_set_is_muted(main_webview, FALSE);
+    }
+    if (warm_timer != 0) {
+        g_source_remove(warm_timer);
+        warm_timer = 0;
+    }
+    profile_mark("window shown");
+    mark_window_ready();
+    return FALSE;
+}
+
+static gboolean on_context_menu(WebKitWebView *web_view, WebKitContextMenu *menu,
+                                GdkEvent *event, WebKitHitTestResult *hit, gpointer user_data) {
+    return TRUE;
+}
+
+// Theme color used to tint the header bar and page background
+static char *theme_color = NULL;
+static char *theme_text_color = NULL;
+
+void weblet_set_theme_color(const char *color, const char *text_color) {
+    g_free(theme_color);
+    g_free(theme_text_color);
+    theme_color = g_strdup(color);
+    theme_text_color = g_strdup(text_color);
+}
+
+// GTK CSS of the user for the window chrome (header bar, title, fonts)
+static char *window_css = NULL;
+
+void weblet_set_window_css(const char *css) {
+    g_free(window_css);
+    window_css = g_strdup(css);
+}
+
+// Color scheme of the window and pages: follow the desktop's
+// org.freedesktop.appearance color-scheme (read from the settings portal and
+// updated when it changes, e.g. at sunset), or always dark or light
+#define COLOR_SCHEME_SYSTEM 0
+#define COLOR_SCHEME_DARK 1
+#define COLOR_SCHEME_LIGHT 2
+
+static int color_scheme = COLOR_SCHEME_SYSTEM;
+static WebKitUserStyleSheet *dark_style_sheet = NULL;
+
+void weblet_set_color_scheme(int scheme) {
+    color_scheme = scheme;
+}
+
+// apply_dark switches the GTK theme variant, which WebKit also reports to
+// pages as prefers-color-scheme, and gives pages without dark styles of
+// their own dark form controls, scrollbars and default colors
+static void apply_dark(gboolean dark) {
+    g_object_set(gtk_settings_get_default(), "gtk-application-prefer-dark-theme", dark, NULL);
+
+    WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+    if (dark && dark_style_sheet == NULL) {
+        dark_style_sheet = webkit_user_style_sheet_new(":root { color-scheme: dark; }",
+                                                       WEBKIT_USER_CONTENT_INJECT_ALL_FRAMES,
+                                                       WEBKIT_USER_STYLE_LEVEL_USER, NULL, NULL);
+        webkit_user_content_manager_add_style_sheet(content_manager, dark_style_sheet);
+    } else if (!dark && dark_style_sheet != NULL) {
+        webkit_user_content_manager_remove_style_sheet(content_manager, dark_style_sheet);
+        webkit_user_style_sheet_unref(dark_style_sheet);
+        dark_style_sheet = NULL;
+    }
+}
+
+// The portal's color-scheme is 0 (no preference), 1 (dark) or 2 (light)
+static void apply_portal_color_scheme(GVariant *value) {
+    value = g_variant_ref(value);
+    while (g_variant_is_of_type(value, G_VARIANT_TYPE_VARIANT)) {
+        GVariant *inner = g_variant_get_variant(value);
+        g_variant_unref(value);
+        value = inner;
+    }
+    if (g_variant_is_of_type(value, G_VARIANT_TYPE_UINT32)) {
+        apply_dark(g_variant_get_uint32(value) == 1);
+    }
+    g_variant_unref(value);
+}
+
+static void on_portal_setting_changed(GDBusConnection *bus, const gchar *sender, const gchar *path,
+                                      const gchar *iface, const gchar *signal, GVariant *params, gpointer data) {
+    const gchar *namespace = NULL;
+    const gchar *key = NULL;
+    GVariant *value = NULL;
+    g_variant_get(params, "(&s&sv)", &namespace, &key, &value);
+    if (g_strcmp0(namespace, "org.freedesktop.appearance") == 0 && g_strcmp0(key, "color-scheme") == 0) {
+        apply_portal_color_scheme(value);
+    }
+    g_variant_unref(value);
+}
+
+static void watch_color_scheme(void) {
+    if (color_scheme != COLOR_SCHEME_SYSTEM) {
+        apply_dark(color_scheme == COLOR_SCHEME_DARK);
+        return;
+    }
+
+    GDBusConnection *bus = g_bus_get_sync(G_BUS_TYPE_SESSION, NULL, NULL);
+    if (bus == NULL) {
+        return;
+    }
+    g_dbus_connection_signal_subscribe(bus, "org.freedesktop.portal.Desktop",
+                                       "org.freedesktop.portal.Settings", "SettingChanged",
+                                       "/org/freedesktop/portal/desktop", NULL, G_DBUS_SIGNAL_FLAGS_NONE,
+                                       on_portal_setting_changed, NULL, NULL);
+
+    // ReadOne is newer, Read wraps the value in one more variant
+    GVariant *reply = NULL;
+    const char *methods[] = {"ReadOne", "Read"};
+    for (int i = 0; i < 2 && reply == NULL; i++) {
+        reply = g_dbus_connection_call_sync(bus, "org.freedesktop.portal.Desktop", "/org/freedesktop/portal/desktop",
+                                            "org.freedesktop.portal.Settings", methods[i],
+                                            g_variant_new("(ss)", "org.freedesktop.appearance", "color-scheme"),
+                                            NULL, G_DBUS_CALL_FLAGS_NONE, 1000, NULL, NULL);
+    }
+    if (reply != NULL) {
+        GVariant *value = g_variant_get_child_value(reply, 0);
+        apply_portal_color_scheme(value);
+        g_variant_unref(value);
+        g_variant_unref(reply);
+    }
+}
+
+// Scripts run at the start of every page (e.g. seeding a login)
+static GPtrArray *startup_scripts = NULL;
+
+void weblet_add_startup_script(const char *script) {
+    if (startup_scripts == NULL) {
+        startup_scripts = g_ptr_array_new_with_free_func(g_free);
+    }
+    g_ptr_array_add(startup_scripts, g_strdup(script));
+}
+
+// The startup-js snippet of the weblet runs once a page finished loading,
+// when buttons it clicks (e.g. "Stay signed in") are usually there
+static char *load_script = NULL;
+
+void weblet_set_load_script(const char *script) {
+    g_free(load_script);
+    load_script = g_strdup(script);
+}
+
+static void on_load_script_changed(WebKitWebView *web_view, WebKitLoadEvent event, gpointer data) {
+    if (event == WEBKIT_LOAD_FINISHED) {
+        webkit_web_view_evaluate_javascript(web_view, load_script, -1, NULL, NULL, NULL, NULL, NULL);
+    }
+}
+
+// JavaScript bridge (window.weblet) for advanced weblets
+static char *bridge_script = NULL;
+static char *base_title = NULL;
+static char *custom_title = NULL;
+static char *app_id = NULL;
+static char *app_icon = NULL;
+static int badge_count = 0;
+
+void weblet_set_bridge_script(const char *script) {
+    g_free(bridge_script);
+    bridge_script = g_strdup(script);
+}
+
+// Window title: "(badge) base - custom title"
+static void refresh_window_title() {
+    if (main_window == NULL) {
+        return;
+    }
+
+    gchar *title = custom_title != NULL && custom_title[0] != '\0'
+        ? g_strdup_printf("%s - %s", base_title, custom_title)
+        : g_strdup(base_title);
+
+    if (badge_count > 0) {
+        gchar *window_title = g_strdup_printf("(%d) %s", badge_count, title);
+        gtk_window_set_title(GTK_WINDOW(main_window), window_title);
+        g_free(window_title);
+    } else {
+        gtk_window_set_title(GTK_WINDOW(main_window), title);
+    }
+
+    // The header bar shows the count as a pill next to the title
+    if (title_label != NULL) {
+        gtk_label_set_text(GTK_LABEL(title_label), title);
+        gchar *count = g_strdup_printf("%d", badge_count);
+        gtk_label_set_text(GTK_LABEL(badge_label), count);
+        gtk_widget_set_visible(badge_label, badge_count > 0);
+        g_free(count);
+    }
+
+    g_free(title);
+}
+
+// Show an unread count on the dock/taskbar entry (Unity LauncherEntry API,
+// supported by Dash to Dock, KDE Plasma and others)
+static void update_launcher_badge() {
+    GDBusConnection *bus = g_bus_get_sync(G_BUS_TYPE_SESSION, NULL, NULL);
+    if (bus == NULL) {
+        return;
+    }
+
+    GVariantBuilder props;
+    g_variant_builder_init(&props, G_VARIANT_TYPE("a{sv}"));
+    g_variant_builder_add(&props, "{sv}", "count", g_variant_new_int64(badge_count));
+    g_variant_builder_add(&props, "{sv}", "count-visible", g_variant_new_boolean(badge_count > 0));
+
+    gchar *app_uri = g_strdup_printf("application://%s.desktop", app_id);
+    g_dbus_connection_emit_signal(bus, NULL, "/com/canonical/unity/launcherentry/weblet",
+                                  "com.canonical.Unity.LauncherEntry", "Update",
+                                  g_variant_new("(sa{sv})", app_uri, &props), NULL);
+    g_free(app_uri);
+    g_object_unref(bus);
+}
+
+// Send a desktop notification through org.freedesktop.Notifications
+static void send_notification(const char *summary, const char *body) {
+    GDBusConnection *bus = g_bus_get_sync(G_BUS_TYPE_SESSION, NULL, NULL);
+    if (bus == NULL) {
+        return;
+    }
+
+    GVariantBuilder actions;
+    GVariantBuilder hints;
+    g_variant_builder_init(&actions, G_VARIANT_TYPE("as"));
+    g_variant_builder_init(&hints, G_VARIANT_TYPE("a{sv}"));
+    g_variant_builder_add(&hints, "{sv}", "desktop-entry", g_variant_new_string(app_id));
+
+    g_dbus_connection_call(bus, "org.freedesktop.Notifications", "/org/freedesktop/Notifications",
+                           "org.freedesktop.Notifications", "Notify",
+                           g_variant_new("(susssasa{sv}i)", base_title, 0, app_icon != NULL ? app_icon : "",
+                                         summary, body, &actions, &hints, -1),
+                           NULL, G_DBUS_CALL_FLAGS_NONE, -1, NULL, NULL, NULL);
+    g_object_unref(bus);
+}
+
+static gchar *bridge_string(JSCValue *message, const char *name) {
+    JSCValue *value = jsc_value_object_get_property(message, name);
+    gchar *str = jsc_value_is_undefined(value) || jsc_value_is_null(value) ? g_strdup("") : jsc_value_to_string(value);
+    g_object_unref(value);
+    return str;
+}
+
+// Handle window.weblet.* calls posted from the page
+static void on_bridge_message(WebKitUserContentManager *manager,
+                              WebKitJavascriptResult *result,
+                              gpointer user_data) {
+    JSCValue *message = webkit_javascript_result_get_js_value(result);
+    if (!jsc_value_is_object(message)) {
+        return;
+    }
+
+    gchar *cmd = bridge_string(message, "cmd");
+
+    if (g_strcmp0(cmd, "setBadge") == 0) {
+        JSCValue *count = jsc_value_object_get_property(message, "count");
+        badge_count = MAX(jsc_value_to_int32(count), 0);
+        g_object_unref(count);
+        refresh_window_title();
+        update_launcher_badge();
+    } else if (g_strcmp0(cmd, "notify") == 0) {
+        gchar *title = bridge_string(message, "title");
+        gchar *body = bridge_string(message, "body");
+        send_notification(title, body);
+        g_free(title);
+        g_free(body);
+    } else if (g_strcmp0(cmd, "setTitle") == 0) {
+        g_free(custom_title);
+        custom_title = bridge_string(message, "title");
+        refresh_window_title();
+    } else if (g_strcmp0(cmd, "requestAttention") == 0) {
+        if (!gtk_window_is_active(GTK_WINDOW(main_window))) {
+            gtk_window_set_urgency_hint(GTK_WINDOW(main_window), TRUE);
+        }
+    } else if (g_strcmp0(cmd, "closeWindow") == 0) {
+        gtk_widget_destroy(main_window);
+    }
+
+    g_free(cmd);
+}
+
+// Clear the urgency hint once the user looks at the window
+static gboolean on_focus_in(GtkWidget *widget, GdkEvent *event, gpointer user_data) {
+    gtk_window_set_urgency_hint(GTK_WINDOW(widget), FALSE);
+    return FALSE;
+}
+
+static void on_load_progress(WebKitWebView *web_view, GParamSpec *pspec, gpointer user_data) {
+    gdouble progress = webkit_web_view_get_estimated_load_progress(web_view);
+    gtk_progress_bar_set_fraction(GTK_PROGRESS_BAR(progress_bar), progress);
+    gtk_widget_set_visible(progress_bar, progress < 1.0);
+}
+
+// Pick up unread counts from page titles like "(3) Inbox"
+static void on_page_title(WebKitWebView *web_view, GParamSpec *pspec, gpointer user_data) {
+    const char *page_title = webkit_web_view_get_title(web_view);
+    int count = 0;
+    if (page_title != NULL && sscanf(page_title, "(%d)", &count) != 1) {
+        count = 0;
+    }
+
+    if (count != badge_count) {
+        badge_count = count;
+        refresh_window_title();
+        update_launcher_badge();
+    }
+}
+
+static void on_menu_back(GtkMenuItem *item, gpointer data) {
+    webkit_web_view_go_back(main_webview);
+}
+
+static void on_menu_forward(GtkMenuItem *item, gpointer data) {
+    webkit_web_view_go_forward(main_webview);
+}
+
+static void on_menu_reload(GtkMenuItem *item, gpointer data) {
+    webkit_web_view_reload(main_webview);
+}
+
+static void on_menu_open_in_browser(GtkMenuItem *item, gpointer data) {
+    const char *uri = webkit_web_view_get_uri(main_webview);
+    if (uri != NULL) {
+        open_external(uri);
+    }
+}
+
+static void on_open_in_browser_action(GSimpleAction *action, GVariant *parameter, gpointer data) {
+    on_menu_open_in_browser(NULL, NULL);
+}
+
+// Autofill (autofill setting): logins are kept in the Secret Service per
+// weblet, origin and username. A script in an isolated world, out of the
+// page's reach, finds the login fields, offers to save what is submitted
+// and fills saved credentials on request.
+static char *autofill_weblet = NULL;
+static char *autofill_script = NULL;
+static GtkWidget *autofill_prompt = NULL;
+
+static const SecretSchema autofill_schema = {
+    "io.github.michalCapo.weblet.Login", SECRET_SCHEMA_DONT_MATCH_NAME,
+    {
+        {"weblet", SECRET_SCHEMA_ATTRIBUTE_STRING},
+        {"origin", SECRET_SCHEMA_ATTRIBUTE_STRING},
+        {"username", SECRET_SCHEMA_ATTRIBUTE_STRING},
+        {NULL, 0},
+    },
+};
+
+void weblet_set_autofill(const char *weblet, const char *script) {
+    g_free(autofill_weblet);
+    g_free(autofill_script);
+    autofill_weblet = g_strdup(weblet);
+    autofill_script = g_strdup(script);
+}
+
+// Toast: a short in-window note at the bottom, e.g. about clipboard access
+static GtkWidget *toast = NULL;
+static GtkWidget *toast_label = NULL;
+static guint toast_timeout = 0;
+
+static gboolean on_toast_timeout(gpointer data) {
+    toast_timeout = 0;
+    gtk_revealer_set_reveal_child(GTK_REVEALER(toast), FALSE);
+    return G_SOURCE_REMOVE;
+}
+
+static void show_toast(const char *text) {
+    if (toast == NULL) {
+        return;
+    }
+    gtk_label_set_text(GTK_LABEL(toast_label), text);
+    gtk_revealer_set_reveal_child(GTK_REVEALER(toast), TRUE);
+    if (toast_timeout != 0) {
+        g_source_remove(toast_timeout);
+    }
+    toast_timeout = g_timeout_add_seconds(3, on_toast_timeout, NULL);
+}
+
+static GtkWidget *build_toast(void) {
+    toast = gtk_revealer_new();
+    gtk_revealer_set_transition_type(GTK_REVEALER(toast), GTK_REVEALER_TRANSITION_TYPE_SLIDE_UP);
+    gtk_widget_set_halign(toast, GTK_ALIGN_CENTER);
+    gtk_widget_set_valign(toast, GTK_ALIGN_END);
+    GtkWidget *frame = gtk_frame_new(NULL);
+    gtk_style_context_add_class(gtk_widget_get_style_context(frame), "app-notification");
+    toast_label = gtk_label_new(NULL);
+    gtk_container_add(GTK_CONTAINER(frame), toast_label);
+    gtk_container_add(GTK_CONTAINER(toast), frame);
+    return toast;
+}
+
+// page_origin returns scheme://host[:port] of the page shown (free with g_free)
+static gchar *page_origin(void) {
+    const char *uri = main_webview != NULL ? webkit_web_view_get_uri(main_webview) : NULL;
+    GUri *parsed = uri != NULL ? g_uri_parse(uri, G_URI_FLAGS_NONE, NULL) : NULL;
+    if (parsed == NULL) {
+        return NULL;
+    }
+    gchar *origin = NULL;
+    if (g_uri_get_host(parsed) != NULL) {
+        origin = g_uri_get_port(parsed) > 0
+            ? g_strdup_printf("%s://%s:%d", g_uri_get_scheme(parsed), g_uri_get_host(parsed), g_uri_get_port(parsed))
+            : g_strdup_printf("%s://%s", g_uri_get_scheme(parsed), g_uri_get_host(parsed));
+    }
+    g_uri_unref(parsed);
+    return origin;
+}
+
+// Clipboard access of pages: "ask" (the default) lets pages write to the
+// clipboard but asks before they read it, once per site while the window is
+// open; "allow" and "deny" don't ask. Either way a toast tells when a page
+// read or wrote the clipboard on its own, not through the user's Ctrl+C/V.
+#define CLIPBOARD_ASK 0
+#define CLIPBOARD_ALLOW 1
+#define CLIPBOARD_DENY 2
+
+static int clipboard_policy = CLIPBOARD_ASK;
+static char *clipboard_script = NULL;
+static GHashTable *clipboard_allowed = NULL; // Origins allowed to read
+static GtkWidget *clipboard_prompt = NULL;
+
+void weblet_set_clipboard(int policy, const char *script) {
+    clipboard_policy = policy;
+    g_free(clipboard_script);
+    clipboard_script = g_strdup(script);
+}
+
+static void clipboard_toast(const char *format) {
+    gchar *origin = page_origin();
+    if (origin == NULL) {
+        return;
+    }
+    gchar *text = g_strdup_printf(tr(format), strstr(origin, "://") + 3);
+    show_toast(text);
+    g_free(text);
+    g_free(origin);
+}
+
+static void on_clipboard_prompt_response(GtkDialog *dialog, gint response, gpointer data) {
+    WebKitPermissionRequest *request = WEBKIT_PERMISSION_REQUEST(data);
+    if (response == GTK_RESPONSE_ACCEPT) {
+        gchar *origin = page_origin();
+        if (origin != NULL) {
+            g_hash_table_add(clipboard_allowed, origin);
+        }
+        webkit_permission_request_allow(request);
+        audit("clipboard-read", "allowed when asked");
+        clipboard_toast("%s read the clipboard");
+    } else {
+        webkit_permission_request_deny(request);
+        audit("clipboard-denied", "denied when asked");
+    }
+    g_object_unref(request);
+    gtk_widget_destroy(GTK_WIDGET(dialog));
+}
+
+static void on_clipboard_request(WebKitPermissionRequest *request) {
+    if (clipboard_policy == CLIPBOARD_DENY || clipboard_prompt != NULL) {
+        if (clipboard_policy == CLIPBOARD_DENY) {
+            audit("clipboard-denied", "clipboard deny");
+        }
+        webkit_permission_request_deny(request);
+        return;
+    }
+    if (clipboard_allowed == NULL) {
+        clipboard_allowed = g_hash_table_new_full(g_str_hash, g_str_equal, g_free, NULL);
+    }
+    gchar *origin = page_origin();
+    gboolean allowed = clipboard_policy == CLIPBOARD_ALLOW ||
+        (origin != NULL && g_hash_table_contains(clipboard_allowed, origin));
+    if (allowed) {
+        webkit_permission_request_allow(request);
+        audit("clipboard-read", clipboard_policy == CLIPBOARD_ALLOW ? "clipboard allow" : "allowed before");
+        clipboard_toast("%s read the clipboard");
+        g_free(origin);
+        return;
+    }
+
+    clipboard_prompt = gtk_message_dialog_new(GTK_WINDOW(main_window), GTK_DIALOG_DESTROY_WITH_PARENT,
+                                              GTK_MESSAGE_QUESTION, GTK_BUTTONS_NONE,
+                                              tr("Allow %s to read the clipboard?"),
+                                              origin != NULL ? origin : webkit_web_view_get_uri(main_webview));
+    gtk_message_dialog_format_secondary_text(GTK_MESSAGE_DIALOG(clipboard_prompt), "%s",
+                                             tr("It can see what you copied, also in other apps."));
+    gtk_dialog_add_buttons(GTK_DIALOG(clipboard_prompt), tr("Deny"), GTK_RESPONSE_REJECT,
+                           tr("Allow"), GTK_RESPONSE_ACCEPT, NULL);
+    gtk_dialog_set_default_response(GTK_DIALOG(clipboard_prompt), GTK_RESPONSE_REJECT);
+    g_signal_connect(clipboard_prompt, "response", G_CALLBACK(on_clipboard_prompt_response), g_object_ref(request));
+    g_signal_connect(clipboard_prompt, "destroy", G_CALLBACK(gtk_widget_destroyed), &clipboard_prompt);
+    gtk_widget_show(clipboard_prompt);
+    g_free(origin);
+}
+
+// The clipboard script posts "read" or "write" when a page script used the
+// clipboard API or execCommand
+static void on_clipboard_message(WebKitUserContentManager *manager, WebKitJavascriptResult *result, gpointer data) {
+    JSCValue *message = webkit_javascript_result_get_js_value(result);
+    gchar *kind = jsc_value_to_string(message);
+    if (g_strcmp0(kind, "write") == 0) {
+        audit("clipboard-write", "by a script");
+        clipboard_toast("%s copied to the clipboard");
+    } else if (g_strcmp0(kind, "read") == 0 && clipboard_policy == CLIPBOARD_ALLOW) {
+        audit("clipboard-read", "by a script");
+        clipboard_toast("%s read the clipboard"); // Asked reads already showed one
+    }
+    g_free(kind);
+}
+
+static void fill_login(const char *username, const char *password) {
+    GVariantDict args;
+    g_variant_dict_init(&args, NULL);
+    g_variant_dict_insert(&args, "username", "s", username);
+    g_variant_dict_insert(&args, "password", "s", password);
+    webkit_web_view_call_async_javascript_function(main_webview, "return webletAutofill.fill(username, password);", -1,
+                                                   g_variant_dict_end(&args), "weblet", NULL, NULL, NULL, NULL);
+}
+
+static void on_login_chosen(GtkMenuItem *item, gpointer data) {
+    SecretItem *login = SECRET_ITEM(data);
+    SecretValue *secret = secret_item_get_secret(login);
+    GHashTable *attributes = secret_item_get_attributes(login);
+    if (secret != NULL) {
+        fill_login(g_hash_table_lookup(attributes, "username"), secret_value_get_text(secret));
+        secret_value_unref(secret);
+    }
+    g_hash_table_unref(attributes);
+}
+
+// The chosen item is activated after the menu is deactivated
+static gboolean destroy_idle(gpointer widget) {
+    gtk_widget_destroy(GTK_WIDGET(widget));
+    return G_SOURCE_REMOVE;
+}
+
+static void on_login_menu_deactivate(GtkMenuShell *menu, gpointer data) {
+    g_idle_add(destroy_idle, menu);
+}
+
+static void on_logins_found(GObject *source, GAsyncResult *result, gpointer data) {
+    gchar *origin = (gchar *)data;
+    GError *error = NULL;
+    GList *logins = secret_service_search_finish(NULL, result, &error);
+    if (error != NULL) {
+        g_printerr("Could not read logins from the keyring: %s\n", error->message);
+        g_error_free(error);
+    } else if (main_webview == NULL) {
+        // The window closed meanwhile
+    } else if (logins == NULL) {
+        GtkWidget *dialog = gtk_message_dialog_new(GTK_WINDOW(main_window), GTK_DIALOG_DESTROY_WITH_PARENT,
+                                                   GTK_MESSAGE_INFO, GTK_BUTTONS_CLOSE,
+                                                   tr("No saved logins for %s."), origin);
+        g_signal_connect(dialog, "response", G_CALLBACK(gtk_widget_destroy), NULL);
+        gtk_widget_show(dialog);
+    } else if (logins->next == NULL) {
+        on_login_chosen(NULL, logins->data);
+    } else {
+        // Several accounts: let the user pick one by username
+        GtkWidget *menu = gtk_menu_new();
+        for (GList *login = logins; login != NULL; login = login->next) {
+            GHashTable *attributes = secret_item_get_attributes(SECRET_ITEM(login->data));
+            const char *username = g_hash_table_lookup(attributes, "username");
+            GtkWidget *item = gtk_menu_item_new_with_label(username != NULL && username[0] != '\0' ? username : "…");
+            g_hash_table_unref(attributes);
+            g_signal_connect_data(item, "activate", G_CALLBACK(on_login_chosen), g_object_ref(login->data),
+                                  (GClosureNotify)g_object_unref, 0);
+            gtk_menu_shell_append(GTK_MENU_SHELL(menu), item);
+        }
+        gtk_widget_show_all(menu);
+        gtk_menu_attach_to_widget(GTK_MENU(menu), main_window, NULL);
+        g_signal_connect(menu, "deactivate", G_CALLBACK(on_login_menu_deactivate), NULL);
+        gtk_menu_popup_at_pointer(GTK_MENU(menu), NULL);
+    }
+    g_list_free_full(logins, g_object_unref);
+    g_free(origin);
+}
+
+// Fill the login form of the page with a saved login of its origin
+static void fill_credentials(void) {
+    gchar *origin = page_origin();
+    if (autofill_weblet == NULL || origin == NULL) {
+        g_free(origin);
+        return;
+    }
+    GHashTable *attributes = secret_attributes_build(&autofill_schema, "weblet", autofill_weblet, "origin", origin, NULL);
+    secret_service_search(NULL, &autofill_schema, attributes,
+                          SECRET_SEARCH_ALL | SECRET_SEARCH_UNLOCK | SECRET_SEARCH_LOAD_SECRETS,
+                          NULL, on_logins_found, origin);
+    g_hash_table_unref(attributes);
+}
+
+static void on_fill_credentials(GtkWidget *widget, gpointer data) {
+    fill_credentials();
+}
+
+static void on_fill_credentials_action(GSimpleAction *action, GVariant *parameter, gpointer data) {
+    fill_credentials();
+}
+
+// A login the user may save, kept while the prompt is shown
+typedef struct {
+    gchar *origin;
+    gchar *username;
+    gchar *password;
+} Login;
+
+static void free_login(Login *login) {
+    g_free(login->origin);
+    g_free(login->username);
+    if (login->password != NULL) {
+        memset(login->password, 0, strlen(login->password));
+        g_free(login->password);
+    }
+    g_free(login);
+}
+
+static void on_login_stored(GObject *source, GAsyncResult *result, gpointer data) {
+    GError *error = NULL;
+    if (!secret_password_store_finish(result, &error)) {
+        g_printerr("Could not save the login in the keyring: %s\n", error->message);
+        g_error_free(error);
+    }
+}
+
+static void on_save_prompt_response(GtkDialog *dialog, gint response, gpointer data) {
+    Login *login = (Login *)data;
+    if (response == GTK_RESPONSE_ACCEPT) {
+        gchar *label = g_strdup_printf("weblet %s: %s", autofill_weblet, login->origin);
+        secret_password_store(&autofill_schema, SECRET_COLLECTION_DEFAULT, label, login->password,
+                              NULL, on_login_stored, NULL,
+                              "weblet", autofill_weblet, "origin", login->origin, "username", login->username, NULL);
+        g_free(label);
+    }
+    free_login(login);
+    gtk_widget_destroy(GTK_WIDGET(dialog));
+}
+
+// Offer to save a submitted login unless the keyring already has it
+static void on_login_lookup(GObject *source, GAsyncResult *result, gpointer data) {
+    Login *login = (Login *)data;
+    gchar *saved = secret_password_lookup_finish(result, NULL);
+    if (main_webview == NULL || autofill_prompt != NULL || g_strcmp0(saved, login->password) == 0) {
+        secret_password_free(saved);
+        free_login(login);
+        return;
+    }
+    secret_password_free(saved);
+
+    const char *account = login->username[0] != '\0' ? login->username : login->origin;
+    autofill_prompt = gtk_message_dialog_new(GTK_WINDOW(main_window), GTK_DIALOG_DESTROY_WITH_PARENT,
+                                             GTK_MESSAGE_QUESTION, GTK_BUTTONS_NONE,
+                                             tr("Save the password of %s?"), account);
+    gtk_message_dialog_format_secondary_text(GTK_MESSAGE_DIALOG(autofill_prompt),
+                                             tr("It is kept in the keyring for %s."), login->origin);
+    gtk_dialog_add_buttons(GTK_DIALOG(autofill_prompt), tr("Not Now"), GTK_RESPONSE_REJECT,
+                           tr("Save"), GTK_RESPONSE_ACCEPT, NULL);
+    gtk_dialog_set_default_response(GTK_DIALOG(autofill_prompt), GTK_RESPONSE_ACCEPT);
+    g_signal_connect(autofill_prompt, "response", G_CALLBACK(on_save_prompt_response), login);
+    g_signal_connect(autofill_prompt, "destroy", G_CALLBACK(gtk_widget_destroyed), &autofill_prompt);
+    gtk_widget_show(autofill_prompt);
+}
+
+// The autofill script posts {username, password} when a login is submitted
+static void on_autofill_message(WebKitUserContentManager *manager, WebKitJavascriptResult *result, gpointer data) {
+    JSCValue *message = webkit_javascript_result_get_js_value(result);
+    gchar *origin = page_origin();
+    if (!jsc_value_is_object(message) || origin == NULL || autofill_prompt != NULL) {
+        g_free(origin);
+        return;
+    }
+    Login *login = g_new0(Login, 1);
+    login->origin = origin;
+    login->username = bridge_string(message, "username");
+    login->password = bridge_string(message, "password");
+    if (login->password[0] == '\0') {
+        free_login(login);
+        return;
+    }
+    secret_password_lookup(&autofill_schema, NULL, on_login_lookup, login,
+                           "weblet", autofill_weblet, "origin", login->origin, "username", login->username, NULL);
+}
+
+// Mini mode shrinks the window to a small frameless one kept above other
+// windows in the bottom right corner of the screen, for a video, a player
+// or a dashboard next to other work. Ctrl+Shift+M, the menus and 'weblet
+// ctl <name> mini' toggle it; leaving it brings back the size and position
+// the window had. Compositors may ignore the position and keeping above on
+// Wayland.
+static int mini_mode = 0;
+static int mini_start = 0;
+static int mini_width = 480;
+static int mini_height = 270;
+static double mini_opacity = 1.0;
+static int normal_x = 0, normal_y = 0, normal_width = 0, normal_height = 0, normal_maximized = 0;
+
+void weblet_set_mini(int start, int width, int height, double opacity) {
+    mini_start = start;
+    if (width > 0 && height > 0) {
+        mini_width = width;
+        mini_height = height;
+    }
+    if (opacity > 0) {
+        mini_opacity = opacity;
+    }
+}
+
+static void set_mini_mode(int enabled) {
+    if (main_window == NULL || kiosk || enabled == mini_mode) {
+        return;
+    }
+    GtkWindow *window = GTK_WINDOW(main_window);
+    GtkWidget *titlebar = gtk_window_get_titlebar(window);
+    mini_mode = enabled;
+    if (enabled) {
+        normal_maximized = gtk_window_is_maximized(window);
+        gtk_window_get_position(window, &normal_x, &normal_y);
+        gtk_window_get_size(window, &normal_width, &normal_height);
+        if (normal_maximized) {
+            gtk_window_unmaximize(window);
+        }
+        if (titlebar != NULL) {
+            gtk_widget_hide(titlebar);
+        }
+        gtk_window_set_decorated(window, FALSE);
+        gtk_window_set_keep_above(window, TRUE);
+        gtk_widget_set_opacity(main_window, mini_opacity);
+        gtk_window_resize(window, mini_width, mini_height);
+
+        GdkDisplay *display = gtk_widget_get_display(main_window);
+        GdkWindow *gdk_window = gtk_widget_get_window(main_window);
+        GdkMonitor *monitor = gdk_window != NULL ? gdk_display_get_monitor_at_window(display, gdk_window)
+                                                 : gdk_display_get_primary_monitor(display);
+        if (monitor != NULL) {
+            GdkRectangle area;
+            gdk_monitor_get_workarea(monitor, &area);
+            gtk_window_move(window, area.x + area.width - mini_width - 16, area.y + area.height - mini_height - 16);
+        }
+    } else {
+        gtk_widget_set_opacity(main_window, 1.0);
+        gtk_window_set_keep_above(window, FALSE);
+        gtk_window_set_decorated(window, !undecorated);
+        if (titlebar != NULL) {
+            gtk_widget_show(titlebar);
+        }
+        gtk_window_resize(window, normal_width, normal_height);
+        gtk_window_move(window, normal_x, normal_y);
+        if (normal_maximized) {
+            gtk_window_maximize(window);
+        }
+    }
+}
+
+static gboolean toggle_mini_idle(gpointer data) {
+    set_mini_mode(!mini_mode);
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_toggle_mini enters or leaves mini mode; thread-safe
+void weblet_toggle_mini() {
+    g_idle_add(toggle_mini_idle, NULL);
+}
+
+static void on_menu_mini(GtkMenuItem *item, gpointer data) {
+    set_mini_mode(!mini_mode);
+}
+
+static void on_mini_action(GSimpleAction *action, GVariant *parameter, gpointer data) {
+    set_mini_mode(!mini_mode);
+}
+
+static gboolean on_mini_key_press(GtkWidget *widget, GdkEventKey *event, gpointer data) {
+    GdkModifierType modifiers = event->state & gtk_accelerator_get_default_mod_mask();
+    if (modifiers == (GDK_CONTROL_MASK | GDK_SHIFT_MASK) &&
+        (event->keyval == GDK_KEY_m || event->keyval == GDK_KEY_M)) {
+        set_mini_mode(!mini_mode);
+        return TRUE;
+    }
+    return FALSE;
+}
+
+// A weblet set to start in mini mode enters it once the window is shown,
+// so leaving it restores where the window opened
+static gboolean on_mini_map(GtkWidget *widget, GdkEvent *event, gpointer data) {
+    if (mini_start) {
+        mini_start = 0;
+        set_mini_mode(1);
+    }
+    return FALSE;
+}
+
+// Picture in picture: the largest playing video of the page floats in a
+// small window above other windows, e.g. a call or a talk next to other
+// work. WebKitGTK has no picture-in-picture of its own, so the pip script
+// in the isolated world draws the video's frames to a canvas and posts
+// them here as JPEG about 15 times a second. Clicking the window pauses or
+// plays the video, double-clicking goes back to the page. Videos of other
+// sites without CORS can't be read; DRM-protected ones stay black.
+static char *pip_script = NULL;
+static GtkWidget *pip_window = NULL;
+static GdkPixbuf *pip_frame = NULL;
+
+void weblet_set_pip_script(const char *script) {
+    g_free(pip_script);
+    pip_script = g_strdup(script);
+}
+
+static void toggle_pip(void) {
+    if (pip_script != NULL && main_webview != NULL && !kiosk) {
+        webkit_web_view_evaluate_javascript(main_webview, pip_script, -1, "weblet", NULL, NULL, NULL, NULL);
+    }
+}
+
+// run_pip_command calls a function of the running pip script
+static void run_pip_command(const char *command) {
+    gchar *source = g_strdup_printf("window.webletPip && window.webletPip.%s()", command);
+    webkit_web_view_evaluate_javascript(main_webview, source, -1, "weblet", NULL, NULL, NULL, NULL);
+    g_free(source);
+}
+
+static gboolean on_pip_draw(GtkWidget *widget, cairo_t *cr, gpointer data) {
+    int width = gtk_widget_get_allocated_width(widget);
+    int height = gtk_widget_get_allocated_height(widget);
+    cairo_set_source_rgb(cr, 0, 0, 0);
+    cairo_paint(cr);
+    if (pip_frame == NULL) {
+        return TRUE;
+    }
+    int frame_width = gdk_pixbuf_get_width(pip_frame);
+    int frame_height = gdk_pixbuf_get_height(pip_frame);
+    double scale = MIN((double)width / frame_width, (double)height / frame_height);
+    cairo_translate(cr, (width - frame_width * scale) / 2, (height - frame_height * scale) / 2);
+    cairo_scale(cr, scale, scale);
+    gdk_cairo_set_source_pixbuf(cr, pip_frame, 0, 0);
+    cairo_paint(cr);
+    return TRUE;
+}
+
+static gboolean on_pip_press(GtkWidget *widget, GdkEventButton *event, gpointer data) {
+    if (event->button != GDK_BUTTON_PRIMARY) {
+        return FALSE;
+    }
+    if (event->type == GDK_2BUTTON_PRESS) {
+        gtk_window_present(GTK_WINDOW(main_window));
+        gtk_widget_destroy(pip_window);
+    } else if (event->type == GDK_BUTTON_PRESS) {
+        run_pip_command("toggle");
+    }
+    return TRUE;
+}
+
+static void on_pip_destroy(GtkWidget *widget, gpointer data) {
+    pip_window = NULL;
+    g_clear_object(&pip_frame);
+    run_pip_command("stop");
+}
+
+// open_pip_window opens the window in the bottom right corner of the
+// screen, sized to the video
+static void open_pip_window(int frame_width, int frame_height) {
+    int width = 400;
+    int height = frame_width > 0 ? width * frame_height / frame_width : 225;
+    pip_window = gtk_window_new(GTK_WINDOW_TOPLEVEL);
+    gtk_window_set_title(GTK_WINDOW(pip_window), base_title != NULL ? base_title : "");
+    gtk_window_set_keep_above(GTK_WINDOW(pip_window), TRUE);
+    gtk_window_set_default_size(GTK_WINDOW(pip_window), width, height);
+
+    GtkWidget *area = gtk_drawing_area_new();
+    gtk_widget_add_events(area, GDK_BUTTON_PRESS_MASK);
+    g_signal_connect(area, "draw", G_CALLBACK(on_pip_draw), NULL);
+    g_signal_connect(area, "button-press-event", G_CALLBACK(on_pip_press), NULL);
+    gtk_container_add(GTK_CONTAINER(pip_window), area);
+    g_signal_connect(pip_window, "destroy", G_CALLBACK(on_pip_destroy), NULL);
+
+    GdkDisplay *display = gtk_widget_get_display(main_window);
+    GdkWindow *gdk_window = gtk_widget_get_window(main_window);
+    GdkMonitor *monitor = gdk_window != NULL ? gdk_display_get_monitor_at_window(display, gdk_window)
+                                             : gdk_display_get_primary_monitor(display);
+    if (monitor != NULL) {
+        GdkRectangle workarea;
+        gdk_monitor_get_workarea(monitor, &workarea);
+        gtk_window_move(GTK_WINDOW(pip_window), workarea.x + workarea.width - width - 16,
+                        workarea.y + workarea.height - height - 16);
+    }
+    gtk_widget_show_all(pip_window);
+}
+
+// Messages of the pip script: a frame as a data: URL, "none" without a
+// video, "protected" for a video that can't be read, "stopped" at the end
+static void on_pip_message(WebKitUserContentManager *manager, WebKitJavascriptResult *result, gpointer data) {
+    static const char frame_prefix[] = "data:image/jpeg;base64,";
+    JSCValue *message = webkit_javascript_result_get_js_value(result);
+    if (!jsc_value_is_string(message)) {
+        return;
+    }
+    gchar *text = jsc_value_to_string(message);
+    if (g_str_has_prefix(text, frame_prefix)) {
+        gsize length = 0;
+        guchar *jpeg = g_base64_decode_inplace(text + strlen(frame_prefix), &length);
+        GdkPixbufLoader *loader = gdk_pixbuf_loader_new();
+        if (gdk_pixbuf_loader_write(loader, jpeg, length, NULL) && gdk_pixbuf_loader_close(loader, NULL)) {
+            GdkPixbuf *frame = gdk_pixbuf_loader_get_pixbuf(loader);
+            if (frame != NULL) {
+                if (pip_window == NULL) {
+                    open_pip_window(gdk_pixbuf_get_width(frame), gdk_pixbuf_get_height(frame));
+                }
+                g_clear_object(&pip_frame);
+                pip_frame = g_object_ref(frame);
+                gtk_widget_queue_draw(pip_window);
+            }
+        } else {
+            gdk_pixbuf_loader_close(loader, NULL);
+        }
+        g_object_unref(loader);
+    } else if (strcmp(text, "none") == 0) {
+        show_toast(tr("No video is playing"));
+    } else if (strcmp(text, "protected") == 0) {
+        show_toast(tr("This video can't be shown in picture in picture"));
+    }
+    if (!g_str_has_prefix(text, frame_prefix) && pip_window != NULL) {
+        gtk_widget_destroy(pip_window);
+    }
+    g_free(text);
+}
+
+// A new page ends the pip script with the old one
+static void on_pip_load_changed(WebKitWebView *web_view, WebKitLoadEvent event, gpointer data) {
+    if (event == WEBKIT_LOAD_STARTED && pip_window != NULL) {
+        gtk_widget_destroy(pip_window);
+    }
+}
+
+static gboolean toggle_pip_idle(gpointer data) {
+    toggle_pip();
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_toggle_pip shows the largest playing video in picture in picture,
+// or ends it; thread-safe
+void weblet_toggle_pip() {
+    g_idle_add(toggle_pip_idle, NULL);
+}
+
+static void on_menu_pip(GtkMenuItem *item, gpointer data) {
+    toggle_pip();
+}
+
+static void on_pip_action(GSimpleAction *action, GVariant *parameter, gpointer data) {
+    toggle_pip();
+}
+
+static gboolean on_pip_key_press(GtkWidget *widget, GdkEventKey *event, gpointer data) {
+    GdkModifierType modifiers = event->state & gtk_accelerator_get_default_mod_mask();
+    if (modifiers == (GDK_CONTROL_MASK | GDK_MOD1_MASK) &&
+        (event->keyval == GDK_KEY_p || event->keyval == GDK_KEY_P)) {
+        toggle_pip();
+        return TRUE;
+    }
+    return FALSE;
+}
+
+// Adds "Open in Browser" to the page's context menu, and "Fill Credentials"
+// to that of text fields with autofill
+static gboolean on_page_context_menu(WebKitWebView *web_view, WebKitContextMenu *menu,
+                                     GdkEvent *event, WebKitHitTestResult *hit, gpointer user_data) {
+    static GSimpleAction *action = NULL;
+    static GSimpleAction *fill_action = NULL;
+    static GSimpleAction *mini_action = NULL;
+    static GSimpleAction *pip_action = NULL;
+    if (action == NULL) {
+        action = g_simple_action_new("open-in-browser", NULL);
+        g_signal_connect(action, "activate", G_CALLBACK(on_open_in_browser_action), NULL);
+        fill_action = g_simple_action_new("fill-credentials", NULL);
+        g_signal_connect(fill_action, "activate", G_CALLBACK(on_fill_credentials_action), NULL);
+        mini_action = g_simple_action_new("mini-mode", NULL);
+        g_signal_connect(mini_action, "activate", G_CALLBACK(on_mini_action), NULL);
+        pip_action = g_simple_action_new("picture-in-picture", NULL);
+        g_signal_connect(pip_action, "activate", G_CALLBACK(on_pip_action), NULL);
+    }
+    webkit_context_menu_append(menu, webkit_context_menu_item_new_separator());
+    if (autofill_weblet != NULL && webkit_hit_test_result_context_is_editable(hit)) {
+        webkit_context_menu_append(menu, webkit_context_menu_item_new_from_gaction(
+            G_ACTION(fill_action), tr("Fill Credentials"), NULL));
+    }
+    webkit_context_menu_append(menu, webkit_context_menu_item_new_from_gaction(
+        G_ACTION(action), tr("Open in Browser"), NULL));
+    webkit_context_menu_append(menu, webkit_context_menu_item_new_from_gaction(
+        G_ACTION(mini_action), mini_mode ? tr("Leave Mini Mode") : tr("Mini Mode"), NULL));
+    if (pip_script != NULL) {
+        webkit_context_menu_append(menu, webkit_context_menu_item_new_from_gaction(
+            G_ACTION(pip_action), pip_window != NULL ? tr("Close Picture in Picture") : tr("Picture in Picture"), NULL));
+    }
+    return FALSE;
+}
+
+static void append_menu_item(GtkWidget *menu, const char *label, GCallback callback) {
+    GtkWidget *item = gtk_menu_item_new_with_label(label);
+    g_signal_connect(item, "activate", callback, NULL);
+    gtk_menu_shell_append(GTK_MENU_SHELL(menu), item);
+}
+
+// Quick links shown in the header bar menu
+static GPtrArray *link_names = NULL;
+static GPtrArray *link_urls = NULL;
+
+void weblet_add_link(const char *name, const char *url) {
+    if (link_names == NULL) {
+        link_names = g_ptr_array_new_with_free_func(g_free);
+        link_urls = g_ptr_array_new_with_free_func(g_free);
+    }
+    g_ptr_array_add(link_names, g_strdup(name));
+    g_ptr_array_add(link_urls, g_strdup(url));
+}
+
+static void on_menu_link(GtkMenuItem *item, gpointer data) {
+    load_page(main_webview, (const char *)data);
+}
+
+static gboolean open_uri_idle(gpointer data) {
+    gchar *uri = (gchar *)data;
+    if (app_running && main_webview != NULL) {
+        load_page(main_webview, uri);
+        gtk_window_present(GTK_WINDOW(main_window));
+    }
+    g_free(uri);
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_open_uri shows a page (a quick link) and raises the window;
+// thread-safe
+void weblet_open_uri(const char *uri) {
+    g_idle_add(open_uri_idle, g_strdup(uri));
+}
+
+// Navigation history (history setting): pages on the weblet's own site are
+// recorded in SQLite and searched with Ctrl+H or 'weblet history'
+static char *history_file = NULL;
+static sqlite3 *history_db = NULL;
+static sqlite3_int64 last_visit = 0;
+static char *last_visit_uri = NULL;
+
+void weblet_set_history_file(const char *path) {
+    g_free(history_file);
+    history_file = g_strdup(path);
+}
+
+static void open_history(void) {
+    if (history_file == NULL) {
+        return;
+    }
+    if (sqlite3_open(history_file, &history_db) != SQLITE_OK) {
+        g_printerr("Could not open history: %s\n", sqlite3_errmsg(history_db));
+        sqlite3_close(history_db);
+        history_db = NULL;
+        return;
+    }
+    sqlite3_busy_timeout(history_db, 1000);
+    sqlite3_exec(history_db,
+        "CREATE TABLE IF NOT EXISTS visits (id INTEGER PRIMARY KEY, url TEXT NOT NULL, title TEXT,"
+        " visited_at INTEGER NOT NULL);"
+        "CREATE INDEX IF NOT EXISTS visits_visited_at ON visits (visited_at);",
+        NULL, NULL, NULL);
+}
+
+// Pages of the start host, its parent or subdomains, the allow patterns and
+// the local directory are in scope; error and blank pages are not
+static gboolean history_in_scope(const char *uri) {
+    if (g_str_has_prefix(uri, "weblet-local:")) {
+        return TRUE;
+    }
+    GUri *parsed = g_uri_parse(uri, G_URI_FLAGS_NONE, NULL);
+    if (parsed == NULL) {
+        return FALSE;
+    }
+    const char *scheme = g_uri_get_scheme(parsed);
+    const char *host = g_uri_get_host(parsed);
+    gboolean in_scope = FALSE;
+    if (host != NULL && start_host != NULL &&
+        (g_ascii_strcasecmp(scheme, "http") == 0 || g_ascii_strcasecmp(scheme, "https") == 0)) {
+        gchar *sub = g_strconcat(".", start_host, NULL);
+        gchar *parent = g_strconcat(".", host, NULL);
+        in_scope = g_ascii_strcasecmp(host, start_host) == 0 || g_str_has_suffix(host, sub) ||
+                   g_str_has_suffix(start_host, parent) || nav_pattern_matches(allow_patterns, uri, host);
+        g_free(sub);
+        g_free(parent);
+    }
+    g_uri_unref(parsed);
+    return in_scope;
+}
+
+static void record_visit(WebKitWebView *web_view) {
+    const char *uri = webkit_web_view_get_uri(web_view);
+    if (history_db == NULL || uri == NULL || g_strcmp0(uri, last_visit_uri) == 0 || !history_in_scope(uri)) {
+        return;
+    }
+    sqlite3_stmt *stmt;
+    if (sqlite3_prepare_v2(history_db, "INSERT INTO visits (url, title, visited_at) VALUES (?, ?, ?)",
+                           -1, &stmt, NULL) != SQLITE_OK) {
+        return;
+    }
+    sqlite3_bind_text(stmt, 1, uri, -1, SQLITE_TRANSIENT);
+    sqlite3_bind_text(stmt, 2, webkit_web_view_get_title(web_view), -1, SQLITE_TRANSIENT);
+    sqlite3_bind_int64(stmt, 3, g_get_real_time() / G_USEC_PER_SEC);
+    if (sqlite3_step(stmt) == SQLITE_DONE) {
+        last_visit = sqlite3_last_insert_rowid(history_db);
+        g_free(last_visit_uri);
+        last_visit_uri = g_strdup(uri);
+    }
+    sqlite3_finalize(stmt);
+}
+
+// Full loads are recorded once committed (after redirects), in-page
+// navigations of single-page apps when the URL changes outside a load
+static void on_history_load_changed(WebKitWebView *web_view, WebKitLoadEvent event, gpointer data) {
+    if (event == WEBKIT_LOAD_COMMITTED) {
+        record_visit(web_view);
+    }
+}
+
+static void on_history_uri_changed(WebKitWebView *web_view, GParamSpec *pspec, gpointer data) {
+    if (!webkit_web_view_is_loading(web_view)) {
+        record_visit(web_view);
+    }
+}
+
+// Titles usually arrive after the visit was recorded
+static void on_history_title_changed(WebKitWebView *web_view, GParamSpec *pspec, gpointer data) {
+    const char *title = webkit_web_view_get_title(web_view);
+    if (history_db == NULL || last_visit == 0 || title == NULL || title[0] == '\0' ||
+        g_strcmp0(webkit_web_view_get_uri(web_view), last_visit_uri) != 0) {
+        return;
+    }
+    sqlite3_stmt *stmt;
+    if (sqlite3_prepare_v2(history_db, "UPDATE visits SET title = ? WHERE id = ?", -1, &stmt, NULL) == SQLITE_OK) {
+        sqlite3_bind_text(stmt, 1, title, -1, SQLITE_TRANSIENT);
+        sqlite3_bind_int64(stmt, 2, last_visit);
+        sqlite3_step(stmt);
+        sqlite3_finalize(stmt);
+    }
+}
+
+static GtkWidget *history_dialog = NULL;
+static GtkWidget *history_list = NULL;
+
+// Fill the history list with the latest visit of each page matching query
+static void fill_history_list(const char *query) {
+    GList *rows = gtk_container_get_children(GTK_CONTAINER(history_list));
+    for (GList *row = rows; row != NULL; row = row->next) {
+        gtk_widget_destroy(GTK_WIDGET(row->data));
+    }
+    g_list_free(rows);
+
+    sqlite3_stmt *stmt;
+    if (sqlite3_prepare_v2(history_db,
+            "SELECT url, ifnull(title, ''), max(visited_at) FROM visits"
+            " WHERE ?1 = '' OR instr(lower(url), lower(?1)) > 0 OR instr(lower(title), lower(?1)) > 0"
+            " GROUP BY url ORDER BY 3 DESC LIMIT 200", -1, &stmt, NULL) != SQLITE_OK) {
+        return;
+    }
+    sqlite3_bind_text(stmt, 1, query, -1, SQLITE_TRANSIENT);
+    while (sqlite3_step(stmt) == SQLITE_ROW) {
+        const char *url = (const char *)sqlite3_column_text(stmt, 0);
+        const char *title = (const char *)sqlite3_column_text(stmt, 1);
+        GDateTime *visited = g_date_time_new_from_unix_local(sqlite3_column_int64(stmt, 2));
+        gchar *when = g_date_time_format(visited, "%x %H:%M");
+        g_date_time_unref(visited);
+
+        gchar *escaped_title = g_markup_escape_text(title[0] != '\0' ? title : url, -1);
+        gchar *escaped_url = g_markup_escape_text(url, -1);
+        gchar *markup = g_strdup_printf("<b>%s</b>\n<small>%s · %s</small>", escaped_title, when, escaped_url);
+        GtkWidget *label = gtk_label_new(NULL);
+        gtk_label_set_markup(GTK_LABEL(label), markup);
+        gtk_label_set_xalign(GTK_LABEL(label), 0);
+        gtk_label_set_ellipsize(GTK_LABEL(label), PANGO_ELLIPSIZE_END);
+        gtk_widget_set_margin_start(label, 6);
+        gtk_widget_set_margin_end(label, 6);
+        gtk_widget_set_margin_top(label, 4);
+        gtk_widget_set_margin_bottom(label, 4);
+        g_free(markup);
+        g_free(escaped_url);
+        g_free(escaped_title);
+        g_free(when);
+
+        GtkWidget *row = gtk_list_box_row_new();
+        gtk_container_add(GTK_CONTAINER(row), label);
+        g_object_set_data_full(G_OBJECT(row), "url", g_strdup(url), g_free);
+        gtk_container_add(GTK_CONTAINER(history_list), row);
+    }
+    sqlite3_finalize(stmt);
+    gtk_widget_show_all(history_list);
+}
+
+static void on_history_search_changed(GtkSearchEntry *entry, gpointer data) {
+    fill_history_list(gtk_entry_get_text(GTK_ENTRY(entry)));
+}
+
+// Enter in the search field opens the first page
+static void on_history_search_activate(GtkEntry *entry, gpointer data) {
+    GtkListBoxRow *row = gtk_list_box_get_row_at_index(GTK_LIST_BOX(history_list), 0);
+    if (row != NULL) {
+        g_signal_emit_by_name(history_list, "row-activated", row);
+    }
+}
+
+static void on_history_row_activated(GtkListBox *list, GtkListBoxRow *row, gpointer data) {
+    load_page(main_webview, g_object_get_data(G_OBJECT(row), "url"));
+    gtk_widget_destroy(history_dialog);
+}
+
+static void on_history_response(GtkDialog *dialog, gint response, gpointer data) {
+    gtk_widget_destroy(GTK_WIDGET(dialog));
+}
+
+static void show_history(void) {
+    if (history_db == NULL || main_webview == NULL) {
+        return;
+    }
+    if (history_dialog != NULL) {
+        gtk_window_present(GTK_WINDOW(history_dialog));
+        return;
+    }
+    history_dialog = gtk_dialog_new();
+    gtk_window_set_title(GTK_WINDOW(history_dialog), tr("History"));
+    gtk_window_set_transient_for(GTK_WINDOW(history_dialog), GTK_WINDOW(main_window));
+    gtk_window_set_modal(GTK_WINDOW(history_dialog), TRUE);
+    gtk_window_set_default_size(GTK_WINDOW(history_dialog), 560, 480);
+    g_signal_connect(history_dialog, "response", G_CALLBACK(on_history_response), NULL);
+    g_signal_connect(history_dialog, "destroy", G_CALLBACK(gtk_widget_destroyed), &history_dialog);
+
+    GtkWidget *search = gtk_search_entry_new();
+    gtk_entry_set_placeholder_text(GTK_ENTRY(search), tr("Search history"));
+    g_signal_connect(search, "search-changed", G_CALLBACK(on_history_search_changed), NULL);
+    g_signal_connect(search, "activate", G_CALLBACK(on_history_search_activate), NULL);
+
+    history_list = gtk_list_box_new();
+    gtk_list_box_set_activate_on_single_click(GTK_LIST_BOX(history_list), TRUE);
+    g_signal_connect(history_list, "row-activated", G_CALLBACK(on_history_row_activated), NULL);
+    GtkWidget *scrolled = gtk_scrolled_window_new(NULL, NULL);
+    gtk_widget_set_vexpand(scrolled, TRUE);
+    gtk_container_add(GTK_CONTAINER(scrolled), history_list);
+
+    GtkWidget *content = gtk_dialog_get_content_area(GTK_DIALOG(history_dialog));
+    gtk_box_set_spacing(GTK_BOX(content), 6);
+    gtk_container_set_border_width(GTK_CONTAINER(content), 6);
+    gtk_box_pack_start(GTK_BOX(content), search, FALSE, FALSE, 0);
+    gtk_box_pack_start(GTK_BOX(content), scrolled, TRUE, TRUE, 0);
+
+    fill_history_list("");
+    gtk_widget_show_all(history_dialog);
+    gtk_widget_grab_focus(search);
+}
+
+static void on_menu_history(GtkMenuItem *item, gpointer data) {
+    show_history();
+}
+
+// Ctrl+H opens the history, before the page sees the key
+static gboolean on_key_press(GtkWidget *widget, GdkEventKey *event, gpointer data) {
+    GdkModifierType modifiers = event->state & gtk_accelerator_get_default_mod_mask();
+    if (history_db != NULL && modifiers == GDK_CONTROL_MASK &&
+        (event->keyval == GDK_KEY_h || event->keyval == GDK_KEY_H)) {
+        show_history();
+        return TRUE;
+    }
+    return FALSE;
+}
+
+// Design tools in the header bar menu, for weblets of design tools without
+// the inspector: a color picker copying the color of the pixel clicked, a
+// ruler showing the size of the element under the pointer or of a dragged
+// area, and window sizes giving the page the viewport of common devices.
+static GtkWidget *pick_layer = NULL;
+static char *ruler_script = NULL;
+
+void weblet_set_ruler_script(const char *script) {
+    g_free(ruler_script);
+    ruler_script = g_strdup(script);
+}
+
+static void on_pick_snapshot(GObject *source, GAsyncResult *result, gpointer data) {
+    int *point = data;
+    cairo_surface_t *snapshot = webkit_web_view_get_snapshot_finish(WEBKIT_WEB_VIEW(source), result, NULL);
+    if (snapshot == NULL) {
+        g_free(point);
+        return;
+    }
+    cairo_surface_flush(snapshot);
+    int width = cairo_image_surface_get_width(snapshot);
+    int height = cairo_image_surface_get_height(snapshot);
+    int view_width = gtk_widget_get_allocated_width(GTK_WIDGET(main_webview));
+    cairo_format_t format = cairo_image_surface_get_format(snapshot);
+    if (width > 0 && height > 0 && view_width > 0 && (format == CAIRO_FORMAT_ARGB32 || format == CAIRO_FORMAT_RGB24)) {
+        // The snapshot has the device pixels of the view
+        double scale = (double)width / view_width;
+        int x = CLAMP((int)(point[0] * scale), 0, width - 1);
+        int y = CLAMP((int)(point[1] * scale), 0, height - 1);
+        guint32 pixel = *(guint32 *)(cairo_image_surface_get_data(snapshot) +
+                                     y * cairo_image_surface_get_stride(snapshot) + x * 4);
+        guint alpha = format == CAIRO_FORMAT_ARGB32 ? pixel >> 24 : 255;
+        guint red = (pixel >> 16) & 0xff, green = (pixel >> 8) & 0xff, blue = pixel & 0xff;
+        // Colors are stored premultiplied
+        if (alpha > 0 && alpha < 255) {
+            red = red * 255 / alpha;
+            green = green * 255 / alpha;
+            blue = blue * 255 / alpha;
+        }
+        gchar *color = g_strdup_printf("#%02x%02x%02x", red, green, blue);
+        gtk_clipboard_set_text(gtk_clipboard_get(GDK_SELECTION_CLIPBOARD), color, -1);
+        gchar *text = g_strdup_printf(tr("Copied color %s"), color);
+        show_toast(text);
+        g_free(text);
+        g_free(color);
+    }
+    cairo_surface_destroy(snapshot);
+    g_free(point);
+}
+
+// The pick layer covers the page while picking, so the click doesn't reach it
+static gboolean on_pick_press(GtkWidget *widget, GdkEventButton *event, gpointer data) {
+    gtk_widget_hide(pick_layer);
+    if (event->button == GDK_BUTTON_PRIMARY) {
+        int *point = g_new(int, 2);
+        point[0] = (int)event->x;
+        point[1] = (int)event->y;
+        webkit_web_view_get_snapshot(main_webview, WEBKIT_SNAPSHOT_REGION_VISIBLE, WEBKIT_SNAPSHOT_OPTIONS_NONE,
+                                     NULL, on_pick_snapshot, point);
+    }
+    return TRUE;
+}
+
+static void on_pick_realize(GtkWidget *widget, gpointer data) {
+    GdkCursor *cursor = gdk_cursor_new_from_name(gtk_widget_get_display(widget), "crosshair");
+    gdk_window_set_cursor(gtk_widget_get_window(widget), cursor);
+    if (cursor != NULL) {
+        g_object_unref(cursor);
+    }
+}
+
+static GtkWidget *build_pick_layer(void) {
+    pick_layer = gtk_event_box_new();
+    gtk_event_box_set_visible_window(GTK_EVENT_BOX(pick_layer), FALSE);
+    gtk_event_box_set_above_child(GTK_EVENT_BOX(pick_layer), TRUE);
+    gtk_widget_add_events(pick_layer, GDK_BUTTON_PRESS_MASK);
+    gtk_widget_set_no_show_all(pick_layer, TRUE);
+    g_signal_connect(pick_layer, "button-press-event", G_CALLBACK(on_pick_press), NULL);
+    g_signal_connect(pick_layer, "realize", G_CALLBACK(on_pick_realize), NULL);
+    return pick_layer;
+}
+
+static void on_menu_pick_color(GtkMenuItem *item, gpointer data) {
+    gtk_widget_show(pick_layer);
+    show_toast(tr("Click to copy a color, Esc cancels"));
+}
+
+static void on_menu_ruler(GtkMenuItem *item, gpointer data) {
+    if (ruler_script != NULL) {
+        // The isolated world keeps the ruler away from the page's scripts
+        webkit_web_view_evaluate_javascript(main_webview, ruler_script, -1, "weblet", NULL, NULL, NULL, NULL);
+    }
+}
+
+// Viewport sizes of common devices, in CSS pixels
+static const struct {
+    const char *name;
+    int width;
+    int height;
+} viewport_presets[] = {
+    {"Phone", 375, 667},
+    {"Large Phone", 414, 896},
+    {"Tablet", 768, 1024},
+    {"Laptop", 1366, 768},
+    {"Desktop", 1920, 1080},
+};
+
+// The window is resized by the difference of the page's size, so the
+// header bar and borders are left out; the page zoom scales CSS pixels
+static void on_menu_viewport(GtkMenuItem *item, gpointer data) {
+    int preset = GPOINTER_TO_INT(data);
+    double zoom = webkit_web_view_get_zoom_level(main_webview);
+    int width = (int)(viewport_presets[preset].width * zoom + 0.5);
+    int height = (int)(viewport_presets[preset].height * zoom + 0.5);
+    GtkWindow *window = GTK_WINDOW(main_window);
+    gtk_window_unmaximize(window);
+    int window_width, window_height;
+    gtk_window_get_size(window, &window_width, &window_height);
+    GtkAllocation view;
+    gtk_widget_get_allocation(GTK_WIDGET(main_webview), &view);
+    gtk_window_resize(window, MAX(1, window_width - view.width + width), MAX(1, window_height - view.height + height));
+    gchar *text = g_strdup_printf(tr("Viewport %d × %d"), viewport_presets[preset].width, viewport_presets[preset].height);
+    show_toast(text);
+    g_free(text);
+}
+
+// Esc stops picking a color
+static gboolean on_tools_key_press(GtkWidget *widget, GdkEventKey *event, gpointer data) {
+    if (event->keyval == GDK_KEY_Escape && pick_layer != NULL && gtk_widget_get_visible(pick_layer)) {
+        gtk_widget_hide(pick_layer);
+        return TRUE;
+    }
+    return FALSE;
+}
+
+static GtkWidget *build_tools_menu(void) {
+    GtkWidget *menu = gtk_menu_new();
+    append_menu_item(menu, tr("Pick Color"), G_CALLBACK(on_menu_pick_color));
+    append_menu_item(menu, tr("Ruler"), G_CALLBACK(on_menu_ruler));
+
+    GtkWidget *sizes = gtk_menu_new();
+    for (guint i = 0; i < G_N_ELEMENTS(viewport_presets); i++) {
+        gchar *label = g_strdup_printf("%s (%d × %d)", tr(viewport_presets[i].name),
+                                       viewport_presets[i].width, viewport_presets[i].height);
+        GtkWidget *item = gtk_menu_item_new_with_label(label);
+        g_signal_connect(item, "activate", G_CALLBACK(on_menu_viewport), GINT_TO_POINTER(i));
+        gtk_menu_shell_append(GTK_MENU_SHELL(sizes), item);
+        g_free(label);
+    }
+    GtkWidget *sizes_item = gtk_menu_item_new_with_label(tr("Viewport Size"));
+    gtk_menu_item_set_submenu(GTK_MENU_ITEM(sizes_item), sizes);
+    gtk_menu_shell_append(GTK_MENU_SHELL(menu), sizes_item);
+    return menu;
+}
+
+static GtkWidget *build_header_bar(const char *title, const char *icon_path) {
+    GtkWidget *header = gtk_header_bar_new();
+    gtk_header_bar_set_show_close_button(GTK_HEADER_BAR(header), TRUE);
+
+    // App icon
+    if (icon_path != NULL && icon_path[0] != '\0') {
+        GdkPixbuf *icon = gdk_pixbuf_new_from_file_at_scale(icon_path, 20, 20, TRUE, NULL);
+        if (icon != NULL) {
+            gtk_header_bar_pack_start(GTK_HEADER_BAR(header), gtk_image_new_from_pixbuf(icon));
+            g_object_unref(icon);
+        }
+    }
+
+    // Title with unread pill
+    GtkWidget *title_box = gtk_box_new(GTK_ORIENTATION_HORIZONTAL, 6);
+    title_label = gtk_label_new(title);
+    gtk_style_context_add_class(gtk_widget_get_style_context(title_label), "title");
+    badge_label = gtk_label_new(NULL);
+    gtk_style_context_add_class(gtk_widget_get_style_context(badge_label), "weblet-badge");
+    gtk_widget_set_no_show_all(badge_label, TRUE);
+    gtk_box_pack_start(GTK_BOX(title_box), title_label, FALSE, FALSE, 0);
+    gtk_box_pack_start(GTK_BOX(title_box), badge_label, FALSE, FALSE, 0);
+    gtk_header_bar_set_custom_title(GTK_HEADER_BAR(header), title_box);
+
+    // Overflow menu
+    GtkWidget *menu = gtk_menu_new();
+    append_menu_item(menu, tr("Back"), G_CALLBACK(on_menu_back));
+    append_menu_item(menu, tr("Forward"), G_CALLBACK(on_menu_forward));
+    append_menu_item(menu, tr("Reload"), G_CALLBACK(on_menu_reload));
+    append_menu_item(menu, tr("Open in Browser"), G_CALLBACK(on_menu_open_in_browser));
+    if (history_db != NULL) {
+        append_menu_item(menu, tr("History"), G_CALLBACK(on_menu_history));
+    }
+    append_menu_item(menu, tr("Mini Mode"), G_CALLBACK(on_menu_mini));
+    if (pip_script != NULL) {
+        append_menu_item(menu, tr("Picture in Picture"), G_CALLBACK(on_menu_pip));
+    }
+    GtkWidget *tools_item = gtk_menu_item_new_with_label(tr("Tools"));
+    gtk_menu_item_set_submenu(GTK_MENU_ITEM(tools_item), build_tools_menu());
+    gtk_menu_shell_append(GTK_MENU_SHELL(menu), tools_item);
+    if (link_names != NULL) {
+        gtk_menu_shell_append(GTK_MENU_SHELL(menu), gtk_separator_menu_item_new());
+        for (guint i = 0; i < link_names->len; i++) {
+            GtkWidget *item = gtk_menu_item_new_with_label(g_ptr_array_index(link_names, i));
+            g_signal_connect(item, "activate", G_CALLBACK(on_menu_link), g_ptr_array_index(link_urls, i));
+            gtk_menu_shell_append(GTK_MENU_SHELL(menu), item);
+        }
+    }
+    gtk_widget_show_all(menu);
+
+    GtkWidget *menu_button = gtk_menu_button_new();
+    gtk_menu_button_set_popup(GTK_MENU_BUTTON(menu_button), menu);
+    gtk_button_set_image(GTK_BUTTON(menu_button),
+                         gtk_image_new_from_icon_name("open-menu-symbolic", GTK_ICON_SIZE_BUTTON));
+    gtk_header_bar_pack_end(GTK_HEADER_BAR(header), menu_button);
+
+    if (autofill_weblet != NULL) {
+        GtkWidget *fill_button = gtk_button_new_from_icon_name("dialog-password-symbolic", GTK_ICON_SIZE_BUTTON);
+        gtk_widget_set_tooltip_text(fill_button, tr("Fill Credentials"));
+        g_signal_connect(fill_button, "clicked", G_CALLBACK(on_fill_credentials), NULL);
+        gtk_header_bar_pack_end(GTK_HEADER_BAR(header), fill_button);
+    }
+
+    GtkCssProvider *css = gtk_css_provider_new();
+    gtk_css_provider_load_from_data(css,
+        ".weblet-badge { background-color: @theme_selected_bg_color; color: @theme_selected_fg_color;"
+        " border-radius: 9px; padding: 0 6px; font-size: smaller; font-weight: bold; }",
+        -1, NULL);
+    gtk_style_context_add_provider_for_screen(gdk_screen_get_default(), GTK_STYLE_PROVIDER(css),
+                                              GTK_STYLE_PROVIDER_PRIORITY_APPLICATION);
+    g_object_unref(css);
+
+    // Tint the header bar with the site's theme color
+    if (theme_color != NULL) {
+        gtk_style_context_add_class(gtk_widget_get_style_context(header), "weblet-themed");
+        gchar *theme_css = g_strdup_printf(
+            "headerbar.weblet-themed { background: %s; border-color: %s; box-shadow: none; }"
+            " headerbar.weblet-themed, headerbar.weblet-themed label, headerbar.weblet-themed button { color: %s; }",
+            theme_color, theme_color, theme_text_color);
+        GtkCssProvider *theme = gtk_css_provider_new();
+        gtk_css_provider_load_from_data(theme, theme_css, -1, NULL);
+        gtk_style_context_add_provider_for_screen(gdk_screen_get_default(), GTK_STYLE_PROVIDER(theme),
+                                                  GTK_STYLE_PROVIDER_PRIORITY_APPLICATION);
+        g_object_unref(theme);
+        g_free(theme_css);
+    }
+
+    return header;
+}
+
+// Monitors with different pixel densities. On Wayland every monitor has its
+// own scale factor; WebKit renders at the new one when the window moves, the
+// window is only redrawn so no stale, blurry tiles stay. On X11 the scale
+// factor is the same everywhere (that of the primary monitor), so the page
+// is zoomed by how much denser or coarser the monitor under the window is,
+// and a page dragged from a HiDPI panel to a 1080p monitor keeps its size.
+static GdkMonitor *current_monitor = NULL;
+
+// Page zoom of the weblet, which the monitor density is applied on top of
+static double page_zoom = 1.0;
+
+void weblet_set_zoom(double zoom) {
+    page_zoom = zoom;
+}
+
+// monitor_density returns the monitor's pixels per 96 DPI, in steps of a
+// quarter like the display settings offer, or 0 if it isn't known
+static double monitor_density(GdkMonitor *monitor) {
+    GdkRectangle geometry;
+    gdk_monitor_get_geometry(monitor, &geometry);
+    int width_mm = gdk_monitor_get_width_mm(monitor);
+    if (width_mm <= 0 || geometry.width <= 0) {
+        return 0; // Projectors and some virtual monitors report no size
+    }
+    // The geometry is in application pixels, the device has scale times more
+    double dpi = geometry.width * gdk_monitor_get_scale_factor(monitor) / (width_mm / 25.4);
+    double density = MAX(1.0, (int)(dpi / 96.0 * 4 + 0.5) / 4.0);
+    return MIN(density, 4.0);
+}
+
+static void update_monitor_scale(void) {
+    GdkWindow *gdk_window = gtk_widget_get_window(main_window);
+    if (gdk_window == NULL || main_webview == NULL) {
+        return;
+    }
+    GdkDisplay *display = gdk_window_get_display(gdk_window);
+    GdkMonitor *monitor = gdk_display_get_monitor_at_window(display, gdk_window);
+    if (monitor == NULL || monitor == current_monitor) {
+        return;
+    }
+    current_monitor = monitor;
+
+    if (GDK_IS_X11_WINDOW(gdk_window)) {
+        GdkMonitor *primary = gdk_display_get_primary_monitor(display);
+        if (primary == NULL) {
+            primary = gdk_display_get_monitor(display, 0);
+        }
+        double density = monitor_density(monitor);
+        double primary_density = monitor_density(primary);
+        if (density > 0 && primary_density > 0) {
+            webkit_web_view_set_zoom_level(main_webview, page_zoom * density / primary_density);
+        }
+    }
+    gtk_widget_queue_draw(GTK_WIDGET(main_webview));
+}
+
+static void on_scale_factor_changed(GtkWidget *widget, GParamSpec *pspec, gpointer data) {
+    current_monitor = NULL;
+    update_monitor_scale();
+}
+
+// Window state (size, maximized and the last page) is kept in
+// window-state.ini in the data directory. The last page is only restored
+// when the window was closed because the session ended, a normal launch
+// still starts at the weblet's URL.
+static gchar *state_file = NULL;
+static int window_width = 0;
+static int window_height = 0;
+static int window_maximized = 0;
+static int session_ending = 0;
+
+// A size given for one launch ('weblet run --window') wins over the saved
+// one, which is kept
+static int temporary_size = 0;
+
+void weblet_set_temporary_size(int enabled) {
+    temporary_size = enabled;
+}
+
+// A window opens maximized when the weblet is set to, until it was left at
+// another state
+void weblet_set_maximized(int enabled) {
+    window_maximized = enabled;
+}
+
+static gboolean on_configure(GtkWidget *widget, GdkEventConfigure *event, gpointer data) {
+    if (!window_maximized && !kiosk && !mini_mode) {
+        gtk_window_get_size(GTK_WINDOW(widget), &window_width, &window_height);
+    }
+    update_monitor_scale();
+    return FALSE;
+}
+
+static gboolean on_window_state(GtkWidget *widget, GdkEventWindowState *event, gpointer data) {
+    if (mini_mode) {
+        return FALSE;
+    }
+    window_maximized = (event->new_window_state & GDK_WINDOW_STATE_MAXIMIZED) != 0;
+    return FALSE;
+}
+
+static void save_window_state(void) {
+    if (state_file == NULL) {
+        return;
+    }
+    GKeyFile *state = g_key_file_new();
+    if (temporary_size) {
+        g_key_file_load_from_file(state, state_file, G_KEY_FILE_NONE, NULL);
+        g_key_file_remove_group(state, "page", NULL);
+    } else {
+        if (window_width > 0 && window_height > 0) {
+            g_key_file_set_integer(state, "window", "width", window_width);
+            g_key_file_set_integer(state, "window", "height", window_height);
+        }
+        g_key_file_set_boolean(state, "window", "maximized", window_maximized);
+    }
+
+    // Hardened (Tor) weblets don't leave their browsing behind
+    if (session_ending && !hardened && main_webview != NULL) {
+        const char *uri = webkit_web_view_get_uri(main_webview);
+        if (uri != NULL && (g_str_has_prefix(uri, "https://") || g_str_has_prefix(uri, "http://"))) {
+            g_key_file_set_string(state, "page", "resume-uri", uri);
+        }
+    }
+
+    g_key_file_save_to_file(state, state_file, NULL);
+    g_key_file_free(state);
 }
 
-// Set WM_CLASS after window is realized
-static void on_realize(GtkWidget *widget, gpointer data) {
-    const char *wm_class = (const char *)data;
-    GdkWindow *gdk_window = gtk_widget_get_window(widget);
-    if (gdk_window != NULL && GDK_IS_X11_WINDOW(gdk_window)) {
-        gdk_x11_window_set_utf8_property(gdk_window, "_GTK_APPLICATION_ID", wm_class);
-        // Set WM_CLASS using Xlib
-        Display *display = GDK_DISPLAY_XDISPLAY(gdk_display_get_default());
-        Window xwindow = GDK_WINDOW_XID(gdk_window);
-        XClassHint *class_hint = XAllocClassHint();
-        if (class_hint) {
-            class_hint->res_name = (char *)wm_class;
-            class_hint->res_class = (char *)wm_class;
-            XSetClassHint(display, xwindow, class_hint);
-            XFree(class_hint);
+// load_window_state applies the saved size and returns the page to resume
+// at, if any
+static gchar *load_window_state(int *width, int *height) {
+    gchar *resume_uri = NULL;
+    GKeyFile *state = g_key_file_new();
+    if (g_key_file_load_from_file(state, state_file, G_KEY_FILE_NONE, NULL)) {
+        int w = g_key_file_get_integer(state, "window", "width", NULL);
+        int h = g_key_file_get_integer(state, "window", "height", NULL);
+        if (w > 0 && h > 0) {
+            *width = window_width = w;
+            *height = window_height = h;
+        }
+        if (g_key_file_has_key(state, "window", "maximized", NULL)) {
+            window_maximized = g_key_file_get_boolean(state, "window", "maximized", NULL);
         }
+        resume_uri = g_key_file_get_string(state, "page", "resume-uri", NULL);
     }
+    g_key_file_free(state);
+    return resume_uri;
 }
 
-// Handle permission requests (microphone, camera, notifications, etc.)
-static gboolean on_permission_request(WebKitWebView *web_view,
-                                       WebKitPermissionRequest *request,
-                                       gpointer user_data) {
-    // Auto-grant media (microphone/camera) permissions
-    if (WEBKIT_IS_USER_MEDIA_PERMISSION_REQUEST(request)) {
-        g_print("Granting microphone/camera permission\n");
-        webkit_permission_request_allow(request);
-        return TRUE;
+// Session end: logind announces shutdown and reboot with PrepareForShutdown
+// (a delay inhibitor gives weblet time to save), GNOME's session manager
+// asks its registered clients to quit on logout.
+static int inhibit_fd = -1;
+static GDBusConnection *session_bus = NULL;
+static gchar *sm_client = NULL;
+static int sm_response_pending = 0;
+
+static void end_session(void) {
+    session_ending = 1;
+    if (app_running && main_window != NULL) {
+        gtk_widget_destroy(main_window);
     }
+}
 
-    // Auto-grant notification permissions
-    if (WEBKIT_IS_NOTIFICATION_PERMISSION_REQUEST(request)) {
-        g_print("Granting notification permission\n");
-        webkit_permission_request_allow(request);
-        return TRUE;
+static void sm_respond(void) {
+    g_dbus_connection_call_sync(session_bus, "org.gnome.SessionManager", sm_client,
+                                "org.gnome.SessionManager.ClientPrivate", "EndSessionResponse",
+                                g_variant_new("(bs)", TRUE, ""), NULL, G_DBUS_CALL_FLAGS_NONE,
+                                -1, NULL, NULL);
+}
+
+// session_quit_done runs once website data was flushed
+static void session_quit_done(void) {
+    if (sm_response_pending) {
+        sm_response_pending = 0;
+        sm_respond();
+    }
+    if (session_bus != NULL && sm_client != NULL) {
+        g_dbus_connection_call_sync(session_bus, "org.gnome.SessionManager", "/org/gnome/SessionManager",
+                                    "org.gnome.SessionManager", "UnregisterClient",
+                                    g_variant_new("(o)", sm_client), NULL, G_DBUS_CALL_FLAGS_NONE,
+                                    -1, NULL, NULL);
+    }
+    if (inhibit_fd >= 0) {
+        close(inhibit_fd);
+        inhibit_fd = -1;
     }
+}
 
-    // Auto-grant geolocation permissions
-    if (WEBKIT_IS_GEOLOCATION_PERMISSION_REQUEST(request)) {
-        g_print("Granting geolocation permission\n");
-        webkit_permission_request_allow(request);
-        return TRUE;
+static void on_prepare_for_shutdown(GDBusConnection *bus, const gchar *sender, const gchar *path,
+                                    const gchar *iface, const gchar *signal, GVariant *params, gpointer data) {
+    gboolean starting = FALSE;
+    g_variant_get(params, "(b)", &starting);
+    if (starting) {
+        end_session();
     }
+}
 
-    // Auto-grant device info permissions (enumerate devices)
-    if (WEBKIT_IS_DEVICE_INFO_PERMISSION_REQUEST(request)) {
-        g_print("Granting device info permission\n");
-        webkit_permission_request_allow(request);
-        return TRUE;
+static void on_sm_signal(GDBusConnection *bus, const gchar *sender, const gchar *path,
+                         const gchar *iface, const gchar *signal, GVariant *params, gpointer data) {
+    if (g_strcmp0(signal, "QueryEndSession") == 0) {
+        sm_respond();
+    } else if (g_strcmp0(signal, "EndSession") == 0) {
+        sm_response_pending = 1;
+        end_session();
+    } else if (g_strcmp0(signal, "Stop") == 0) {
+        end_session();
     }
+}
 
-    // For other permissions, allow by default
-    webkit_permission_request_allow(request);
-    return TRUE;
+static void watch_session_end(const char *wm_class) {
+    GDBusConnection *system_bus = g_bus_get_sync(G_BUS_TYPE_SYSTEM, NULL, NULL);
+    if (system_bus != NULL) {
+        GUnixFDList *fds = NULL;
+        GVariant *reply = g_dbus_connection_call_with_unix_fd_list_sync(
+            system_bus, "org.freedesktop.login1", "/org/freedesktop/login1",
+            "org.freedesktop.login1.Manager", "Inhibit",
+            g_variant_new("(ssss)", "shutdown", "weblet", "Saving weblet data", "delay"),
+            G_VARIANT_TYPE("(h)"), G_DBUS_CALL_FLAGS_NONE, -1, NULL, &fds, NULL, NULL);
+        if (reply != NULL) {
+            gint index = 0;
+            g_variant_get(reply, "(h)", &index);
+            inhibit_fd = g_unix_fd_list_get(fds, index, NULL);
+            g_variant_unref(reply);
+            g_object_unref(fds);
+        }
+        g_dbus_connection_signal_subscribe(system_bus, "org.freedesktop.login1",
+                                           "org.freedesktop.login1.Manager", "PrepareForShutdown",
+                                           "/org/freedesktop/login1", NULL, G_DBUS_SIGNAL_FLAGS_NONE,
+                                           on_prepare_for_shutdown, NULL, NULL);
+    }
+
+    session_bus = g_bus_get_sync(G_BUS_TYPE_SESSION, NULL, NULL);
+    if (session_bus == NULL) {
+        return;
+    }
+    const char *startup_id = g_getenv("DESKTOP_AUTOSTART_ID");
+    GVariant *reply = g_dbus_connection_call_sync(
+        session_bus, "org.gnome.SessionManager", "/org/gnome/SessionManager",
+        "org.gnome.SessionManager", "RegisterClient",
+        g_variant_new("(ss)", wm_class, startup_id != NULL ? startup_id : ""),
+        G_VARIANT_TYPE("(o)"), G_DBUS_CALL_FLAGS_NONE, -1, NULL, NULL);
+    if (reply == NULL) {
+        return;
+    }
+    g_variant_get(reply, "(o)", &sm_client);
+    g_variant_unref(reply);
+    g_dbus_connection_signal_subscribe(session_bus, "org.gnome.SessionManager",
+                                       "org.gnome.SessionManager.ClientPrivate", NULL, sm_client,
+                                       NULL, G_DBUS_SIGNAL_FLAGS_NONE, on_sm_signal, NULL, NULL);
 }
 
 void weblet_init(const char *title, const char *url, const char *data_dir, const char *icon_path, const char *wm_class, int width, int height) {
@@ -82,10 +2987,25 @@ void weblet_init(const char *title, const char *url, const char *data_dir, const
     g_set_application_name(title);
 
     gtk_init(NULL, NULL);
+    profile_mark("gtk initialized");
+
+    // Saved window size, and the page to resume at after the session ended
+    state_file = g_build_filename(data_dir, "window-state.ini", NULL);
+    int launch_width = width, launch_height = height;
+    gchar *resume_uri = load_window_state(&width, &height);
+    if (temporary_size) {
+        width = launch_width;
+        height = launch_height;
+        window_maximized = 0;
+    }
+    const char *load_url = resume_uri != NULL ? resume_uri : url;
 
     // Create window
     main_window = gtk_window_new(GTK_WINDOW_TOPLEVEL);
     gtk_window_set_title(GTK_WINDOW(main_window), title);
+    base_title = g_strdup(title);
+    app_id = g_strdup(wm_class);
+    app_icon = g_strdup(icon_path);
     gtk_window_set_default_size(GTK_WINDOW(main_window), width, height);
     gtk_window_set_position(GTK_WINDOW(main_window), GTK_WIN_POS_CENTER);
 
@@ -93,6 +3013,34 @@ void weblet_init(const char *title, const char *url, const char *data_dir, const
     gtk_window_set_role(GTK_WINDOW(main_window), wm_class);
 
     g_signal_connect(main_window, "destroy", G_CALLBACK(on_destroy), NULL);
+    g_signal_connect(main_window, "delete-event", G_CALLBACK(on_delete), NULL);
+    g_signal_connect(main_window, "configure-event", G_CALLBACK(on_configure), NULL);
+    g_signal_connect(main_window, "window-state-event", G_CALLBACK(on_window_state), NULL);
+    g_signal_connect(main_window, "map-event", G_CALLBACK(on_map_event), NULL);
+    g_signal_connect(main_window, "notify::scale-factor", G_CALLBACK(on_scale_factor_changed), NULL);
+
+    // The user's CSS comes last so it wins over the header bar's own
+    if (window_css != NULL) {
+        GtkCssProvider *css = gtk_css_provider_new();
+        GError *error = NULL;
+        if (!gtk_css_provider_load_from_data(css, window_css, -1, &error)) {
+            g_printerr("Invalid window CSS: %s\n", error->message);
+            g_error_free(error);
+        }
+        gtk_style_context_add_provider_for_screen(gdk_screen_get_default(), GTK_STYLE_PROVIDER(css),
+                                                  GTK_STYLE_PROVIDER_PRIORITY_USER);
+        g_object_unref(css);
+    }
+    if (window_maximized) {
+        gtk_window_maximize(GTK_WINDOW(main_window));
+    }
+    watch_session_end(wm_class);
+    if (pause_on_lock) {
+        watch_session_lock();
+    }
+    g_signal_connect(main_window, "focus-in-event", G_CALLBACK(on_focus_in), NULL);
+    g_atomic_int_set(&last_active, monotonic_seconds());
+    g_signal_connect(main_window, "notify::is-active", G_CALLBACK(on_active_changed), NULL);
 
     // Connect realize signal to set WM_CLASS after window is mapped
     char *wm_class_copy = strdup(wm_class);
@@ -111,38 +3059,89 @@ void weblet_init(const char *title, const char *url, const char *data_dir, const
     }
 
     // Create WebKitWebsiteDataManager with persistent storage
+    const char *site_data_dir = site_dir != NULL ? site_dir : data_dir;
     WebKitWebsiteDataManager *data_manager = webkit_website_data_manager_new(
-        "base-data-directory", data_dir,
-        "base-cache-directory", data_dir,
+        "base-data-directory", site_data_dir,
+        "base-cache-directory", cache_dir != NULL ? cache_dir : site_data_dir,
         NULL
     );
 
+    // Route all traffic through a proxy (e.g. Tor) if configured
+    if (proxy_uri != NULL) {
+        WebKitNetworkProxySettings *proxy_settings = webkit_network_proxy_settings_new(proxy_uri, NULL);
+        webkit_website_data_manager_set_network_proxy_settings(
+            data_manager, WEBKIT_NETWORK_PROXY_MODE_CUSTOM, proxy_settings);
+        webkit_network_proxy_settings_free(proxy_settings);
+    }
+
     // Create WebKitWebContext with the data manager
     WebKitWebContext *context = webkit_web_context_new_with_website_data_manager(data_manager);
+    web_context = context;
+    website_data = data_manager;
+    g_signal_connect(context, "download-started", G_CALLBACK(on_download_started), NULL);
+
+    if (fixed_location) {
+        g_signal_connect(webkit_web_context_get_geolocation_manager(context), "start",
+                         G_CALLBACK(on_geolocation_start), NULL);
+    }
+
+    // Register weblet-local:// so remote pages can use local assets
+    if (local_dir != NULL) {
+        webkit_web_context_register_uri_scheme(context, "weblet-local", on_local_scheme_request,
+                                               g_strdup(local_dir), g_free);
+        WebKitSecurityManager *security = webkit_web_context_get_security_manager(context);
+        webkit_security_manager_register_uri_scheme_as_secure(security, "weblet-local");
+        webkit_security_manager_register_uri_scheme_as_cors_enabled(security, "weblet-local");
+    }
 
     // Configure cookie manager for persistence
     WebKitCookieManager *cookie_manager = webkit_website_data_manager_get_cookie_manager(data_manager);
-    gchar *cookie_file = g_build_filename(data_dir, "cookies.sqlite", NULL);
+    gchar *cookie_file = g_build_filename(site_data_dir, "cookies.sqlite", NULL);
     webkit_cookie_manager_set_persistent_storage(
         cookie_manager,
         cookie_file,
         WEBKIT_COOKIE_PERSISTENT_STORAGE_SQLITE
     );
-    webkit_cookie_manager_set_accept_policy(cookie_manager, WEBKIT_COOKIE_POLICY_ACCEPT_ALWAYS);
+    webkit_cookie_manager_set_accept_policy(cookie_manager, privacy_script != NULL ?
+        WEBKIT_COOKIE_POLICY_ACCEPT_NO_THIRD_PARTY : WEBKIT_COOKIE_POLICY_ACCEPT_ALWAYS);
     g_free(cookie_file);
 
+    // Drop what was stored while offline support was on
+    WebKitWebsiteDataTypes dropped = 0;
+    if (!enable_service_workers) {
+        dropped |= WEBKIT_WEBSITE_DATA_SERVICE_WORKER_REGISTRATIONS;
+    }
+    if (!enable_offline_cache) {
+        dropped |= WEBKIT_WEBSITE_DATA_DOM_CACHE | WEBKIT_WEBSITE_DATA_OFFLINE_APPLICATION_CACHE;
+    }
+    if (dropped != 0) {
+        webkit_website_data_manager_clear(data_manager, dropped, 0, NULL, NULL, NULL);
+    }
+
     // Create webview with the context
     main_webview = WEBKIT_WEB_VIEW(webkit_web_view_new_with_context(context));
+    if (page_zoom != 1.0) {
+        webkit_web_view_set_zoom_level(main_webview, page_zoom);
+    }
+
+    // Paint the theme color while pages load instead of a white flash
+    if (theme_color != NULL) {
+        GdkRGBA background;
+        if (gdk_rgba_parse(&background, theme_color)) {
+            webkit_web_view_set_background_color(main_webview, &background);
+        }
+    }
 
     // Configure settings for full web app support
     WebKitSettings *settings = webkit_web_view_get_settings(main_webview);
 
     // Set Chrome user-agent to avoid "Unsupported Browser" on Discord, Teams, etc.
-    webkit_settings_set_user_agent(settings,
+    webkit_settings_set_user_agent(settings, user_agent != NULL ? user_agent :
         "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36");
 
-    webkit_settings_set_enable_javascript(settings, TRUE);
-    webkit_settings_set_javascript_can_access_clipboard(settings, TRUE);
+    webkit_settings_set_enable_javascript(settings, enable_javascript);
+    webkit_settings_set_auto_load_images(settings, auto_load_images);
+    webkit_settings_set_javascript_can_access_clipboard(settings, clipboard_policy == CLIPBOARD_ALLOW);
 
     // Audio/Video support
     webkit_settings_set_enable_media_stream(settings, TRUE);        // Microphone/Camera
@@ -156,20 +3155,270 @@ void weblet_init(const char *title, const char *url, const char *data_dir, const
     webkit_settings_set_hardware_acceleration_policy(settings, WEBKIT_HARDWARE_ACCELERATION_POLICY_ALWAYS);
 
     // Other features
-    webkit_settings_set_enable_webgl(settings, TRUE);
-    webkit_settings_set_enable_developer_extras(settings, FALSE);
+    webkit_settings_set_enable_webgl(settings, enable_webgl);
+    webkit_settings_set_enable_developer_extras(settings, inspectable);
+
+    // Reduce fingerprinting surface and leaks (Tor mode)
+    if (hardened) {
+        webkit_settings_set_enable_webgl(settings, FALSE);
+        webkit_settings_set_enable_media_stream(settings, FALSE);
+        webkit_settings_set_enable_webaudio(settings, FALSE);
+        webkit_settings_set_enable_encrypted_media(settings, FALSE);
+        webkit_settings_set_javascript_can_access_clipboard(settings, FALSE);
+        webkit_settings_set_hardware_acceleration_policy(settings, WEBKIT_HARDWARE_ACCELERATION_POLICY_NEVER);
+    }
+
+    g_signal_connect(main_webview, "notify::uri", G_CALLBACK(on_uri_changed), NULL);
 
     // Connect permission request handler for microphone/camera/notifications
     g_signal_connect(main_webview, "permission-request", G_CALLBACK(on_permission_request), NULL);
 
-    // Add webview to window
-    gtk_container_add(GTK_CONTAINER(main_window), GTK_WIDGET(main_webview));
+    // Inject the window.weblet bridge
+    if (bridge_script != NULL) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        WebKitUserScript *script = webkit_user_script_new(bridge_script,
+                                                          WEBKIT_USER_CONTENT_INJECT_TOP_FRAME,
+                                                          WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START,
+                                                          NULL, NULL);
+        webkit_user_content_manager_add_script(content_manager, script);
+        webkit_user_script_unref(script);
+
+        g_signal_connect(content_manager, "script-message-received::weblet", G_CALLBACK(on_bridge_message), NULL);
+        webkit_user_content_manager_register_script_message_handler(content_manager, "weblet");
+    }
+
+    if (startup_scripts != NULL) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        for (guint i = 0; i < startup_scripts->len; i++) {
+            WebKitUserScript *script = webkit_user_script_new(g_ptr_array_index(startup_scripts, i),
+                                                              WEBKIT_USER_CONTENT_INJECT_TOP_FRAME,
+                                                              WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START,
+                                                              NULL, NULL);
+            webkit_user_content_manager_add_script(content_manager, script);
+            webkit_user_script_unref(script);
+        }
+    }
+    if (load_script != NULL) {
+        g_signal_connect(main_webview, "load-changed", G_CALLBACK(on_load_script_changed), NULL);
+    }
+
+    if (idle_timeout > 0) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        WebKitUserScript *script = webkit_user_script_new(idle_script,
+                                                          WEBKIT_USER_CONTENT_INJECT_TOP_FRAME,
+                                                          WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START,
+                                                          NULL, NULL);
+        webkit_user_content_manager_add_script(content_manager, script);
+        webkit_user_script_unref(script);
+        g_signal_connect(main_webview, "load-changed", G_CALLBACK(on_idle_load_changed), NULL);
+    }
+    g_signal_connect(main_webview, "notify::is-playing-audio", G_CALLBACK(on_playing_audio_changed), NULL);
+    g_signal_connect(main_webview, "notify::is-loading", G_CALLBACK(on_loading_changed), NULL);
+    g_signal_connect(main_webview, "notify::camera-capture-state", G_CALLBACK(on_capture_state_changed), NULL);
+    g_signal_connect(main_webview, "notify::microphone-capture-state", G_CALLBACK(on_capture_state_changed), NULL);
+    g_signal_connect(main_webview, "notify::display-capture-state", G_CALLBACK(on_capture_state_changed), NULL);
+
+    if (clipboard_script != NULL && !hardened) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        WebKitUserScript *script = webkit_user_script_new(clipboard_script,
+                                                          WEBKIT_USER_CONTENT_INJECT_TOP_FRAME,
+                                                          WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START,
+                                                          NULL, NULL);
+        webkit_user_content_manager_add_script(content_manager, script);
+        webkit_user_script_unref(script);
+        g_signal_connect(content_manager, "script-message-received::webletClipboard",
+                         G_CALLBACK(on_clipboard_message), NULL);
+        webkit_user_content_manager_register_script_message_handler(content_manager, "webletClipboard");
+    }
+    watch_color_scheme();
+
+    // Inject the autofill script into its own world
+    if (autofill_script != NULL) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        WebKitUserScript *script = webkit_user_script_new_for_world(autofill_script,
+                                                                    WEBKIT_USER_CONTENT_INJECT_TOP_FRAME,
+                                                                    WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_END,
+                                                                    "weblet", NULL, NULL);
+        webkit_user_content_manager_add_script(content_manager, script);
+        webkit_user_script_unref(script);
+
+        g_signal_connect(content_manager, "script-message-received::webletAutofill",
+                         G_CALLBACK(on_autofill_message), NULL);
+        webkit_user_content_manager_register_script_message_handler_in_world(content_manager,
+                                                                             "webletAutofill", "weblet");
+    }
+
+    // Frames of the picture in picture script
+    if (pip_script != NULL && !kiosk) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        g_signal_connect(content_manager, "script-message-received::webletPip", G_CALLBACK(on_pip_message), NULL);
+        webkit_user_content_manager_register_script_message_handler_in_world(content_manager, "webletPip", "weblet");
+        g_signal_connect(main_webview, "load-changed", G_CALLBACK(on_pip_load_changed), NULL);
+    }
+
+    if (send_dnt || send_gpc) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        GString *source = g_string_new(NULL);
+        if (send_dnt) {
+            g_string_append(source, "Object.defineProperty(Navigator.prototype, 'doNotTrack', "
+                                    "{ get: () => '1', configurable: true });");
+        }
+        if (send_gpc) {
+            g_string_append(source, "Object.defineProperty(Navigator.prototype, 'globalPrivacyControl', "
+                                    "{ get: () => true, configurable: true });");
+        }
+        WebKitUserScript *script = webkit_user_script_new(source->str,
+                                                          WEBKIT_USER_CONTENT_INJECT_ALL_FRAMES,
+                                                          WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START,
+                                                          NULL, NULL);
+        webkit_user_content_manager_add_script(content_manager, script);
+        webkit_user_script_unref(script);
+        g_string_free(source, TRUE);
+    }
+
+    if (privacy_script != NULL) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        WebKitUserScript *script = webkit_user_script_new(privacy_script,
+                                                          WEBKIT_USER_CONTENT_INJECT_ALL_FRAMES,
+                                                          WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START,
+                                                          NULL, NULL);
+        webkit_user_content_manager_add_script(content_manager, script);
+        webkit_user_script_unref(script);
+    }
+
+    // Hide the offline APIs so pages use the network only
+    if (!enable_service_workers || !enable_offline_cache) {
+        WebKitUserContentManager *content_manager = webkit_web_view_get_user_content_manager(main_webview);
+        GString *source = g_string_new(NULL);
+        if (!enable_service_workers) {
+            g_string_append(source, "delete Navigator.prototype.serviceWorker;");
+        }
+        if (!enable_offline_cache) {
+            g_string_append(source, "delete window.caches; delete Window.prototype.caches;");
+        }
+        WebKitUserScript *script = webkit_user_script_new(source->str,
+                                                          WEBKIT_USER_CONTENT_INJECT_ALL_FRAMES,
+                                                          WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START,
+                                                          NULL, NULL);
+        webkit_user_content_manager_add_script(content_manager, script);
+        webkit_user_script_unref(script);
+        g_string_free(source, TRUE);
+    }
+
+    // Startup profiling, before on_load_failed, which stops the signal when
+    // it shows a page
+    if (profile_file != NULL) {
+        g_signal_connect(main_webview, "load-failed", G_CALLBACK(on_profile_load_failed), NULL);
+        g_signal_connect(main_webview, "load-changed", G_CALLBACK(on_profile_load_changed), NULL);
+        g_signal_connect_after(main_webview, "draw", G_CALLBACK(on_profile_draw), NULL);
+    }
+
+    // Connect load failure handler (waiting page for local dev servers)
+    g_signal_connect(main_webview, "load-failed", G_CALLBACK(on_load_failed), NULL);
+    g_signal_connect(main_webview, "load-failed-with-tls-errors", G_CALLBACK(on_tls_errors), NULL);
+    g_signal_connect(main_webview, "close", G_CALLBACK(on_webview_close), NULL);
+    g_signal_connect(main_webview, "web-process-terminated", G_CALLBACK(on_web_process_terminated), NULL);
+
+    GUri *start = g_uri_parse(url, G_URI_FLAGS_NONE, NULL);
+    if (start != NULL) {
+        start_host = g_strdup(g_uri_get_host(start));
+        g_uri_unref(start);
+    }
+
+    // Contain navigations by the navigation patterns and rewrite them for
+    // redirect rules
+    if (redirect_count > 0 || allow_patterns != NULL || deny_patterns != NULL) {
+        g_signal_connect(main_webview, "load-changed", G_CALLBACK(on_navigation_load_changed), NULL);
+    }
+
+    // Record visited pages
+    open_history();
+    if (history_db != NULL) {
+        g_signal_connect(main_webview, "load-changed", G_CALLBACK(on_history_load_changed), NULL);
+        g_signal_connect(main_webview, "notify::uri", G_CALLBACK(on_history_uri_changed), NULL);
+        g_signal_connect(main_webview, "notify::title", G_CALLBACK(on_history_title_changed), NULL);
+        if (!kiosk) {
+            g_signal_connect(main_window, "key-press-event", G_CALLBACK(on_key_press), NULL);
+        }
+    }
+
+    if (kiosk) {
+        g_signal_connect(main_webview, "context-menu", G_CALLBACK(on_context_menu), NULL);
+        gtk_window_fullscreen(GTK_WINDOW(main_window));
+    } else {
+        g_signal_connect(main_webview, "context-menu", G_CALLBACK(on_page_context_menu), NULL);
+        g_signal_connect(main_window, "key-press-event", G_CALLBACK(on_mini_key_press), NULL);
+        g_signal_connect(main_window, "key-press-event", G_CALLBACK(on_pip_key_press), NULL);
+        if (mini_start) {
+            g_signal_connect(main_window, "map-event", G_CALLBACK(on_mini_map), NULL);
+        }
+    }
+
+    // Add webview to window (with header bar and progress overlay if enabled)
+    GtkWidget *overlay = gtk_overlay_new();
+    gtk_container_add(GTK_CONTAINER(overlay), GTK_WIDGET(main_webview));
+    gtk_overlay_add_overlay(GTK_OVERLAY(overlay), build_toast());
+    if (undecorated && !kiosk) {
+        gtk_window_set_decorated(GTK_WINDOW(main_window), FALSE);
+    } else if (use_header_bar && !kiosk) {
+        gtk_window_set_titlebar(GTK_WINDOW(main_window), build_header_bar(title, icon_path));
+        gtk_overlay_add_overlay(GTK_OVERLAY(overlay), build_pick_layer());
+        g_signal_connect(main_window, "key-press-event", G_CALLBACK(on_tools_key_press), NULL);
+
+        progress_bar = gtk_progress_bar_new();
+        gtk_style_context_add_class(gtk_widget_get_style_context(progress_bar), "osd");
+        gtk_widget_set_valign(progress_bar, GTK_ALIGN_START);
+        gtk_widget_set_no_show_all(progress_bar, TRUE);
+        gtk_overlay_add_overlay(GTK_OVERLAY(overlay), progress_bar);
+        g_signal_connect(main_webview, "notify::estimated-load-progress", G_CALLBACK(on_load_progress), NULL);
+        g_signal_connect(main_webview, "notify::title", G_CALLBACK(on_page_title), NULL);
+    }
+    gtk_container_add(GTK_CONTAINER(main_window), overlay);
+
+    // Load URL (after compiling request rules so the first load is filtered too)
+    if (content_rules != NULL) {
+        gchar *store_path = g_build_filename(data_dir, "content-filters", NULL);
+        WebKitUserContentFilterStore *store = webkit_user_content_filter_store_new(store_path);
+        GBytes *source = g_bytes_new(content_rules, strlen(content_rules));
+        webkit_user_content_filter_store_save(store, "weblet-rules", source, NULL,
+                                              on_content_filter_saved, g_strdup(load_url));
+        g_bytes_unref(source);
+        g_object_unref(store);
+        g_free(store_path);
+    } else {
+        load_page(main_webview, load_url);
+    }
+    g_free(resume_uri);
+
+    if (start_hidden) {
+        gtk_window_iconify(GTK_WINDOW(main_window));
+    }
 
-    // Load URL
-    webkit_web_view_load_uri(main_webview, url);
+    // Show all widgets; a prewarmed window only shows its contents, the
+    // window itself is presented by weblet_focus
+    if (prewarm) {
+        webkit_web_view_set_is_muted(main_webview, TRUE);
+        gtk_widget_show_all(gtk_bin_get_child(GTK_BIN(main_window)));
+        GtkWidget *titlebar = gtk_window_get_titlebar(GTK_WINDOW(main_window));
+        if (titlebar != NULL) {
+            gtk_widget_show_all(titlebar);
+        }
+        gtk_widget_realize(main_window);
+        if (ready_file != NULL) {
+            g_file_set_contents(ready_file, "prewarmed\n", -1, NULL);
+        }
+        if (warm_settle > 0) {
+            g_signal_connect(main_webview, "load-changed", G_CALLBACK(on_warm_load_changed), NULL);
+            warm_timer = g_timeout_add_seconds(warm_limit, on_warm_done, NULL);
+        }
+    } else {
+        gtk_widget_show_all(main_window);
+    }
 
-    // Show all widgets
-    gtk_widget_show_all(main_window);
+    // A minimized window may not be mapped until it is restored
+    if (start_hidden) {
+        mark_window_ready();
+    }
 
     app_running = 1;
 }
@@ -178,14 +3427,48 @@ void weblet_run() {
     if (app_running) {
         // Add timer to check for focus requests from IPC (every 100ms)
         g_timeout_add(100, on_focus_check, NULL);
+        if (idle_timeout > 0) {
+            watch_idle();
+        }
+        if (thumbnail_file != NULL && thumbnail_interval > 0) {
+            g_timeout_add_seconds(thumbnail_interval, on_thumbnail_timer, NULL);
+        }
+        if (keep_alive > 0) {
+            g_timeout_add_seconds(keep_alive, on_keep_alive, NULL);
+        }
         gtk_main();
     }
+    if (history_db != NULL) {
+        sqlite3_close(history_db);
+        history_db = NULL;
+    }
 }
 
-void weblet_quit() {
+static gboolean quit_idle(gpointer data) {
     if (app_running && main_window != NULL) {
         gtk_widget_destroy(main_window);
     }
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_quit closes the window (flushing website data) from any thread
+void weblet_quit() {
+    g_idle_add(quit_idle, NULL);
+}
+
+static gboolean end_session_idle(gpointer data) {
+    end_session();
+    return G_SOURCE_REMOVE;
+}
+
+// weblet_end_session closes the window like weblet_quit, but remembers the
+// page to resume at
+void weblet_end_session() {
+    g_idle_add(end_session_idle, NULL);
+}
+
+int weblet_session_ended() {
+    return session_ending;
 }
 
 void weblet_focus() {
@@ -219,10 +3502,350 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
+// bridgeScript exposes window.weblet to the page; calls are forwarded to
+// the "weblet" script message handler
+const bridgeScript = `(function () {
+	const post = (message) => window.webkit.messageHandlers.weblet.postMessage(message);
+	window.weblet = Object.freeze({
+		setBadge: (count) => post({ cmd: "setBadge", count: Number(count) || 0 }),
+		notify: (title, body) => post({ cmd: "notify", title: String(title), body: body == null ? "" : String(body) }),
+		setTitle: (title) => post({ cmd: "setTitle", title: title == null ? "" : String(title) }),
+		requestAttention: () => post({ cmd: "requestAttention" }),
+		closeWindow: () => post({ cmd: "closeWindow" }),
+	});
+})();`
+
+// idleScript makes the page see a hidden, unfocused window while the user
+// is idle; webletSetIdle is called when that changes, and the page also
+// gets a "webletidle" event with detail.idle
+const idleScript = `(function () {
+	let idle = false;
+	const proto = Document.prototype;
+	const hidden = Object.getOwnPropertyDescriptor(proto, "hidden");
+	const visibilityState = Object.getOwnPropertyDescriptor(proto, "visibilityState");
+	const hasFocus = proto.hasFocus;
+	Object.defineProperty(proto, "hidden", {
+		configurable: true,
+		enumerable: true,
+		get() { return idle || hidden.get.call(this); },
+	});
+	Object.defineProperty(proto, "visibilityState", {
+		configurable: true,
+		enumerable: true,
+		get() { return idle ? "hidden" : visibilityState.get.call(this); },
+	});
+	proto.hasFocus = function () { return !idle && hasFocus.call(this); };
+
+	window.webletSetIdle = (value) => {
+		if (value === idle) {
+			return;
+		}
+		idle = value;
+		document.dispatchEvent(new Event("visibilitychange"));
+		if (idle) {
+			window.dispatchEvent(new Event("blur"));
+		} else if (hasFocus.call(document)) {
+			window.dispatchEvent(new Event("focus"));
+		}
+		window.dispatchEvent(new CustomEvent("webletidle", { detail: { idle } }));
+	};
+})();`
+
+// clipboardScript posts "read" or "write" to the "webletClipboard" handler
+// when the page uses the clipboard from a script, for the clipboard toast
+const clipboardScript = `(function () {
+	const post = (kind) => window.webkit.messageHandlers.webletClipboard.postMessage(kind);
+	const clipboard = navigator.clipboard;
+	if (clipboard) {
+		for (const [name, kind] of [["readText", "read"], ["read", "read"], ["writeText", "write"], ["write", "write"]]) {
+			const original = clipboard[name];
+			if (typeof original === "function") {
+				clipboard[name] = function (...args) {
+					const result = original.apply(this, args);
+					result.then(() => post(kind), () => {});
+					return result;
+				};
+			}
+		}
+	}
+	const execCommand = Document.prototype.execCommand;
+	Document.prototype.execCommand = function (command, ...args) {
+		const done = execCommand.call(this, command, ...args);
+		const name = String(command).toLowerCase();
+		if (done && (name === "copy" || name === "cut")) {
+			post("write");
+		} else if (done && name === "paste") {
+			post("read");
+		}
+		return done;
+	};
+})();`
+
+// rulerScript turns the ruler of the Tools menu on or off, in the isolated
+// "weblet" world: it outlines the element under the pointer with its size,
+// or the area dragged over, until Esc or the menu item again
+const rulerScript = `(function () {
+	if (window.webletRuler) {
+		window.webletRuler();
+		return;
+	}
+	const color = "#e0306a";
+	const layer = document.createElement("div");
+	layer.style.cssText = "position:fixed;inset:0;z-index:2147483647;cursor:crosshair;";
+	const box = document.createElement("div");
+	box.style.cssText = "position:fixed;pointer-events:none;display:none;box-sizing:border-box;" +
+		"border:1px solid " + color + ";background:rgba(224,48,106,0.12);";
+	const label = document.createElement("div");
+	label.style.cssText = "position:fixed;pointer-events:none;display:none;white-space:nowrap;" +
+		"font:12px/18px monospace;color:#fff;background:" + color + ";padding:0 5px;border-radius:3px;";
+	layer.append(box, label);
+
+	const show = (left, top, width, height, text) => {
+		Object.assign(box.style, { display: "block", left: left + "px", top: top + "px", width: width + "px", height: height + "px" });
+		label.textContent = text + "  " + Math.round(width) + " × " + Math.round(height);
+		label.style.display = "block";
+		label.style.left = Math.max(0, Math.min(left, innerWidth - label.offsetWidth)) + "px";
+		label.style.top = (top >= 20 ? top - 20 : Math.min(top + height + 2, innerHeight - 18)) + "px";
+	};
+	const describe = (element) => {
+		let name = element.localName;
+		if (element.id) {
+			name += "#" + element.id;
+		}
+		if (typeof element.className === "string" && element.className.trim()) {
+			name += "." + element.className.trim().split(/\s+/).slice(0, 2).join(".");
+		}
+		return name;
+	};
+
+	let start = null;
+	const onMove = (event) => {
+		if (start) {
+			show(Math.min(start.x, event.clientX), Math.min(start.y, event.clientY),
+				Math.abs(event.clientX - start.x), Math.abs(event.clientY - start.y), "");
+			return;
+		}
+		layer.style.pointerEvents = "none";
+		const element = document.elementFromPoint(event.clientX, event.clientY);
+		layer.style.pointerEvents = "";
+		if (element) {
+			const rect = element.getBoundingClientRect();
+			show(rect.left, rect.top, rect.width, rect.height, describe(element));
+		}
+	};
+	const onDown = (event) => {
+		event.preventDefault();
+		start = { x: event.clientX, y: event.clientY };
+	};
+	const onUp = () => {
+		start = null;
+	};
+	const onKey = (event) => {
+		if (event.key === "Escape") {
+			event.preventDefault();
+			event.stopPropagation();
+			stop();
+		}
+	};
+	const stop = () => {
+		layer.remove();
+		window.removeEventListener("keydown", onKey, true);
+		delete window.webletRuler;
+	};
+	layer.addEventListener("mousemove", onMove);
+	layer.addEventListener("mousedown", onDown);
+	layer.addEventListener("mouseup", onUp);
+	window.addEventListener("keydown", onKey, true);
+	window.webletRuler = stop;
+	document.documentElement.append(layer);
+})();`
+
+// pipScript starts or ends picture in picture of the largest playing video,
+// in the isolated "weblet" world: it posts the video's frames as JPEG data:
+// URLs to the webletPip handler, and window.webletPip.toggle() plays or
+// pauses it
+const pipScript = `(function () {
+  if (window.webletPip) {
+    window.webletPip.stop();
+    return;
+  }
+  const post = (message) => window.webkit.messageHandlers.webletPip.postMessage(message);
+  const area = (video) => {
+    const rect = video.getBoundingClientRect();
+    return rect.width * rect.height;
+  };
+  const videos = Array.from(document.querySelectorAll('video')).filter((v) => v.readyState >= 2 && v.videoWidth > 0);
+  const playing = videos.filter((v) => !v.paused && !v.ended);
+  const video = (playing.length > 0 ? playing : videos).sort((a, b) => area(b) - area(a))[0];
+  if (!video) {
+    post('none');
+    return;
+  }
+
+  const canvas = document.createElement('canvas');
+  const context = canvas.getContext('2d');
+  let timer = 0;
+  let lastTime = -1;
+  const stop = (reason) => {
+    clearInterval(timer);
+    video.removeEventListener('ended', onEnded);
+    delete window.webletPip;
+    post(reason || 'stopped');
+  };
+  const onEnded = () => stop();
+  const draw = () => {
+    if (!video.isConnected) {
+      stop();
+      return;
+    }
+    // A paused video only needs a new frame after seeking
+    if (video.paused && video.currentTime === lastTime) {
+      return;
+    }
+    lastTime = video.currentTime;
+    const scale = Math.min(1, 640 / video.videoWidth);
+    const width = Math.round(video.videoWidth * scale);
+    const height = Math.round(video.videoHeight * scale);
+    if (canvas.width !== width || canvas.height !== height) {
+      canvas.width = width;
+      canvas.height = height;
+    }
+    try {
+      context.drawImage(video, 0, 0, width, height);
+      post(canvas.toDataURL('image/jpeg', 0.75));
+    } catch (e) {
+      // Cross-origin video without CORS taints the canvas
+      stop('protected');
+    }
+  };
+  window.webletPip = {
+    stop: () => stop(),
+    toggle: () => (video.paused ? video.play() : video.pause()),
+  };
+  video.addEventListener('ended', onEnded);
+  timer = setInterval(draw, 66);
+  draw();
+})();`
+
+// autofillScript runs in the isolated "weblet" world: it finds login
+// fields, posts submitted logins to the "webletAutofill" handler and
+// defines webletAutofill.fill for saved ones
+const autofillScript = `(function () {
+	const usable = (input) => input.offsetParent !== null && !input.disabled && !input.readOnly;
+	const userFieldType = /^(text|email|tel)?$/;
+	const userFieldHint = /user|login|mail|account|ident/i;
+
+	// The first visible password field and the username field of its form:
+	// one marked with autocomplete, otherwise the text field before it
+	const findFields = () => {
+		const password = Array.from(document.querySelectorAll("input[type=password]")).find(usable) || null;
+		const scope = (password && password.form) || document;
+		const candidates = Array.from(scope.querySelectorAll("input"))
+			.filter((input) => usable(input) && userFieldType.test(input.getAttribute("type") || ""));
+		let username = candidates.find((input) => /username|email/.test(input.autocomplete));
+		if (!username && password) {
+			username = candidates.filter((input) => input.compareDocumentPosition(password) & Node.DOCUMENT_POSITION_FOLLOWING).pop();
+		}
+		if (!username) {
+			username = candidates.find((input) => userFieldHint.test(input.name + " " + input.id));
+		}
+		return { username: username || null, password };
+	};
+
+	// Set values the way typing does, so frameworks see the change
+	const valueSetter = Object.getOwnPropertyDescriptor(HTMLInputElement.prototype, "value").set;
+	const setValue = (input, value) => {
+		valueSetter.call(input, value);
+		input.dispatchEvent(new Event("input", { bubbles: true }));
+		input.dispatchEvent(new Event("change", { bubbles: true }));
+	};
+
+	window.webletAutofill = Object.freeze({
+		fill: (username, password) => {
+			const fields = findFields();
+			if (fields.username && username) {
+				setValue(fields.username, username);
+			}
+			if (fields.password) {
+				setValue(fields.password, password);
+			}
+			return fields.username !== null || fields.password !== null;
+		},
+	});
+
+	// Many apps log in from a click or Enter handler rather than a submit
+	const capture = () => {
+		const fields = findFields();
+		if (fields.password && fields.password.value) {
+			window.webkit.messageHandlers.webletAutofill.postMessage({
+				username: fields.username ? fields.username.value : "",
+				password: fields.password.value,
+			});
+		}
+	};
+	document.addEventListener("submit", capture, true);
+	document.addEventListener("click", (event) => {
+		if (event.target instanceof Element && event.target.closest("button, input[type=submit]")) {
+			capture();
+		}
+	}, true);
+	document.addEventListener("keydown", (event) => {
+		if (event.key === "Enter" && event.target instanceof HTMLInputElement && event.target.type === "password") {
+			capture();
+		}
+	}, true);
+})();`
+
+// privacyScript adds per-page noise to canvas readback, so canvas
+// fingerprints don't identify the weblet, and sends no referrers
+const privacyScript = `(function () {
+	const seed = Math.floor(Math.random() * 256);
+	const getImageData = CanvasRenderingContext2D.prototype.getImageData;
+	const noise = (data) => {
+		for (let i = 0; i < data.length; i += 97) {
+			data[i] ^= (seed + i) & 1;
+		}
+	};
+	CanvasRenderingContext2D.prototype.getImageData = function () {
+		const image = getImageData.apply(this, arguments);
+		noise(image.data);
+		return image;
+	};
+	const noisyCopy = (canvas) => {
+		const copy = document.createElement("canvas");
+		copy.width = canvas.width;
+		copy.height = canvas.height;
+		const ctx = copy.width && copy.height ? copy.getContext("2d") : null;
+		if (!ctx) {
+			return canvas;
+		}
+		ctx.drawImage(canvas, 0, 0);
+		const image = getImageData.call(ctx, 0, 0, copy.width, copy.height);
+		noise(image.data);
+		ctx.putImageData(image, 0, 0);
+		return copy;
+	};
+	const toDataURL = HTMLCanvasElement.prototype.toDataURL;
+	HTMLCanvasElement.prototype.toDataURL = function () {
+		return toDataURL.apply(noisyCopy(this), arguments);
+	};
+	const toBlob = HTMLCanvasElement.prototype.toBlob;
+	HTMLCanvasElement.prototype.toBlob = function () {
+		return toBlob.apply(noisyCopy(this), arguments);
+	};
+
+	const referrer = document.createElement("meta");
+	referrer.name = "referrer";
+	referrer.content = "no-referrer";
+	(document.head || document.documentElement).appendChild(referrer);
+})();`
+
 // tryFocusExistingWindow attempts to connect to an existing weblet instance
 // Returns true if focus request was sent successfully, false if no instance exists
 func tryFocusExistingWindow(socketPath string) bool {
@@ -237,8 +3860,9 @@ func tryFocusExistingWindow(socketPath string) bool {
 	return true
 }
 
-// startFocusListener starts a Unix socket listener for focus requests
-func startFocusListener(socketPath string) (net.Listener, error) {
+// startFocusListener starts a Unix socket listener for control commands
+// (see Query)
+func startFocusListener(socketPath, thumbnailFile string) (net.Listener, error) {
 	// Remove stale socket if exists
 	os.Remove(socketPath)
 
@@ -254,11 +3878,57 @@ func startFocusListener(socketPath string) (net.Listener, error) {
 				return // Listener closed
 			}
 
-			buf := make([]byte, 16)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			buf := make([]byte, 4096)
 			n, _ := conn.Read(buf)
-			if n > 0 && string(buf[:n]) == "focus" {
+			command := string(buf[:n])
+			if uri, ok := strings.CutPrefix(command, "open "); ok {
+				cURI := C.CString(uri)
+				C.weblet_open_uri(cURI)
+				C.free(unsafe.Pointer(cURI))
+				conn.Write([]byte("ok\n"))
+			}
+			switch command {
+			case "focus":
 				log.Println("Received focus request from another instance")
 				C.weblet_request_focus()
+			case "url":
+				if uri := C.weblet_current_uri(); uri != nil {
+					conn.Write([]byte(C.GoString(uri) + "\n"))
+					C.g_free(C.gpointer(uri))
+				}
+			case "copy-url":
+				C.weblet_copy_uri()
+				conn.Write([]byte("ok\n"))
+			case "mute", "unmute":
+				C.weblet_set_muted(cBool(command == "mute"))
+				conn.Write([]byte("ok\n"))
+			case "close":
+				C.weblet_close()
+				conn.Write([]byte("ok\n"))
+			case "mini":
+				C.weblet_toggle_mini()
+				conn.Write([]byte("ok\n"))
+			case "pip":
+				C.weblet_toggle_pip()
+				conn.Write([]byte("ok\n"))
+			case "thumbnail":
+				if thumbnailFile == "" {
+					break
+				}
+				// The snapshot is taken asynchronously on the GTK thread
+				before, _ := os.Stat(thumbnailFile)
+				C.weblet_request_thumbnail()
+				for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+					if info, err := os.Stat(thumbnailFile); err == nil && (before == nil || info.ModTime().After(before.ModTime())) {
+						conn.Write([]byte(thumbnailFile + "\n"))
+						break
+					}
+				}
+			case "unused":
+				fmt.Fprintf(conn, "%d\n", int(C.weblet_unused_seconds()))
+			case "activity":
+				fmt.Fprintf(conn, "%s\n", C.GoString(C.weblet_activity()))
 			}
 			conn.Close()
 		}
@@ -270,22 +3940,21 @@ func startFocusListener(socketPath string) (net.Listener, error) {
 // runWebview opens a webview window with the given URL and title
 // Uses persistent storage for cookies, localStorage, and other web data
 // This function blocks until the window is closed
-func RunWebview(webletURL, title string) {
+func RunWebview(webletURL, title string, opts Options) {
 	// Get data directory for this weblet
-	homeDir, err := os.UserHomeDir()
+	webletDir, err := DataDir()
 	if err != nil {
 		log.Fatalf("Failed to get home directory: %v", err)
 	}
 
-	dataDir := filepath.Join(homeDir, ".weblet", "data", title)
+	dataDir := filepath.Join(webletDir, "data", title)
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
 
 	// Socket path for single-instance communication
-	sockDir := filepath.Join(homeDir, ".weblet", "sockets")
-	os.MkdirAll(sockDir, 0755)
-	socketPath := filepath.Join(sockDir, title+".sock")
+	socketPath, _ := ControlSocket(title)
+	os.MkdirAll(filepath.Dir(socketPath), 0755)
 
 	// Try to focus existing instance first
 	if tryFocusExistingWindow(socketPath) {
@@ -294,7 +3963,7 @@ func RunWebview(webletURL, title string) {
 	}
 
 	// Find icon for this weblet
-	iconPath := findWebletIcon(homeDir, webletURL, title)
+	iconPath := findWebletIcon(webletDir, webletURL, title)
 
 	// WM_CLASS should match StartupWMClass in .desktop file
 	// Format: weblet-<name> to match weblet-<name>.desktop
@@ -304,7 +3973,7 @@ func RunWebview(webletURL, title string) {
 	log.Printf("Data directory: %s", dataDir)
 
 	// Start socket listener for focus requests
-	listener, err := startFocusListener(socketPath)
+	listener, err := startFocusListener(socketPath, opts.ThumbnailFile)
 	if err != nil {
 		log.Printf("Warning: Failed to start focus listener: %v", err)
 	} else {
@@ -315,7 +3984,7 @@ func RunWebview(webletURL, title string) {
 	}
 
 	// Convert strings to C strings
-	cTitle := C.CString(title)
+	cTitle := C.CString(title + opts.TitleSuffix)
 	cURL := C.CString(webletURL)
 	cDataDir := C.CString(dataDir)
 	cIconPath := C.CString(iconPath)
@@ -326,26 +3995,289 @@ func RunWebview(webletURL, title string) {
 	defer C.free(unsafe.Pointer(cIconPath))
 	defer C.free(unsafe.Pointer(cWMClass))
 
-	// Handle graceful shutdown
+	// Apply request rules
+	if opts.ContentRules != "" {
+		cRules := C.CString(opts.ContentRules)
+		defer C.free(unsafe.Pointer(cRules))
+		C.weblet_set_content_rules(cRules)
+	}
+	if opts.LocalDir != "" {
+		cLocalDir := C.CString(opts.LocalDir)
+		defer C.free(unsafe.Pointer(cLocalDir))
+		C.weblet_set_local_dir(cLocalDir)
+	}
+	if opts.RetryOnFailure {
+		C.weblet_set_retry_on_failure(1)
+	}
+	if opts.Proxy != "" {
+		cProxy := C.CString(opts.Proxy)
+		defer C.free(unsafe.Pointer(cProxy))
+		C.weblet_set_proxy(cProxy)
+	}
+	if opts.UserAgent != "" {
+		cUserAgent := C.CString(opts.UserAgent)
+		defer C.free(unsafe.Pointer(cUserAgent))
+		C.weblet_set_user_agent(cUserAgent)
+	}
+	if opts.Hardened {
+		C.weblet_set_hardened(1)
+	}
+	C.weblet_set_content_settings(cBool(!opts.DisableJavaScript), cBool(!opts.DisableImages), cBool(!opts.DisableWebGL))
+	if opts.FixedLocation {
+		C.weblet_set_location(C.double(opts.Latitude), C.double(opts.Longitude))
+	}
+	C.weblet_set_privacy_signals(cBool(opts.DoNotTrack), cBool(opts.GlobalPrivacyControl))
+	C.weblet_set_offline_settings(cBool(!opts.DisableServiceWorkers), cBool(!opts.DisableOfflineCache))
+	for _, script := range opts.StartupScripts {
+		cScript := C.CString(script)
+		C.weblet_add_startup_script(cScript)
+		C.free(unsafe.Pointer(cScript))
+	}
+	if opts.LoadScript != "" {
+		cLoadScript := C.CString(opts.LoadScript)
+		C.weblet_set_load_script(cLoadScript)
+		C.free(unsafe.Pointer(cLoadScript))
+	}
+	if opts.Autofill {
+		cName := C.CString(title)
+		cAutofill := C.CString(autofillScript)
+		defer C.free(unsafe.Pointer(cName))
+		defer C.free(unsafe.Pointer(cAutofill))
+		C.weblet_set_autofill(cName, cAutofill)
+	}
+	cClipboard := C.CString(clipboardScript)
+	defer C.free(unsafe.Pointer(cClipboard))
+	switch opts.Clipboard {
+	case "allow":
+		C.weblet_set_clipboard(C.CLIPBOARD_ALLOW, cClipboard)
+	case "deny":
+		C.weblet_set_clipboard(C.CLIPBOARD_DENY, cClipboard)
+	default:
+		C.weblet_set_clipboard(C.CLIPBOARD_ASK, cClipboard)
+	}
+	if opts.IdleTimeout > 0 {
+		cIdle := C.CString(idleScript)
+		defer C.free(unsafe.Pointer(cIdle))
+		C.weblet_set_idle_presence(C.int(opts.IdleTimeout), cIdle)
+	}
+	if opts.KeepAlive > 0 {
+		C.weblet_set_keep_alive(C.int(opts.KeepAlive), cBool(opts.KeepAliveReload))
+	}
+	if opts.Watch != "" {
+		if err := watchDir(opts.Watch, func() { C.weblet_reload() }); err != nil {
+			log.Printf("Could not watch %s: %v", opts.Watch, err)
+		}
+	}
+	if opts.AuditFile != "" {
+		cAuditFile := C.CString(opts.AuditFile)
+		defer C.free(unsafe.Pointer(cAuditFile))
+		C.weblet_set_audit_file(cAuditFile)
+	}
+	if opts.Browser != "" {
+		cBrowser := C.CString(opts.Browser)
+		defer C.free(unsafe.Pointer(cBrowser))
+		C.weblet_set_browser(cBrowser)
+	}
+	if opts.CrashFile != "" {
+		cCrashFile := C.CString(opts.CrashFile)
+		defer C.free(unsafe.Pointer(cCrashFile))
+		C.weblet_set_crash_file(cCrashFile)
+	}
+	if opts.ThumbnailFile != "" {
+		cThumbnailFile := C.CString(opts.ThumbnailFile)
+		defer C.free(unsafe.Pointer(cThumbnailFile))
+		C.weblet_set_thumbnails(cThumbnailFile, C.int(opts.ThumbnailInterval))
+	}
+	if opts.ProfileFile != "" {
+		cProfileFile := C.CString(opts.ProfileFile)
+		defer C.free(unsafe.Pointer(cProfileFile))
+		C.weblet_set_profile_file(cProfileFile)
+	}
+	if opts.HistoryFile != "" {
+		cHistoryFile := C.CString(opts.HistoryFile)
+		defer C.free(unsafe.Pointer(cHistoryFile))
+		C.weblet_set_history_file(cHistoryFile)
+	}
+	for text, translated := range opts.Translations {
+		cText := C.CString(text)
+		cTranslated := C.CString(translated)
+		C.weblet_add_translation(cText, cTranslated)
+		C.free(unsafe.Pointer(cText))
+		C.free(unsafe.Pointer(cTranslated))
+	}
+	if opts.Kiosk {
+		C.weblet_set_kiosk(1)
+	}
+	if opts.Zoom > 0 {
+		C.weblet_set_zoom(C.double(opts.Zoom))
+	}
+	if opts.Mini || opts.MiniWidth > 0 || opts.MiniOpacity > 0 {
+		C.weblet_set_mini(cBool(opts.Mini), C.int(opts.MiniWidth), C.int(opts.MiniHeight), C.double(opts.MiniOpacity))
+	}
+	if opts.Maximized {
+		C.weblet_set_maximized(1)
+	}
+	if opts.PauseOnLock {
+		C.weblet_set_pause_on_lock(1)
+	}
+	if opts.Undecorated {
+		C.weblet_set_undecorated(1)
+	}
+	if opts.TemporarySize {
+		C.weblet_set_temporary_size(1)
+	}
+	if opts.StartHidden {
+		C.weblet_set_start_hidden(1)
+	}
+	if opts.Prewarm {
+		C.weblet_set_prewarm(1)
+	}
+	if opts.Inspector > 0 {
+		// Read by WebKit when the web context starts
+		os.Setenv("WEBKIT_INSPECTOR_SERVER", fmt.Sprintf("127.0.0.1:%d", opts.Inspector))
+		os.Setenv("WEBKIT_INSPECTOR_HTTP_SERVER", fmt.Sprintf("127.0.0.1:%d", opts.Inspector+1))
+		C.weblet_set_inspectable(1)
+	}
+	if opts.Warm > 0 {
+		C.weblet_set_warm(C.int(opts.Warm))
+	}
+	if opts.CacheDir != "" {
+		cCacheDir := C.CString(opts.CacheDir)
+		defer C.free(unsafe.Pointer(cCacheDir))
+		C.weblet_set_cache_dir(cCacheDir)
+	}
+	if opts.SiteDir != "" {
+		if err := os.MkdirAll(opts.SiteDir, 0700); err != nil {
+			log.Fatalf("Failed to create site data directory: %v", err)
+		}
+		cSiteDir := C.CString(opts.SiteDir)
+		defer C.free(unsafe.Pointer(cSiteDir))
+		C.weblet_set_site_dir(cSiteDir)
+	}
+	if opts.ReadyFile != "" {
+		cReadyFile := C.CString(opts.ReadyFile)
+		defer C.free(unsafe.Pointer(cReadyFile))
+		C.weblet_set_ready_file(cReadyFile)
+	}
+	cPip := C.CString(pipScript)
+	defer C.free(unsafe.Pointer(cPip))
+	C.weblet_set_pip_script(cPip)
+	if opts.HeaderBar {
+		C.weblet_set_header_bar(1)
+		cRuler := C.CString(rulerScript)
+		defer C.free(unsafe.Pointer(cRuler))
+		C.weblet_set_ruler_script(cRuler)
+	}
+	switch opts.ColorScheme {
+	case "dark":
+		C.weblet_set_color_scheme(C.COLOR_SCHEME_DARK)
+	case "light":
+		C.weblet_set_color_scheme(C.COLOR_SCHEME_LIGHT)
+	}
+	if opts.ThemeColor != "" {
+		cThemeColor := C.CString(opts.ThemeColor)
+		cThemeTextColor := C.CString(opts.ThemeTextColor)
+		defer C.free(unsafe.Pointer(cThemeColor))
+		defer C.free(unsafe.Pointer(cThemeTextColor))
+		C.weblet_set_theme_color(cThemeColor, cThemeTextColor)
+	}
+	if opts.WindowCSS != "" {
+		cWindowCSS := C.CString(opts.WindowCSS)
+		defer C.free(unsafe.Pointer(cWindowCSS))
+		C.weblet_set_window_css(cWindowCSS)
+	}
+	for _, link := range opts.Links {
+		cName := C.CString(link.Name)
+		cLinkURL := C.CString(link.URL)
+		C.weblet_add_link(cName, cLinkURL)
+		C.free(unsafe.Pointer(cName))
+		C.free(unsafe.Pointer(cLinkURL))
+	}
+	if opts.Privacy {
+		cPrivacy := C.CString(privacyScript)
+		defer C.free(unsafe.Pointer(cPrivacy))
+		C.weblet_set_privacy_script(cPrivacy)
+	}
+	if opts.Bridge {
+		cBridge := C.CString(bridgeScript)
+		defer C.free(unsafe.Pointer(cBridge))
+		C.weblet_set_bridge_script(cBridge)
+	}
+	if opts.ErrorPage != "" {
+		cErrorPage := C.CString(opts.ErrorPage)
+		defer C.free(unsafe.Pointer(cErrorPage))
+		C.weblet_set_error_page(cErrorPage)
+	}
+	for _, r := range opts.Redirects {
+		cFrom := C.CString(r.From)
+		cTo := C.CString(r.To)
+		C.weblet_add_redirect(cFrom, cTo)
+		C.free(unsafe.Pointer(cFrom))
+		C.free(unsafe.Pointer(cTo))
+	}
+	for _, pattern := range opts.AllowPatterns {
+		cPattern := C.CString(pattern)
+		C.weblet_add_nav_pattern(cPattern, 1)
+		C.free(unsafe.Pointer(cPattern))
+	}
+	for _, pattern := range opts.DenyPatterns {
+		cPattern := C.CString(pattern)
+		C.weblet_add_nav_pattern(cPattern, 0)
+		C.free(unsafe.Pointer(cPattern))
+	}
+
+	// Handle graceful shutdown; SIGHUP means the session is going away
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		<-sigChan
+		sig := <-sigChan
 		log.Println("Shutting down weblet...")
+		if sig == syscall.SIGHUP {
+			C.weblet_end_session()
+			return
+		}
 		C.weblet_quit()
 	}()
 
 	// Initialize and run webview with persistent storage
-	C.weblet_init(cTitle, cURL, cDataDir, cIconPath, cWMClass, 1200, 800)
+	width, height := 1200, 800
+	if opts.Width > 0 && opts.Height > 0 {
+		width, height = opts.Width, opts.Height
+	}
+	C.weblet_init(cTitle, cURL, cDataDir, cIconPath, cWMClass, C.int(width), C.int(height))
 	C.weblet_run()
 
 	log.Println("Weblet window closed")
 }
 
+// Available reports whether this build includes the native webview
+const Available = true
+
+// Versions returns the versions of the WebKitGTK and GTK libraries in use
+func Versions() string {
+	return fmt.Sprintf("WebKitGTK %d.%d.%d, GTK %d.%d.%d",
+		C.webkit_get_major_version(), C.webkit_get_minor_version(), C.webkit_get_micro_version(),
+		C.gtk_get_major_version(), C.gtk_get_minor_version(), C.gtk_get_micro_version())
+}
+
+// SessionEnded reports whether the window was closed because the session
+// ended (logout, shutdown or SIGHUP) rather than by the user
+func SessionEnded() bool {
+	return C.weblet_session_ended() != 0
+}
+
+// cBool converts a Go bool to a C int flag
+func cBool(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 // findWebletIcon looks for an icon file for the given weblet
-func findWebletIcon(homeDir, webletURL, webletName string) string {
-	iconDir := filepath.Join(homeDir, ".weblet", "icons")
+func findWebletIcon(webletDir, webletURL, webletName string) string {
+	iconDir := filepath.Join(webletDir, "icons")
 
 	// Try PNG first, then ICO, then other formats
 	extensions := []string{".png", ".ico", ".svg", ".jpg"}
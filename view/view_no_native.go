@@ -3,10 +3,34 @@
 package view
 
 import (
+	"errors"
 	"log"
 )
 
+// Available reports whether this build includes the native webview
+const Available = false
+
+// Versions is a stub; there are no native libraries in this build
+func Versions() string {
+	return ""
+}
+
 // RunWebview is a stub that informs the user that native mode is not available
-func RunWebview(webletURL, title string) {
+func RunWebview(webletURL, title string, opts Options) {
 	log.Fatalf("Error: Native webview mode is not available in this build. Please use Chrome mode (default) or rebuild with WebKit support.")
 }
+
+// SessionEnded reports whether the window was closed because the session ended
+func SessionEnded() bool {
+	return false
+}
+
+// InstallLauncher is a stub; the DynamicLauncher portal needs native mode support
+func InstallLauncher(name string, icon []byte, desktopID, entry string) error {
+	return errors.New("the DynamicLauncher portal isn't available in this build")
+}
+
+// UninstallLauncher is a stub; the DynamicLauncher portal needs native mode support
+func UninstallLauncher(desktopID string) error {
+	return errors.New("the DynamicLauncher portal isn't available in this build")
+}
@@ -0,0 +1,126 @@
+package view
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// With 'weblet run <name> --watch <dir>' the window of a local dev server
+// or directory reloads when files under <dir> change, as a live preview.
+// Directories are watched with inotify, also ones created later; hidden
+// ones (.git) and node_modules are left out, like editor backup files.
+
+// watchSettle is how long changes have to stop before the page reloads:
+// editors and build tools write several files at once
+const watchSettle = 200 * time.Millisecond
+
+// watchMask are the inotify events of a changed file
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// dirWatch is an inotify instance watching a directory tree
+type dirWatch struct {
+	fd   int
+	dirs map[int32]string // Watch descriptor -> directory
+}
+
+// watchIgnored reports whether changes of a file or directory are left out
+func watchIgnored(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || name == "node_modules"
+}
+
+// watchDir calls changed after files under dir changed, once per burst of
+// changes; it watches until the process ends
+func watchDir(dir string, changed func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	w := &dirWatch{fd: fd, dirs: map[int32]string{}}
+	if err := w.addTree(dir); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	go w.run(changed)
+	return nil
+}
+
+// addTree watches dir and the directories under it
+func (w *dirWatch) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			// A directory removed while walking is no reason to stop
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != dir && watchIgnored(entry.Name()) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			log.Printf("Not watching %s: %v", path, err)
+			return nil
+		}
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// run reads the events and calls changed when they settle
+func (w *dirWatch) run(changed func()) {
+	var timer *time.Timer
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			log.Printf("Stopped watching files: %v", err)
+			return
+		}
+
+		changes := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			name := strings.TrimRight(string(nameBytes), "\x00")
+
+			dir, known := w.dirs[event.Wd]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, event.Wd)
+				continue
+			}
+			if !known || name == "" || watchIgnored(name) {
+				continue
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				w.addTree(filepath.Join(dir, name))
+			}
+			changes = true
+		}
+
+		if !changes {
+			continue
+		}
+		if timer == nil {
+			timer = time.AfterFunc(watchSettle, changed)
+		} else {
+			timer.Reset(watchSettle)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 'weblet cache warm <name>' loads a weblet once without showing it, so its
+// service worker installs and precaches and the HTTP cache fills again
+// (e.g. after it was cleared) and the next start is fast. Native weblets
+// load in a prewarmed window that closes warmSettle seconds after the page
+// loaded (and stays if it is opened meanwhile), Chrome weblets in a
+// headless Chrome with their profile.
+
+// warmSettle is how long a loaded page gets to fill its caches, in seconds
+const warmSettle = 10
+
+// warmTimeout is how long warming may take at most
+const warmTimeout = 3 * time.Minute
+
+// WarmCache loads a weblet hidden to fill its caches
+func (wm *WebletManager) WarmCache(name string) error {
+	weblet, exists := wm.weblets[name]
+	if !exists {
+		return newError(ErrNotFound, "weblet '%s' not found", name)
+	}
+	if wm.isRunning(weblet) {
+		fmt.Print(T("Weblet '%s' is running, its cache is warm\n", name))
+		return nil
+	}
+
+	if !weblet.UseChrome && !nativeAvailable() {
+		return newBackendMissingError("native")
+	}
+
+	fmt.Print(T("Loading weblet '%s' hidden to warm its cache...\n", name))
+	var err error
+	if weblet.UseChrome {
+		err = wm.warmChrome(weblet)
+	} else {
+		err = wm.warmNative(weblet)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Print(T("Warmed the cache of weblet '%s'\n", name))
+	return nil
+}
+
+// warmNative starts the weblet in a warming window and waits for it to
+// close
+func (wm *WebletManager) warmNative(weblet *Weblet) error {
+	os.Setenv("WEBLET_WARM", strconv.Itoa(warmSettle))
+	defer os.Unsetenv("WEBLET_WARM")
+	if err := wm.Run(weblet.Name); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(warmTimeout)
+	for wm.runningPID(weblet) > 0 {
+		// Opened meanwhile, the window stays
+		if !wm.isPrewarmed(weblet.Name) && wm.isWebletWindowOpen(weblet.Name) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("weblet '%s' didn't finish loading within %s", weblet.Name, warmTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return nil
+}
+
+// warmChrome loads the weblet's page in a headless Chrome with its profile
+func (wm *WebletManager) warmChrome(weblet *Weblet) error {
+	browser := findChrome()
+	if browser == "" {
+		return newBackendMissingError("chrome")
+	}
+
+	// --virtual-time-budget lets the page run its timers (and service
+	// worker installation) for warmSettle seconds before the DOM is dumped
+	args := []string{
+		"--headless=new", "--no-first-run", "--disable-gpu",
+		"--user-data-dir=" + wm.chromeProfileDir(weblet.Name),
+		"--virtual-time-budget=" + strconv.Itoa(warmSettle*1000),
+	}
+	args = append(args, wm.chromeCacheArgs(weblet)...)
+	if weblet.Privacy {
+		args = append(args, privacyChromeArgs()...)
+	}
+	if weblet.Tor {
+		addr, err := wm.ensureTor()
+		if err != nil {
+			return err
+		}
+		args = append(args, torChromeArgs(addr)...)
+	}
+	args = append(args, "--dump-dom", weblet.URL)
+
+	var env []string
+	if weblet.Privacy {
+		env = append(env, "TZ="+privacyTimezone)
+	}
+	cmd, err := wm.launchCommand(weblet, env, browser, args...)
+	if err != nil {
+		return err
+	}
+	if logFile := wm.openLog(weblet.Name); logFile != nil {
+		cmd.Stderr = logFile
+		defer logFile.Close()
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start Chrome: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("Chrome failed to load the page: %w", err)
+		}
+	case <-time.After(warmTimeout):
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("weblet '%s' didn't finish loading within %s", weblet.Name, warmTimeout)
+	}
+	return nil
+}
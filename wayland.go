@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// On wlroots compositors (Sway, Hyprland, river, labwc, ...) windows are
+// listed and activated with the wlr-foreign-toplevel-management protocol.
+// Only the handful of messages needed for that are implemented here, on top
+// of the plain Wayland wire format, so no helper tools are required.
+
+var errNoForeignToplevel = errors.New("compositor doesn't support wlr-foreign-toplevel-management")
+
+// Opcodes of the requests and events used
+const (
+	wlDisplaySync        = 0
+	wlDisplayGetRegistry = 1
+	wlDisplayError       = 0
+	wlRegistryBind       = 0
+	wlRegistryGlobal     = 0
+	wlCallbackDone       = 0
+
+	wlrManagerToplevel = 0
+	wlrHandleActivate  = 4
+	wlrHandleTitle     = 0
+	wlrHandleAppID     = 1
+	wlrHandleClosed    = 6
+)
+
+// wlrWindow is a toplevel window as reported by the compositor
+type wlrWindow struct {
+	id     uint32
+	AppID  string
+	Title  string
+	closed bool
+}
+
+type wlClient struct {
+	conn   net.Conn
+	nextID uint32
+	seat   uint32
+}
+
+// wlConnect connects to the compositor of $WAYLAND_DISPLAY
+func wlConnect() (*wlClient, error) {
+	display := os.Getenv("WAYLAND_DISPLAY")
+	if display == "" || stubBackend {
+		return nil, errors.New("not running under Wayland")
+	}
+	if !filepath.IsAbs(display) {
+		display = filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), display)
+	}
+
+	conn, err := net.DialTimeout("unix", display, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Object 1 is always wl_display
+	return &wlClient{conn: conn, nextID: 2}, nil
+}
+
+func (c *wlClient) newID() uint32 {
+	id := c.nextID
+	c.nextID++
+	return id
+}
+
+// request sends a request; args are uint32 or string
+func (c *wlClient) request(object uint32, opcode uint16, args ...any) error {
+	msg := make([]byte, 8)
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			msg = binary.LittleEndian.AppendUint32(msg, v)
+		case string:
+			// Length includes the NUL, the data is padded to 32 bits
+			msg = binary.LittleEndian.AppendUint32(msg, uint32(len(v)+1))
+			msg = append(msg, v...)
+			msg = append(msg, make([]byte, 4-len(v)%4)...)
+		}
+	}
+	binary.LittleEndian.PutUint32(msg[0:], object)
+	binary.LittleEndian.PutUint32(msg[4:], uint32(len(msg))<<16|uint32(opcode))
+
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// readEvent reads the next event and returns its object, opcode and payload
+func (c *wlClient) readEvent() (uint32, uint16, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return 0, 0, nil, err
+	}
+	object := binary.LittleEndian.Uint32(header[0:])
+	word := binary.LittleEndian.Uint32(header[4:])
+	size := int(word >> 16)
+	if size < 8 {
+		return 0, 0, nil, fmt.Errorf("invalid Wayland message size %d", size)
+	}
+
+	payload := make([]byte, size-8)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return object, uint16(word & 0xffff), payload, nil
+}
+
+// roundtrip dispatches events to handle until the server has processed
+// every request sent so far
+func (c *wlClient) roundtrip(handle func(object uint32, opcode uint16, payload []byte)) error {
+	callback := c.newID()
+	if err := c.request(1, wlDisplaySync, callback); err != nil {
+		return err
+	}
+
+	for {
+		object, opcode, payload, err := c.readEvent()
+		if err != nil {
+			return err
+		}
+		switch {
+		case object == callback && opcode == wlCallbackDone:
+			return nil
+		case object == 1 && opcode == wlDisplayError:
+			// object_id, code, message
+			if len(payload) < 8 {
+				return errors.New("wayland error")
+			}
+			return fmt.Errorf("wayland error: %s", wlString(payload[8:]))
+		}
+		handle(object, opcode, payload)
+	}
+}
+
+func wlUint(data []byte) uint32 {
+	if len(data) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(data)
+}
+
+func wlString(data []byte) string {
+	n := int(wlUint(data))
+	if n == 0 || len(data) < 4+n {
+		return ""
+	}
+	return string(data[4 : 4+n-1])
+}
+
+// wlrToplevels connects to the compositor and lists its windows. The
+// returned client stays connected so a window can be activated.
+func wlrToplevels() (*wlClient, []*wlrWindow, error) {
+	c, err := wlConnect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registry := c.newID()
+	if err := c.request(1, wlDisplayGetRegistry, registry); err != nil {
+		c.conn.Close()
+		return nil, nil, err
+	}
+
+	type global struct {
+		name, version uint32
+	}
+	globals := make(map[string]global)
+	err = c.roundtrip(func(object uint32, opcode uint16, payload []byte) {
+		if object == registry && opcode == wlRegistryGlobal && len(payload) >= 8 {
+			// name, interface (length-prefixed, padded) and version
+			padded := (int(wlUint(payload[4:])) + 3) / 4 * 4
+			if len(payload) >= 12+padded {
+				globals[wlString(payload[4:])] = global{name: wlUint(payload), version: wlUint(payload[8+padded:])}
+			}
+		}
+	})
+	if err != nil {
+		c.conn.Close()
+		return nil, nil, err
+	}
+
+	manager, ok := globals["zwlr_foreign_toplevel_manager_v1"]
+	seat, hasSeat := globals["wl_seat"]
+	if !ok || !hasSeat {
+		c.conn.Close()
+		return nil, nil, errNoForeignToplevel
+	}
+
+	managerID := c.newID()
+	c.request(registry, wlRegistryBind, manager.name, "zwlr_foreign_toplevel_manager_v1", min(manager.version, 3), managerID)
+	c.seat = c.newID()
+	c.request(registry, wlRegistryBind, seat.name, "wl_seat", uint32(1), c.seat)
+
+	// The manager announces every window with a new handle, followed by its
+	// title and app_id
+	windows := make(map[uint32]*wlrWindow)
+	var order []*wlrWindow
+	handle := func(object uint32, opcode uint16, payload []byte) {
+		if object == managerID && opcode == wlrManagerToplevel {
+			w := &wlrWindow{id: wlUint(payload)}
+			windows[w.id] = w
+			order = append(order, w)
+			return
+		}
+		w, ok := windows[object]
+		if !ok {
+			return
+		}
+		switch opcode {
+		case wlrHandleTitle:
+			w.Title = wlString(payload)
+		case wlrHandleAppID:
+			w.AppID = wlString(payload)
+		case wlrHandleClosed:
+			w.closed = true
+		}
+	}
+	// Handles are created on the first roundtrip, their details follow
+	for i := 0; i < 2; i++ {
+		if err := c.roundtrip(handle); err != nil {
+			c.conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	var open []*wlrWindow
+	for _, w := range order {
+		if !w.closed {
+			open = append(open, w)
+		}
+	}
+	return c, open, nil
+}
+
+// wlrFindWindow reports whether a window matching match is open
+func wlrFindWindow(match func(appID, title string) bool) (bool, error) {
+	c, windows, err := wlrToplevels()
+	if err != nil {
+		return false, err
+	}
+	defer c.conn.Close()
+
+	for _, w := range windows {
+		if match(w.AppID, w.Title) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// wlrFocusWindow activates the first window matching match
+func wlrFocusWindow(match func(appID, title string) bool) error {
+	c, windows, err := wlrToplevels()
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	for _, w := range windows {
+		if match(w.AppID, w.Title) {
+			if err := c.request(w.id, wlrHandleActivate, c.seat); err != nil {
+				return err
+			}
+			return c.roundtrip(func(uint32, uint16, []byte) {})
+		}
+	}
+	return errors.New("no matching window")
+}
+
+// matchWebletWindow matches the window of a weblet by its app_id
+// (weblet-<name>, see view.go) or by its title
+func matchWebletWindow(name string) func(appID, title string) bool {
+	class := strings.ToLower("weblet-" + name)
+	nameLower := strings.ToLower(name)
+	return func(appID, title string) bool {
+		if strings.Contains(strings.ToLower(appID), class) {
+			return true
+		}
+		title = strings.ToLower(title)
+		return title == nameLower || strings.HasPrefix(title, nameLower+" ")
+	}
+}
+
+// matchChromeWindow matches a Chrome app window by its title, which is the
+// page title, like the wmctrl based lookup does
+func matchChromeWindow(name, webletURL string) func(appID, title string) bool {
+	hints := []string{strings.ToLower(name)}
+	if parsed, err := url.Parse(webletURL); err == nil {
+		parts := strings.Split(strings.TrimPrefix(parsed.Host, "www."), ".")
+		if len(parts) >= 2 {
+			hints = append(hints, strings.ToLower(parts[len(parts)-2]))
+		}
+	}
+
+	isWeblet := matchWebletWindow(name)
+	return func(appID, title string) bool {
+		if isWeblet(appID, title) {
+			return true
+		}
+		appID = strings.ToLower(appID)
+		if !strings.Contains(appID, "chrom") {
+			return false
+		}
+		title = strings.ToLower(title)
+		for _, hint := range hints {
+			if strings.Contains(title, hint) {
+				return true
+			}
+		}
+		return false
+	}
+}